@@ -0,0 +1,599 @@
+// Assembler of Z80 source code.
+//
+// The assembler accepts the standard Z80 mnemonics (including the undocumented
+// instructions), labels, expressions and the following directives:
+//
+//	ORG address
+//	label EQU value
+//	DEFB value,...     (also DB, DEFM, DM; values can be strings)
+//	DEFW value,...     (also DW)
+//	DEFS size[,fill]   (also DS)
+//	INCBIN "file"
+//
+// Mnemonics and register names are case-insensitive, labels are case-sensitive.
+// A label is defined by putting it at the beginning of a line, optionally followed by a colon.
+// Multiple statements can be written on a single line by separating them with colons.
+// Comments start with a semicolon.
+//
+// Numbers can be written as 123, $7f, #7f, 0x7f, 7fh, %101, 101b or 'c'.
+// The symbol '$' denotes the address of the current instruction.
+package asm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// The address of the first instruction if the source code does not start with ORG
+const DEFAULT_ORIGIN = 0x8000
+
+// An error in the source code
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// A contiguous block of assembled code
+type Chunk struct {
+	Address uint16
+	Data    []byte
+}
+
+// The output of the assembler
+type Program struct {
+	// The assembled code, in the order of appearance in the source code
+	Chunks []Chunk
+
+	// The values of labels and EQU constants
+	Symbols map[string]int
+}
+
+// Returns the total number of assembled bytes
+func (p *Program) Size() int {
+	size := 0
+	for _, chunk := range p.Chunks {
+		size += len(chunk.Data)
+	}
+	return size
+}
+
+// The destination of 'Program.WriteToMemory'. It is implemented by spectrum.Memory.
+type MemoryWriter interface {
+	Write(address uint16, value byte, protectROM bool)
+}
+
+// Writes the program into the memory. The program is allowed to patch the ROM.
+func (p *Program) WriteToMemory(memory MemoryWriter) {
+	for _, chunk := range p.Chunks {
+		for i, b := range chunk.Data {
+			memory.Write(chunk.Address+uint16(i), b, false)
+		}
+	}
+}
+
+// Writes the program into the RAM of the snapshot. If the snapshot contains
+// the state of a 128k machine, the program is written into the banks
+// which are paged in.
+func (p *Program) WriteToSnapshot(s *formats.FullSnapshot) error {
+	for _, chunk := range p.Chunks {
+		if (chunk.Address < 0x4000) || (int(chunk.Address)+len(chunk.Data) > 0x10000) {
+			return fmt.Errorf("the code at 0x%04x does not fit into the RAM", chunk.Address)
+		}
+	}
+
+	for _, chunk := range p.Chunks {
+		for i, b := range chunk.Data {
+			address := chunk.Address + uint16(i)
+			s.Mem[address-0x4000] = b
+
+			if s.Machine128k != nil {
+				var bank byte
+				switch address >> 14 {
+				case 1:
+					bank = 5
+				case 2:
+					bank = 2
+				default:
+					bank = s.Machine128k.Port7ffd & 0x07
+				}
+				s.Machine128k.Ram[bank][address&0x3fff] = b
+			}
+		}
+	}
+
+	return nil
+}
+
+// Assembles the source code. The files included by INCBIN are searched for
+// in the current directory.
+func Assemble(source string) (*Program, error) {
+	return assemble(source, "")
+}
+
+// Assembles the specified file. The files included by INCBIN are searched for
+// in the directory containing the file.
+func AssembleFile(path string) (*Program, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return assemble(string(source), filepath.Dir(path))
+}
+
+// A single statement of the source code
+type statement struct {
+	line     int
+	label    string
+	mnemonic string // Uppercase, an empty string if the statement only defines a label
+	operands []string
+}
+
+type assembler struct {
+	dir        string
+	statements []statement
+
+	// Pass 1 computes the values of all labels, pass 2 generates the code
+	pass int
+
+	symbols map[string]int
+	start   uint16 // The address of the current statement
+	address uint16 // The address of the next byte
+	chunks  []Chunk
+
+	// The contents of the files included by INCBIN
+	files map[string][]byte
+
+	// Set when an expression refers to an undefined symbol in pass 1
+	undefined bool
+
+	// The first error encountered while processing the current statement
+	err error
+}
+
+func assemble(source string, dir string) (*Program, error) {
+	statements, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &assembler{
+		dir:        dir,
+		statements: statements,
+		symbols:    make(map[string]int),
+		files:      make(map[string][]byte),
+	}
+
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.address = DEFAULT_ORIGIN
+		a.chunks = []Chunk{{Address: DEFAULT_ORIGIN}}
+
+		for _, stmt := range a.statements {
+			a.err = nil
+			a.statement(stmt)
+			if a.err != nil {
+				return nil, &Error{Line: stmt.line, Msg: a.err.Error()}
+			}
+		}
+	}
+
+	program := &Program{Symbols: a.symbols}
+	for _, chunk := range a.chunks {
+		if len(chunk.Data) > 0 {
+			program.Chunks = append(program.Chunks, chunk)
+		}
+	}
+	return program, nil
+}
+
+// Records an error, unless an error has already been recorded for the current statement
+func (a *assembler) errorf(format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (a *assembler) statement(stmt statement) {
+	a.start = a.address
+
+	if stmt.label != "" {
+		if stmt.mnemonic == "EQU" {
+			a.equ(stmt)
+			return
+		}
+		a.defineSymbol(stmt.label, int(a.address))
+	}
+
+	switch stmt.mnemonic {
+	case "":
+		// A label on its own
+
+	case "ORG":
+		if a.checkOperands(stmt, 1) {
+			address := a.definedValue(stmt.operands[0])
+			a.address = uint16(address)
+			a.start = a.address
+			a.chunks = append(a.chunks, Chunk{Address: a.address})
+		}
+
+	case "EQU":
+		a.errorf("EQU requires a label")
+
+	case "DEFB", "DB", "DEFM", "DM":
+		a.defb(stmt)
+
+	case "DEFW", "DW":
+		if len(stmt.operands) == 0 {
+			a.errorf("missing operand")
+		}
+		for _, operand := range stmt.operands {
+			a.emitWord(a.value(operand))
+		}
+
+	case "DEFS", "DS":
+		if (len(stmt.operands) != 1) && (len(stmt.operands) != 2) {
+			a.errorf("invalid number of operands")
+			return
+		}
+		size := a.definedValue(stmt.operands[0])
+		if (size < 0) || (size > 0x10000) {
+			a.errorf("invalid size %d", size)
+			return
+		}
+		fill := 0
+		if len(stmt.operands) == 2 {
+			fill = a.value(stmt.operands[1])
+		}
+		for i := 0; i < size; i++ {
+			a.emitByte(fill)
+		}
+
+	case "INCBIN":
+		if a.checkOperands(stmt, 1) {
+			data := a.incbin(stmt.operands[0])
+			for _, b := range data {
+				a.emitByte(int(b))
+			}
+		}
+
+	default:
+		a.instruction(stmt.mnemonic, stmt.operands)
+	}
+}
+
+func (a *assembler) checkOperands(stmt statement, n int) bool {
+	if len(stmt.operands) != n {
+		a.errorf("%s requires %d operand(s)", stmt.mnemonic, n)
+		return false
+	}
+	return true
+}
+
+func (a *assembler) defineSymbol(name string, value int) {
+	if a.pass == 1 {
+		if _, exists := a.symbols[name]; exists {
+			a.errorf("symbol \"%s\" is already defined", name)
+			return
+		}
+	}
+	a.symbols[name] = value
+}
+
+func (a *assembler) equ(stmt statement) {
+	if !a.checkOperands(stmt, 1) {
+		return
+	}
+
+	// In pass 1, the value may depend on labels which are defined later.
+	// Such a symbol is left undefined until pass 2.
+	a.undefined = false
+	value := a.value(stmt.operands[0])
+	if a.pass == 1 {
+		if _, exists := a.symbols[stmt.label]; exists {
+			a.errorf("symbol \"%s\" is already defined", stmt.label)
+			return
+		}
+		if a.undefined {
+			return
+		}
+	}
+	a.symbols[stmt.label] = value
+}
+
+func (a *assembler) defb(stmt statement) {
+	if len(stmt.operands) == 0 {
+		a.errorf("missing operand")
+	}
+
+	for _, operand := range stmt.operands {
+		if s, isString := unquote(operand); isString && (len(s) != 1) {
+			for i := 0; i < len(s); i++ {
+				a.emitByte(int(s[i]))
+			}
+		} else {
+			a.emitByte(a.byteValue(operand))
+		}
+	}
+}
+
+func (a *assembler) incbin(operand string) []byte {
+	name, isString := unquote(operand)
+	if !isString {
+		a.errorf("INCBIN requires a file name in quotes")
+		return nil
+	}
+
+	path := name
+	if !filepath.IsAbs(path) && (a.dir != "") {
+		path = filepath.Join(a.dir, path)
+	}
+
+	data, cached := a.files[path]
+	if !cached {
+		var err error
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			a.errorf("%s", err)
+			return nil
+		}
+		a.files[path] = data
+	}
+	return data
+}
+
+// Returns the contents of a string enclosed in single or double quotes
+func unquote(s string) (string, bool) {
+	if (len(s) >= 2) && ((s[0] == '"') || (s[0] == '\'')) && (s[len(s)-1] == s[0]) {
+		return s[1 : len(s)-1], true
+	}
+	return "", false
+}
+
+// ======
+// Output
+// ======
+
+func (a *assembler) emitByte(b int) {
+	chunk := &a.chunks[len(a.chunks)-1]
+	if a.pass == 2 {
+		chunk.Data = append(chunk.Data, byte(b))
+	}
+	a.address++
+}
+
+func (a *assembler) emitWord(w int) {
+	a.emitByte(w & 0xff)
+	a.emitByte((w >> 8) & 0xff)
+}
+
+func (a *assembler) emit(bytes ...byte) {
+	for _, b := range bytes {
+		a.emitByte(int(b))
+	}
+}
+
+// ===========
+// Expressions
+// ===========
+
+// Evaluates an expression. In pass 1, undefined symbols evaluate to 0.
+func (a *assembler) value(expr string) int {
+	value, err := evaluate(expr, a.lookup)
+	if err != nil {
+		a.errorf("%s", err)
+		return 0
+	}
+	return value
+}
+
+// Evaluates an expression which must not depend on symbols defined later in the source code
+func (a *assembler) definedValue(expr string) int {
+	a.undefined = false
+	value := a.value(expr)
+	if a.undefined {
+		a.errorf("\"%s\" must not refer to symbols defined later", expr)
+	}
+	return value
+}
+
+// Evaluates an 8-bit value (-128 ... 255)
+func (a *assembler) byteValue(expr string) int {
+	value := a.value(expr)
+	if (a.pass == 2) && ((value < -128) || (value > 255)) {
+		a.errorf("value %d does not fit into a byte", value)
+	}
+	return value & 0xff
+}
+
+// Evaluates a 16-bit value (-32768 ... 65535)
+func (a *assembler) wordValue(expr string) int {
+	value := a.value(expr)
+	if (a.pass == 2) && ((value < -32768) || (value > 65535)) {
+		a.errorf("value %d does not fit into a word", value)
+	}
+	return value & 0xffff
+}
+
+func (a *assembler) lookup(name string) (int, error) {
+	if name == "$" {
+		return int(a.start), nil
+	}
+
+	value, defined := a.symbols[name]
+	if !defined {
+		if a.pass == 1 {
+			a.undefined = true
+			return 0, nil
+		}
+		return 0, fmt.Errorf("undefined symbol \"%s\"", name)
+	}
+	return value, nil
+}
+
+// =======
+// Parsing
+// =======
+
+// Splits the source code into statements
+func parse(source string) ([]statement, error) {
+	var statements []statement
+
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		lineNumber := i + 1
+
+		segments, err := split(stripComment(line), ':')
+		if err != nil {
+			return nil, &Error{Line: lineNumber, Msg: err.Error()}
+		}
+
+		// A label starts at the beginning of the line, or it is followed by a colon
+		label := ""
+		for j, segment := range segments {
+			text := strings.TrimSpace(segment)
+			if text == "" {
+				continue
+			}
+
+			fields := strings.Fields(text)
+			first := fields[0]
+
+			isLabel := false
+			if !isKeyword(first) && isIdentifier(first) {
+				followedByColon := (j < len(segments)-1) && (len(fields) == 1)
+				atLineStart := (j == 0) && (segment[0] != ' ') && (segment[0] != '\t')
+				isEqu := (len(fields) > 1) && (strings.ToUpper(fields[1]) == "EQU")
+				isLabel = followedByColon || atLineStart || isEqu
+			}
+
+			if isLabel {
+				if label != "" {
+					statements = append(statements, statement{line: lineNumber, label: label})
+				}
+				label = first
+				text = strings.TrimSpace(text[len(first):])
+				if text == "" {
+					// The label applies to the statement after the colon
+					continue
+				}
+			}
+
+			stmt, err := parseStatement(text)
+			if err != nil {
+				return nil, &Error{Line: lineNumber, Msg: err.Error()}
+			}
+			stmt.line = lineNumber
+			stmt.label = label
+			label = ""
+			statements = append(statements, stmt)
+		}
+
+		if label != "" {
+			statements = append(statements, statement{line: lineNumber, label: label})
+		}
+	}
+
+	return statements, nil
+}
+
+// Parses a mnemonic and its operands
+func parseStatement(text string) (statement, error) {
+	var stmt statement
+
+	mnemonic := text
+	operands := ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		mnemonic, operands = text[:i], strings.TrimSpace(text[i:])
+	}
+	stmt.mnemonic = strings.ToUpper(mnemonic)
+
+	if !isKeyword(stmt.mnemonic) {
+		return stmt, fmt.Errorf("unknown instruction \"%s\"", mnemonic)
+	}
+
+	if operands != "" {
+		list, err := split(operands, ',')
+		if err != nil {
+			return stmt, err
+		}
+		for _, operand := range list {
+			operand = strings.TrimSpace(operand)
+			if operand == "" {
+				return stmt, fmt.Errorf("missing operand")
+			}
+			stmt.operands = append(stmt.operands, operand)
+		}
+	}
+
+	return stmt, nil
+}
+
+// Removes the comment from a line
+func stripComment(line string) string {
+	segments, err := split(line, ';')
+	if err != nil {
+		// The error is reported later
+		return line
+	}
+	return segments[0]
+}
+
+// Splits 's' at each occurrence of 'sep' which is not inside parentheses or quotes
+func split(s string, sep byte) ([]string, error) {
+	var result []string
+
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || (c == '\'' && !isShadowRegister(s[:i])):
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i += end + 1
+
+		case c == '(':
+			depth++
+
+		case c == ')':
+			depth--
+
+		case (c == sep) && (depth == 0):
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(result, s[start:]), nil
+}
+
+// Returns true if 's' ends with AF, in which case an apostrophe
+// following it denotes the shadow register AF'
+func isShadowRegister(s string) bool {
+	s = strings.ToUpper(s)
+	return strings.HasSuffix(s, "AF") && ((len(s) == 2) || !isIdentChar(s[len(s)-3]))
+}
+
+var directives = map[string]bool{
+	"ORG": true, "EQU": true,
+	"DEFB": true, "DB": true, "DEFM": true, "DM": true,
+	"DEFW": true, "DW": true,
+	"DEFS": true, "DS": true,
+	"INCBIN": true,
+}
+
+// Returns true if 's' is a mnemonic or a directive
+func isKeyword(s string) bool {
+	s = strings.ToUpper(s)
+	_, isInstruction := instructions[s]
+	return isInstruction || directives[s]
+}
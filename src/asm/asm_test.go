@@ -0,0 +1,230 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/remogatto/gospeccy/src/disasm"
+	"github.com/remogatto/gospeccy/src/formats"
+	"github.com/remogatto/prettytest"
+)
+
+type testSuite struct {
+	prettytest.Suite
+}
+
+// Assembles the source code, and returns the bytes of the first chunk
+func (t *testSuite) assemble(source string) []byte {
+	program, err := Assemble(source)
+	t.Nil(err)
+	if (err != nil) || (len(program.Chunks) == 0) {
+		return nil
+	}
+	return program.Chunks[0].Data
+}
+
+func (t *testSuite) TestAssemble() {
+	source := `
+; Fill the screen attributes
+attrs   equ $5800
+        org 32768
+start:  ld hl,attrs
+        ld bc,size
+loop:   ld (hl),%00111000 : inc hl
+        dec bc
+        ld a,b : or c
+        jr nz,loop
+        ret
+size    equ 768
+`
+	program, err := Assemble(source)
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(1, len(program.Chunks))
+		t.Equal(uint16(0x8000), program.Chunks[0].Address)
+		t.Equal(0x8000, program.Symbols["start"])
+		t.Equal(0x8006, program.Symbols["loop"])
+		t.Equal(768, program.Symbols["size"])
+
+		expected := []byte{
+			0x21, 0x00, 0x58,
+			0x01, 0x00, 0x03,
+			0x36, 0x38, 0x23,
+			0x0b,
+			0x78, 0xb1,
+			0x20, 0xf8,
+			0xc9,
+		}
+		t.Equal(string(expected), string(program.Chunks[0].Data))
+	}
+}
+
+func (t *testSuite) TestAssemble_directives() {
+	source := `
+        org $6000
+        defb 1, -1, 'A', "hi", label & $ff
+        defw label, $1234
+        defs 3, $aa
+        ds 2
+label:  db 0
+        org $7000
+        incbin "testdata/data.bin"
+`
+	program, err := Assemble(source)
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(2, len(program.Chunks))
+		t.Equal(uint16(0x6000), program.Chunks[0].Address)
+		t.Equal(uint16(0x7000), program.Chunks[1].Address)
+
+		expected := []byte{1, 0xff, 'A', 'h', 'i', 0x0f, 0x0f, 0x60, 0x34, 0x12, 0xaa, 0xaa, 0xaa, 0, 0, 0}
+		t.Equal(string(expected), string(program.Chunks[0].Data))
+		t.Equal("\x01\x02\x03", string(program.Chunks[1].Data))
+		t.Equal(19, program.Size())
+	}
+}
+
+func (t *testSuite) TestAssemble_expressions() {
+	t.Equal(string([]byte{0x3e, 14}), string(t.assemble("ld a,2+3*4")))
+	t.Equal(string([]byte{0x3e, 20}), string(t.assemble("ld a,(2+3)*4")))
+	t.Equal(string([]byte{0x3e, 0x0f}), string(t.assemble("ld a,0FFh >> 4")))
+	t.Equal(string([]byte{0x3e, 0x05}), string(t.assemble("ld a,101b")))
+	t.Equal(string([]byte{0x3e, 0x02}), string(t.assemble("ld a,17 % 5")))
+	t.Equal(string([]byte{0x3e, 0xfe}), string(t.assemble("ld a,~1")))
+	t.Equal(string([]byte{0x21, 0x00, 0x80}), string(t.assemble("ld hl,$")))
+	t.Equal(string([]byte{0x18, 0xfe}), string(t.assemble("jr $")))
+
+	// Indirect addressing versus an expression in parentheses
+	t.Equal(string([]byte{0x3a, 0x05, 0x00}), string(t.assemble("ld a,(5)")))
+	t.Equal(string([]byte{0x3e, 0x06}), string(t.assemble("ld a,(5)+(1)")))
+}
+
+func (t *testSuite) TestAssemble_statements() {
+	// The example from the interpreter
+	t.Equal(string([]byte{0x3e, 0x02, 0xd3, 0xfe}), string(t.assemble("ld a,2: out (254),a")))
+
+	t.Equal(string([]byte{0x08, 0x00}), string(t.assemble("EX AF,AF' ; comment: nop\n nop")))
+	t.Equal(string([]byte{0x10, 0xfe}), string(t.assemble("x: y: djnz x")))
+	t.Equal(string([]byte{0x3e, ';'}), string(t.assemble("ld a,';'")))
+}
+
+// Each instruction disassembled by the disassembler can be assembled back
+func (t *testSuite) TestAssemble_disassemblerOutput() {
+	prefixes := [][]byte{{}, {0xcb}, {0xed}, {0xdd}, {0xfd}, {0xdd, 0xcb, 0xfb}, {0xfd, 0xcb, 0x05}}
+
+	for _, prefix := range prefixes {
+		for opcode := 0; opcode < 256; opcode++ {
+			code := append(append([]byte{}, prefix...), byte(opcode), 0x12, 0x80)
+			instr := disasm.Disassemble(disasm.Bytes{Origin: 0x8000, Data: code}, 0x8000)
+
+			program, err := Assemble(instr.Mnemonic)
+			if err != nil {
+				t.True(false, err.Error())
+				continue
+			}
+			data := program.Chunks[0].Data
+
+			if strings.HasPrefix(instr.Mnemonic, "DEFB") {
+				t.Equal(string(instr.Bytes), string(data))
+				continue
+			}
+
+			// Some instructions have alternative encodings (NEG, IM 0, ...)
+			reassembled := disasm.Disassemble(disasm.Bytes{Origin: 0x8000, Data: data}, 0x8000)
+			t.Equal(instr.Mnemonic, reassembled.Mnemonic)
+			t.Equal(len(data), reassembled.Len())
+		}
+	}
+}
+
+func (t *testSuite) TestAssemble_errors() {
+	tests := []struct {
+		source string
+		line   int
+	}{
+		{"nop\n  foo a,b", 2},
+		{"nop\nnop\n ld a,label", 3},
+		{"ld a,256", 1},
+		{"jr $+200", 1},
+		{"ld (ix+128),a", 1},
+		{"\n ld hl,(ix+1)", 2},
+		{"ld ixh,l", 1},
+		{"ld ixh,iyl", 1},
+		{"label: nop\nlabel: nop", 2},
+		{"org later\nlater: nop", 1},
+		{"defb \"unterminated", 1},
+		{"ld a,5/0", 1},
+		{"incbin \"testdata/missing.bin\"", 1},
+		{"im 3", 1},
+		{"rst 1", 1},
+	}
+
+	for _, test := range tests {
+		_, err := Assemble(test.source)
+		t.Not(t.Nil(err))
+
+		if e, ok := err.(*Error); ok {
+			t.Equal(test.line, e.Line)
+		} else {
+			t.True(false, test.source)
+		}
+	}
+}
+
+func (t *testSuite) TestAssembleFile() {
+	program, err := AssembleFile("testdata/incbin.asm")
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal("\x3e\x01\x01\x02\x03", string(program.Chunks[0].Data))
+	}
+}
+
+type memory [0x10000]byte
+
+func (m *memory) Write(address uint16, value byte, protectROM bool) {
+	m[address] = value
+}
+
+func (t *testSuite) TestWriteToMemory() {
+	program, err := Assemble("org 10: defb 1,2")
+	t.Nil(err)
+
+	if !t.Failed() {
+		var m memory
+		program.WriteToMemory(&m)
+		t.Equal(byte(1), m[10])
+		t.Equal(byte(2), m[11])
+	}
+}
+
+func (t *testSuite) TestWriteToSnapshot() {
+	program, err := Assemble("org $c000: defb 1,2")
+	t.Nil(err)
+
+	if !t.Failed() {
+		var snapshot formats.FullSnapshot
+		t.Nil(program.WriteToSnapshot(&snapshot))
+		t.Equal(byte(1), snapshot.Mem[0x8000])
+		t.Equal(byte(2), snapshot.Mem[0x8001])
+
+		// 128k: the bank paged in at 0xc000
+		snapshot.Machine128k = &formats.State128k{Port7ffd: 3}
+		t.Nil(program.WriteToSnapshot(&snapshot))
+		t.Equal(byte(2), snapshot.Machine128k.Ram[3][1])
+	}
+
+	program, err = Assemble("org 0: nop")
+	t.Nil(err)
+
+	if !t.Failed() {
+		var snapshot formats.FullSnapshot
+		t.Not(t.Nil(program.WriteToSnapshot(&snapshot)))
+	}
+}
+
+func TestAsm(t *testing.T) {
+	prettytest.Run(t, new(testSuite))
+}
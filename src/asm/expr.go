@@ -0,0 +1,317 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expression tokens
+const (
+	tok_number = iota
+	tok_symbol
+	tok_operator
+	tok_end
+)
+
+type token struct {
+	kind  int
+	text  string
+	value int
+}
+
+// Splits an expression into tokens
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case (c == ' ') || (c == '\t'):
+			i++
+
+		case c == '\'':
+			// Character constant
+			if (i+2 >= len(s)) || (s[i+2] != '\'') {
+				return nil, fmt.Errorf("invalid character constant in \"%s\"", s)
+			}
+			tokens = append(tokens, token{kind: tok_number, text: s[i : i+3], value: int(s[i+1])})
+			i += 3
+
+		case isDigit(c) || (c == '%' && (i+1 < len(s)) && isBinaryDigit(s[i+1]) && !afterOperand(tokens)) ||
+			((c == '$' || c == '#') && (i+1 < len(s)) && isHexDigit(s[i+1])):
+			j := i + 1
+			for (j < len(s)) && isIdentChar(s[j]) {
+				j++
+			}
+			value, err := parseNumber(s[i:j])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tok_number, text: s[i:j], value: value})
+			i = j
+
+		case isIdentStart(c):
+			j := i + 1
+			for (j < len(s)) && isIdentChar(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tok_symbol, text: s[i:j]})
+			i = j
+
+		case c == '$':
+			// The address of the current instruction
+			tokens = append(tokens, token{kind: tok_symbol, text: "$"})
+			i++
+
+		case (c == '<' || c == '>') && (i+1 < len(s)) && (s[i+1] == c):
+			tokens = append(tokens, token{kind: tok_operator, text: s[i : i+2]})
+			i += 2
+
+		case strings.IndexByte("+-*/%&|^~()", c) >= 0:
+			tokens = append(tokens, token{kind: tok_operator, text: s[i : i+1]})
+			i++
+
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' in \"%s\"", c, s)
+		}
+	}
+
+	return append(tokens, token{kind: tok_end}), nil
+}
+
+// Returns true if the last token ends an operand, in which case
+// the next '%' is the modulo operator
+func afterOperand(tokens []token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	last := tokens[len(tokens)-1]
+	return (last.kind != tok_operator) || (last.text == ")")
+}
+
+// Parses numbers in the following formats: 123, $7f, #7f, 0x7f, 7fh, %101, 101b
+func parseNumber(s string) (int, error) {
+	lower := strings.ToLower(s)
+
+	var digits string
+	var base int
+	switch {
+	case strings.HasPrefix(lower, "$") || strings.HasPrefix(lower, "#"):
+		digits, base = lower[1:], 16
+	case strings.HasPrefix(lower, "0x"):
+		digits, base = lower[2:], 16
+	case strings.HasPrefix(lower, "%"):
+		digits, base = lower[1:], 2
+	case strings.HasSuffix(lower, "h"):
+		digits, base = lower[:len(lower)-1], 16
+	case strings.HasSuffix(lower, "b") && (strings.Trim(lower[:len(lower)-1], "01") == ""):
+		digits, base = lower[:len(lower)-1], 2
+	default:
+		digits, base = lower, 10
+	}
+
+	value, err := strconv.ParseInt(digits, base, 32)
+	if (err != nil) || (digits == "") {
+		return 0, fmt.Errorf("invalid number \"%s\"", s)
+	}
+	return int(value), nil
+}
+
+func isDigit(c byte) bool {
+	return (c >= '0') && (c <= '9')
+}
+
+func isBinaryDigit(c byte) bool {
+	return (c == '0') || (c == '1')
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ((c >= 'a') && (c <= 'f')) || ((c >= 'A') && (c <= 'F'))
+}
+
+func isIdentStart(c byte) bool {
+	return ((c >= 'a') && (c <= 'z')) || ((c >= 'A') && (c <= 'Z')) || (c == '_') || (c == '.')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+// Returns true if 's' is a valid label name
+func isIdentifier(s string) bool {
+	if (s == "") || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// A recursive-descent evaluator of expressions.
+//
+// Operators, from the lowest to the highest priority:
+//
+//	|
+//	^
+//	&
+//	<< >>
+//	+ -
+//	* / %
+//	unary - + ~
+type evaluator struct {
+	tokens []token
+	pos    int
+
+	// Resolves symbols
+	lookup func(name string) (int, error)
+}
+
+// Evaluates the expression 's'
+func evaluate(s string, lookup func(name string) (int, error)) (int, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 1 {
+		return 0, fmt.Errorf("missing expression")
+	}
+
+	e := &evaluator{tokens: tokens, lookup: lookup}
+	value, err := e.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	if e.peek().kind != tok_end {
+		return 0, fmt.Errorf("unexpected \"%s\" in \"%s\"", e.peek().text, s)
+	}
+	return value, nil
+}
+
+var binaryOperators = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (e *evaluator) peek() token {
+	return e.tokens[e.pos]
+}
+
+func (e *evaluator) next() token {
+	t := e.tokens[e.pos]
+	if t.kind != tok_end {
+		e.pos++
+	}
+	return t
+}
+
+// Parses binary operators having the specified priority or higher
+func (e *evaluator) binary(priority int) (int, error) {
+	if priority == len(binaryOperators) {
+		return e.unary()
+	}
+
+	left, err := e.binary(priority + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		t := e.peek()
+		if (t.kind != tok_operator) || !contains(binaryOperators[priority], t.text) {
+			return left, nil
+		}
+		e.next()
+
+		right, err := e.binary(priority + 1)
+		if err != nil {
+			return 0, err
+		}
+
+		switch t.text {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if t.text == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (e *evaluator) unary() (int, error) {
+	t := e.next()
+
+	switch t.kind {
+	case tok_number:
+		return t.value, nil
+
+	case tok_symbol:
+		return e.lookup(t.text)
+
+	case tok_operator:
+		switch t.text {
+		case "-", "+", "~":
+			value, err := e.unary()
+			if err != nil {
+				return 0, err
+			}
+			switch t.text {
+			case "-":
+				return -value, nil
+			case "~":
+				return ^value, nil
+			}
+			return value, nil
+
+		case "(":
+			value, err := e.binary(0)
+			if err != nil {
+				return 0, err
+			}
+			if e.next().text != ")" {
+				return 0, fmt.Errorf("missing ')'")
+			}
+			return value, nil
+		}
+		return 0, fmt.Errorf("unexpected \"%s\"", t.text)
+	}
+
+	return 0, fmt.Errorf("unexpected end of expression")
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,774 @@
+package asm
+
+import (
+	"strings"
+)
+
+// Operand kinds
+const (
+	op_imm = iota // An expression: 5, label+1, ...
+	op_reg        // A register or a condition: A, HL, IXH, AF', NZ, ...
+	op_ind        // A register in parentheses: (HL), (BC), (C), (IX+d), ...
+	op_mem        // An expression in parentheses: ($5c78), ...
+)
+
+type operand struct {
+	kind int
+
+	// The uppercase register name (op_reg, op_ind)
+	name string
+
+	// The expression (op_imm, op_mem), or the displacement of (IX+d) and (IY+d)
+	expr string
+}
+
+var registers = map[string]bool{
+	"A": true, "B": true, "C": true, "D": true, "E": true, "H": true, "L": true,
+	"I": true, "R": true, "F": true,
+	"IXH": true, "IXL": true, "IYH": true, "IYL": true,
+	"AF": true, "AF'": true, "BC": true, "DE": true, "HL": true, "SP": true, "IX": true, "IY": true,
+	"NZ": true, "Z": true, "NC": true, "PO": true, "PE": true, "P": true, "M": true,
+}
+
+var indirectRegisters = map[string]bool{
+	"BC": true, "DE": true, "HL": true, "SP": true, "C": true, "IX": true, "IY": true,
+}
+
+func parseOperand(s string) operand {
+	upper := strings.ToUpper(s)
+	if registers[upper] {
+		return operand{kind: op_reg, name: upper}
+	}
+
+	if enclosedInParentheses(s) {
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		upper = strings.ToUpper(inner)
+
+		if indirectRegisters[upper] {
+			return operand{kind: op_ind, name: upper}
+		}
+
+		if strings.HasPrefix(upper, "IX") || strings.HasPrefix(upper, "IY") {
+			disp := strings.TrimSpace(inner[2:])
+			if strings.HasPrefix(disp, "+") || strings.HasPrefix(disp, "-") {
+				return operand{kind: op_ind, name: upper[0:2], expr: disp}
+			}
+		}
+
+		return operand{kind: op_mem, expr: inner}
+	}
+
+	return operand{kind: op_imm, expr: s}
+}
+
+// Returns true if the whole string is enclosed in a pair of matching parentheses
+func enclosedInParentheses(s string) bool {
+	if (len(s) < 2) || (s[0] != '(') || (s[len(s)-1] != ')') {
+		return false
+	}
+
+	depth := 0
+	for i := 0; i < len(s)-1; i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// An operand which can be encoded as an 8-bit register: B, C, D, E, H, L, (HL), A.
+// IXH, IXL, IYH, IYL, (IX+d) and (IY+d) are encoded as H, L and (HL) with a prefix.
+type reg8 struct {
+	code   byte
+	prefix byte     // 0, 0xdd or 0xfd
+	disp   *operand // The (IX+d) or (IY+d) operand
+}
+
+var reg8Codes = map[string]reg8{
+	"B": {code: 0}, "C": {code: 1}, "D": {code: 2}, "E": {code: 3}, "H": {code: 4}, "L": {code: 5}, "A": {code: 7},
+	"IXH": {code: 4, prefix: 0xdd}, "IXL": {code: 5, prefix: 0xdd},
+	"IYH": {code: 4, prefix: 0xfd}, "IYL": {code: 5, prefix: 0xfd},
+}
+
+func toReg8(op *operand) (reg8, bool) {
+	switch op.kind {
+	case op_reg:
+		r, ok := reg8Codes[op.name]
+		return r, ok
+
+	case op_ind:
+		switch op.name {
+		case "HL":
+			return reg8{code: 6}, true
+		case "IX":
+			return reg8{code: 6, prefix: 0xdd, disp: op}, true
+		case "IY":
+			return reg8{code: 6, prefix: 0xfd, disp: op}, true
+		}
+	}
+	return reg8{}, false
+}
+
+// Returns true if the operand is one of B, C, D, E, H, L, A
+func (r reg8) plain() bool {
+	return (r.prefix == 0) && (r.code != 6)
+}
+
+// Returns the index prefix and the displacement of an instruction with two 8-bit register operands
+func combineReg8(x, y reg8) (prefix byte, disp *operand, ok bool) {
+	switch {
+	case (x.disp != nil) || (y.disp != nil):
+		// The other operand cannot be IXH, IXL, IYH, IYL
+		if (x.prefix != 0) && (y.prefix != 0) {
+			return 0, nil, false
+		}
+		if x.disp != nil {
+			return x.prefix, x.disp, true
+		}
+		return y.prefix, y.disp, true
+
+	case (x.prefix != 0) || (y.prefix != 0):
+		// The prefix affects both operands: H and L cannot be combined with IXH, IXL, IYH, IYL
+		if (x.prefix != 0) && (y.prefix != 0) && (x.prefix != y.prefix) {
+			return 0, nil, false
+		}
+		if ((x.prefix == 0) && (x.code >= 4) && (x.code <= 6)) || ((y.prefix == 0) && (y.code >= 4) && (y.code <= 6)) {
+			return 0, nil, false
+		}
+		return x.prefix | y.prefix, nil, true
+	}
+
+	return 0, nil, true
+}
+
+// Returns the code (0 ... 3) of BC, DE, HL/IX/IY, SP (or AF if 'af' is true), and the index prefix
+func toReg16(op operand, af bool) (code byte, prefix byte, ok bool) {
+	if op.kind != op_reg {
+		return 0, 0, false
+	}
+
+	switch op.name {
+	case "BC":
+		return 0, 0, true
+	case "DE":
+		return 1, 0, true
+	case "HL":
+		return 2, 0, true
+	case "IX":
+		return 2, 0xdd, true
+	case "IY":
+		return 2, 0xfd, true
+	case "SP":
+		return 3, 0, !af
+	case "AF":
+		return 3, 0, af
+	}
+	return 0, 0, false
+}
+
+// Returns the prefix of HL (0), IX (0xdd) or IY (0xfd)
+func toHL(op operand) (prefix byte, ok bool) {
+	code, prefix, ok := toReg16(op, false)
+	return prefix, ok && (code == 2)
+}
+
+var conditions = map[string]byte{
+	"NZ": 0, "Z": 1, "NC": 2, "C": 3, "PO": 4, "PE": 5, "P": 6, "M": 7,
+}
+
+func toCondition(op operand) (byte, bool) {
+	if op.kind != op_reg {
+		return 0, false
+	}
+	cc, ok := conditions[op.name]
+	return cc, ok
+}
+
+func isReg(op operand, name string) bool {
+	return (op.kind == op_reg) && (op.name == name)
+}
+
+func isInd(op operand, name string) bool {
+	return (op.kind == op_ind) && (op.name == name) && (op.expr == "")
+}
+
+// ========
+// Encoding
+// ========
+
+// An instruction encoder. It returns false if the operands are invalid.
+type encoder func(a *assembler, mnemonic string, ops []operand) bool
+
+var instructions map[string]encoder
+
+var implied = map[string][]byte{
+	"NOP": {0x00}, "RLCA": {0x07}, "RRCA": {0x0f}, "RLA": {0x17}, "RRA": {0x1f},
+	"DAA": {0x27}, "CPL": {0x2f}, "SCF": {0x37}, "CCF": {0x3f}, "HALT": {0x76},
+	"EXX": {0xd9}, "DI": {0xf3}, "EI": {0xfb},
+	"NEG": {0xed, 0x44}, "RETN": {0xed, 0x45}, "RETI": {0xed, 0x4d},
+	"RRD": {0xed, 0x67}, "RLD": {0xed, 0x6f},
+	"LDI": {0xed, 0xa0}, "CPI": {0xed, 0xa1}, "INI": {0xed, 0xa2}, "OUTI": {0xed, 0xa3},
+	"LDD": {0xed, 0xa8}, "CPD": {0xed, 0xa9}, "IND": {0xed, 0xaa}, "OUTD": {0xed, 0xab},
+	"LDIR": {0xed, 0xb0}, "CPIR": {0xed, 0xb1}, "INIR": {0xed, 0xb2}, "OTIR": {0xed, 0xb3},
+	"LDDR": {0xed, 0xb8}, "CPDR": {0xed, 0xb9}, "INDR": {0xed, 0xba}, "OTDR": {0xed, 0xbb},
+}
+
+var aluOps = map[string]byte{
+	"ADD": 0, "ADC": 1, "SUB": 2, "SBC": 3, "AND": 4, "XOR": 5, "OR": 6, "CP": 7,
+}
+
+var rotOps = map[string]byte{
+	"RLC": 0, "RRC": 1, "RL": 2, "RR": 3, "SLA": 4, "SRA": 5, "SLL": 6, "SL1": 6, "SRL": 7,
+}
+
+var bitOps = map[string]byte{
+	"BIT": 0x40, "RES": 0x80, "SET": 0xc0,
+}
+
+func init() {
+	instructions = map[string]encoder{
+		"LD":   encodeLD,
+		"INC":  encodeINCDEC,
+		"DEC":  encodeINCDEC,
+		"PUSH": encodePUSHPOP,
+		"POP":  encodePUSHPOP,
+		"EX":   encodeEX,
+		"JP":   encodeJP,
+		"JR":   encodeJR,
+		"DJNZ": encodeJR,
+		"CALL": encodeCALL,
+		"RET":  encodeRET,
+		"RST":  encodeRST,
+		"IM":   encodeIM,
+		"IN":   encodeIN,
+		"OUT":  encodeOUT,
+	}
+	for mnemonic := range implied {
+		instructions[mnemonic] = encodeImplied
+	}
+	for mnemonic := range aluOps {
+		instructions[mnemonic] = encodeALU
+	}
+	for mnemonic := range rotOps {
+		instructions[mnemonic] = encodeRotation
+	}
+	for mnemonic := range bitOps {
+		instructions[mnemonic] = encodeBit
+	}
+}
+
+// Assembles an instruction
+func (a *assembler) instruction(mnemonic string, operands []string) {
+	ops := make([]operand, len(operands))
+	for i, s := range operands {
+		ops[i] = parseOperand(s)
+	}
+
+	if !instructions[mnemonic](a, mnemonic, ops) {
+		a.errorf("invalid operands: %s %s", mnemonic, strings.Join(operands, ","))
+	}
+}
+
+// Emits: [prefix] opcode [displacement]
+func (a *assembler) emitOp(prefix byte, opcode byte, disp *operand) {
+	if prefix != 0 {
+		a.emit(prefix)
+	}
+	a.emit(opcode)
+	if disp != nil {
+		a.emitByte(a.displacement(disp))
+	}
+}
+
+// Returns the displacement of (IX+d) or (IY+d)
+func (a *assembler) displacement(op *operand) int {
+	if op.expr == "" {
+		return 0
+	}
+	d := a.value(op.expr)
+	if (a.pass == 2) && ((d < -128) || (d > 127)) {
+		a.errorf("index offset %d out of range", d)
+	}
+	return d & 0xff
+}
+
+// Emits the displacement of a relative jump to the specified address
+func (a *assembler) emitRelative(target string) {
+	// The displacement is relative to the address of the next instruction
+	offset := a.wordValue(target) - (int(a.address) + 1)
+	if offset > 0x7fff {
+		offset -= 0x10000
+	} else if offset < -0x8000 {
+		offset += 0x10000
+	}
+	if (a.pass == 2) && ((offset < -128) || (offset > 127)) {
+		a.errorf("relative jump out of range (%d bytes)", offset)
+	}
+	a.emitByte(offset & 0xff)
+}
+
+func encodeImplied(a *assembler, mnemonic string, ops []operand) bool {
+	if len(ops) != 0 {
+		return false
+	}
+	a.emit(implied[mnemonic]...)
+	return true
+}
+
+func encodeLD(a *assembler, mnemonic string, ops []operand) bool {
+	if len(ops) != 2 {
+		return false
+	}
+	dst, src := ops[0], ops[1]
+
+	dst8, dstIs8 := toReg8(&ops[0])
+	src8, srcIs8 := toReg8(&ops[1])
+
+	switch {
+	case dstIs8 && srcIs8:
+		// LD r,r'
+		if (dst8.code == 6) && (src8.code == 6) {
+			return false
+		}
+		prefix, disp, ok := combineReg8(dst8, src8)
+		if !ok {
+			return false
+		}
+		a.emitOp(prefix, 0x40|dst8.code<<3|src8.code, disp)
+		return true
+
+	case dstIs8 && (src.kind == op_imm):
+		// LD r,n
+		a.emitOp(dst8.prefix, 0x06|dst8.code<<3, dst8.disp)
+		a.emitByte(a.byteValue(src.expr))
+		return true
+
+	case isReg(dst, "A"):
+		switch {
+		case isInd(src, "BC"):
+			a.emit(0x0a)
+		case isInd(src, "DE"):
+			a.emit(0x1a)
+		case src.kind == op_mem:
+			a.emit(0x3a)
+			a.emitWord(a.wordValue(src.expr))
+		case isReg(src, "I"):
+			a.emit(0xed, 0x57)
+		case isReg(src, "R"):
+			a.emit(0xed, 0x5f)
+		default:
+			return false
+		}
+		return true
+
+	case isReg(src, "A"):
+		switch {
+		case isInd(dst, "BC"):
+			a.emit(0x02)
+		case isInd(dst, "DE"):
+			a.emit(0x12)
+		case dst.kind == op_mem:
+			a.emit(0x32)
+			a.emitWord(a.wordValue(dst.expr))
+		case isReg(dst, "I"):
+			a.emit(0xed, 0x47)
+		case isReg(dst, "R"):
+			a.emit(0xed, 0x4f)
+		default:
+			return false
+		}
+		return true
+	}
+
+	if code, prefix, ok := toReg16(dst, false); ok {
+		switch {
+		case src.kind == op_imm:
+			// LD rr,nn
+			a.emitOp(prefix, 0x01|code<<4, nil)
+			a.emitWord(a.wordValue(src.expr))
+
+		case src.kind == op_mem:
+			// LD rr,(nn)
+			if code == 2 {
+				a.emitOp(prefix, 0x2a, nil)
+			} else {
+				a.emit(0xed, 0x4b|code<<4)
+			}
+			a.emitWord(a.wordValue(src.expr))
+
+		case code == 3:
+			// LD SP,HL
+			srcPrefix, ok := toHL(src)
+			if !ok {
+				return false
+			}
+			a.emitOp(srcPrefix, 0xf9, nil)
+
+		default:
+			return false
+		}
+		return true
+	}
+
+	if dst.kind == op_mem {
+		// LD (nn),rr
+		code, prefix, ok := toReg16(src, false)
+		if !ok {
+			return false
+		}
+		if code == 2 {
+			a.emitOp(prefix, 0x22, nil)
+		} else {
+			a.emit(0xed, 0x43|code<<4)
+		}
+		a.emitWord(a.wordValue(dst.expr))
+		return true
+	}
+
+	return false
+}
+
+func encodeALU(a *assembler, mnemonic string, ops []operand) bool {
+	y := aluOps[mnemonic]
+
+	if len(ops) == 2 {
+		// 16-bit arithmetic
+		if prefix, ok := toHL(ops[0]); ok {
+			code, srcPrefix, ok := toReg16(ops[1], false)
+			if !ok || ((code == 2) && (srcPrefix != prefix)) {
+				return false
+			}
+			switch {
+			case mnemonic == "ADD":
+				a.emitOp(prefix, 0x09|code<<4, nil)
+			case (mnemonic == "ADC") && (prefix == 0):
+				a.emit(0xed, 0x4a|code<<4)
+			case (mnemonic == "SBC") && (prefix == 0):
+				a.emit(0xed, 0x42|code<<4)
+			default:
+				return false
+			}
+			return true
+		}
+
+		if !isReg(ops[0], "A") {
+			return false
+		}
+		ops = ops[1:]
+	}
+
+	if len(ops) != 1 {
+		return false
+	}
+
+	if r, ok := toReg8(&ops[0]); ok {
+		a.emitOp(r.prefix, 0x80|y<<3|r.code, r.disp)
+		return true
+	}
+	if ops[0].kind == op_imm {
+		a.emit(0xc6 | y<<3)
+		a.emitByte(a.byteValue(ops[0].expr))
+		return true
+	}
+	return false
+}
+
+func encodeINCDEC(a *assembler, mnemonic string, ops []operand) bool {
+	if len(ops) != 1 {
+		return false
+	}
+
+	var dec byte
+	if mnemonic == "DEC" {
+		dec = 1
+	}
+
+	if r, ok := toReg8(&ops[0]); ok {
+		a.emitOp(r.prefix, 0x04|r.code<<3|dec, r.disp)
+		return true
+	}
+	if code, prefix, ok := toReg16(ops[0], false); ok {
+		a.emitOp(prefix, 0x03|code<<4|dec<<3, nil)
+		return true
+	}
+	return false
+}
+
+func encodePUSHPOP(a *assembler, mnemonic string, ops []operand) bool {
+	if len(ops) != 1 {
+		return false
+	}
+
+	code, prefix, ok := toReg16(ops[0], true)
+	if !ok {
+		return false
+	}
+
+	if mnemonic == "PUSH" {
+		a.emitOp(prefix, 0xc5|code<<4, nil)
+	} else {
+		a.emitOp(prefix, 0xc1|code<<4, nil)
+	}
+	return true
+}
+
+func encodeEX(a *assembler, mnemonic string, ops []operand) bool {
+	if len(ops) != 2 {
+		return false
+	}
+
+	switch {
+	case isReg(ops[0], "AF") && (isReg(ops[1], "AF'") || isReg(ops[1], "AF")):
+		a.emit(0x08)
+	case isReg(ops[0], "DE") && isReg(ops[1], "HL"):
+		a.emit(0xeb)
+	case isInd(ops[0], "SP"):
+		prefix, ok := toHL(ops[1])
+		if !ok {
+			return false
+		}
+		a.emitOp(prefix, 0xe3, nil)
+	default:
+		return false
+	}
+	return true
+}
+
+func encodeJP(a *assembler, mnemonic string, ops []operand) bool {
+	switch len(ops) {
+	case 1:
+		switch {
+		case ops[0].kind == op_imm:
+			a.emit(0xc3)
+			a.emitWord(a.wordValue(ops[0].expr))
+		case isInd(ops[0], "HL"):
+			a.emit(0xe9)
+		case isInd(ops[0], "IX"):
+			a.emit(0xdd, 0xe9)
+		case isInd(ops[0], "IY"):
+			a.emit(0xfd, 0xe9)
+		default:
+			return false
+		}
+		return true
+
+	case 2:
+		cc, ok := toCondition(ops[0])
+		if !ok || (ops[1].kind != op_imm) {
+			return false
+		}
+		a.emit(0xc2 | cc<<3)
+		a.emitWord(a.wordValue(ops[1].expr))
+		return true
+	}
+	return false
+}
+
+// Encodes JR and DJNZ
+func encodeJR(a *assembler, mnemonic string, ops []operand) bool {
+	switch {
+	case (len(ops) == 1) && (ops[0].kind == op_imm):
+		if mnemonic == "DJNZ" {
+			a.emit(0x10)
+		} else {
+			a.emit(0x18)
+		}
+		a.emitRelative(ops[0].expr)
+		return true
+
+	case (len(ops) == 2) && (mnemonic == "JR") && (ops[1].kind == op_imm):
+		cc, ok := toCondition(ops[0])
+		if !ok || (cc > 3) {
+			return false
+		}
+		a.emit(0x20 | cc<<3)
+		a.emitRelative(ops[1].expr)
+		return true
+	}
+	return false
+}
+
+func encodeCALL(a *assembler, mnemonic string, ops []operand) bool {
+	switch {
+	case (len(ops) == 1) && (ops[0].kind == op_imm):
+		a.emit(0xcd)
+		a.emitWord(a.wordValue(ops[0].expr))
+		return true
+
+	case (len(ops) == 2) && (ops[1].kind == op_imm):
+		cc, ok := toCondition(ops[0])
+		if !ok {
+			return false
+		}
+		a.emit(0xc4 | cc<<3)
+		a.emitWord(a.wordValue(ops[1].expr))
+		return true
+	}
+	return false
+}
+
+func encodeRET(a *assembler, mnemonic string, ops []operand) bool {
+	switch len(ops) {
+	case 0:
+		a.emit(0xc9)
+		return true
+
+	case 1:
+		cc, ok := toCondition(ops[0])
+		if !ok {
+			return false
+		}
+		a.emit(0xc0 | cc<<3)
+		return true
+	}
+	return false
+}
+
+func encodeRST(a *assembler, mnemonic string, ops []operand) bool {
+	if (len(ops) != 1) || (ops[0].kind != op_imm) {
+		return false
+	}
+
+	n := a.value(ops[0].expr)
+	if (n & ^0x38) != 0 {
+		a.errorf("invalid restart address %d", n)
+	}
+	a.emit(0xc7 | byte(n&0x38))
+	return true
+}
+
+func encodeIM(a *assembler, mnemonic string, ops []operand) bool {
+	if (len(ops) != 1) || (ops[0].kind != op_imm) {
+		return false
+	}
+
+	switch a.value(ops[0].expr) {
+	case 0:
+		a.emit(0xed, 0x46)
+	case 1:
+		a.emit(0xed, 0x56)
+	case 2:
+		a.emit(0xed, 0x5e)
+	default:
+		a.errorf("invalid interrupt mode")
+	}
+	return true
+}
+
+func encodeIN(a *assembler, mnemonic string, ops []operand) bool {
+	switch len(ops) {
+	case 1:
+		// IN (C), an alias of IN F,(C)
+		if !isInd(ops[0], "C") {
+			return false
+		}
+		a.emit(0xed, 0x70)
+		return true
+
+	case 2:
+		switch {
+		case isReg(ops[0], "A") && (ops[1].kind == op_mem):
+			a.emit(0xdb)
+			a.emitByte(a.byteValue(ops[1].expr))
+			return true
+
+		case isInd(ops[1], "C"):
+			if isReg(ops[0], "F") {
+				a.emit(0xed, 0x70)
+				return true
+			}
+			if r, ok := toReg8(&ops[0]); ok && r.plain() {
+				a.emit(0xed, 0x40|r.code<<3)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func encodeOUT(a *assembler, mnemonic string, ops []operand) bool {
+	if len(ops) != 2 {
+		return false
+	}
+
+	switch {
+	case (ops[0].kind == op_mem) && isReg(ops[1], "A"):
+		a.emit(0xd3)
+		a.emitByte(a.byteValue(ops[0].expr))
+		return true
+
+	case isInd(ops[0], "C"):
+		if ops[1].kind == op_imm {
+			if a.value(ops[1].expr) != 0 {
+				return false
+			}
+			a.emit(0xed, 0x71)
+			return true
+		}
+		if r, ok := toReg8(&ops[1]); ok && r.plain() {
+			a.emit(0xed, 0x41|r.code<<3)
+			return true
+		}
+	}
+	return false
+}
+
+// Emits a CB-prefixed instruction. 'operands' are the (IX+d) operand
+// and the optional register receiving a copy of the result.
+func (a *assembler) emitCB(opcode byte, operands []operand) bool {
+	r, ok := toReg8(&operands[0])
+	if !ok {
+		return false
+	}
+
+	switch {
+	case r.disp != nil:
+		// Undocumented: the result is also copied into a register
+		code := byte(6)
+		if len(operands) == 2 {
+			copyTo, ok := toReg8(&operands[1])
+			if !ok || !copyTo.plain() {
+				return false
+			}
+			code = copyTo.code
+		}
+		a.emit(r.prefix, 0xcb)
+		a.emitByte(a.displacement(r.disp))
+		a.emit(opcode | code)
+
+	case (r.prefix == 0) && (len(operands) == 1):
+		a.emit(0xcb, opcode|r.code)
+
+	default:
+		return false
+	}
+	return true
+}
+
+func encodeRotation(a *assembler, mnemonic string, ops []operand) bool {
+	if (len(ops) != 1) && (len(ops) != 2) {
+		return false
+	}
+	return a.emitCB(rotOps[mnemonic]<<3, ops)
+}
+
+func encodeBit(a *assembler, mnemonic string, ops []operand) bool {
+	if (len(ops) < 2) || (len(ops) > 3) || (ops[0].kind != op_imm) {
+		return false
+	}
+	if (mnemonic == "BIT") && (len(ops) == 3) {
+		return false
+	}
+
+	bit := a.value(ops[0].expr)
+	if (bit < 0) || (bit > 7) {
+		a.errorf("invalid bit number %d", bit)
+	}
+	return a.emitCB(bitOps[mnemonic]|byte(bit&7)<<3, ops[1:])
+}
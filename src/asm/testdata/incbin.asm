@@ -0,0 +1,2 @@
+	ld a,1
+	incbin "data.bin"
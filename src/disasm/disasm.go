@@ -0,0 +1,484 @@
+// Disassembler of Z80 machine code.
+//
+// All instructions are supported, including the undocumented ones
+// (SLL, IXH/IXL/IYH/IYL operands, DDCB/FDCB instructions which copy
+// the result to a register, IN F,(C), OUT (C),0, ...).
+package disasm
+
+import "fmt"
+
+// The source of the disassembled code
+type MemoryReader interface {
+	// Returns the byte at the specified address without any side-effects
+	Read(address uint16) byte
+}
+
+// A MemoryReader reading from a byte slice. The first byte of the slice
+// is located at address 'Origin'. Bytes outside of the slice are read as zeroes.
+type Bytes struct {
+	Origin uint16
+	Data   []byte
+}
+
+func (b Bytes) Read(address uint16) byte {
+	i := int(address - b.Origin)
+	if i < len(b.Data) {
+		return b.Data[i]
+	}
+	return 0
+}
+
+// A disassembled instruction
+type Instruction struct {
+	Address  uint16
+	Bytes    []byte
+	Mnemonic string // For example: "LD A,(IX+$05)"
+}
+
+// Returns the length of the instruction in bytes
+func (instr *Instruction) Len() int {
+	return len(instr.Bytes)
+}
+
+// Returns the address of the next instruction
+func (instr *Instruction) Next() uint16 {
+	return instr.Address + uint16(len(instr.Bytes))
+}
+
+// Returns a line of a listing (address, bytes, mnemonic)
+func (instr *Instruction) String() string {
+	bytes := ""
+	for _, b := range instr.Bytes {
+		bytes += fmt.Sprintf("%02x ", b)
+	}
+	return fmt.Sprintf("%04x  %-12s  %s", instr.Address, bytes, instr.Mnemonic)
+}
+
+// Disassembles the instruction at the specified address
+func Disassemble(memory MemoryReader, address uint16) Instruction {
+	d := decoder{memory: memory, pc: address}
+	mnemonic := d.decode()
+
+	bytes := make([]byte, 0, 4)
+	for a := address; a != d.pc; a++ {
+		bytes = append(bytes, memory.Read(a))
+	}
+
+	return Instruction{Address: address, Bytes: bytes, Mnemonic: mnemonic}
+}
+
+// Disassembles 'n' consecutive instructions starting at the specified address
+func DisassembleN(memory MemoryReader, address uint16, n int) []Instruction {
+	instructions := make([]Instruction, n)
+	for i := range instructions {
+		instructions[i] = Disassemble(memory, address)
+		address = instructions[i].Next()
+	}
+	return instructions
+}
+
+// Disassembles all instructions contained in 'data'. The first byte
+// of 'data' is located at address 'origin'.
+func DisassembleBytes(data []byte, origin uint16) []Instruction {
+	memory := Bytes{Origin: origin, Data: data}
+
+	var instructions []Instruction
+	for offset := 0; offset < len(data); {
+		instr := Disassemble(memory, origin+uint16(offset))
+		instructions = append(instructions, instr)
+		offset += instr.Len()
+	}
+	return instructions
+}
+
+var (
+	table_r   = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	table_rp  = [4]string{"BC", "DE", "HL", "SP"}
+	table_rp2 = [4]string{"BC", "DE", "HL", "AF"}
+	table_cc  = [8]string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+	table_alu = [8]string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	table_rot = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SLL", "SRL"}
+	table_im  = [8]string{"0", "0", "1", "2", "0", "0", "1", "2"}
+
+	table_misc1 = [8]string{"RLCA", "RRCA", "RLA", "RRA", "DAA", "CPL", "SCF", "CCF"}
+	table_misc2 = [8]string{"LD I,A", "LD R,A", "LD A,I", "LD A,R", "RRD", "RLD", "NOP", "NOP"}
+
+	table_bli = [4][4]string{
+		{"LDI", "CPI", "INI", "OUTI"},
+		{"LDD", "CPD", "IND", "OUTD"},
+		{"LDIR", "CPIR", "INIR", "OTIR"},
+		{"LDDR", "CPDR", "INDR", "OTDR"},
+	}
+)
+
+type decoder struct {
+	memory MemoryReader
+	pc     uint16 // The address of the next byte to fetch
+
+	// "IX" or "IY" after a DD or FD prefix, otherwise an empty string
+	index string
+
+	// The displacement of (IX+d) or (IY+d), if it has already been fetched
+	disp     int8
+	haveDisp bool
+}
+
+func (d *decoder) fetch() byte {
+	b := d.memory.Read(d.pc)
+	d.pc++
+	return b
+}
+
+// Fetches an 8-bit operand
+func (d *decoder) n() string {
+	return fmt.Sprintf("$%02X", d.fetch())
+}
+
+// Fetches a 16-bit operand
+func (d *decoder) nn() string {
+	lo := d.fetch()
+	hi := d.fetch()
+	return fmt.Sprintf("$%04X", uint16(hi)<<8|uint16(lo))
+}
+
+// Fetches the displacement of a relative jump
+func (d *decoder) relative() string {
+	e := int8(d.fetch())
+	return fmt.Sprintf("$%04X", d.pc+uint16(e))
+}
+
+// Returns HL, IX or IY
+func (d *decoder) hl() string {
+	if d.index != "" {
+		return d.index
+	}
+	return "HL"
+}
+
+// Returns (HL), or (IX+d)/(IY+d). The displacement is fetched if needed.
+func (d *decoder) ihl() string {
+	if d.index == "" {
+		return "(HL)"
+	}
+
+	if !d.haveDisp {
+		d.disp = int8(d.fetch())
+		d.haveDisp = true
+	}
+
+	if d.disp < 0 {
+		return fmt.Sprintf("(%s-$%02X)", d.index, -int(d.disp))
+	}
+	return fmt.Sprintf("(%s+$%02X)", d.index, d.disp)
+}
+
+// Returns the 8-bit register with index 'i'. After a DD/FD prefix,
+// H and L are replaced by the halves of the index register.
+func (d *decoder) r(i byte) string {
+	switch {
+	case i == 6:
+		return d.ihl()
+	case (d.index != "") && (i == 4):
+		return d.index + "H"
+	case (d.index != "") && (i == 5):
+		return d.index + "L"
+	}
+	return table_r[i]
+}
+
+// Returns the 16-bit register with index 'i', HL is replaced by IX/IY
+func (d *decoder) rp(i byte) string {
+	if i == 2 {
+		return d.hl()
+	}
+	return table_rp[i]
+}
+
+func (d *decoder) rp2(i byte) string {
+	if i == 2 {
+		return d.hl()
+	}
+	return table_rp2[i]
+}
+
+func (d *decoder) decode() string {
+	opcode := d.fetch()
+
+	switch opcode {
+	case 0xcb:
+		return d.decodeCB()
+
+	case 0xed:
+		return d.decodeED()
+
+	case 0xdd, 0xfd:
+		// A sequence of prefixes: only the last one has any effect
+		switch d.memory.Read(d.pc) {
+		case 0xdd, 0xed, 0xfd:
+			return fmt.Sprintf("DEFB $%02X", opcode)
+		}
+
+		if opcode == 0xdd {
+			d.index = "IX"
+		} else {
+			d.index = "IY"
+		}
+
+		opcode = d.fetch()
+		if opcode == 0xcb {
+			return d.decodeIndexCB()
+		}
+	}
+
+	return d.decodeUnprefixed(opcode)
+}
+
+func (d *decoder) decodeUnprefixed(opcode byte) string {
+	x := opcode >> 6
+	y := (opcode >> 3) & 7
+	z := opcode & 7
+	p := y >> 1
+	q := y & 1
+
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				return "NOP"
+			case 1:
+				return "EX AF,AF'"
+			case 2:
+				return "DJNZ " + d.relative()
+			case 3:
+				return "JR " + d.relative()
+			default:
+				return "JR " + table_cc[y-4] + "," + d.relative()
+			}
+
+		case 1:
+			if q == 0 {
+				return "LD " + d.rp(p) + "," + d.nn()
+			}
+			return "ADD " + d.hl() + "," + d.rp(p)
+
+		case 2:
+			switch y {
+			case 0:
+				return "LD (BC),A"
+			case 1:
+				return "LD A,(BC)"
+			case 2:
+				return "LD (DE),A"
+			case 3:
+				return "LD A,(DE)"
+			case 4:
+				return "LD (" + d.nn() + ")," + d.hl()
+			case 5:
+				return "LD " + d.hl() + ",(" + d.nn() + ")"
+			case 6:
+				return "LD (" + d.nn() + "),A"
+			default:
+				return "LD A,(" + d.nn() + ")"
+			}
+
+		case 3:
+			if q == 0 {
+				return "INC " + d.rp(p)
+			}
+			return "DEC " + d.rp(p)
+
+		case 4:
+			return "INC " + d.r(y)
+
+		case 5:
+			return "DEC " + d.r(y)
+
+		case 6:
+			// The displacement precedes the immediate operand
+			dst := d.r(y)
+			return "LD " + dst + "," + d.n()
+
+		default:
+			return table_misc1[y]
+		}
+
+	case 1:
+		if (y == 6) && (z == 6) {
+			return "HALT"
+		}
+
+		// If one of the operands is (IX+d), the other operand is not affected by the prefix
+		if (y == 6) || (z == 6) {
+			if y == 6 {
+				return "LD " + d.ihl() + "," + table_r[z]
+			}
+			return "LD " + table_r[y] + "," + d.ihl()
+		}
+		return "LD " + d.r(y) + "," + d.r(z)
+
+	case 2:
+		return table_alu[y] + d.r(z)
+
+	default:
+		switch z {
+		case 0:
+			return "RET " + table_cc[y]
+
+		case 1:
+			if q == 0 {
+				return "POP " + d.rp2(p)
+			}
+			switch p {
+			case 0:
+				return "RET"
+			case 1:
+				return "EXX"
+			case 2:
+				return "JP (" + d.hl() + ")"
+			default:
+				return "LD SP," + d.hl()
+			}
+
+		case 2:
+			return "JP " + table_cc[y] + "," + d.nn()
+
+		case 3:
+			switch y {
+			case 0:
+				return "JP " + d.nn()
+			case 2:
+				return "OUT (" + d.n() + "),A"
+			case 3:
+				return "IN A,(" + d.n() + ")"
+			case 4:
+				return "EX (SP)," + d.hl()
+			case 5:
+				return "EX DE,HL"
+			case 6:
+				return "DI"
+			default:
+				return "EI"
+			}
+
+		case 4:
+			return "CALL " + table_cc[y] + "," + d.nn()
+
+		case 5:
+			if q == 0 {
+				return "PUSH " + d.rp2(p)
+			}
+			return "CALL " + d.nn()
+
+		case 6:
+			return table_alu[y] + d.n()
+
+		default:
+			return fmt.Sprintf("RST $%02X", y*8)
+		}
+	}
+}
+
+func (d *decoder) decodeCB() string {
+	opcode := d.fetch()
+	x := opcode >> 6
+	y := (opcode >> 3) & 7
+	z := opcode & 7
+
+	switch x {
+	case 0:
+		return table_rot[y] + " " + table_r[z]
+	case 1:
+		return fmt.Sprintf("BIT %d,%s", y, table_r[z])
+	case 2:
+		return fmt.Sprintf("RES %d,%s", y, table_r[z])
+	default:
+		return fmt.Sprintf("SET %d,%s", y, table_r[z])
+	}
+}
+
+// Decodes DDCB and FDCB instructions: prefix, 0xCB, displacement, opcode
+func (d *decoder) decodeIndexCB() string {
+	operand := d.ihl()
+	opcode := d.fetch()
+	x := opcode >> 6
+	y := (opcode >> 3) & 7
+	z := opcode & 7
+
+	// Undocumented: the result is also copied into a register
+	copyTo := ""
+	if z != 6 {
+		copyTo = "," + table_r[z]
+	}
+
+	switch x {
+	case 0:
+		return table_rot[y] + " " + operand + copyTo
+	case 1:
+		return fmt.Sprintf("BIT %d,%s", y, operand)
+	case 2:
+		return fmt.Sprintf("RES %d,%s%s", y, operand, copyTo)
+	default:
+		return fmt.Sprintf("SET %d,%s%s", y, operand, copyTo)
+	}
+}
+
+func (d *decoder) decodeED() string {
+	opcode := d.fetch()
+	x := opcode >> 6
+	y := (opcode >> 3) & 7
+	z := opcode & 7
+	p := y >> 1
+	q := y & 1
+
+	switch {
+	case x == 1:
+		switch z {
+		case 0:
+			if y == 6 {
+				return "IN F,(C)"
+			}
+			return "IN " + table_r[y] + ",(C)"
+
+		case 1:
+			if y == 6 {
+				return "OUT (C),0"
+			}
+			return "OUT (C)," + table_r[y]
+
+		case 2:
+			if q == 0 {
+				return "SBC HL," + table_rp[p]
+			}
+			return "ADC HL," + table_rp[p]
+
+		case 3:
+			if q == 0 {
+				return "LD (" + d.nn() + ")," + table_rp[p]
+			}
+			return "LD " + table_rp[p] + ",(" + d.nn() + ")"
+
+		case 4:
+			return "NEG"
+
+		case 5:
+			if y == 1 {
+				return "RETI"
+			}
+			return "RETN"
+
+		case 6:
+			return "IM " + table_im[y]
+
+		default:
+			return table_misc2[y]
+		}
+
+	case (x == 2) && (z <= 3) && (y >= 4):
+		return table_bli[y-4][z]
+	}
+
+	// Invalid instruction, executed as two NOPs
+	return fmt.Sprintf("DEFB $ED,$%02X", opcode)
+}
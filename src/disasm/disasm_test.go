@@ -0,0 +1,125 @@
+package disasm
+
+import (
+	"testing"
+
+	"github.com/remogatto/prettytest"
+)
+
+type testSuite struct {
+	prettytest.Suite
+}
+
+// Instruction encodings and their expected disassembly, at address 0x8000
+var tests = []struct {
+	bytes    []byte
+	mnemonic string
+}{
+	{[]byte{0x00}, "NOP"},
+	{[]byte{0x21, 0x00, 0x40}, "LD HL,$4000"},
+	{[]byte{0x08}, "EX AF,AF'"},
+	{[]byte{0x10, 0xfe}, "DJNZ $8000"},
+	{[]byte{0x18, 0x03}, "JR $8005"},
+	{[]byte{0x38, 0x80}, "JR C,$7F82"},
+	{[]byte{0x22, 0x34, 0x12}, "LD ($1234),HL"},
+	{[]byte{0x3a, 0x78, 0x5c}, "LD A,($5C78)"},
+	{[]byte{0x36, 0xaa}, "LD (HL),$AA"},
+	{[]byte{0x76}, "HALT"},
+	{[]byte{0x7e}, "LD A,(HL)"},
+	{[]byte{0x96}, "SUB (HL)"},
+	{[]byte{0x8f}, "ADC A,A"},
+	{[]byte{0xc9}, "RET"},
+	{[]byte{0xd8}, "RET C"},
+	{[]byte{0xcd, 0x00, 0x80}, "CALL $8000"},
+	{[]byte{0xe3}, "EX (SP),HL"},
+	{[]byte{0xd3, 0xfe}, "OUT ($FE),A"},
+	{[]byte{0xfe, 0x10}, "CP $10"},
+	{[]byte{0xff}, "RST $38"},
+
+	// CB prefix
+	{[]byte{0xcb, 0x00}, "RLC B"},
+	{[]byte{0xcb, 0x30}, "SLL B"},
+	{[]byte{0xcb, 0x7e}, "BIT 7,(HL)"},
+	{[]byte{0xcb, 0xc7}, "SET 0,A"},
+
+	// ED prefix
+	{[]byte{0xed, 0x70}, "IN F,(C)"},
+	{[]byte{0xed, 0x71}, "OUT (C),0"},
+	{[]byte{0xed, 0x78}, "IN A,(C)"},
+	{[]byte{0xed, 0x4b, 0x00, 0x60}, "LD BC,($6000)"},
+	{[]byte{0xed, 0x52}, "SBC HL,DE"},
+	{[]byte{0xed, 0x4c}, "NEG"},
+	{[]byte{0xed, 0x4d}, "RETI"},
+	{[]byte{0xed, 0x5e}, "IM 2"},
+	{[]byte{0xed, 0x5f}, "LD A,R"},
+	{[]byte{0xed, 0xb0}, "LDIR"},
+	{[]byte{0xed, 0xbb}, "OTDR"},
+	{[]byte{0xed, 0x00}, "DEFB $ED,$00"},
+
+	// DD and FD prefixes
+	{[]byte{0xdd, 0x21, 0x00, 0x5c}, "LD IX,$5C00"},
+	{[]byte{0xdd, 0x7e, 0x05}, "LD A,(IX+$05)"},
+	{[]byte{0xfd, 0x75, 0xfd}, "LD (IY-$03),L"},
+	{[]byte{0xdd, 0x36, 0x02, 0x99}, "LD (IX+$02),$99"},
+	{[]byte{0xdd, 0x64}, "LD IXH,IXH"},
+	{[]byte{0xfd, 0x6f}, "LD IYL,A"},
+	{[]byte{0xdd, 0x24}, "INC IXH"},
+	{[]byte{0xfd, 0x86, 0x01}, "ADD A,(IY+$01)"},
+	{[]byte{0xdd, 0x29}, "ADD IX,IX"},
+	{[]byte{0xdd, 0xe9}, "JP (IX)"},
+	{[]byte{0xfd, 0xe5}, "PUSH IY"},
+	{[]byte{0xdd, 0x00}, "NOP"},
+
+	// DDCB and FDCB prefixes
+	{[]byte{0xdd, 0xcb, 0x05, 0x06}, "RLC (IX+$05)"},
+	{[]byte{0xdd, 0xcb, 0x05, 0x00}, "RLC (IX+$05),B"},
+	{[]byte{0xfd, 0xcb, 0x01, 0x4e}, "BIT 1,(IY+$01)"},
+	{[]byte{0xfd, 0xcb, 0xff, 0xc7}, "SET 0,(IY-$01),A"},
+	{[]byte{0xdd, 0xcb, 0x10, 0xbe}, "RES 7,(IX+$10)"},
+}
+
+func (t *testSuite) TestDisassemble() {
+	for _, test := range tests {
+		instr := Disassemble(Bytes{Origin: 0x8000, Data: test.bytes}, 0x8000)
+		t.Equal(test.mnemonic, instr.Mnemonic)
+		t.Equal(len(test.bytes), instr.Len())
+	}
+}
+
+func (t *testSuite) TestDisassemble_prefixSequence() {
+	// A DD or FD prefix followed by another prefix is a single-byte NOP
+	instructions := DisassembleBytes([]byte{0xdd, 0xfd, 0xed, 0x78}, 0)
+
+	t.Equal(3, len(instructions))
+	if !t.Failed() {
+		t.Equal("DEFB $DD", instructions[0].Mnemonic)
+		t.Equal("DEFB $FD", instructions[1].Mnemonic)
+		t.Equal("IN A,(C)", instructions[2].Mnemonic)
+	}
+}
+
+func (t *testSuite) TestDisassembleBytes() {
+	code := []byte{0x21, 0x00, 0x40, 0xdd, 0xcb, 0x05, 0x06, 0xc9}
+	instructions := DisassembleBytes(code, 0x8000)
+
+	t.Equal(3, len(instructions))
+	if !t.Failed() {
+		t.Equal(uint16(0x8003), instructions[1].Address)
+		t.Equal(uint16(0x8007), instructions[2].Address)
+		t.Equal("RET", instructions[2].Mnemonic)
+		t.Equal("8003  dd cb 05 06   RLC (IX+$05)", instructions[1].String())
+	}
+}
+
+func (t *testSuite) TestDisassembleN() {
+	// The address wraps around at the end of memory
+	memory := Bytes{Origin: 0xffff, Data: []byte{0x3e}}
+	instructions := DisassembleN(memory, 0xffff, 2)
+
+	t.Equal("LD A,$00", instructions[0].Mnemonic)
+	t.Equal(uint16(0x0001), instructions[1].Address)
+}
+
+func TestDisasm(t *testing.T) {
+	prettytest.Run(t, new(testSuite))
+}
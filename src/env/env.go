@@ -2,17 +2,51 @@ package env
 
 import "reflect"
 
+// An environment in which agents publish objects and wait for them.
+// Agents which should not see each other's objects, such as several
+// emulated machines running in one process, can use separate environments.
+type Env struct {
+	// Set of all published objects
+	objects map[reflect.Type]*objectInfo_t
+
+	// Set of all published named objects
+	namedObjects map[string]*objectInfo_t
+
+	commandChannel chan interface{}
+}
+
+// Creates a new empty environment
+func NewEnv() *Env {
+	e := &Env{
+		objects:        make(map[reflect.Type]*objectInfo_t),
+		namedObjects:   make(map[string]*objectInfo_t),
+		commandChannel: make(chan interface{}),
+	}
+
+	go e.commandLoop()
+
+	return e
+}
+
+// The environment used by the package-level functions
+var defaultEnv = NewEnv()
+
+// Returns the environment used by the package-level functions
+func Default() *Env {
+	return defaultEnv
+}
+
 // Publish an object for other agents to find.
 //
 // In case the environment already contains an object of the same type,
 // this function returns an error.
-func Publish(object interface{}) (PublishedObject, error) {
+func (e *Env) Publish(object interface{}) (PublishedObject, error) {
 	if object == nil {
 		panic("nil object")
 	}
 
 	resultCh := make(chan cmd_publish_result)
-	commandChannel <- cmd_publish{
+	e.commandChannel <- cmd_publish{
 		name_orEmpty: "",
 		object:       object,
 		resultCh:     resultCh,
@@ -32,7 +66,7 @@ type PublishedObject interface {
 //
 // In case the environment already contains an object with the same name,
 // this function returns an error.
-func PublishName(name string, object interface{}) (PublishedObject, error) {
+func (e *Env) PublishName(name string, object interface{}) (PublishedObject, error) {
 	if name == "" {
 		panic("empty name")
 	}
@@ -41,7 +75,7 @@ func PublishName(name string, object interface{}) (PublishedObject, error) {
 	}
 
 	resultCh := make(chan cmd_publish_result)
-	commandChannel <- cmd_publish{
+	e.commandChannel <- cmd_publish{
 		name_orEmpty: name,
 		object:       object,
 		resultCh:     resultCh,
@@ -54,13 +88,13 @@ func PublishName(name string, object interface{}) (PublishedObject, error) {
 //
 // If there is currently no object of such a type in the environment,
 // this function returns nil.
-func Find(objectType reflect.Type) (object_orNil interface{}) {
+func (e *Env) Find(objectType reflect.Type) (object_orNil interface{}) {
 	if objectType == nil {
 		panic("nil objectType")
 	}
 
 	resultCh := make(chan cmd_find_result)
-	commandChannel <- cmd_find{
+	e.commandChannel <- cmd_find{
 		objectType: objectType,
 		resultCh:   resultCh,
 	}
@@ -72,13 +106,13 @@ func Find(objectType reflect.Type) (object_orNil interface{}) {
 //
 // If there is currently no object with such a name in the environment,
 // this function returns nil.
-func FindName(name string) (object_orNil interface{}) {
+func (e *Env) FindName(name string) (object_orNil interface{}) {
 	if name == "" {
 		panic("empty name")
 	}
 
 	resultCh := make(chan cmd_find_result)
-	commandChannel <- cmd_findName{
+	e.commandChannel <- cmd_findName{
 		name:     name,
 		resultCh: resultCh,
 	}
@@ -92,13 +126,13 @@ func FindName(name string) (object_orNil interface{}) {
 // this function returns immediately.
 //
 // This function never returns nil.
-func Wait(objectType reflect.Type) (object interface{}) {
+func (e *Env) Wait(objectType reflect.Type) (object interface{}) {
 	if objectType == nil {
 		panic("nil objectType")
 	}
 
 	resultCh := make(chan cmd_wait_result)
-	commandChannel <- cmd_wait{
+	e.commandChannel <- cmd_wait{
 		objectType: objectType,
 		resultCh:   resultCh,
 	}
@@ -112,13 +146,13 @@ func Wait(objectType reflect.Type) (object interface{}) {
 // this function returns immediately.
 //
 // This function never returns nil.
-func WaitName(name string) (object interface{}) {
+func (e *Env) WaitName(name string) (object interface{}) {
 	if name == "" {
 		panic("empty name")
 	}
 
 	resultCh := make(chan cmd_wait_result)
-	commandChannel <- cmd_waitName{
+	e.commandChannel <- cmd_waitName{
 		name:     name,
 		resultCh: resultCh,
 	}
@@ -130,13 +164,13 @@ func WaitName(name string) (object interface{}) {
 // When the object gets published, it will be sent to the specified channel.
 //
 // This function return immediately.
-func WaitAsync(objectType reflect.Type, ch chan<- interface{}) {
+func (e *Env) WaitAsync(objectType reflect.Type, ch chan<- interface{}) {
 	if objectType == nil {
 		panic("nil objectType")
 	}
 
 	go func() {
-		ch <- Wait(objectType)
+		ch <- e.Wait(objectType)
 	}()
 }
 
@@ -144,12 +178,56 @@ func WaitAsync(objectType reflect.Type, ch chan<- interface{}) {
 // When the object gets published, it will be sent to the specified channel.
 //
 // This function return immediately.
-func WaitNameAsync(name string, ch chan<- interface{}) {
+func (e *Env) WaitNameAsync(name string, ch chan<- interface{}) {
 	if name == "" {
 		panic("empty name")
 	}
 
 	go func() {
-		ch <- WaitName(name)
+		ch <- e.WaitName(name)
 	}()
 }
+
+// =======================
+// The default environment
+// =======================
+
+// Same as Default().Publish(object)
+func Publish(object interface{}) (PublishedObject, error) {
+	return defaultEnv.Publish(object)
+}
+
+// Same as Default().PublishName(name, object)
+func PublishName(name string, object interface{}) (PublishedObject, error) {
+	return defaultEnv.PublishName(name, object)
+}
+
+// Same as Default().Find(objectType)
+func Find(objectType reflect.Type) (object_orNil interface{}) {
+	return defaultEnv.Find(objectType)
+}
+
+// Same as Default().FindName(name)
+func FindName(name string) (object_orNil interface{}) {
+	return defaultEnv.FindName(name)
+}
+
+// Same as Default().Wait(objectType)
+func Wait(objectType reflect.Type) (object interface{}) {
+	return defaultEnv.Wait(objectType)
+}
+
+// Same as Default().WaitName(name)
+func WaitName(name string) (object interface{}) {
+	return defaultEnv.WaitName(name)
+}
+
+// Same as Default().WaitAsync(objectType, ch)
+func WaitAsync(objectType reflect.Type, ch chan<- interface{}) {
+	defaultEnv.WaitAsync(objectType, ch)
+}
+
+// Same as Default().WaitNameAsync(name, ch)
+func WaitNameAsync(name string, ch chan<- interface{}) {
+	defaultEnv.WaitNameAsync(name, ch)
+}
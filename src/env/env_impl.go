@@ -6,6 +6,8 @@ import (
 )
 
 type objectInfo_t struct {
+	env *Env
+
 	name_orEmpty string
 
 	objectType reflect.Type
@@ -20,7 +22,7 @@ type objectInfo_t struct {
 
 func (i *objectInfo_t) Remove() {
 	resultCh := make(chan cmd_remove_result)
-	commandChannel <- cmd_remove{
+	i.env.commandChannel <- cmd_remove{
 		name_orEmpty: i.name_orEmpty,
 		objectType:   i.objectType,
 		resultCh:     resultCh,
@@ -31,14 +33,6 @@ func (i *objectInfo_t) Remove() {
 	}
 }
 
-// Set of all published objects
-var objects = make(map[reflect.Type]*objectInfo_t)
-
-// Set of all published named objects
-var namedObjects = make(map[string]*objectInfo_t)
-
-var commandChannel = make(chan interface{})
-
 // Publish
 type cmd_publish struct {
 	name_orEmpty string
@@ -51,18 +45,19 @@ type cmd_publish_result struct {
 	err error
 }
 
-func do_publish(cmd cmd_publish) {
+func (e *Env) do_publish(cmd cmd_publish) {
 	objectType := reflect.TypeOf(cmd.object)
 
 	var info *objectInfo_t
 	if cmd.name_orEmpty != "" {
-		info = namedObjects[cmd.name_orEmpty]
+		info = e.namedObjects[cmd.name_orEmpty]
 	} else {
-		info = objects[objectType]
+		info = e.objects[objectType]
 	}
 
 	if info == nil {
 		info = &objectInfo_t{
+			env:          e,
 			name_orEmpty: cmd.name_orEmpty,
 			objectType:   objectType,
 			object_orNil: cmd.object,
@@ -70,9 +65,9 @@ func do_publish(cmd cmd_publish) {
 		}
 
 		if cmd.name_orEmpty != "" {
-			namedObjects[cmd.name_orEmpty] = info
+			e.namedObjects[cmd.name_orEmpty] = info
 		} else {
-			objects[objectType] = info
+			e.objects[objectType] = info
 		}
 	} else {
 		if info.object_orNil != nil {
@@ -122,10 +117,10 @@ type cmd_find_result struct {
 	object_orNil interface{}
 }
 
-func do_find(cmd cmd_find) {
+func (e *Env) do_find(cmd cmd_find) {
 	var object_orNil interface{} = nil
 	{
-		info := objects[cmd.objectType]
+		info := e.objects[cmd.objectType]
 		if info != nil {
 			object_orNil = info.object_orNil
 		}
@@ -136,10 +131,10 @@ func do_find(cmd cmd_find) {
 	}
 }
 
-func do_findName(cmd cmd_findName) {
+func (e *Env) do_findName(cmd cmd_findName) {
 	var object_orNil interface{} = nil
 	{
-		info := namedObjects[cmd.name]
+		info := e.namedObjects[cmd.name]
 		if info != nil {
 			object_orNil = info.object_orNil
 		}
@@ -165,16 +160,17 @@ type cmd_wait_result struct {
 	object interface{}
 }
 
-func do_wait(cmd cmd_wait) {
-	info := objects[cmd.objectType]
+func (e *Env) do_wait(cmd cmd_wait) {
+	info := e.objects[cmd.objectType]
 	if info == nil {
 		// Wait for the object to be published
 		info = &objectInfo_t{
+			env:          e,
 			objectType:   cmd.objectType,
 			object_orNil: nil,
 			waiters:      []chan<- cmd_wait_result{cmd.resultCh},
 		}
-		objects[cmd.objectType] = info
+		e.objects[cmd.objectType] = info
 	} else if info.object_orNil == nil {
 		// Wait for the object to be published
 		info.waiters = append(info.waiters, cmd.resultCh)
@@ -186,17 +182,18 @@ func do_wait(cmd cmd_wait) {
 	}
 }
 
-func do_waitName(cmd cmd_waitName) {
-	info := namedObjects[cmd.name]
+func (e *Env) do_waitName(cmd cmd_waitName) {
+	info := e.namedObjects[cmd.name]
 	if info == nil {
 		// Wait for the object to be published
 		info = &objectInfo_t{
+			env:          e,
 			name_orEmpty: cmd.name,
 			objectType:   nil,
 			object_orNil: nil,
 			waiters:      []chan<- cmd_wait_result{cmd.resultCh},
 		}
-		namedObjects[cmd.name] = info
+		e.namedObjects[cmd.name] = info
 	} else if info.object_orNil == nil {
 		// Wait for the object to be published
 		info.waiters = append(info.waiters, cmd.resultCh)
@@ -219,15 +216,15 @@ type cmd_remove_result struct {
 	err error
 }
 
-func do_remove(cmd cmd_remove) {
+func (e *Env) do_remove(cmd cmd_remove) {
 	if cmd.name_orEmpty != "" {
-		info := namedObjects[cmd.name_orEmpty]
+		info := e.namedObjects[cmd.name_orEmpty]
 		if info != nil {
 			if len(info.waiters) > 0 {
 				panic("this cannot happen")
 			}
 
-			delete(namedObjects, cmd.name_orEmpty)
+			delete(e.namedObjects, cmd.name_orEmpty)
 			cmd.resultCh <- cmd_remove_result{
 				err: nil,
 			}
@@ -237,13 +234,13 @@ func do_remove(cmd cmd_remove) {
 			}
 		}
 	} else {
-		info := objects[cmd.objectType]
+		info := e.objects[cmd.objectType]
 		if info != nil {
 			if len(info.waiters) > 0 {
 				panic("this cannot happen")
 			}
 
-			delete(objects, cmd.objectType)
+			delete(e.objects, cmd.objectType)
 			cmd.resultCh <- cmd_remove_result{
 				err: nil,
 			}
@@ -256,25 +253,21 @@ func do_remove(cmd cmd_remove) {
 }
 
 // The main command loop, running in a separate goroutine
-func commandLoop() {
-	for untypedCommand := range commandChannel {
+func (e *Env) commandLoop() {
+	for untypedCommand := range e.commandChannel {
 		switch cmd := untypedCommand.(type) {
 		case cmd_publish:
-			do_publish(cmd)
+			e.do_publish(cmd)
 		case cmd_find:
-			do_find(cmd)
+			e.do_find(cmd)
 		case cmd_findName:
-			do_findName(cmd)
+			e.do_findName(cmd)
 		case cmd_wait:
-			do_wait(cmd)
+			e.do_wait(cmd)
 		case cmd_waitName:
-			do_waitName(cmd)
+			e.do_waitName(cmd)
 		case cmd_remove:
-			do_remove(cmd)
+			e.do_remove(cmd)
 		}
 	}
 }
-
-func init() {
-	go commandLoop()
-}
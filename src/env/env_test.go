@@ -11,7 +11,6 @@ type T struct{}
 var waitGroup sync.WaitGroup
 
 func add() {
-	waitGroup.Add(1)
 	Wait(reflect.TypeOf(T{}))
 	waitGroup.Done()
 }
@@ -28,6 +27,8 @@ func publish_remove() error {
 }
 
 func Test1(t *testing.T) {
+	// The goroutines are added before publish_remove waits for them
+	waitGroup.Add(2)
 	go add()
 	go add()
 
@@ -36,3 +37,26 @@ func Test1(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSeparateEnvironments(t *testing.T) {
+	e1 := NewEnv()
+	e2 := NewEnv()
+
+	pub, err := e1.Publish(T{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Remove()
+
+	if e2.Find(reflect.TypeOf(T{})) != nil {
+		t.Fatal("object published in one environment is visible in another one")
+	}
+
+	_, err = e2.Publish(T{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e1.Find(reflect.TypeOf(T{})) == nil {
+		t.Fatal("object not found")
+	}
+}
@@ -0,0 +1,333 @@
+package formats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// RZX block IDs
+const (
+	RZX_BLOCK_CREATOR         = 0x10
+	RZX_BLOCK_SECURITY_INFO   = 0x20
+	RZX_BLOCK_SECURITY_SIGN   = 0x21
+	RZX_BLOCK_SNAPSHOT        = 0x30
+	RZX_BLOCK_INPUT_RECORDING = 0x80
+)
+
+const rzxSignature = "RZX!"
+
+const (
+	_RZX_HEADER_SIZE       = 10
+	_RZX_BLOCK_HEADER_SIZE = 5
+	_RZX_MAJOR_VERSION     = 0
+	_RZX_MINOR_VERSION     = 13
+
+	_RZX_CREATOR_SIZE   = 29
+	_RZX_SNAPSHOT_SIZE  = 17
+	_RZX_RECORDING_SIZE = 18
+
+	_RZX_SNAPSHOT_EXTERNAL    = 0x01
+	_RZX_SNAPSHOT_COMPRESSED  = 0x02
+	_RZX_RECORDING_PROTECTED  = 0x01
+	_RZX_RECORDING_COMPRESSED = 0x02
+
+	// The IN counter of a frame which repeats the values of the previous frame
+	_RZX_REPEATED_FRAME = 0xffff
+)
+
+// The input of the emulated machine during one frame
+type RZXFrame struct {
+	// The number of opcode fetches (M1 cycles) executed during the frame
+	FetchCount uint16
+
+	// The values returned by the IN instructions executed during the frame
+	Inputs []byte
+}
+
+// An input recording: the snapshot from which the recording starts,
+// and the input of each frame following the snapshot.
+type RZX struct {
+	// The name of the emulator which created the recording
+	Creator string
+
+	Snapshot Snapshot
+
+	// The number of T-states since the last interrupt at the start of the recording
+	TStates uint32
+
+	Frames []RZXFrame
+}
+
+// Decode an RZX input recording from binary data.
+// Only the first snapshot is used, the frames of all input recording blocks are concatenated.
+func NewRZX(data []byte) (*RZX, error) {
+	if (len(data) < _RZX_HEADER_SIZE) || (string(data[0:4]) != rzxSignature) {
+		return nil, errors.New("invalid RZX file")
+	}
+	if data[4] != _RZX_MAJOR_VERSION {
+		return nil, fmt.Errorf("unsupported RZX version %d.%d", data[4], data[5])
+	}
+
+	var rzx RZX
+	var haveRecording bool
+
+	i := _RZX_HEADER_SIZE
+	for i < len(data) {
+		if i+_RZX_BLOCK_HEADER_SIZE > len(data) {
+			return nil, errors.New("invalid RZX file: truncated block header")
+		}
+
+		id := data[i]
+		size := binary.LittleEndian.Uint32(data[i+1 : i+5])
+		if (size < _RZX_BLOCK_HEADER_SIZE) || (uint64(size) > uint64(len(data)-i)) {
+			return nil, fmt.Errorf("invalid RZX file: invalid length of block 0x%02x", id)
+		}
+
+		block := data[i+_RZX_BLOCK_HEADER_SIZE : i+int(size)]
+		i += int(size)
+
+		switch id {
+		case RZX_BLOCK_CREATOR:
+			if len(block) < _RZX_CREATOR_SIZE-_RZX_BLOCK_HEADER_SIZE {
+				return nil, errors.New("invalid RZX file: invalid creator block")
+			}
+			rzx.Creator = strings.TrimRight(string(block[0:20]), "\x00")
+
+		case RZX_BLOCK_SECURITY_INFO, RZX_BLOCK_SECURITY_SIGN:
+			return nil, errors.New("read RZX file: signed recordings are not supported")
+
+		case RZX_BLOCK_SNAPSHOT:
+			if rzx.Snapshot != nil {
+				// Snapshots taken in the middle of the recording are not needed
+				continue
+			}
+			snapshot, err := readRZXSnapshot(block)
+			if err != nil {
+				return nil, err
+			}
+			rzx.Snapshot = snapshot
+
+		case RZX_BLOCK_INPUT_RECORDING:
+			if len(block) < _RZX_RECORDING_SIZE-_RZX_BLOCK_HEADER_SIZE {
+				return nil, errors.New("invalid RZX file: invalid input recording block")
+			}
+			if !haveRecording {
+				rzx.TStates = binary.LittleEndian.Uint32(block[5:9])
+				haveRecording = true
+			}
+			err := rzx.readInputRecording(block)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if rzx.Snapshot == nil {
+		return nil, errors.New("invalid RZX file: no snapshot")
+	}
+	if !haveRecording {
+		return nil, errors.New("invalid RZX file: no input recording")
+	}
+
+	return &rzx, nil
+}
+
+// Decodes the snapshot embedded in a snapshot block
+func readRZXSnapshot(block []byte) (Snapshot, error) {
+	if len(block) < _RZX_SNAPSHOT_SIZE-_RZX_BLOCK_HEADER_SIZE {
+		return nil, errors.New("invalid RZX file: invalid snapshot block")
+	}
+
+	flags := binary.LittleEndian.Uint32(block[0:4])
+	extension := strings.ToLower(strings.TrimRight(string(block[4:8]), "\x00"))
+	length := binary.LittleEndian.Uint32(block[8:12])
+
+	if (flags & _RZX_SNAPSHOT_EXTERNAL) != 0 {
+		return nil, errors.New("read RZX file: external snapshots are not supported")
+	}
+
+	data := block[12:]
+	if (flags & _RZX_SNAPSHOT_COMPRESSED) != 0 {
+		var err error
+		data, err = inflate(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if uint32(len(data)) != length {
+		return nil, errors.New("invalid RZX file: invalid size of the snapshot")
+	}
+
+	format, ok := formatFromExtension("snapshot." + extension)
+	if !ok {
+		return nil, fmt.Errorf("read RZX file: unsupported snapshot format \"%s\"", extension)
+	}
+
+	return SnapshotData(data).Decode(format)
+}
+
+// Appends the frames of an input recording block
+func (rzx *RZX) readInputRecording(block []byte) error {
+	numFrames := binary.LittleEndian.Uint32(block[0:4])
+	flags := binary.LittleEndian.Uint32(block[9:13])
+
+	if (flags & _RZX_RECORDING_PROTECTED) != 0 {
+		return errors.New("read RZX file: encrypted recordings are not supported")
+	}
+
+	data := block[13:]
+	if (flags & _RZX_RECORDING_COMPRESSED) != 0 {
+		var err error
+		data, err = inflate(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	var previous []byte
+	pos := 0
+	for n := uint32(0); n < numFrames; n++ {
+		if pos+4 > len(data) {
+			return errors.New("invalid RZX file: truncated input recording")
+		}
+
+		fetchCount := binary.LittleEndian.Uint16(data[pos:])
+		inCount := int(binary.LittleEndian.Uint16(data[pos+2:]))
+		pos += 4
+
+		var inputs []byte
+		if inCount == _RZX_REPEATED_FRAME {
+			inputs = previous
+		} else {
+			if pos+inCount > len(data) {
+				return errors.New("invalid RZX file: truncated input recording")
+			}
+			inputs = data[pos : pos+inCount]
+			pos += inCount
+		}
+
+		rzx.Frames = append(rzx.Frames, RZXFrame{FetchCount: fetchCount, Inputs: inputs})
+		previous = inputs
+	}
+
+	return nil
+}
+
+// Turn the input recording into binary data (RZX format, version 0.13).
+// The snapshot is embedded in the SZX format. All blocks are compressed using zlib.
+func (rzx *RZX) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.Write([]byte{'R', 'Z', 'X', '!', _RZX_MAJOR_VERSION, _RZX_MINOR_VERSION, 0, 0, 0, 0})
+
+	// Creator
+	{
+		var creator [_RZX_CREATOR_SIZE - _RZX_BLOCK_HEADER_SIZE]byte
+		copy(creator[0:19], rzx.Creator)
+		writeRZXBlock(&buf, RZX_BLOCK_CREATOR, creator[:])
+	}
+
+	// Snapshot
+	{
+		snapshot, ok := rzx.Snapshot.(*FullSnapshot)
+		if !ok {
+			snapshot = newFullSnapshot(rzx.Snapshot)
+		}
+
+		szx, err := snapshot.EncodeSZX()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := deflate(szx)
+		if err != nil {
+			return nil, err
+		}
+
+		var header [_RZX_SNAPSHOT_SIZE - _RZX_BLOCK_HEADER_SIZE]byte
+		binary.LittleEndian.PutUint32(header[0:], _RZX_SNAPSHOT_COMPRESSED)
+		copy(header[4:8], "szx")
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(szx)))
+		writeRZXBlock(&buf, RZX_BLOCK_SNAPSHOT, append(header[:], compressed...))
+	}
+
+	// Input recording
+	{
+		var frames bytes.Buffer
+		var previous []byte
+		for i, frame := range rzx.Frames {
+			if len(frame.Inputs) >= _RZX_REPEATED_FRAME {
+				return nil, fmt.Errorf("write RZX file: too many inputs in frame %d", i)
+			}
+
+			var counts [4]byte
+			binary.LittleEndian.PutUint16(counts[0:], frame.FetchCount)
+			if (i > 0) && (len(frame.Inputs) > 0) && bytes.Equal(frame.Inputs, previous) {
+				binary.LittleEndian.PutUint16(counts[2:], _RZX_REPEATED_FRAME)
+				frames.Write(counts[:])
+			} else {
+				binary.LittleEndian.PutUint16(counts[2:], uint16(len(frame.Inputs)))
+				frames.Write(counts[:])
+				frames.Write(frame.Inputs)
+			}
+			previous = frame.Inputs
+		}
+
+		compressed, err := deflate(frames.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		var header [_RZX_RECORDING_SIZE - _RZX_BLOCK_HEADER_SIZE]byte
+		binary.LittleEndian.PutUint32(header[0:], uint32(len(rzx.Frames)))
+		binary.LittleEndian.PutUint32(header[5:], rzx.TStates)
+		binary.LittleEndian.PutUint32(header[9:], _RZX_RECORDING_COMPRESSED)
+		writeRZXBlock(&buf, RZX_BLOCK_INPUT_RECORDING, append(header[:], compressed...))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeRZXBlock(buf *bytes.Buffer, id byte, data []byte) {
+	var header [_RZX_BLOCK_HEADER_SIZE]byte
+	header[0] = id
+	binary.LittleEndian.PutUint32(header[1:], uint32(_RZX_BLOCK_HEADER_SIZE+len(data)))
+	buf.Write(header[:])
+	buf.Write(data)
+}
+
+// Copies the state of a snapshot into a FullSnapshot
+func newFullSnapshot(s Snapshot) *FullSnapshot {
+	full := &FullSnapshot{Cpu: s.CpuState(), Ula: s.UlaState(), Mem: *s.Memory()}
+	if s128k, ok := s.(Snapshot128k); ok && (s128k.State128k() != nil) {
+		state := *s128k.State128k()
+		full.Machine128k = &state
+	}
+	return full
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package formats
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+func (t *testSuite) TestEncodeRZX() {
+	data, err := ioutil.ReadFile("testdata/fire.sna")
+	t.Nil(err)
+	sna, err := SnapshotData(data).DecodeSNA()
+	t.Nil(err)
+
+	if !t.Failed() {
+		rzx := &RZX{
+			Creator:  "GoSpeccy",
+			Snapshot: sna,
+			TStates:  1234,
+			Frames: []RZXFrame{
+				{FetchCount: 100, Inputs: []byte{0xbf, 0xff}},
+				{FetchCount: 200, Inputs: []byte{0xbf, 0xff}},
+				{FetchCount: 300, Inputs: nil},
+				{FetchCount: 400, Inputs: []byte{0x1f}},
+			},
+		}
+
+		encoded, err := rzx.Encode()
+		t.Nil(err)
+		t.Equal("RZX!", string(encoded[0:4]))
+
+		decoded, err := NewRZX(encoded)
+		t.Nil(err)
+
+		if !t.Failed() {
+			t.Equal("GoSpeccy", decoded.Creator)
+			t.Equal(uint32(1234), decoded.TStates)
+			t.Equal(sna.CpuState(), decoded.Snapshot.CpuState())
+			t.True(bytes.Equal(sna.Memory()[:], decoded.Snapshot.Memory()[:]))
+
+			t.Equal(len(rzx.Frames), len(decoded.Frames))
+			if !t.Failed() {
+				for i, frame := range rzx.Frames {
+					t.Equal(frame.FetchCount, decoded.Frames[i].FetchCount)
+					t.Equal(string(frame.Inputs), string(decoded.Frames[i].Inputs))
+				}
+			}
+		}
+
+		format, err := DetectFormatFromBytes(encoded)
+		t.Nil(err)
+		if !t.Failed() {
+			t.Equal(FORMAT_RZX, format.Format)
+		}
+	}
+}
+
+func (t *testSuite) TestDecodeRZXError() {
+	_, err := NewRZX([]byte("RZX!"))
+	t.Not(t.Nil(err))
+
+	// No snapshot and no input recording
+	_, err = NewRZX([]byte{'R', 'Z', 'X', '!', 0, 13, 0, 0, 0, 0})
+	t.Not(t.Nil(err))
+
+	// Truncated block
+	_, err = NewRZX([]byte{'R', 'Z', 'X', '!', 0, 13, 0, 0, 0, 0, RZX_BLOCK_SNAPSHOT, 100, 0, 0, 0})
+	t.Not(t.Nil(err))
+}
@@ -69,8 +69,13 @@ func (data SnapshotData) DecodeSNA() (*SNA, error) {
 	return &s, nil
 }
 
-// Turn snapshot into binary data (SNA format)
+// Turn snapshot into binary data (SNA format).
+// The SNA format cannot hold the state of a 128k machine.
 func (s *FullSnapshot) EncodeSNA() ([]byte, error) {
+	if s.Machine128k != nil {
+		return nil, errors.New("the SNA format does not support 128k snapshots, use the Z80 or SZX format")
+	}
+
 	var data [49179]byte
 
 	// Save registers
@@ -0,0 +1,16 @@
+package formats
+
+func (t *testSuite) TestEncodeSNA_128k() {
+	var snapshot FullSnapshot
+	snapshot.Cpu.SP = 0x8000
+	snapshot.Machine128k = new(State128k)
+
+	// The SNA format would silently drop the 128k state
+	_, err := snapshot.EncodeSNA()
+	t.Not(t.Nil(err))
+
+	snapshot.Machine128k = nil
+	encoded, err := snapshot.EncodeSNA()
+	t.Nil(err)
+	t.Equal(49179, len(encoded))
+}
@@ -0,0 +1,501 @@
+package formats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Machine identifiers used by the SZX format
+const (
+	SZX_MACHINE_16K = iota
+	SZX_MACHINE_48K
+	SZX_MACHINE_128K
+	SZX_MACHINE_PLUS2
+	SZX_MACHINE_PLUS2A
+	SZX_MACHINE_PLUS3
+	SZX_MACHINE_PLUS3E
+	SZX_MACHINE_PENTAGON128
+	SZX_MACHINE_TC2048
+	SZX_MACHINE_TC2068
+	SZX_MACHINE_SCORPION
+	SZX_MACHINE_SE
+	SZX_MACHINE_TS2068
+	SZX_MACHINE_PENTAGON512
+	SZX_MACHINE_PENTAGON1024
+	SZX_MACHINE_NTSC48K
+	SZX_MACHINE_128KE
+)
+
+// Joystick types used by the SZX format
+const (
+	SZX_JOYSTICK_KEMPSTON = iota
+	SZX_JOYSTICK_FULLER
+	SZX_JOYSTICK_CURSOR
+	SZX_JOYSTICK_SINCLAIR1
+	SZX_JOYSTICK_SINCLAIR2
+	SZX_JOYSTICK_SPECTRUMPLUS
+	SZX_JOYSTICK_TIMEX1
+	SZX_JOYSTICK_TIMEX2
+	SZX_JOYSTICK_NONE
+)
+
+const szxSignature = "ZXST"
+
+const (
+	_SZX_HEADER_SIZE       = 8
+	_SZX_CHUNK_HEADER_SIZE = 8
+	_SZX_MAJOR_VERSION     = 1
+	_SZX_MINOR_VERSION     = 4
+
+	_SZX_Z80R_SIZE = 37
+	_SZX_SPCR_SIZE = 8
+	_SZX_AY_SIZE   = 18
+	_SZX_JOY_SIZE  = 6
+	_SZX_KEYB_SIZE = 5
+	_SZX_PLTT_SIZE = 66
+	_SZX_SCLD_SIZE = 2
+
+	_SZX_Z80R_EILAST     = 0x01
+	_SZX_Z80R_HALTED     = 0x02
+	_SZX_RAMP_COMPRESSED = 0x01
+	_SZX_AY_128AY        = 0x02
+	_SZX_KEYB_ISSUE2     = 0x01
+	_SZX_PLTT_ENABLED    = 0x01
+)
+
+type SZX struct {
+	cpu CpuState
+	ula UlaState
+	mem [48 * 1024]byte
+
+	machineId byte
+	state128k *State128k // nil in case of a 48k machine
+	issue2    bool
+	joystick  byte
+}
+
+// Decode [SZX snapshot] from binary data.
+// Only the chunks relevant to the 16k, 48k and 128k machines are interpreted,
+// all other chunks are ignored.
+func (data SnapshotData) DecodeSZX() (*SZX, error) {
+	if (len(data) < _SZX_HEADER_SIZE) || (string(data[0:4]) != szxSignature) {
+		return nil, errors.New("invalid SZX snapshot")
+	}
+	if data[4] != _SZX_MAJOR_VERSION {
+		return nil, fmt.Errorf("unsupported SZX snapshot version %d.%d", data[4], data[5])
+	}
+
+	var s SZX
+	s.machineId = data[6]
+	s.joystick = SZX_JOYSTICK_NONE
+
+	switch s.machineId {
+	case SZX_MACHINE_16K, SZX_MACHINE_48K, SZX_MACHINE_NTSC48K, SZX_MACHINE_TC2048:
+		// 48k
+	case SZX_MACHINE_128K, SZX_MACHINE_PLUS2:
+		s.state128k = new(State128k)
+	default:
+		return nil, fmt.Errorf("read SZX snapshot: unsupported machine (id %d)", s.machineId)
+	}
+
+	var ram [8][]byte
+	var haveRegisters bool
+
+	i := _SZX_HEADER_SIZE
+	for i < len(data) {
+		if i+_SZX_CHUNK_HEADER_SIZE > len(data) {
+			return nil, errors.New("invalid SZX snapshot: truncated chunk header")
+		}
+
+		id := string(data[i : i+4])
+		size := binary.LittleEndian.Uint32(data[i+4 : i+8])
+		i += _SZX_CHUNK_HEADER_SIZE
+
+		if uint64(size) > uint64(len(data)-i) {
+			return nil, errors.New("invalid SZX snapshot: truncated chunk \"" + id + "\"")
+		}
+
+		chunk := data[i : i+int(size)]
+		i += int(size)
+
+		switch id {
+		case "Z80R":
+			if len(chunk) < _SZX_Z80R_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid Z80R chunk")
+			}
+			err := s.readZ80R(chunk)
+			if err != nil {
+				return nil, err
+			}
+			haveRegisters = true
+
+		case "SPCR":
+			if len(chunk) < _SZX_SPCR_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid SPCR chunk")
+			}
+			s.ula.Border = chunk[0] & 0x07
+			if s.state128k != nil {
+				s.state128k.Port7ffd = chunk[1]
+			}
+
+		case "RAMP":
+			if len(chunk) < 3 {
+				return nil, errors.New("invalid SZX snapshot: invalid RAMP chunk")
+			}
+			page, pageData, err := readRAMP(chunk)
+			if err != nil {
+				return nil, err
+			}
+			ram[page] = pageData
+
+		case "AY\x00\x00":
+			if len(chunk) < _SZX_AY_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid AY chunk")
+			}
+			if s.state128k != nil {
+				s.state128k.Ay.SelectedRegister = chunk[1]
+				copy(s.state128k.Ay.Registers[:], chunk[2:18])
+			}
+
+		case "JOY\x00":
+			if len(chunk) < _SZX_JOY_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid JOY chunk")
+			}
+			s.joystick = chunk[4]
+
+		case "KEYB":
+			if len(chunk) < _SZX_KEYB_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid KEYB chunk")
+			}
+			s.issue2 = (binary.LittleEndian.Uint32(chunk[0:4]) & _SZX_KEYB_ISSUE2) != 0
+			if s.issue2 {
+				s.ula.Issue = 2
+			} else {
+				s.ula.Issue = 3
+			}
+
+		case "PLTT":
+			if len(chunk) < _SZX_PLTT_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid PLTT chunk")
+			}
+			s.ula.ULAplus = &ULAplusState{
+				Enabled:  (chunk[0] & _SZX_PLTT_ENABLED) != 0,
+				Register: chunk[1] & 0x3f,
+			}
+			copy(s.ula.ULAplus.Palette[:], chunk[2:66])
+
+		case "SCLD":
+			if len(chunk) < _SZX_SCLD_SIZE {
+				return nil, errors.New("invalid SZX snapshot: invalid SCLD chunk")
+			}
+			s.ula.Timex = &TimexState{PortFF: chunk[1]}
+		}
+	}
+
+	if !haveRegisters {
+		return nil, errors.New("invalid SZX snapshot: no Z80R chunk")
+	}
+
+	// Memory (missing pages are filled with zeroes)
+	if s.state128k != nil {
+		for bank := 0; bank < 8; bank++ {
+			copy(s.state128k.Ram[bank][:], ram[bank])
+		}
+		copy(s.mem[0x0000:], s.state128k.Ram[5][:])
+		copy(s.mem[0x4000:], s.state128k.Ram[2][:])
+		copy(s.mem[0x8000:], s.state128k.Ram[s.state128k.Port7ffd&0x07][:])
+	} else {
+		copy(s.mem[0x0000:], ram[5])
+		copy(s.mem[0x4000:], ram[2])
+		copy(s.mem[0x8000:], ram[0])
+	}
+
+	return &s, nil
+}
+
+func (s *SZX) readZ80R(chunk []byte) error {
+	word := func(i int) uint16 {
+		return uint16(chunk[i]) | (uint16(chunk[i+1]) << 8)
+	}
+
+	s.cpu.F = chunk[0]
+	s.cpu.A = chunk[1]
+	s.cpu.C = chunk[2]
+	s.cpu.B = chunk[3]
+	s.cpu.E = chunk[4]
+	s.cpu.D = chunk[5]
+	s.cpu.L = chunk[6]
+	s.cpu.H = chunk[7]
+	s.cpu.F_ = chunk[8]
+	s.cpu.A_ = chunk[9]
+	s.cpu.C_ = chunk[10]
+	s.cpu.B_ = chunk[11]
+	s.cpu.E_ = chunk[12]
+	s.cpu.D_ = chunk[13]
+	s.cpu.L_ = chunk[14]
+	s.cpu.H_ = chunk[15]
+	s.cpu.IX = word(16)
+	s.cpu.IY = word(18)
+	s.cpu.SP = word(20)
+	s.cpu.PC = word(22)
+	s.cpu.I = chunk[24]
+	s.cpu.R = chunk[25]
+
+	if chunk[26] != 0 {
+		s.cpu.IFF1 = 1
+	} else {
+		s.cpu.IFF1 = 0
+	}
+
+	if chunk[27] != 0 {
+		s.cpu.IFF2 = 1
+	} else {
+		s.cpu.IFF2 = 0
+	}
+
+	switch IM := chunk[28]; IM {
+	case 0, 1, 2:
+		s.cpu.IM = IM
+	default:
+		return errors.New("invalid interrupt mode")
+	}
+
+	s.cpu.Tstate = uint(binary.LittleEndian.Uint32(chunk[29:33]))
+
+	// chunk[33]: the length of the interrupt, ignored
+
+	flags := chunk[34]
+	s.cpu.LastEI = (flags & _SZX_Z80R_EILAST) != 0
+	s.cpu.Halted = (flags & _SZX_Z80R_HALTED) != 0
+
+	s.cpu.MEMPTR = word(35)
+
+	return nil
+}
+
+// Returns the page number and the decompressed contents of a RAMP chunk
+func readRAMP(chunk []byte) (int, []byte, error) {
+	flags := uint16(chunk[0]) | (uint16(chunk[1]) << 8)
+	page := int(chunk[2])
+	if page >= 8 {
+		return 0, nil, fmt.Errorf("read SZX snapshot: unsupported RAM page %d", page)
+	}
+
+	pageData := chunk[3:]
+	if (flags & _SZX_RAMP_COMPRESSED) != 0 {
+		r, err := zlib.NewReader(bytes.NewReader(pageData))
+		if err != nil {
+			return 0, nil, err
+		}
+		pageData, err = ioutil.ReadAll(r)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if len(pageData) != 0x4000 {
+		return 0, nil, errors.New("invalid SZX snapshot: invalid size of RAM page")
+	}
+
+	return page, pageData, nil
+}
+
+func (s *SZX) CpuState() CpuState {
+	return s.cpu
+}
+
+func (s *SZX) UlaState() UlaState {
+	return s.ula
+}
+
+func (s *SZX) Memory() *[48 * 1024]byte {
+	return &s.mem
+}
+
+func (s *SZX) State128k() *State128k {
+	return s.state128k
+}
+
+// Returns the SZX identifier of the machine the snapshot was taken from
+func (s *SZX) MachineId() byte {
+	return s.machineId
+}
+
+// Returns true if the snapshot requests Issue 2 keyboard emulation
+func (s *SZX) Issue2() bool {
+	return s.issue2
+}
+
+// Returns the type of the joystick connected to the machine (SZX_JOYSTICK_*)
+func (s *SZX) Joystick() byte {
+	return s.joystick
+}
+
+// Turn snapshot into binary data (SZX format, version 1.4).
+// RAM pages are compressed using zlib.
+func (s *FullSnapshot) EncodeSZX() ([]byte, error) {
+	var buf bytes.Buffer
+
+	machineId := byte(SZX_MACHINE_48K)
+	interruptLength := byte(InterruptLength)
+	if s.Machine128k != nil {
+		machineId = SZX_MACHINE_128K
+		interruptLength = 36
+	} else if s.Ula.Timex != nil {
+		machineId = SZX_MACHINE_TC2048
+	}
+
+	buf.Write([]byte{'Z', 'X', 'S', 'T', _SZX_MAJOR_VERSION, _SZX_MINOR_VERSION, machineId, 0})
+
+	// Registers
+	{
+		var z80r [_SZX_Z80R_SIZE]byte
+		z80r[0] = s.Cpu.F
+		z80r[1] = s.Cpu.A
+		z80r[2] = s.Cpu.C
+		z80r[3] = s.Cpu.B
+		z80r[4] = s.Cpu.E
+		z80r[5] = s.Cpu.D
+		z80r[6] = s.Cpu.L
+		z80r[7] = s.Cpu.H
+		z80r[8] = s.Cpu.F_
+		z80r[9] = s.Cpu.A_
+		z80r[10] = s.Cpu.C_
+		z80r[11] = s.Cpu.B_
+		z80r[12] = s.Cpu.E_
+		z80r[13] = s.Cpu.D_
+		z80r[14] = s.Cpu.L_
+		z80r[15] = s.Cpu.H_
+		binary.LittleEndian.PutUint16(z80r[16:], s.Cpu.IX)
+		binary.LittleEndian.PutUint16(z80r[18:], s.Cpu.IY)
+		binary.LittleEndian.PutUint16(z80r[20:], s.Cpu.SP)
+		binary.LittleEndian.PutUint16(z80r[22:], s.Cpu.PC)
+		z80r[24] = s.Cpu.I
+		z80r[25] = s.Cpu.R
+		z80r[26] = s.Cpu.IFF1
+		z80r[27] = s.Cpu.IFF2
+		z80r[28] = s.Cpu.IM
+		binary.LittleEndian.PutUint32(z80r[29:], uint32(s.Cpu.Tstate))
+		z80r[33] = interruptLength
+
+		if s.Cpu.LastEI {
+			z80r[34] |= _SZX_Z80R_EILAST
+		}
+		if s.Cpu.Halted {
+			z80r[34] |= _SZX_Z80R_HALTED
+		}
+
+		binary.LittleEndian.PutUint16(z80r[35:], s.Cpu.MEMPTR)
+
+		writeSZXChunk(&buf, "Z80R", z80r[:])
+	}
+
+	// Spectrum registers
+	{
+		var spcr [_SZX_SPCR_SIZE]byte
+		spcr[0] = s.Ula.Border & 0x07
+		if s.Machine128k != nil {
+			spcr[1] = s.Machine128k.Port7ffd
+		}
+		writeSZXChunk(&buf, "SPCR", spcr[:])
+	}
+
+	// Joystick: GoSpeccy always emulates a Kempston joystick
+	writeSZXChunk(&buf, "JOY\x00", []byte{0, 0, 0, 0, SZX_JOYSTICK_KEMPSTON, SZX_JOYSTICK_NONE})
+
+	// Keyboard
+	if s.Ula.Issue != 0 {
+		var keyb [_SZX_KEYB_SIZE]byte
+		if s.Ula.Issue == 2 {
+			binary.LittleEndian.PutUint32(keyb[0:], _SZX_KEYB_ISSUE2)
+		}
+		keyb[4] = SZX_JOYSTICK_NONE
+		writeSZXChunk(&buf, "KEYB", keyb[:])
+	}
+
+	// Timex SCLD: the last values written to ports 0xf4 and 0xff
+	if s.Ula.Timex != nil {
+		writeSZXChunk(&buf, "SCLD", []byte{0, s.Ula.Timex.PortFF})
+	}
+
+	// ULAplus palette
+	if s.Ula.ULAplus != nil {
+		var pltt [_SZX_PLTT_SIZE]byte
+		if s.Ula.ULAplus.Enabled {
+			pltt[0] = _SZX_PLTT_ENABLED
+		}
+		pltt[1] = s.Ula.ULAplus.Register & 0x3f
+		copy(pltt[2:], s.Ula.ULAplus.Palette[:])
+		writeSZXChunk(&buf, "PLTT", pltt[:])
+	}
+
+	// Sound chip
+	if s.Machine128k != nil {
+		var ay [_SZX_AY_SIZE]byte
+		ay[0] = _SZX_AY_128AY
+		ay[1] = s.Machine128k.Ay.SelectedRegister
+		copy(ay[2:], s.Machine128k.Ay.Registers[:])
+		writeSZXChunk(&buf, "AY\x00\x00", ay[:])
+	}
+
+	// Memory
+	if s.Machine128k != nil {
+		for page := 0; page < 8; page++ {
+			err := writeRAMP(&buf, page, s.Machine128k.Ram[page][:])
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		pages := []struct {
+			page int
+			addr int
+		}{
+			{5, 0x4000},
+			{2, 0x8000},
+			{0, 0xc000},
+		}
+		for _, p := range pages {
+			err := writeRAMP(&buf, p.page, s.Mem[p.addr-0x4000:p.addr-0x4000+0x4000])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeSZXChunk(buf *bytes.Buffer, id string, data []byte) {
+	var header [_SZX_CHUNK_HEADER_SIZE]byte
+	copy(header[0:4], id)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+
+	buf.Write(header[:])
+	buf.Write(data)
+}
+
+func writeRAMP(buf *bytes.Buffer, page int, pageData []byte) error {
+	var compressed bytes.Buffer
+	compressed.Write([]byte{_SZX_RAMP_COMPRESSED, 0, byte(page)})
+
+	w, err := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(pageData)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	writeSZXChunk(buf, "RAMP", compressed.Bytes())
+	return nil
+}
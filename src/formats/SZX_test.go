@@ -0,0 +1,166 @@
+package formats
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+func (t *testSuite) TestEncodeSZX() {
+	data, err := ioutil.ReadFile("testdata/fire.z80")
+	t.Nil(err)
+	z80, err := SnapshotData(data).DecodeZ80()
+	t.Nil(err)
+
+	if !t.Failed() {
+		snapshot := &FullSnapshot{Cpu: z80.CpuState(), Ula: z80.UlaState(), Mem: *z80.Memory()}
+		snapshot.Cpu.Halted = true
+		snapshot.Cpu.MEMPTR = 0x1234
+
+		encoded, err := snapshot.EncodeSZX()
+		t.Nil(err)
+		t.Equal("ZXST", string(encoded[0:4]))
+
+		decoded, err := SnapshotData(encoded).DecodeSZX()
+		t.Nil(err)
+
+		if !t.Failed() {
+			t.Equal(byte(SZX_MACHINE_48K), decoded.MachineId())
+			t.Equal(byte(SZX_JOYSTICK_KEMPSTON), decoded.Joystick())
+			t.Equal(snapshot.Cpu, decoded.CpuState())
+			t.Equal(snapshot.Ula, decoded.UlaState())
+			t.True(bytes.Equal(snapshot.Mem[:], decoded.Memory()[:]))
+			t.True(decoded.State128k() == nil)
+		}
+	}
+}
+
+func (t *testSuite) TestEncodeSZX_128k() {
+	var snapshot FullSnapshot
+	snapshot.Cpu.PC = 0x8000
+	snapshot.Cpu.Tstate = 1000
+	snapshot.Cpu.LastEI = true
+	snapshot.Ula.Border = 3
+
+	state := new(State128k)
+	state.Port7ffd = 0x13
+	state.Ay.SelectedRegister = 8
+	state.Ay.Registers[8] = 0x0f
+	for bank := 0; bank < 8; bank++ {
+		state.Ram[bank][0] = byte(bank)
+		state.Ram[bank][0x3fff] = byte(0x80 | bank)
+	}
+	snapshot.Machine128k = state
+
+	encoded, err := snapshot.EncodeSZX()
+	t.Nil(err)
+
+	decoded, err := SnapshotData(encoded).DecodeSZX()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(byte(SZX_MACHINE_128K), decoded.MachineId())
+		t.Equal(snapshot.Cpu, decoded.CpuState())
+		t.Equal(snapshot.Ula, decoded.UlaState())
+		t.True(decoded.State128k() != nil)
+
+		if !t.Failed() {
+			t.Equal(*state, *decoded.State128k())
+
+			// The 48k view contains banks 5, 2 and the bank paged in at 0xc000
+			t.Equal(byte(5), decoded.Memory()[0x0000])
+			t.Equal(byte(2), decoded.Memory()[0x4000])
+			t.Equal(byte(3), decoded.Memory()[0x8000])
+		}
+	}
+
+	program, err := SnapshotData(encoded).Decode(FORMAT_SZX)
+	t.Nil(err)
+	_, ok := program.(Snapshot128k)
+	t.True(ok)
+}
+
+func (t *testSuite) TestDecodeSZXError() {
+	_, err := SnapshotData(nil).DecodeSZX()
+	t.Not(t.Nil(err))
+
+	// Missing registers
+	_, err = SnapshotData([]byte{'Z', 'X', 'S', 'T', 1, 4, SZX_MACHINE_48K, 0}).DecodeSZX()
+	t.Not(t.Nil(err))
+
+	// Unsupported machine
+	_, err = SnapshotData([]byte{'Z', 'X', 'S', 'T', 1, 4, SZX_MACHINE_PLUS3, 0}).DecodeSZX()
+	t.Not(t.Nil(err))
+
+	// Truncated chunk
+	_, err = SnapshotData([]byte{'Z', 'X', 'S', 'T', 1, 4, SZX_MACHINE_48K, 0, 'Z', '8', '0', 'R', 37, 0, 0, 0, 0}).DecodeSZX()
+	t.Not(t.Nil(err))
+}
+
+func (t *testSuite) TestEncodeSZX_Issue2() {
+	var snapshot FullSnapshot
+	snapshot.Ula.Issue = 2
+
+	encoded, err := snapshot.EncodeSZX()
+	t.Nil(err)
+
+	decoded, err := SnapshotData(encoded).DecodeSZX()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.True(decoded.Issue2())
+		t.Equal(byte(2), decoded.UlaState().Issue)
+	}
+}
+
+func (t *testSuite) TestEncodeSZX_ULAplus() {
+	var snapshot FullSnapshot
+	snapshot.Ula.ULAplus = &ULAplusState{Enabled: true, Register: 5}
+	for i := range snapshot.Ula.ULAplus.Palette {
+		snapshot.Ula.ULAplus.Palette[i] = byte(4 * i)
+	}
+
+	encoded, err := snapshot.EncodeSZX()
+	t.Nil(err)
+
+	decoded, err := SnapshotData(encoded).DecodeSZX()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Not(t.Nil(decoded.UlaState().ULAplus))
+		if !t.Failed() {
+			t.Equal(*snapshot.Ula.ULAplus, *decoded.UlaState().ULAplus)
+		}
+	}
+
+	// No PLTT chunk
+	snapshot.Ula.ULAplus = nil
+	encoded, err = snapshot.EncodeSZX()
+	t.Nil(err)
+
+	decoded, err = SnapshotData(encoded).DecodeSZX()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Nil(decoded.UlaState().ULAplus)
+	}
+}
+
+func (t *testSuite) TestEncodeSZX_Timex() {
+	var snapshot FullSnapshot
+	snapshot.Ula.Timex = &TimexState{PortFF: 0x3e}
+
+	encoded, err := snapshot.EncodeSZX()
+	t.Nil(err)
+
+	decoded, err := SnapshotData(encoded).DecodeSZX()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(byte(SZX_MACHINE_TC2048), decoded.MachineId())
+		t.Nil(decoded.State128k())
+		t.Not(t.Nil(decoded.UlaState().Timex))
+		if !t.Failed() {
+			t.Equal(byte(0x3e), decoded.UlaState().Timex.PortFF)
+		}
+	}
+}
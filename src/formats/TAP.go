@@ -1,6 +1,9 @@
 package formats
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	TAP_FILE_PROGRAM         = 0
@@ -16,22 +19,30 @@ func joinBytes(h, l byte) uint16 {
 	return uint16(l) | (uint16(h) << 8)
 }
 
-func checksum(data []byte) bool {
+// Returns the XOR of all bytes
+func parity(data []byte) byte {
 	sum := byte(0)
 	for _, v := range data {
 		sum ^= v
 	}
-	return sum == 0
+	return sum
+}
+
+func checksum(data []byte) bool {
+	return parity(data) == 0
 }
 
-type tapBlock interface {
+// A block of a TAP tape. The data of the block consists of
+// the flag byte, the data bytes and the checksum byte.
+type TAPBlock interface {
 	BlockType() byte // Usually returns TAP_BLOCK_HEADER or TAP_BLOCK_DATA
 	Len() int        // Same as 'len(Data())'
 	Data() []byte
 	checksum() bool
 }
 
-type tapBlockHeader struct {
+// A header block, as saved by the ROM
+type TAPHeader struct {
 	data       []byte
 	tapType    byte // Usually, the value is one of TAP_FILE_*
 	filename   string
@@ -39,43 +50,98 @@ type tapBlockHeader struct {
 	par1, par2 uint16
 }
 
-func (header *tapBlockHeader) BlockType() byte {
+// Creates a new header block. The filename is padded with spaces
+// or truncated to 10 characters.
+func NewTAPHeader(fileType byte, filename string, length, param1, param2 uint16) *TAPHeader {
+	data := make([]byte, 19)
+	data[0] = TAP_BLOCK_HEADER
+	data[1] = fileType
+	copy(data[2:12], fmt.Sprintf("%-10.10s", filename))
+	data[12], data[13] = byte(length&0xff), byte(length>>8)
+	data[14], data[15] = byte(param1&0xff), byte(param1>>8)
+	data[16], data[17] = byte(param2&0xff), byte(param2>>8)
+	data[18] = parity(data[0:18])
+
+	return readBlock_header(data)
+}
+
+// Returns the type of the file (usually one of TAP_FILE_*)
+func (header *TAPHeader) FileType() byte {
+	return header.tapType
+}
+
+// Returns the 10-character filename
+func (header *TAPHeader) Filename() string {
+	return header.filename
+}
+
+// Returns the length of the data block which follows the header
+func (header *TAPHeader) Length() uint16 {
+	return header.length
+}
+
+// Returns the 1st parameter. This is the autostart line of a program,
+// or the start address of a code file.
+func (header *TAPHeader) Param1() uint16 {
+	return header.par1
+}
+
+// Returns the 2nd parameter. This is the start of the variable area
+// of a program, or 32768 for a code file.
+func (header *TAPHeader) Param2() uint16 {
+	return header.par2
+}
+
+func (header *TAPHeader) BlockType() byte {
 	return header.data[0]
 }
 
-func (header *tapBlockHeader) Len() int {
+func (header *TAPHeader) Len() int {
 	return len(header.data)
 }
 
-func (header *tapBlockHeader) Data() []byte {
+func (header *TAPHeader) Data() []byte {
 	return header.data
 }
 
-func (header *tapBlockHeader) checksum() bool {
+func (header *TAPHeader) checksum() bool {
 	return checksum(header.data)
 }
 
-type tapBlockData []byte
+// A block which is not a header
+type TAPData []byte
 
-func (data tapBlockData) BlockType() byte {
+func (data TAPData) BlockType() byte {
 	return data[0]
 }
 
-func (data tapBlockData) Len() int {
+func (data TAPData) Len() int {
 	return len(data)
 }
 
-func (data tapBlockData) Data() []byte {
+func (data TAPData) Data() []byte {
 	return data
 }
 
-func (data tapBlockData) checksum() bool {
+func (data TAPData) checksum() bool {
 	return checksum(data)
 }
 
+// Creates a new block with the specified flag byte and data.
+// The checksum byte is computed automatically.
+func NewTAPBlock(flag byte, data []byte) TAPBlock {
+	blockData := make([]byte, 0, len(data)+2)
+	blockData = append(blockData, flag)
+	blockData = append(blockData, data...)
+	blockData = append(blockData, parity(blockData))
+
+	return newBlock(blockData)
+}
+
+// The zero value of TAP is an empty tape
 type TAP struct {
 	data   []byte
-	blocks []tapBlock
+	blocks []TAPBlock
 }
 
 func NewTAP(data []byte) (*TAP, error) {
@@ -97,12 +163,73 @@ func (tap *TAP) At(pos uint) byte {
 	return tap.data[pos]
 }
 
-func (tap *TAP) GetBlock(pos int) tapBlock {
+// Returns the number of blocks
+func (tap *TAP) NumBlocks() int {
+	return len(tap.blocks)
+}
+
+func (tap *TAP) GetBlock(pos int) TAPBlock {
 	return tap.blocks[pos]
 }
 
-func readBlock_header(data []byte) *tapBlockHeader {
-	header := new(tapBlockHeader)
+// Returns all blocks of the tape
+func (tap *TAP) Blocks() []TAPBlock {
+	return append([]TAPBlock(nil), tap.blocks...)
+}
+
+// Inserts the block at index 'pos'. If 'pos' equals to NumBlocks(),
+// the block is appended to the end of the tape.
+func (tap *TAP) InsertBlock(pos int, block TAPBlock) error {
+	if (pos < 0) || (pos > len(tap.blocks)) {
+		return errors.New("block index out of range")
+	}
+	if (block == nil) || (block.Len() == 0) {
+		return errors.New("block size can't be 0")
+	}
+	if !block.checksum() {
+		return errors.New("checksum failed")
+	}
+
+	tap.blocks = append(tap.blocks, nil)
+	copy(tap.blocks[pos+1:], tap.blocks[pos:])
+	tap.blocks[pos] = block
+	tap.updateData()
+
+	return nil
+}
+
+// Removes the block at index 'pos'
+func (tap *TAP) DeleteBlock(pos int) error {
+	if (pos < 0) || (pos >= len(tap.blocks)) {
+		return errors.New("block index out of range")
+	}
+
+	tap.blocks = append(tap.blocks[:pos], tap.blocks[pos+1:]...)
+	tap.updateData()
+
+	return nil
+}
+
+// Moves the block at index 'from' to index 'to'
+func (tap *TAP) MoveBlock(from, to int) error {
+	if (from < 0) || (from >= len(tap.blocks)) || (to < 0) || (to >= len(tap.blocks)) {
+		return errors.New("block index out of range")
+	}
+
+	block := tap.blocks[from]
+	if from < to {
+		copy(tap.blocks[from:to], tap.blocks[from+1:to+1])
+	} else {
+		copy(tap.blocks[to+1:from+1], tap.blocks[to:from])
+	}
+	tap.blocks[to] = block
+	tap.updateData()
+
+	return nil
+}
+
+func readBlock_header(data []byte) *TAPHeader {
+	header := new(TAPHeader)
 
 	header.data = data
 	header.tapType = data[1]
@@ -114,18 +241,46 @@ func readBlock_header(data []byte) *tapBlockHeader {
 	return header
 }
 
-func readBlock_data(data []byte) tapBlockData {
-	return tapBlockData(data)
+func readBlock_data(data []byte) TAPData {
+	return TAPData(data)
 }
 
-func (tap *TAP) readBlock(data []byte) (tapBlock, error) {
-	var block tapBlock
-	if data[0] == TAP_BLOCK_HEADER {
-		block = readBlock_header(data)
-	} else {
-		block = readBlock_data(data)
+// Appends a block to the end of the tape. The data has to begin with the flag
+// byte and end with the checksum byte.
+func (tap *TAP) AppendBlock(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("block size can't be 0")
+	}
+
+	block, err := tap.readBlock(append([]byte(nil), data...))
+	if err != nil {
+		return err
 	}
 
+	return tap.InsertBlock(len(tap.blocks), block)
+}
+
+// Encodes the tape into the binary TAP format
+func (tap *TAP) Encode() []byte {
+	data := make([]byte, 0, len(tap.data)+2*len(tap.blocks))
+	for _, block := range tap.blocks {
+		length := block.Len()
+		data = append(data, byte(length&0xff), byte(length>>8))
+		data = append(data, block.Data()...)
+	}
+	return data
+}
+
+func newBlock(data []byte) TAPBlock {
+	if (data[0] == TAP_BLOCK_HEADER) && (len(data) == 19) {
+		return readBlock_header(data)
+	}
+	return readBlock_data(data)
+}
+
+func (tap *TAP) readBlock(data []byte) (TAPBlock, error) {
+	block := newBlock(data)
+
 	if !block.checksum() {
 		return nil, errors.New("checksum failed")
 	}
@@ -165,17 +320,18 @@ func (tap *TAP) read(data []byte) error {
 		pos += blockLength
 	}
 
-	tap.data = make([]byte, len(data)-(len(tap.blocks)*2))
-	c := 0
-	for _, blk := range tap.blocks {
-		for _, blk_data := range blk.Data() {
-			tap.data[c] = blk_data
-			c++
-		}
-	}
-	if c != len(tap.data) {
+	tap.updateData()
+	if len(tap.data) != len(data)-(len(tap.blocks)*2) {
 		panic("assertion failed")
 	}
 
 	return nil
 }
+
+// Concatenates the data of all blocks
+func (tap *TAP) updateData() {
+	tap.data = make([]byte, 0, len(tap.data))
+	for _, blk := range tap.blocks {
+		tap.data = append(tap.data, blk.Data()...)
+	}
+}
@@ -19,8 +19,8 @@ func (t *testSuite) TestReadTAP() {
 	tap, err := NewTAP(data)
 	t.Nil(err)
 
-	headerBlock := tap.blocks[0].(*tapBlockHeader)
-	dataBlock := tap.blocks[1].(tapBlockData)
+	headerBlock := tap.blocks[0].(*TAPHeader)
+	dataBlock := tap.blocks[1].(TAPData)
 
 	t.Equal(23, int(tap.Len()))
 
@@ -44,8 +44,8 @@ func (t *testSuite) TestReadTAPCodeFile() {
 	t.Nil(err)
 
 	if !t.Failed() {
-		headerBlock := tap.blocks[0].(*tapBlockHeader)
-		dataBlock := tap.blocks[1].(tapBlockData)
+		headerBlock := tap.blocks[0].(*TAPHeader)
+		dataBlock := tap.blocks[1].(TAPData)
 
 		t.Equal(byte(TAP_FILE_CODE), headerBlock.tapType)
 		t.Equal(uint16(0), headerBlock.par1)
@@ -67,8 +67,8 @@ func (t *testSuite) TestReadTAPProgramFile() {
 	t.Nil(err)
 
 	if !t.Failed() {
-		headerBlock := tap.blocks[0].(*tapBlockHeader)
-		dataBlock := tap.blocks[1].(tapBlockData)
+		headerBlock := tap.blocks[0].(*TAPHeader)
+		dataBlock := tap.blocks[1].(TAPData)
 
 		t.Equal(byte(TAP_FILE_PROGRAM), headerBlock.tapType)
 		t.Equal(uint16(0x8000), headerBlock.par1)
@@ -99,8 +99,8 @@ func (t *testSuite) TestReadTAPWithCustomLoader() {
 	t.Nil(err)
 
 	if !t.Failed() {
-		headerBlock := tap.blocks[0].(*tapBlockHeader)
-		dataBlock := tap.blocks[1].(tapBlockData)
+		headerBlock := tap.blocks[0].(*TAPHeader)
+		dataBlock := tap.blocks[1].(TAPData)
 
 		t.Equal(byte(TAP_FILE_PROGRAM), headerBlock.tapType)
 		t.Equal(uint16(0x0a), headerBlock.par1)
@@ -116,8 +116,8 @@ func (t *testSuite) TestNewTAPFromFile() {
 	t.Nil(err)
 
 	if !t.Failed() {
-		headerBlock := tap.blocks[0].(*tapBlockHeader)
-		dataBlock := tap.blocks[1].(tapBlockData)
+		headerBlock := tap.blocks[0].(*TAPHeader)
+		dataBlock := tap.blocks[1].(TAPData)
 
 		t.Equal(byte(TAP_FILE_CODE), headerBlock.tapType)
 		t.Equal(uint16(0), headerBlock.par1)
@@ -143,12 +143,112 @@ func (t *testSuite) TestTAPAt() {
 }
 
 func (t *testSuite) TestTAPGetBlock() {
-	_, ok := tap.GetBlock(0).(*tapBlockHeader)
+	_, ok := tap.GetBlock(0).(*TAPHeader)
 	t.True(ok)
-	_, ok = tap.GetBlock(1).(tapBlockData)
+	_, ok = tap.GetBlock(1).(TAPData)
 	t.True(ok)
 }
 
 func (t *testSuite) TestTAPBlockLen() {
 	t.Equal(19, tap.GetBlock(0).Len())
 }
+
+func (t *testSuite) TestTAPEncode() {
+	data, err := ioutil.ReadFile(tapProgramFn)
+	t.Nil(err)
+
+	t.True(bytes.Equal(data, tap.Encode()))
+}
+
+func (t *testSuite) TestTAPAppendBlock() {
+	var newTap TAP
+
+	t.Nil(newTap.AppendBlock(tap.GetBlock(0).Data()))
+	t.Nil(newTap.AppendBlock(tap.GetBlock(1).Data()))
+	t.Equal(2, newTap.NumBlocks())
+	t.Equal(tap.Len(), newTap.Len())
+	t.True(bytes.Equal(tap.Encode(), newTap.Encode()))
+
+	_, ok := newTap.GetBlock(0).(*TAPHeader)
+	t.True(ok)
+
+	// Invalid checksum
+	t.Not(t.Nil(newTap.AppendBlock([]byte{TAP_BLOCK_DATA, 0x01, 0x02})))
+	t.Not(t.Nil(newTap.AppendBlock(nil)))
+	t.Equal(2, newTap.NumBlocks())
+}
+
+func (t *testSuite) TestTAPHeaderFields() {
+	header, ok := tap.GetBlock(0).(*TAPHeader)
+	t.True(ok)
+
+	if !t.Failed() {
+		t.Equal(byte(TAP_FILE_PROGRAM), header.FileType())
+		t.Equal("HELLO     ", header.Filename())
+		t.Equal(uint16(0x14), header.Length())
+		t.Equal(uint16(0x8000), header.Param1())
+		t.Equal(uint16(0x14), header.Param2())
+	}
+}
+
+func (t *testSuite) TestNewTAPHeader() {
+	header := NewTAPHeader(TAP_FILE_PROGRAM, "HELLO", 0x14, 0x8000, 0x14)
+
+	t.True(bytes.Equal(tap.GetBlock(0).Data(), header.Data()))
+
+	header = NewTAPHeader(TAP_FILE_CODE, "AVERYLONGFILENAME", 6912, 16384, 32768)
+	t.Equal("AVERYLONGF", header.Filename())
+	t.Equal(19, header.Len())
+	t.True(header.checksum())
+}
+
+func (t *testSuite) TestNewTAPBlock() {
+	block := NewTAPBlock(TAP_BLOCK_DATA, tap.GetBlock(1).Data()[1:21])
+	t.True(bytes.Equal(tap.GetBlock(1).Data(), block.Data()))
+
+	_, ok := NewTAPBlock(TAP_BLOCK_HEADER, tap.GetBlock(0).Data()[1:18]).(*TAPHeader)
+	t.True(ok)
+}
+
+func sameBlock(a, b TAPBlock) bool {
+	return bytes.Equal(a.Data(), b.Data())
+}
+
+func (t *testSuite) TestTAPEditBlocks() {
+	var newTap TAP
+
+	header := NewTAPHeader(TAP_FILE_CODE, "SCREEN", 3, 16384, 32768)
+	code := NewTAPBlock(TAP_BLOCK_DATA, []byte{1, 2, 3})
+
+	t.Nil(newTap.InsertBlock(0, code))
+	t.Nil(newTap.InsertBlock(0, header))
+	t.Nil(newTap.InsertBlock(2, tap.GetBlock(0)))
+	t.Not(t.Nil(newTap.InsertBlock(4, code)))
+	t.Equal(3, newTap.NumBlocks())
+	t.True(sameBlock(header, newTap.GetBlock(0)))
+	t.True(sameBlock(code, newTap.GetBlock(1)))
+
+	t.Nil(newTap.MoveBlock(2, 0))
+	t.True(sameBlock(tap.GetBlock(0), newTap.GetBlock(0)))
+	t.True(sameBlock(header, newTap.GetBlock(1)))
+	t.True(sameBlock(code, newTap.GetBlock(2)))
+
+	t.Nil(newTap.MoveBlock(0, 2))
+	t.True(sameBlock(header, newTap.GetBlock(0)))
+	t.True(sameBlock(code, newTap.GetBlock(1)))
+	t.True(sameBlock(tap.GetBlock(0), newTap.GetBlock(2)))
+	t.Not(t.Nil(newTap.MoveBlock(0, 3)))
+
+	t.Nil(newTap.DeleteBlock(2))
+	t.Not(t.Nil(newTap.DeleteBlock(2)))
+	t.Equal(2, len(newTap.Blocks()))
+	t.Equal(uint(19+5), newTap.Len())
+	t.Equal(byte(1), newTap.At(20))
+
+	encoded, err := NewTAP(newTap.Encode())
+	t.Nil(err)
+	if !t.Failed() {
+		t.True(bytes.Equal(header.Data(), encoded.GetBlock(0).Data()))
+		t.True(bytes.Equal(code.Data(), encoded.GetBlock(1).Data()))
+	}
+}
@@ -0,0 +1,381 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// TZX block IDs
+const (
+	TZX_BLOCK_STANDARD_SPEED   = 0x10
+	TZX_BLOCK_TURBO_SPEED      = 0x11
+	TZX_BLOCK_PURE_TONE        = 0x12
+	TZX_BLOCK_PULSE_SEQUENCE   = 0x13
+	TZX_BLOCK_PURE_DATA        = 0x14
+	TZX_BLOCK_DIRECT_RECORDING = 0x15
+	TZX_BLOCK_CSW_RECORDING    = 0x18
+	TZX_BLOCK_GENERALIZED_DATA = 0x19
+	TZX_BLOCK_PAUSE            = 0x20
+	TZX_BLOCK_GROUP_START      = 0x21
+	TZX_BLOCK_GROUP_END        = 0x22
+	TZX_BLOCK_JUMP             = 0x23
+	TZX_BLOCK_LOOP_START       = 0x24
+	TZX_BLOCK_LOOP_END         = 0x25
+	TZX_BLOCK_CALL_SEQUENCE    = 0x26
+	TZX_BLOCK_RETURN           = 0x27
+	TZX_BLOCK_SELECT           = 0x28
+	TZX_BLOCK_STOP_48K         = 0x2a
+	TZX_BLOCK_SIGNAL_LEVEL     = 0x2b
+	TZX_BLOCK_TEXT             = 0x30
+	TZX_BLOCK_MESSAGE          = 0x31
+	TZX_BLOCK_ARCHIVE_INFO     = 0x32
+	TZX_BLOCK_HARDWARE_TYPE    = 0x33
+	TZX_BLOCK_CUSTOM_INFO      = 0x35
+	TZX_BLOCK_GLUE             = 0x5a
+)
+
+// Timings of the standard ROM loader, in T-states
+const (
+	TZX_PILOT_PULSE         = 2168
+	TZX_SYNC1_PULSE         = 667
+	TZX_SYNC2_PULSE         = 735
+	TZX_ZERO_PULSE          = 855
+	TZX_ONE_PULSE           = 1710
+	TZX_HEADER_PILOT_PULSES = 8063
+	TZX_DATA_PILOT_PULSES   = 3223
+	TZX_TSTATES_PER_MS      = 3500
+)
+
+const (
+	tzxSignature             = "ZXTape!\x1a"
+	tzxHeaderLength          = 10
+	tzxMaxSupportedMajorVers = 1
+)
+
+type TZXBlock interface {
+	ID() byte
+}
+
+// Block 0x10: data saved by the ROM routine
+type TZXStandardSpeed struct {
+	Pause uint16 // Pause after this block (milliseconds)
+	Data  []byte // Flag byte, data bytes, checksum byte
+}
+
+// Block 0x11: like the standard speed block, but all timings are specified
+type TZXTurboSpeed struct {
+	PilotPulse  uint16
+	Sync1Pulse  uint16
+	Sync2Pulse  uint16
+	ZeroPulse   uint16
+	OnePulse    uint16
+	PilotPulses uint16 // Number of pulses of the pilot tone
+	UsedBits    byte   // Used bits in the last byte (1..8)
+	Pause       uint16 // Pause after this block (milliseconds)
+	Data        []byte
+}
+
+// Block 0x12: 'NumPulses' pulses, each of length 'PulseLen' T-states
+type TZXPureTone struct {
+	PulseLen  uint16
+	NumPulses uint16
+}
+
+// Block 0x13: a sequence of pulses of different lengths
+type TZXPulseSequence struct {
+	Pulses []uint16
+}
+
+// Block 0x14: data without pilot tone and sync pulses
+type TZXPureData struct {
+	ZeroPulse uint16
+	OnePulse  uint16
+	UsedBits  byte   // Used bits in the last byte (1..8)
+	Pause     uint16 // Pause after this block (milliseconds)
+	Data      []byte
+}
+
+// Block 0x15: samples of the EAR signal. Each bit is a sample, 1 means "high".
+type TZXDirectRecording struct {
+	TStatesPerSample uint16
+	Pause            uint16 // Pause after this block (milliseconds)
+	UsedBits         byte   // Used bits (samples) in the last byte (1..8)
+	Data             []byte
+}
+
+// Block 0x20: silence. A zero duration means "stop the tape".
+type TZXPause struct {
+	Duration uint16 // Milliseconds
+}
+
+// Block 0x21
+type TZXGroupStart struct {
+	Name string
+}
+
+// Block 0x22
+type TZXGroupEnd struct{}
+
+// Block 0x24: the blocks up to the next TZXLoopEnd are played 'Repetitions' times
+type TZXLoopStart struct {
+	Repetitions uint16
+}
+
+// Block 0x25
+type TZXLoopEnd struct{}
+
+// Block 0x2A: stop the tape if the emulated machine is a 48k Spectrum
+type TZXStop48k struct{}
+
+// A block which is read but whose meaning is ignored (descriptions, archive info, etc)
+type TZXOtherBlock struct {
+	BlockID byte
+	Data    []byte // The block body, excluding the ID
+}
+
+func (b *TZXStandardSpeed) ID() byte   { return TZX_BLOCK_STANDARD_SPEED }
+func (b *TZXTurboSpeed) ID() byte      { return TZX_BLOCK_TURBO_SPEED }
+func (b *TZXPureTone) ID() byte        { return TZX_BLOCK_PURE_TONE }
+func (b *TZXPulseSequence) ID() byte   { return TZX_BLOCK_PULSE_SEQUENCE }
+func (b *TZXPureData) ID() byte        { return TZX_BLOCK_PURE_DATA }
+func (b *TZXDirectRecording) ID() byte { return TZX_BLOCK_DIRECT_RECORDING }
+func (b *TZXPause) ID() byte           { return TZX_BLOCK_PAUSE }
+func (b *TZXGroupStart) ID() byte      { return TZX_BLOCK_GROUP_START }
+func (b *TZXGroupEnd) ID() byte        { return TZX_BLOCK_GROUP_END }
+func (b *TZXLoopStart) ID() byte       { return TZX_BLOCK_LOOP_START }
+func (b *TZXLoopEnd) ID() byte         { return TZX_BLOCK_LOOP_END }
+func (b *TZXStop48k) ID() byte         { return TZX_BLOCK_STOP_48K }
+func (b *TZXOtherBlock) ID() byte      { return b.BlockID }
+
+// Returns the number of pilot pulses, which depends on the flag byte of the block
+func (b *TZXStandardSpeed) PilotPulses() uint16 {
+	if (len(b.Data) > 0) && (b.Data[0] < 0x80) {
+		return TZX_HEADER_PILOT_PULSES
+	}
+	return TZX_DATA_PILOT_PULSES
+}
+
+type TZX struct {
+	MajorVersion, MinorVersion byte
+
+	blocks []TZXBlock
+}
+
+// Decodes TZX from binary data
+func NewTZX(data []byte) (*TZX, error) {
+	if (len(data) < tzxHeaderLength) || !bytes.Equal(data[0:8], []byte(tzxSignature)) {
+		return nil, errors.New("invalid TZX signature")
+	}
+
+	tzx := &TZX{
+		MajorVersion: data[8],
+		MinorVersion: data[9],
+		blocks:       make([]TZXBlock, 0),
+	}
+
+	if tzx.MajorVersion > tzxMaxSupportedMajorVers {
+		return nil, fmt.Errorf("unsupported TZX version %d.%d", tzx.MajorVersion, tzx.MinorVersion)
+	}
+
+	err := tzx.read(data[tzxHeaderLength:])
+	if err != nil {
+		return nil, err
+	}
+
+	return tzx, nil
+}
+
+// Returns the number of blocks
+func (tzx *TZX) Len() int {
+	return len(tzx.blocks)
+}
+
+func (tzx *TZX) GetBlock(i int) TZXBlock {
+	return tzx.blocks[i]
+}
+
+// A helper for reading little-endian values with bounds checking
+type tzxReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *tzxReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if (n < 0) || (r.pos+n > len(r.data)) {
+		r.err = errors.New("invalid TZX data: unexpected end of data")
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *tzxReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *tzxReader) word() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return joinBytes(b[1], b[0])
+}
+
+func (r *tzxReader) triple() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0]) | (int(b[1]) << 8) | (int(b[2]) << 16)
+}
+
+func (r *tzxReader) dword() int {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int(b[0]) | (int(b[1]) << 8) | (int(b[2]) << 16) | (int(b[3]) << 24)
+}
+
+func usedBits(b byte) byte {
+	if (b == 0) || (b > 8) {
+		return 8
+	}
+	return b
+}
+
+func (tzx *TZX) read(data []byte) error {
+	r := &tzxReader{data: data}
+
+	for (r.pos < len(data)) && (r.err == nil) {
+		id := r.byte()
+		start := r.pos
+
+		var block TZXBlock
+		switch id {
+		case TZX_BLOCK_STANDARD_SPEED:
+			b := &TZXStandardSpeed{}
+			b.Pause = r.word()
+			b.Data = r.bytes(int(r.word()))
+			block = b
+
+		case TZX_BLOCK_TURBO_SPEED:
+			b := &TZXTurboSpeed{}
+			b.PilotPulse = r.word()
+			b.Sync1Pulse = r.word()
+			b.Sync2Pulse = r.word()
+			b.ZeroPulse = r.word()
+			b.OnePulse = r.word()
+			b.PilotPulses = r.word()
+			b.UsedBits = usedBits(r.byte())
+			b.Pause = r.word()
+			b.Data = r.bytes(r.triple())
+			block = b
+
+		case TZX_BLOCK_PURE_TONE:
+			b := &TZXPureTone{}
+			b.PulseLen = r.word()
+			b.NumPulses = r.word()
+			block = b
+
+		case TZX_BLOCK_PULSE_SEQUENCE:
+			b := &TZXPulseSequence{}
+			n := int(r.byte())
+			b.Pulses = make([]uint16, n)
+			for i := 0; i < n; i++ {
+				b.Pulses[i] = r.word()
+			}
+			block = b
+
+		case TZX_BLOCK_PURE_DATA:
+			b := &TZXPureData{}
+			b.ZeroPulse = r.word()
+			b.OnePulse = r.word()
+			b.UsedBits = usedBits(r.byte())
+			b.Pause = r.word()
+			b.Data = r.bytes(r.triple())
+			block = b
+
+		case TZX_BLOCK_DIRECT_RECORDING:
+			b := &TZXDirectRecording{}
+			b.TStatesPerSample = r.word()
+			b.Pause = r.word()
+			b.UsedBits = usedBits(r.byte())
+			b.Data = r.bytes(r.triple())
+			block = b
+
+		case TZX_BLOCK_PAUSE:
+			block = &TZXPause{Duration: r.word()}
+
+		case TZX_BLOCK_GROUP_START:
+			block = &TZXGroupStart{Name: string(r.bytes(int(r.byte())))}
+
+		case TZX_BLOCK_GROUP_END:
+			block = &TZXGroupEnd{}
+
+		case TZX_BLOCK_LOOP_START:
+			block = &TZXLoopStart{Repetitions: r.word()}
+
+		case TZX_BLOCK_LOOP_END:
+			block = &TZXLoopEnd{}
+
+		case TZX_BLOCK_STOP_48K:
+			r.bytes(r.dword())
+			block = &TZXStop48k{}
+
+		// Blocks which are skipped
+		case TZX_BLOCK_CSW_RECORDING, TZX_BLOCK_GENERALIZED_DATA, TZX_BLOCK_SIGNAL_LEVEL:
+			r.bytes(r.dword())
+		case TZX_BLOCK_JUMP:
+			r.bytes(2)
+		case TZX_BLOCK_CALL_SEQUENCE:
+			r.bytes(2 * int(r.word()))
+		case TZX_BLOCK_RETURN:
+		case TZX_BLOCK_SELECT, TZX_BLOCK_ARCHIVE_INFO:
+			r.bytes(int(r.word()))
+		case TZX_BLOCK_TEXT:
+			r.bytes(int(r.byte()))
+		case TZX_BLOCK_MESSAGE:
+			r.byte()
+			r.bytes(int(r.byte()))
+		case TZX_BLOCK_HARDWARE_TYPE:
+			r.bytes(3 * int(r.byte()))
+		case TZX_BLOCK_CUSTOM_INFO:
+			r.bytes(16)
+			r.bytes(r.dword())
+		case TZX_BLOCK_GLUE:
+			r.bytes(9)
+
+		default:
+			// TZX v1.10+ blocks which are not known to this decoder
+			// start with a 32-bit length of the block body
+			if id >= 0x40 {
+				r.bytes(r.dword())
+			} else {
+				return fmt.Errorf("unsupported TZX block 0x%02x", id)
+			}
+		}
+
+		if r.err != nil {
+			return r.err
+		}
+
+		if block == nil {
+			block = &TZXOtherBlock{BlockID: id, Data: data[start:r.pos]}
+		}
+
+		tzx.blocks = append(tzx.blocks, block)
+	}
+
+	return r.err
+}
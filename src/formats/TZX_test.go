@@ -0,0 +1,130 @@
+package formats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+)
+
+var tzxProgramFn = path.Join(testdataDir, "hello.tzx")
+
+func (t *testSuite) TestReadTZX() {
+	data, err := ioutil.ReadFile(tzxProgramFn)
+	t.Nil(err)
+	tzx, err := NewTZX(data)
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(byte(1), tzx.MajorVersion)
+		t.Equal(byte(20), tzx.MinorVersion)
+		t.Equal(3, tzx.Len())
+
+		text, ok := tzx.GetBlock(0).(*TZXOtherBlock)
+		t.True(ok)
+		t.Equal(byte(TZX_BLOCK_TEXT), text.ID())
+
+		header, ok := tzx.GetBlock(1).(*TZXStandardSpeed)
+		t.True(ok)
+		t.Equal(uint16(1000), header.Pause)
+		t.Equal(19, len(header.Data))
+		t.Equal(uint16(TZX_HEADER_PILOT_PULSES), header.PilotPulses())
+
+		data, ok := tzx.GetBlock(2).(*TZXStandardSpeed)
+		t.True(ok)
+		t.Equal(byte(TAP_BLOCK_DATA), data.Data[0])
+		t.Equal(uint16(TZX_DATA_PILOT_PULSES), data.PilotPulses())
+	}
+}
+
+func (t *testSuite) TestReadTZXBlocks() {
+	data := []byte("ZXTape!\x1a\x01\x14")
+	data = append(data,
+		// Turbo speed
+		0x11, 0x78, 0x08, 0x9b, 0x02, 0xdf, 0x02, 0x57, 0x03, 0xae, 0x06, 0x7f, 0x1f, 0x07, 0xe8, 0x03, 0x02, 0x00, 0x00, 0xff, 0x80,
+		// Pure tone
+		0x12, 0x78, 0x08, 0x10, 0x00,
+		// Pulse sequence
+		0x13, 0x02, 0x9b, 0x02, 0xdf, 0x02,
+		// Pure data
+		0x14, 0x57, 0x03, 0xae, 0x06, 0x08, 0x00, 0x00, 0x01, 0x00, 0x00, 0xaa,
+		// Direct recording
+		0x15, 0x4f, 0x00, 0x00, 0x00, 0x04, 0x01, 0x00, 0x00, 0xf0,
+		// Group start, pause, group end
+		0x21, 0x04, 'T', 'E', 'S', 'T',
+		0x20, 0x64, 0x00,
+		0x22,
+		// Loop
+		0x24, 0x03, 0x00,
+		0x20, 0x01, 0x00,
+		0x25,
+		// Stop the tape if in 48k mode
+		0x2a, 0x00, 0x00, 0x00, 0x00,
+	)
+
+	tzx, err := NewTZX(data)
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(12, tzx.Len())
+
+		turbo := tzx.GetBlock(0).(*TZXTurboSpeed)
+		t.Equal(uint16(2168), turbo.PilotPulse)
+		t.Equal(uint16(667), turbo.Sync1Pulse)
+		t.Equal(uint16(735), turbo.Sync2Pulse)
+		t.Equal(uint16(855), turbo.ZeroPulse)
+		t.Equal(uint16(1710), turbo.OnePulse)
+		t.Equal(uint16(8063), turbo.PilotPulses)
+		t.Equal(byte(7), turbo.UsedBits)
+		t.Equal(uint16(1000), turbo.Pause)
+		t.True(bytes.Equal([]byte{0xff, 0x80}, turbo.Data))
+
+		tone := tzx.GetBlock(1).(*TZXPureTone)
+		t.Equal(uint16(2168), tone.PulseLen)
+		t.Equal(uint16(16), tone.NumPulses)
+
+		sequence := tzx.GetBlock(2).(*TZXPulseSequence)
+		t.Equal(2, len(sequence.Pulses))
+		t.Equal(uint16(735), sequence.Pulses[1])
+
+		pureData := tzx.GetBlock(3).(*TZXPureData)
+		t.Equal(byte(8), pureData.UsedBits)
+		t.True(bytes.Equal([]byte{0xaa}, pureData.Data))
+
+		recording := tzx.GetBlock(4).(*TZXDirectRecording)
+		t.Equal(uint16(79), recording.TStatesPerSample)
+		t.Equal(byte(4), recording.UsedBits)
+		t.True(bytes.Equal([]byte{0xf0}, recording.Data))
+
+		t.Equal("TEST", tzx.GetBlock(5).(*TZXGroupStart).Name)
+		t.Equal(uint16(100), tzx.GetBlock(6).(*TZXPause).Duration)
+		t.Equal(byte(TZX_BLOCK_GROUP_END), tzx.GetBlock(7).ID())
+		t.Equal(uint16(3), tzx.GetBlock(8).(*TZXLoopStart).Repetitions)
+		t.Equal(byte(TZX_BLOCK_LOOP_END), tzx.GetBlock(10).ID())
+		t.Equal(byte(TZX_BLOCK_STOP_48K), tzx.GetBlock(11).ID())
+	}
+}
+
+func (t *testSuite) TestReadTZXError() {
+	_, err := NewTZX(nil)
+	t.Not(t.Nil(err))
+
+	// Truncated standard speed block
+	_, err = NewTZX([]byte("ZXTape!\x1a\x01\x14\x10\xe8\x03\x13\x00\x00"))
+	t.Not(t.Nil(err))
+}
+
+func (t *testSuite) TestReadProgram_TZX() {
+	program, err := ReadProgram(tzxProgramFn)
+	_, ok := program.(*TZX)
+
+	t.Nil(err)
+	t.True(ok)
+}
+
+func (t *testSuite) TestReadProgram_TZX_ZIP() {
+	program, err := ReadProgram("testdata/hello.tzx.zip")
+	_, ok := program.(*TZX)
+
+	t.Nil(err)
+	t.True(ok)
+}
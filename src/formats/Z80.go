@@ -7,6 +7,8 @@ type Z80 struct {
 	ula UlaState
 	mem [48 * 1024]byte
 
+	state128k *State128k // nil in case of a 48k machine
+
 	samRom                   bool
 	issue2_emulation         bool
 	doubleInterruptFrequency bool
@@ -111,12 +113,15 @@ func (data SnapshotData) readHeader_v1(s *Z80) error {
 	s.videoSynchronization = ((data[29] >> 4) & 0x03)
 	s.joystick = ((data[29] >> 6) & 0x03)
 
+	if s.issue2_emulation {
+		s.ula.Issue = 2
+	} else {
+		s.ula.Issue = 3
+	}
+
 	if s.samRom {
 		return errors.New("unsupported feature: SamRom")
 	}
-	if s.issue2_emulation {
-		return errors.New("unsupported feature: Issue 2 emulation")
-	}
 
 	return nil
 }
@@ -195,12 +200,17 @@ func (data SnapshotData) decodeZ80_v2() (*Z80, error) {
 		// 48k
 	case 1:
 		// 48k + If.1
+	case 3:
+		// 128k
+		data.readHeader_128k(&s)
+	case 4:
+		// 128k + If.1
+		data.readHeader_128k(&s)
 	default:
 		return nil, errors.New("read Z80 snapshot version 2.01: unsupported hardware mode")
 	}
 
-	// data[35]: no meaning in 48k mode
-	// data[36]: no meaning in 48k mode
+	// data[36]: ignored
 
 	var modifyHardware bool = ((data[37] >> 7) != 0)
 	if modifyHardware {
@@ -208,8 +218,6 @@ func (data SnapshotData) decodeZ80_v2() (*Z80, error) {
 	}
 
 	// rest of data[37]: ignored
-	// data[38]: ignored
-	// data[39..54]: ignored
 
 	// Memory blocks
 	{
@@ -249,12 +257,17 @@ func (data SnapshotData) decodeZ80_v3() (*Z80, error) {
 		// 48k
 	case 1:
 		// 48k + If.1
+	case 4:
+		// 128k
+		data.readHeader_128k(&s)
+	case 5:
+		// 128k + If.1
+		data.readHeader_128k(&s)
 	default:
 		return nil, errors.New("read Z80 snapshot version 3.0x: unsupported hardware mode")
 	}
 
-	// data[35]: no meaning in 48k mode
-	// data[36]: no meaning in 48k mode
+	// data[36]: ignored
 
 	var modifyHardware bool = ((data[37] >> 7) != 0)
 	if modifyHardware {
@@ -262,12 +275,13 @@ func (data SnapshotData) decodeZ80_v3() (*Z80, error) {
 	}
 
 	// rest of data[37]: ignored
-	// data[38]: ignored
-	// data[39..54]: ignored
 
 	tstate_low := uint(data[55]) | (uint(data[56]) << 8)
 	tstate_hi := uint(data[57] & 0x03)
-	const T4 = TStatesPerFrame / 4
+	T4 := uint(TStatesPerFrame / 4)
+	if s.state128k != nil {
+		T4 = TStatesPerFrame128k / 4
+	}
 	s.cpu.Tstate = ((tstate_hi-3)%4)*T4 + (T4 - (tstate_low % T4) - 1)
 
 	// data[58]: always ignored
@@ -312,6 +326,14 @@ func (data SnapshotData) decodeZ80_v3() (*Z80, error) {
 	return &s, nil
 }
 
+// Reads the part of the version 2/3 header which is specific to 128k machines
+func (data SnapshotData) readHeader_128k(s *Z80) {
+	s.state128k = new(State128k)
+	s.state128k.Port7ffd = data[35]
+	s.state128k.Ay.SelectedRegister = data[38]
+	copy(s.state128k.Ay.Registers[:], data[39:55])
+}
+
 func z80_loadMemBlocks(s *Z80, data []byte) error {
 	pages := make(map[byte]([]byte))
 
@@ -346,6 +368,10 @@ func z80_loadMemBlocks(s *Z80, data []byte) error {
 		return errors.New("invalid Z80 snapshot")
 	}
 
+	if s.state128k != nil {
+		return z80_load128kPages(s, pages)
+	}
+
 	if len(pages) != 3 {
 		return errors.New("invalid Z80 snapshot")
 	}
@@ -379,6 +405,31 @@ func z80_loadMemBlocks(s *Z80, data []byte) error {
 	return nil
 }
 
+// In 128k mode, pages 3..10 contain RAM banks 0..7
+func z80_load128kPages(s *Z80, pages map[byte]([]byte)) error {
+	if len(pages) != 8 {
+		return errors.New("invalid Z80 snapshot")
+	}
+
+	for page, pageData := range pages {
+		if !((page >= 3) && (page <= 10)) {
+			return errors.New("invalid Z80 snapshot")
+		}
+		if len(pageData) != 0x4000 {
+			return errors.New("invalid Z80 snapshot")
+		}
+
+		copy(s.state128k.Ram[page-3][:], pageData)
+	}
+
+	// The 48k of RAM visible to the CPU
+	copy(s.mem[0x0000:], s.state128k.Ram[5][:])
+	copy(s.mem[0x4000:], s.state128k.Ram[2][:])
+	copy(s.mem[0x8000:], s.state128k.Ram[s.state128k.Port7ffd&0x07][:])
+
+	return nil
+}
+
 func z80_decompress(in []byte) []byte {
 	// The input is decompressed in 2 phases:
 	//  1. Determine output size
@@ -441,3 +492,155 @@ func (s *Z80) UlaState() UlaState {
 func (s *Z80) Memory() *[48 * 1024]byte {
 	return &s.mem
 }
+
+func (s *Z80) State128k() *State128k {
+	return s.state128k
+}
+
+// Turn snapshot into binary data (Z80 format, version 3.0x)
+func (s *FullSnapshot) EncodeZ80() ([]byte, error) {
+	data := make([]byte, _Z80_V3_HEADER_SIZE, _Z80_V3_HEADER_SIZE+8*(3+0x4000))
+
+	// Version 1 header
+	data[0] = s.Cpu.A
+	data[1] = s.Cpu.F
+	data[2] = s.Cpu.C
+	data[3] = s.Cpu.B
+	data[4] = s.Cpu.L
+	data[5] = s.Cpu.H
+	// data[6..7]: PC is zero, which means version 2 or later
+	data[8] = byte(s.Cpu.SP & 0xff)
+	data[9] = byte(s.Cpu.SP >> 8)
+	data[10] = s.Cpu.I
+	data[11] = s.Cpu.R & 0x7f
+	data[12] = ((s.Cpu.R >> 7) & 0x01) | ((s.Ula.Border & 0x07) << 1)
+	data[13] = s.Cpu.E
+	data[14] = s.Cpu.D
+	data[15] = s.Cpu.C_
+	data[16] = s.Cpu.B_
+	data[17] = s.Cpu.E_
+	data[18] = s.Cpu.D_
+	data[19] = s.Cpu.L_
+	data[20] = s.Cpu.H_
+	data[21] = s.Cpu.A_
+	data[22] = s.Cpu.F_
+	data[23] = byte(s.Cpu.IY & 0xff)
+	data[24] = byte(s.Cpu.IY >> 8)
+	data[25] = byte(s.Cpu.IX & 0xff)
+	data[26] = byte(s.Cpu.IX >> 8)
+
+	if s.Cpu.IFF1 != 0 {
+		data[27] = 1
+	}
+	if s.Cpu.IFF2 != 0 {
+		data[28] = 1
+	}
+
+	data[29] = s.Cpu.IM & 0x03
+	if s.Ula.Issue == 2 {
+		data[29] |= 0x04
+	}
+
+	// Version 3 header
+	extendedHeaderLength := _Z80_V3_HEADER_SIZE - _Z80_V1_HEADER_SIZE - 2
+	data[30] = byte(extendedHeaderLength)
+	data[31] = 0
+	data[32] = byte(s.Cpu.PC & 0xff)
+	data[33] = byte(s.Cpu.PC >> 8)
+
+	var tstatesPerFrame uint = TStatesPerFrame
+	if s.Machine128k != nil {
+		data[34] = 4 // Hardware mode: 128k
+		data[35] = s.Machine128k.Port7ffd
+		data[38] = s.Machine128k.Ay.SelectedRegister
+		copy(data[39:55], s.Machine128k.Ay.Registers[:])
+
+		tstatesPerFrame = TStatesPerFrame128k
+	} else {
+		data[34] = 0 // Hardware mode: 48k
+
+		// data[35..54]: no meaning in 48k mode
+	}
+
+	T4 := tstatesPerFrame / 4
+	tstate := s.Cpu.Tstate % tstatesPerFrame
+	tstate_low := T4 - (tstate % T4) - 1
+	data[55] = byte(tstate_low & 0xff)
+	data[56] = byte(tstate_low >> 8)
+	data[57] = byte((tstate/T4 + 3) % 4)
+
+	// data[58..85]: zero, meaning no Spectator, MGT, Multiface, RAM in ROM area,
+	// user defined joysticks, disk interfaces
+
+	// Memory blocks
+	if s.Machine128k != nil {
+		for bank := byte(0); bank < 8; bank++ {
+			data = z80_appendMemBlock(data, bank+3, s.Machine128k.Ram[bank][:])
+		}
+	} else {
+		for _, block := range []struct {
+			page byte
+			addr int
+		}{
+			{8, 0x4000},
+			{4, 0x8000},
+			{5, 0xc000},
+		} {
+			data = z80_appendMemBlock(data, block.page, s.Mem[block.addr-0x4000:block.addr-0x4000+0x4000])
+		}
+	}
+
+	return data, nil
+}
+
+func z80_appendMemBlock(data []byte, page byte, pageData []byte) []byte {
+	compressed := z80_compress(pageData)
+	if len(compressed) < 0x4000 {
+		data = append(data, byte(len(compressed)&0xff), byte(len(compressed)>>8), page)
+		data = append(data, compressed...)
+	} else {
+		data = append(data, 0xff, 0xff, page)
+		data = append(data, pageData...)
+	}
+
+	return data
+}
+
+// Compresses the data using the RLE scheme of the Z80 format:
+// a sequence of 5 or more identical bytes, or of 2 or more 0xED bytes,
+// is replaced by 0xED 0xED count value.
+func z80_compress(in []byte) []byte {
+	out := make([]byte, 0, len(in))
+
+	len_in := len(in)
+	i := 0
+	for i < len_in {
+		value := in[i]
+
+		count := 1
+		for (i+count < len_in) && (in[i+count] == value) && (count < 255) {
+			count++
+		}
+
+		switch {
+		case (count >= 5) || ((value == 0xED) && (count >= 2)):
+			out = append(out, 0xED, 0xED, byte(count), value)
+			i += count
+
+		case value == 0xED:
+			// The byte directly following a single 0xED is never compressed
+			out = append(out, value)
+			i++
+			if i < len_in {
+				out = append(out, in[i])
+				i++
+			}
+
+		default:
+			out = append(out, value)
+			i++
+		}
+	}
+
+	return out
+}
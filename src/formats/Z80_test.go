@@ -0,0 +1,110 @@
+package formats
+
+import (
+	"bytes"
+	"io/ioutil"
+)
+
+func (t *testSuite) TestZ80Compress() {
+	in := []byte{1, 2, 2, 2, 2, 2, 0xed, 3, 0xed, 0xed, 4, 4, 4, 4, 0xed}
+	t.True(bytes.Equal(in, z80_decompress(z80_compress(in))))
+
+	long := make([]byte, 0x4000)
+	compressed := z80_compress(long)
+	t.True(len(compressed) < 300)
+	t.True(bytes.Equal(long, z80_decompress(compressed)))
+}
+
+func (t *testSuite) TestEncodeZ80() {
+	data, err := ioutil.ReadFile("testdata/fire.z80")
+	t.Nil(err)
+	z80, err := SnapshotData(data).DecodeZ80()
+	t.Nil(err)
+
+	if !t.Failed() {
+		snapshot := &FullSnapshot{Cpu: z80.CpuState(), Ula: z80.UlaState(), Mem: *z80.Memory()}
+
+		encoded, err := snapshot.EncodeZ80()
+		t.Nil(err)
+		t.Equal(_Z80_V3_HEADER_SIZE, int(encoded[30])+_Z80_V1_HEADER_SIZE+2)
+
+		decoded, err := SnapshotData(encoded).DecodeZ80()
+		t.Nil(err)
+
+		if !t.Failed() {
+			t.Equal(snapshot.Cpu, decoded.CpuState())
+			t.Equal(snapshot.Ula, decoded.UlaState())
+			t.True(bytes.Equal(snapshot.Mem[:], decoded.Memory()[:]))
+		}
+	}
+}
+
+func (t *testSuite) TestEncodeZ80_128k() {
+	data, err := ioutil.ReadFile("testdata/fire.z80")
+	t.Nil(err)
+	z80, err := SnapshotData(data).DecodeZ80()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.True(z80.State128k() == nil)
+
+		snapshot := &FullSnapshot{Cpu: z80.CpuState(), Ula: z80.UlaState()}
+		snapshot.Cpu.Tstate = 70000
+
+		state := new(State128k)
+		state.Port7ffd = 0x13
+		state.Ay.SelectedRegister = 8
+		state.Ay.Registers[8] = 0x0f
+		for bank := 0; bank < 8; bank++ {
+			state.Ram[bank][0] = byte(bank)
+			state.Ram[bank][0x3fff] = byte(0x80 | bank)
+		}
+		snapshot.Machine128k = state
+
+		encoded, err := snapshot.EncodeZ80()
+		t.Nil(err)
+		t.Equal(byte(4), encoded[34])
+
+		decoded, err := SnapshotData(encoded).DecodeZ80()
+		t.Nil(err)
+
+		if !t.Failed() {
+			t.Equal(snapshot.Cpu, decoded.CpuState())
+			t.Equal(snapshot.Ula, decoded.UlaState())
+			t.True(decoded.State128k() != nil)
+
+			if !t.Failed() {
+				t.Equal(*state, *decoded.State128k())
+
+				// The 48k view contains banks 5, 2 and the bank paged in at 0xc000
+				t.Equal(byte(5), decoded.Memory()[0x0000])
+				t.Equal(byte(2), decoded.Memory()[0x4000])
+				t.Equal(byte(3), decoded.Memory()[0x8000])
+			}
+		}
+	}
+}
+
+func (t *testSuite) TestEncodeZ80_Issue2() {
+	data, err := ioutil.ReadFile("testdata/fire.z80")
+	t.Nil(err)
+	z80, err := SnapshotData(data).DecodeZ80()
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(byte(3), z80.UlaState().Issue)
+
+		snapshot := &FullSnapshot{Cpu: z80.CpuState(), Ula: z80.UlaState(), Mem: *z80.Memory()}
+		snapshot.Ula.Issue = 2
+
+		encoded, err := snapshot.EncodeZ80()
+		t.Nil(err)
+
+		decoded, err := SnapshotData(encoded).DecodeZ80()
+		t.Nil(err)
+
+		if !t.Failed() {
+			t.Equal(byte(2), decoded.UlaState().Issue)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package formats
 
 import (
+	"bytes"
 	"errors"
 	"io/ioutil"
 	"path"
@@ -9,8 +10,9 @@ import (
 )
 
 const (
-	TStatesPerFrame = 69888
-	InterruptLength = 32
+	TStatesPerFrame     = 69888
+	TStatesPerFrame128k = 70908
+	InterruptLength     = 32
 )
 
 type CpuState struct {
@@ -21,11 +23,48 @@ type CpuState struct {
 	SP, PC                         uint16
 
 	Tstate uint
+
+	// The CPU is executing a HALT instruction
+	Halted bool
+
+	// The last executed instruction was EI, so an interrupt cannot be accepted yet
+	LastEI bool
+
+	// The internal register MEMPTR (also known as WZ)
+	MEMPTR uint16
 }
 
 type UlaState struct {
 	// 0..7
 	Border byte
+
+	// The issue of the machine's board (2 or 3), or 0 if the snapshot does not record it.
+	// It determines the value of bit 6 read from port 0xfe.
+	Issue byte
+
+	// The state of the ULAplus palette, or nil if the snapshot does not record it
+	ULAplus *ULAplusState
+
+	// The state of the Timex SCLD, or nil if the snapshot was not taken from a Timex machine
+	Timex *TimexState
+}
+
+// The state of the Timex SCLD
+type TimexState struct {
+	// The last value written to port 0xff (screen mode and interrupt disabling)
+	PortFF byte
+}
+
+// The state of the ULAplus palette extension
+type ULAplusState struct {
+	// Whether the 64-colour palette mode is enabled
+	Enabled bool
+
+	// The last value written to port 0xbf3b
+	Register byte
+
+	// The palette registers, in GRB332 format
+	Palette [64]byte
 }
 
 type Snapshot interface {
@@ -34,10 +73,38 @@ type Snapshot interface {
 	Memory() *[48 * 1024]byte
 }
 
+// The state of the AY-3-8912 sound chip
+type AyState struct {
+	SelectedRegister byte
+	Registers        [16]byte
+}
+
+// The state specific to machines with 128k of memory
+type State128k struct {
+	// The last value written to port 0x7ffd
+	Port7ffd byte
+
+	// All eight 16k RAM banks
+	Ram [8][0x4000]byte
+
+	Ay AyState
+}
+
+// Implemented by snapshots which are able to hold the state of a 128k machine
+type Snapshot128k interface {
+	Snapshot
+
+	// Returns nil if the snapshot was taken from a 48k machine
+	State128k() *State128k
+}
+
 type FullSnapshot struct {
 	Cpu CpuState
 	Ula UlaState
 	Mem [48 * 1024]byte
+
+	// The state of a 128k machine, or nil
+	Machine128k *State128k
 }
 
 func (s *FullSnapshot) CpuState() CpuState {
@@ -52,6 +119,10 @@ func (s *FullSnapshot) Memory() *[48 * 1024]byte {
 	return &s.Mem
 }
 
+func (s *FullSnapshot) State128k() *State128k {
+	return s.Machine128k
+}
+
 type SnapshotData []byte
 
 type Archive interface {
@@ -63,6 +134,9 @@ const (
 	FORMAT_SNA = iota
 	FORMAT_Z80
 	FORMAT_TAP
+	FORMAT_TZX
+	FORMAT_SZX
+	FORMAT_RZX
 )
 
 const (
@@ -75,50 +149,36 @@ type FormatInfo struct {
 	Encapsulation int
 }
 
-// Determines the format of the specified file based on its name,
-// or based on the names of embedded files in case the file is an archive.
+// Determines the format of the specified file based on its contents,
+// or based on the contents of embedded files in case the file is an archive.
+// The name of the file is used only to choose between formats matching the contents.
 // Returns an error if the format could not be detected.
 func DetectFormat(filePath string) (*FormatInfo, error) {
-	return detectFormat(filePath, ENCAPSULATION_NONE, true)
-}
-
-func detectFormat(filePath string, encapsulation int, allowEncapsulation bool) (*FormatInfo, error) {
-	ext := strings.ToLower(path.Ext(filePath))
-
-	switch ext {
-	case ".sna":
-		return &FormatInfo{FORMAT_SNA, encapsulation}, nil
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
 
-	case ".z80":
-		return &FormatInfo{FORMAT_Z80, encapsulation}, nil
+	return detectFormat(data, filePath, ENCAPSULATION_NONE, true)
+}
 
-	case ".tap":
-		return &FormatInfo{FORMAT_TAP, encapsulation}, nil
+// Determines the format of the binary data based on signatures and on the structure of the data.
+// Returns an error if the format could not be detected.
+func DetectFormatFromBytes(data []byte) (*FormatInfo, error) {
+	return detectFormat(data, "", ENCAPSULATION_NONE, true)
+}
 
-	case ".zip":
+func detectFormat(data []byte, filePath string, encapsulation int, allowEncapsulation bool) (*FormatInfo, error) {
+	if isZIP(data) {
 		if (encapsulation == ENCAPSULATION_NONE) && allowEncapsulation {
-			archive, err := ReadZipFile(filePath)
+			archive, err := ReadZip(bytes.NewReader(data), int64(len(data)))
 			if err != nil {
 				return nil, err
 			}
 
-			var embeddedFile_format *FormatInfo
-			{
-				n := 0
-				for _, name := range archive.Filenames() {
-					format, err := detectFormat(name, ENCAPSULATION_ZIP, false)
-					if err == nil {
-						embeddedFile_format = format
-						n++
-					}
-				}
-
-				if n == 0 {
-					return nil, errors.New("the archive does not contain any supported files")
-				}
-				if n >= 2 {
-					return nil, errors.New("the archive contains multiple supported files")
-				}
+			_, embeddedFile_format, err := findEmbeddedFile(archive)
+			if err != nil {
+				return nil, err
 			}
 
 			return embeddedFile_format, nil
@@ -127,90 +187,256 @@ func detectFormat(filePath string, encapsulation int, allowEncapsulation bool) (
 		}
 	}
 
-	return nil, errors.New("unrecognized file format")
+	candidates := matchingFormats(data)
+	if len(candidates) == 0 {
+		return nil, errors.New("unrecognized file format")
+	}
+
+	// If the contents match several formats, the extension decides
+	if format, ok := formatFromExtension(filePath); ok {
+		for _, candidate := range candidates {
+			if candidate == format {
+				return &FormatInfo{candidate, encapsulation}, nil
+			}
+		}
+	}
+
+	return &FormatInfo{candidates[0], encapsulation}, nil
 }
 
-// Decode a snapshot from binary data.
-// The filename is a hint used to determine the snapshot format.
-func (data SnapshotData) Decode(format int) (Snapshot, error) {
-	switch format {
-	case FORMAT_SNA:
-		return data.DecodeSNA()
+// Returns the contents and the format of the only supported file contained in the archive
+func findEmbeddedFile(archive Archive) ([]byte, *FormatInfo, error) {
+	var embeddedFile_data []byte
+	var embeddedFile_format *FormatInfo
 
-	case FORMAT_Z80:
-		return data.DecodeZ80()
+	n := 0
+	for i, name := range archive.Filenames() {
+		data, err := archive.Read(i)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		format, err := detectFormat(data, name, ENCAPSULATION_ZIP, false)
+		if err == nil {
+			embeddedFile_data = data
+			embeddedFile_format = format
+			n++
+		}
 	}
 
-	return nil, errors.New("unknown snapshot format")
+	if n == 0 {
+		return nil, nil, errors.New("the archive does not contain any supported files")
+	}
+	if n >= 2 {
+		return nil, nil, errors.New("the archive contains multiple supported files")
+	}
+
+	return embeddedFile_data, embeddedFile_format, nil
 }
 
-func readZIP(filePath string) (interface{}, error) {
-	archive, err := ReadZipFile(filePath)
-	if err != nil {
-		return nil, err
+// Returns the format corresponding to the extension of the file name
+func formatFromExtension(filePath string) (int, bool) {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".sna":
+		return FORMAT_SNA, true
+	case ".z80":
+		return FORMAT_Z80, true
+	case ".szx":
+		return FORMAT_SZX, true
+	case ".tap":
+		return FORMAT_TAP, true
+	case ".tzx":
+		return FORMAT_TZX, true
+	case ".rzx":
+		return FORMAT_RZX, true
 	}
 
-	var embeddedFile_index int
-	var embeddedFile_format *FormatInfo
-	{
-		n := 0
-		for i, name := range archive.Filenames() {
-			format, err := detectFormat(name, ENCAPSULATION_ZIP, false)
-			if err == nil {
-				embeddedFile_index = i
-				embeddedFile_format = format
-				n++
-			}
+	return 0, false
+}
+
+// Returns the formats whose structure matches the data, the most likely format first
+func matchingFormats(data []byte) []int {
+	switch {
+	case bytes.HasPrefix(data, []byte(tzxSignature)):
+		return []int{FORMAT_TZX}
+	case bytes.HasPrefix(data, []byte(szxSignature)):
+		return []int{FORMAT_SZX}
+	case bytes.HasPrefix(data, []byte(rzxSignature)):
+		return []int{FORMAT_RZX}
+	}
+
+	var formats []int
+	if isTAP(data) {
+		formats = append(formats, FORMAT_TAP)
+	}
+	if isSNA(data) {
+		formats = append(formats, FORMAT_SNA)
+	}
+	if isZ80(data) {
+		formats = append(formats, FORMAT_Z80)
+	}
+
+	return formats
+}
+
+func isZIP(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04"))
+}
+
+// Returns true if the data is a sequence of blocks with valid lengths and checksums
+func isTAP(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	pos := 0
+	for pos < len(data) {
+		if pos+2 > len(data) {
+			return false
 		}
 
-		if n == 0 {
-			return nil, errors.New("the archive does not contain any supported program files")
+		blockLength := int(data[pos]) | (int(data[pos+1]) << 8)
+		pos += 2
+
+		if (blockLength < 2) || (pos+blockLength > len(data)) {
+			return false
 		}
-		if n >= 2 {
-			return nil, errors.New("the archive contains multiple program files")
+		if parity(data[pos:pos+blockLength]) != 0 {
+			return false
 		}
+
+		pos += blockLength
 	}
 
-	var data []byte
-	data, err = archive.Read(embeddedFile_index)
-	if err != nil {
-		return nil, err
+	return true
+}
+
+func isSNA(data []byte) bool {
+	return (len(data) == 49179) && (data[25] <= 2)
+}
+
+// Returns true if the Z80 header is consistent with the size of the data
+func isZ80(data []byte) bool {
+	if len(data) < _Z80_V1_HEADER_SIZE {
+		return false
+	}
+	if (data[29] & 0x03) == 3 {
+		// Invalid interrupt mode
+		return false
 	}
 
-	if embeddedFile_format.Format == FORMAT_TAP {
+	PC := uint16(data[6]) | (uint16(data[7]) << 8)
+
+	if PC != 0 {
+		// Version 1.xx
+		data12 := data[12]
+		if data12 == 255 {
+			data12 = 1
+		}
+
+		if (data12 & 0x20) != 0 {
+			return bytes.HasSuffix(data[_Z80_V1_HEADER_SIZE:], []byte{0x00, 0xED, 0xED, 0x00})
+		}
+		return len(data) == _Z80_V1_HEADER_SIZE+48*1024
+	}
+
+	// Version 2.01 or 3.0x
+	if len(data) < _Z80_V2_HEADER_SIZE {
+		return false
+	}
+
+	headerSize := _Z80_V1_HEADER_SIZE + 2 + (int(data[30]) | (int(data[31]) << 8))
+	switch headerSize {
+	case _Z80_V2_HEADER_SIZE, _Z80_V3_HEADER_SIZE, _Z80_V3X_HEADER_SIZE:
+	default:
+		return false
+	}
+
+	// The memory blocks have to fill the rest of the data exactly
+	numBlocks := 0
+	pos := headerSize
+	for pos < len(data) {
+		if pos+3 > len(data) {
+			return false
+		}
+
+		length := int(data[pos]) | (int(data[pos+1]) << 8)
+		if length == 0xFFFF {
+			length = 0x4000
+		}
+
+		pos += 3 + length
+		numBlocks++
+	}
+
+	return (pos == len(data)) && (numBlocks > 0)
+}
+
+// Decode a snapshot from binary data.
+func (data SnapshotData) Decode(format int) (Snapshot, error) {
+	switch format {
+	case FORMAT_SNA:
+		return data.DecodeSNA()
+
+	case FORMAT_Z80:
+		return data.DecodeZ80()
+
+	case FORMAT_SZX:
+		return data.DecodeSZX()
+	}
+
+	return nil, errors.New("unknown snapshot format")
+}
+
+// Decodes a tape, a snapshot or an input recording from binary data
+func decodeProgram(data []byte, format int) (interface{}, error) {
+	switch format {
+	case FORMAT_TAP:
 		return NewTAP(data)
+
+	case FORMAT_TZX:
+		return NewTZX(data)
+
+	case FORMAT_RZX:
+		return NewRZX(data)
 	}
 
-	return SnapshotData(data).Decode(embeddedFile_format.Format)
+	return SnapshotData(data).Decode(format)
 }
 
 // Read a program from the specified file.
 // Return the program and errors if any.
 // The file can be compressed.
+// The format is determined from the contents of the file,
+// the extension is used only if the contents match several formats.
 func ReadProgram(filePath string) (interface{}, error) {
-	ext := strings.ToLower(path.Ext(filePath))
-
-	// ZIP archive
-	if ext == ".zip" {
-		return readZIP(filePath)
-	}
-
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
 
+	// ZIP archive
+	if isZIP(data) {
+		archive, err := ReadZip(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+
+		embeddedFile_data, embeddedFile_format, err := findEmbeddedFile(archive)
+		if err != nil {
+			return nil, err
+		}
+
+		return decodeProgram(embeddedFile_data, embeddedFile_format.Format)
+	}
+
 	var format *FormatInfo
-	format, err = detectFormat(filePath, ENCAPSULATION_NONE, false)
+	format, err = detectFormat(data, filePath, ENCAPSULATION_NONE, false)
 	if err != nil {
 		return nil, err
 	}
 
-	if format.Format == FORMAT_TAP {
-		return NewTAP(data)
-	}
-
-	return SnapshotData(data).Decode(format.Format)
+	return decodeProgram(data, format.Format)
 }
 
 func splitWord(word uint16) (byte, byte) {
@@ -1,6 +1,9 @@
 package formats
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"strings"
 	"testing"
 
@@ -49,6 +52,68 @@ func (t *testSuite) TestReadProgram_ZIP_ambiguous() {
 	t.True(strings.Contains(err.Error(), "multiple"))
 }
 
+func (t *testSuite) TestDetectFormatFromBytes() {
+	files := []struct {
+		path          string
+		format        int
+		encapsulation int
+	}{
+		{"testdata/fire.sna", FORMAT_SNA, ENCAPSULATION_NONE},
+		{"testdata/fire.z80", FORMAT_Z80, ENCAPSULATION_NONE},
+		{"testdata/fire.tap", FORMAT_TAP, ENCAPSULATION_NONE},
+		{"testdata/hello.tap", FORMAT_TAP, ENCAPSULATION_NONE},
+		{"testdata/code.tap", FORMAT_TAP, ENCAPSULATION_NONE},
+		{"testdata/hello.tzx", FORMAT_TZX, ENCAPSULATION_NONE},
+		{"testdata/fire.sna.zip", FORMAT_SNA, ENCAPSULATION_ZIP},
+		{"testdata/hello.tzx.zip", FORMAT_TZX, ENCAPSULATION_ZIP},
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file.path)
+		t.Nil(err)
+
+		format, err := DetectFormatFromBytes(data)
+		t.Nil(err)
+		if !t.Failed() {
+			t.Equal(file.format, format.Format)
+			t.Equal(file.encapsulation, format.Encapsulation)
+		}
+	}
+
+	_, err := DetectFormatFromBytes([]byte("Hello World"))
+	t.Not(t.Nil(err))
+
+	_, err = DetectFormatFromBytes(nil)
+	t.Not(t.Nil(err))
+}
+
+func (t *testSuite) TestReadProgram_misnamed() {
+	data, err := ioutil.ReadFile("testdata/fire.z80")
+	t.Nil(err)
+
+	dir, err := ioutil.TempDir("", "gospeccy")
+	t.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// No extension
+	filePath := path.Join(dir, "fire")
+	t.Nil(ioutil.WriteFile(filePath, data, 0600))
+
+	program, err := ReadProgram(filePath)
+	t.Nil(err)
+	_, ok := program.(*Z80)
+	t.True(ok)
+
+	// Wrong extension
+	filePath = path.Join(dir, "fire.sna")
+	t.Nil(ioutil.WriteFile(filePath, data, 0600))
+
+	program, err = ReadProgram(filePath)
+	t.Nil(err)
+	_, ok = program.(*Z80)
+	t.True(ok)
+}
+
 func TestFormats(t *testing.T) {
 	prettytest.Run(t, new(testSuite))
 }
@@ -75,21 +75,35 @@ func newApplication(verbose bool) *spectrum.Application {
 	return app
 }
 
-func newEmulationCore(app *spectrum.Application, acceleratedLoad bool) (*spectrum.Spectrum48k, error) {
-	romPath, err := spectrum.SystemRomPath("48.rom")
-	if err != nil {
-		return nil, err
+func newEmulationCore(app *spectrum.Application, machineType spectrum.MachineType, acceleratedLoad, instantLoad, instantSave bool) (*spectrum.Spectrum48k, error) {
+	var roms [][0x4000]byte
+	for _, romFilename := range spectrum.RomFilenames(machineType) {
+		romPath, err := app.SearchPaths.SystemRomPath(romFilename)
+		if err != nil {
+			return nil, err
+		}
+
+		rom, err := spectrum.ReadROM(romPath)
+		if err != nil {
+			return nil, err
+		}
+
+		roms = append(roms, *rom)
 	}
 
-	rom, err := spectrum.ReadROM(romPath)
+	speccy, err := spectrum.NewSpectrum(app, machineType, roms)
 	if err != nil {
 		return nil, err
 	}
-
-	speccy := spectrum.NewSpectrum48k(app, *rom)
 	if acceleratedLoad {
 		speccy.TapeDrive().AcceleratedLoad = true
 	}
+	if instantLoad {
+		speccy.TapeDrive().InstantLoad = true
+	}
+	if instantSave {
+		speccy.TapeDrive().InstantSave = true
+	}
 
 	env.Publish(speccy)
 
@@ -162,7 +176,11 @@ func exit(app *spectrum.Application) {
 var (
 	help            = flag.Bool("help", false, "Show usage")
 	acceleratedLoad = flag.Bool("accelerated-load", false, "Accelerated tape loading")
-	fps             = flag.Float64("fps", spectrum.DefaultFPS, "Frames per second")
+	instantLoad     = flag.Bool("instant-load", false, "Instant tape loading (works with the ROM loader only)")
+	instantSave     = flag.Bool("instant-save", false, "Instant tape saving into the tape written by saveTape() (works with the ROM saver only)")
+	fps             = flag.Float64("fps", 0, "Frames per second (0 means the default of the emulated machine)")
+	machine         = flag.String("machine", "48k", "Emulated machine (48k, 128k, tc2048)")
+	issue           = flag.Uint("issue", spectrum.DefaultIssue, "Board issue, which affects reading the EAR bit of port 0xfe (2, 3)")
 	verbose         = flag.Bool("verbose", false, "Enable debugging messages")
 	cpuProfile      = flag.String("hostcpu-profile", "", "Write host-CPU profile to the specified file (for 'pprof')")
 	wos             = flag.String("wos", "", "Download from WorldOfSpectrum; you must provide a query regex (ex: -wos=jetsetwilly)")
@@ -175,7 +193,7 @@ func main() {
 	// Handle options
 	{
 		flag.Usage = func() {
-			fmt.Fprintf(os.Stderr, "GoSpeccy - A ZX Spectrum 48k Emulator written in Go\n\n")
+			fmt.Fprintf(os.Stderr, "GoSpeccy - A ZX Spectrum 48k/128k Emulator written in Go\n\n")
 			fmt.Fprintf(os.Stderr, "Usage:\n\n")
 			fmt.Fprintf(os.Stderr, "\tgospeccy [options] [image.sna]\n\n")
 			fmt.Fprintf(os.Stderr, "Options are:\n\n")
@@ -228,13 +246,32 @@ func main() {
 		spectrum.InstallSignalHandler(&handler)
 	}
 
-	speccy, err := newEmulationCore(app, *acceleratedLoad)
+	machineType, err := spectrum.ParseMachineType(*machine)
 	if err != nil {
 		app.PrintfMsg("%s", err)
 		exit(app)
 		return
 	}
 
+	speccy, err := newEmulationCore(app, machineType, *acceleratedLoad, *instantLoad, *instantSave)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	// Set the board issue
+	{
+		errChan := make(chan error)
+		speccy.CommandChannel <- spectrum.Cmd_SetIssue{Issue: *issue, ErrChan: errChan}
+		err := <-errChan
+		if err != nil {
+			app.PrintfMsg("%s", err)
+			exit(app)
+			return
+		}
+	}
+
 	// Run startup scripts.
 	// The startup scripts may change the display settings or enable/disable the audio.
 	// They may also terminate the program.
@@ -256,7 +293,7 @@ func main() {
 		programName = file
 
 		var err error
-		path, err := spectrum.ProgramPath(file)
+		path, err := app.SearchPaths.ProgramPath(file)
 		if err != nil {
 			app.PrintfMsg("%s", err)
 			exit(app)
@@ -341,7 +378,8 @@ func main() {
 	if program_orNil != nil {
 		program := program_orNil
 
-		if _, isTAP := program.(*formats.TAP); isTAP {
+		switch program.(type) {
+		case *formats.TAP, *formats.TZX:
 			romLoaded := make(chan (<-chan bool))
 			speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
 			<-(<-romLoaded)
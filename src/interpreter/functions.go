@@ -2,23 +2,23 @@ package interpreter
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/remogatto/gospeccy/src/asm"
+	"github.com/remogatto/gospeccy/src/disasm"
 	"github.com/remogatto/gospeccy/src/formats"
 	"github.com/remogatto/gospeccy/src/spectrum"
 	"github.com/sbinet/go-eval"
 )
 
-// Contains the names of all defined functions
-var definedFunctions = make(map[string]byte)
-
-func defineFunction(name string, t *eval.FuncType, f eval.FuncValue) {
-	w.DefineVar(name, t, f)
-	definedFunctions[name] = 0
+func (intp *Interpreter) defineFunction(name string, t *eval.FuncType, f eval.FuncValue) {
+	intp.w.DefineVar(name, t, f)
+	intp.definedFunctions[name] = 0
 }
 
 type Function struct {
@@ -29,54 +29,57 @@ type Function struct {
 	Help_value string
 }
 
-var functionsToAdd []Function
-
-func DefineFunction(f Function) {
-	mutex.Lock()
-	defer mutex.Unlock()
+// Defines an additional function in the interpreter
+func (intp *Interpreter) DefineFunction(f Function) {
+	intp.mutex.Lock()
+	defer intp.mutex.Unlock()
 
-	if w == nil {
+	if intp.w == nil {
 		// Postpone the function definition until interpreter initialization
-		functionsToAdd = append(functionsToAdd, f)
+		intp.functionsToAdd = append(intp.functionsToAdd, f)
 	} else {
-		defineFunction(f.Name, f.Type, f.Value)
+		evalMutex.Lock()
+		intp.defineFunction(f.Name, f.Type, f.Value)
+		evalMutex.Unlock()
 
 		if (f.Help_key != "") && (f.Help_value != "") {
-			help_keys = append(help_keys, f.Help_key)
-			help_vals = append(help_vals, f.Help_value)
+			intp.help_keys = append(intp.help_keys, f.Help_key)
+			intp.help_vals = append(intp.help_vals, f.Help_value)
 		}
 	}
 }
 
+// Defines an additional function in the default interpreter
+func DefineFunction(f Function) {
+	defaultInterpreter.DefineFunction(f)
+}
+
 // ================
 // Various commands
 // ================
 
-var help_keys []string
-var help_vals []string
-
 // Signature: func help()
-func wrapper_help(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	fmt.Fprintf(stdout, "\nAvailable commands:\n")
+func (intp *Interpreter) wrapper_help(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	fmt.Fprintf(intp.stdout, "\nAvailable commands:\n")
 
 	maxKeyLen := 1
-	for i := 0; i < len(help_keys); i++ {
-		if len(help_keys[i]) > maxKeyLen {
-			maxKeyLen = len(help_keys[i])
+	for i := 0; i < len(intp.help_keys); i++ {
+		if len(intp.help_keys[i]) > maxKeyLen {
+			maxKeyLen = len(intp.help_keys[i])
 		}
 	}
 
-	for i := 0; i < len(help_keys); i++ {
-		fmt.Fprintf(stdout, "  %s", help_keys[i])
-		for j := len(help_keys[i]); j < maxKeyLen; j++ {
-			fmt.Fprintf(stdout, " ")
+	for i := 0; i < len(intp.help_keys); i++ {
+		fmt.Fprintf(intp.stdout, "  %s", intp.help_keys[i])
+		for j := len(intp.help_keys[i]); j < maxKeyLen; j++ {
+			fmt.Fprintf(intp.stdout, " ")
 		}
-		fmt.Fprintf(stdout, "  %s\n", help_vals[i])
+		fmt.Fprintf(intp.stdout, "  %s\n", intp.help_vals[i])
 	}
 }
 
 // Signature: func exit()
-func wrapper_exit(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_exit(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	// Implementation note:
 	//   The following test has to be there only in cases in which something can go wrong.
 	//   For example if the user tried to execute "exit(); audio(false)" then GoSpeccy would panic.
@@ -87,14 +90,14 @@ func wrapper_exit(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	//   since it is potentially possible for the statement "audio(false)" to be hidden in a defer statement.
 	//   So, the best option (until somebody implements a better one) is to convert the problematic commands
 	//   into statements that are doing nothing while the application is in the process of being exited.
-	if app.TerminationInProgress() || app.Terminated() {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
-	app.RequestExit()
+	intp.app.RequestExit()
 }
 
 // Signature: func vars() []string
-func wrapper_vars(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_vars(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	vars := make([]eval.Value, 0, len(intp.vars))
 
 	for varName, _ := range intp.vars {
@@ -112,133 +115,559 @@ func wrapper_vars(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func definedFunction(name string) bool
-func wrapper_definedFunction(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_definedFunction(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	name := in[0].(eval.StringValue).Get(t)
-	_, defined := definedFunctions[name]
+	_, defined := intp.definedFunctions[name]
 	out[0].(eval.BoolValue).Set(t, defined)
 }
 
 // Signature: func reset()
-func wrapper_reset(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_reset(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 	romLoaded := make(chan (<-chan bool))
-	speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
+	intp.speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
 	<-(<-romLoaded)
 }
 
 // Signature: func addSearchPath(path string)
-func wrapper_addSearchPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_addSearchPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	path := in[0].(eval.StringValue).Get(t)
-	spectrum.AddCustomSearchPath(path)
+	intp.app.SearchPaths.AddCustomSearchPath(path)
 }
 
 // Signature: func setDownloadPath(path string)
-func wrapper_setDownloadPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_setDownloadPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	path := in[0].(eval.StringValue).Get(t)
-	spectrum.SetDownloadPath(path)
+	intp.app.SearchPaths.SetDownloadPath(path)
 }
 
-func load(path string) {
+func (intp *Interpreter) load(path string) {
 	var program interface{}
 	program, err := formats.ReadProgram(path)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
-	if _, isTAP := program.(*formats.TAP); isTAP {
+	switch program.(type) {
+	case *formats.TAP, *formats.TZX:
 		romLoaded := make(chan (<-chan bool))
-		speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
+		intp.speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
 		<-(<-romLoaded)
 	}
 
 	errChan := make(chan error)
-	speccy.CommandChannel <- spectrum.Cmd_Load{path, program, errChan}
+	intp.speccy.CommandChannel <- spectrum.Cmd_Load{path, program, errChan}
 
 	err = <-errChan
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 }
 
-// Signature: func load(path string)
-func wrapper_load(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+// Signature: func (intp *Interpreter) load(path string)
+func (intp *Interpreter) wrapper_load(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	path := in[0].(eval.StringValue).Get(t)
 
 	var err error
-	path, err = spectrum.ProgramPath(path)
+	path, err = intp.app.SearchPaths.ProgramPath(path)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
-	load(path)
+	intp.load(path)
 }
 
 // Signature: func cmdLineArg() string
-func wrapper_cmdLineArg(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	out[0].(eval.StringValue).Set(t, cmdLineArg)
+func (intp *Interpreter) wrapper_cmdLineArg(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	out[0].(eval.StringValue).Set(t, intp.cmdLineArg)
 }
 
 // Signature: func save(path string)
-func wrapper_save(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_save(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	path := in[0].(eval.StringValue).Get(t)
 
 	ch := make(chan *formats.FullSnapshot)
-	speccy.CommandChannel <- spectrum.Cmd_MakeSnapshot{ch}
+	intp.speccy.CommandChannel <- spectrum.Cmd_MakeSnapshot{ch}
 
 	fullSnapshot := <-ch
 
-	data, err := fullSnapshot.EncodeSNA()
+	var data []byte
+	var err error
+	var format string
+	switch {
+	case strings.HasSuffix(strings.ToLower(path), ".z80"):
+		data, err = fullSnapshot.EncodeZ80()
+		format = "Z80"
+	case strings.HasSuffix(strings.ToLower(path), ".szx"):
+		data, err = fullSnapshot.EncodeSZX()
+		format = "SZX"
+	default:
+		data, err = fullSnapshot.EncodeSNA()
+		format = "SNA"
+	}
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
 	err = ioutil.WriteFile(path, data, 0600)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	if intp.app.Verbose {
+		fmt.Fprintf(intp.stdout, "wrote %s snapshot \"%s\"\n", format, path)
+	}
+}
+
+// Signature: func saveTape(path string)
+func (intp *Interpreter) wrapper_saveTape(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	path := in[0].(eval.StringValue).Get(t)
+
+	ch := make(chan *formats.TAP)
+	intp.speccy.CommandChannel <- spectrum.Cmd_MakeSavedTape{Chan: ch}
+
+	tap := <-ch
+	if tap.NumBlocks() == 0 {
+		fmt.Fprintf(intp.stdout, "nothing has been saved to the tape (see instantSave)\n")
+		return
+	}
+
+	err := ioutil.WriteFile(path, tap.Encode(), 0600)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	if intp.app.Verbose {
+		fmt.Fprintf(intp.stdout, "wrote TAP file \"%s\" (%d blocks)\n", path, tap.NumBlocks())
+	}
+}
+
+// Signature: func clearSavedTape()
+func (intp *Interpreter) wrapper_clearSavedTape(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_ClearSavedTape{}
+}
+
+// Signature: func tapeResume()
+func (intp *Interpreter) wrapper_tapeResume(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_TapeResume{}
+}
+
+// Signature: func breakpoint(address uint)
+func (intp *Interpreter) wrapper_breakpoint(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	address := in[0].(eval.UintValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetBreakpoint{Address: uint16(address), Enable: true}
+}
+
+// Signature: func delBreakpoint(address uint)
+func (intp *Interpreter) wrapper_delBreakpoint(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	address := in[0].(eval.UintValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetBreakpoint{Address: uint16(address), Enable: false}
+}
+
+// Signature: func breakpoints()
+func (intp *Interpreter) wrapper_breakpoints(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	ch := make(chan spectrum.DebuggerState)
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerState{Chan: ch}
+	state := <-ch
+
+	for _, address := range state.Breakpoints {
+		fmt.Fprintf(intp.stdout, "0x%04x\n", address)
+	}
+	for _, w := range state.Watchpoints {
+		fmt.Fprintf(intp.stdout, "watch 0x%04x-0x%04x %s\n", w.Start, w.End, accessString(w.Access))
+	}
+	for _, p := range state.PortBreakpoints {
+		fmt.Fprintf(intp.stdout, "port 0x%04x mask 0x%04x %s\n", p.Port, p.Mask, accessString(p.Access))
+	}
+}
+
+// Converts "r", "w" or "rw" to spectrum.WATCH_*
+func parseAccess(mode string) (int, error) {
+	switch strings.ToLower(mode) {
+	case "r":
+		return spectrum.WATCH_READ, nil
+	case "w":
+		return spectrum.WATCH_WRITE, nil
+	case "rw", "wr":
+		return spectrum.WATCH_READ | spectrum.WATCH_WRITE, nil
+	}
+	return 0, errors.New("invalid access mode \"" + mode + "\", expected \"r\", \"w\" or \"rw\"")
+}
+
+func accessString(access int) string {
+	s := ""
+	if (access & spectrum.WATCH_READ) != 0 {
+		s += "r"
+	}
+	if (access & spectrum.WATCH_WRITE) != 0 {
+		s += "w"
+	}
+	return s
+}
+
+// Signature: func watch(start, end uint, mode string)
+func (intp *Interpreter) wrapper_watch(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	start := in[0].(eval.UintValue).Get(t)
+	end := in[1].(eval.UintValue).Get(t)
+	mode := in[2].(eval.StringValue).Get(t)
+
+	access, err := parseAccess(mode)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	watchpoint := spectrum.Watchpoint{Start: uint16(start), End: uint16(end), Access: access}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetWatchpoint{Watchpoint: watchpoint}
+}
+
+// Signature: func unwatch(start, end uint)
+func (intp *Interpreter) wrapper_unwatch(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	start := in[0].(eval.UintValue).Get(t)
+	end := in[1].(eval.UintValue).Get(t)
+
+	watchpoint := spectrum.Watchpoint{Start: uint16(start), End: uint16(end), Access: 0}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetWatchpoint{Watchpoint: watchpoint}
+}
+
+// Signature: func portBreakpoint(port, mask uint, mode string)
+func (intp *Interpreter) wrapper_portBreakpoint(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	port := in[0].(eval.UintValue).Get(t)
+	mask := in[1].(eval.UintValue).Get(t)
+	mode := in[2].(eval.StringValue).Get(t)
+
+	access, err := parseAccess(mode)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	portBreakpoint := spectrum.PortBreakpoint{Port: uint16(port), Mask: uint16(mask), Access: access}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetPortBreakpoint{PortBreakpoint: portBreakpoint}
+}
+
+// Signature: func delPortBreakpoint(port, mask uint)
+func (intp *Interpreter) wrapper_delPortBreakpoint(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	port := in[0].(eval.UintValue).Get(t)
+	mask := in[1].(eval.UintValue).Get(t)
+
+	portBreakpoint := spectrum.PortBreakpoint{Port: uint16(port), Mask: uint16(mask), Access: 0}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetPortBreakpoint{PortBreakpoint: portBreakpoint}
+}
+
+// Signature: func pause()
+func (intp *Interpreter) wrapper_pause(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerPause{}
+}
+
+// Signature: func cont()
+func (intp *Interpreter) wrapper_cont(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerContinue{}
+}
+
+// Signature: func step()
+func (intp *Interpreter) wrapper_step(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerStep{Over: false}
+}
+
+// Signature: func stepOver()
+func (intp *Interpreter) wrapper_stepOver(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerStep{Over: true}
+}
+
+// Signature: func runTo(address uint)
+func (intp *Interpreter) wrapper_runTo(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	address := in[0].(eval.UintValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerRunTo{Address: uint16(address)}
+}
+
+// Signature: func regs()
+func (intp *Interpreter) wrapper_regs(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	ch := make(chan spectrum.DebuggerState)
+	intp.speccy.CommandChannel <- spectrum.Cmd_DebuggerState{Chan: ch}
+	state := <-ch
+
+	cpu := state.Cpu
+	fmt.Fprintf(intp.stdout, "PC=%04x SP=%04x  AF=%02x%02x BC=%02x%02x DE=%02x%02x HL=%02x%02x  IX=%04x IY=%04x\n",
+		cpu.PC, cpu.SP, cpu.A, cpu.F, cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L, cpu.IX, cpu.IY)
+	fmt.Fprintf(intp.stdout, "I=%02x R=%02x  AF'=%02x%02x BC'=%02x%02x DE'=%02x%02x HL'=%02x%02x  IM=%d IFF1=%d IFF2=%d\n",
+		cpu.I, cpu.R, cpu.A_, cpu.F_, cpu.B_, cpu.C_, cpu.D_, cpu.E_, cpu.H_, cpu.L_, cpu.IM, cpu.IFF1, cpu.IFF2)
+
+	flags := []byte("SZ5H3PNC")
+	for i := range flags {
+		if (cpu.F & (0x80 >> uint(i))) == 0 {
+			flags[i] = '-'
+		}
+	}
+
+	status := "running"
+	if state.Paused {
+		status = "paused"
+	}
+	if cpu.Halted {
+		status += ", halted"
+	}
+
+	fmt.Fprintf(intp.stdout, "F=%s  frame=%d T=%d  (%s)\n", flags, state.Frame, cpu.Tstate, status)
+}
+
+// Signature: func disasm(address, count uint)
+func (intp *Interpreter) wrapper_disasm(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	address := in[0].(eval.UintValue).Get(t)
+	count := in[1].(eval.UintValue).Get(t)
+
+	ch := make(chan []disasm.Instruction)
+	intp.speccy.CommandChannel <- spectrum.Cmd_Disassemble{Address: uint16(address), Count: uint(count), Chan: ch}
+	for _, instr := range <-ch {
+		fmt.Fprintf(intp.stdout, "%s\n", instr.String())
+	}
+}
+
+func (intp *Interpreter) startTrace(config spectrum.TraceConfig) {
+	errChan := make(chan error)
+	intp.speccy.CommandChannel <- spectrum.Cmd_TraceStart{Config: config, ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func traceStart(path string)
+func (intp *Interpreter) wrapper_traceStart(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	path := in[0].(eval.StringValue).Get(t)
+	intp.startTrace(spectrum.TraceConfig{Path: path, Format: spectrum.TraceFormatFromPath(path), Start: 0x0000, End: 0xffff})
+}
+
+// Signature: func traceStartRange(path string, start, end uint)
+func (intp *Interpreter) wrapper_traceStartRange(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	path := in[0].(eval.StringValue).Get(t)
+	start := in[1].(eval.UintValue).Get(t)
+	end := in[2].(eval.UintValue).Get(t)
+	if (start > 0xffff) || (end > 0xffff) {
+		fmt.Fprintf(intp.stdout, "invalid address range\n")
+		return
+	}
+	intp.startTrace(spectrum.TraceConfig{Path: path, Format: spectrum.TraceFormatFromPath(path), Start: uint16(start), End: uint16(end)})
+}
+
+// Signature: func traceStop()
+func (intp *Interpreter) wrapper_traceStop(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	errChan := make(chan error)
+	intp.speccy.CommandChannel <- spectrum.Cmd_TraceStop{ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func rewind(seconds float32)
+func (intp *Interpreter) wrapper_rewind(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	seconds := in[0].(eval.FloatValue).Get(t)
+
+	errChan := make(chan error)
+	intp.speccy.CommandChannel <- spectrum.Cmd_Rewind{Seconds: float32(seconds), ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func rewindConfig(interval, capacity uint)
+func (intp *Interpreter) wrapper_rewindConfig(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	interval := in[0].(eval.UintValue).Get(t)
+	capacity := in[1].(eval.UintValue).Get(t)
+
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetRewind{Interval: uint(interval), Capacity: uint(capacity)}
+}
+
+// Signature: func rzxRecord(path string)
+func (intp *Interpreter) wrapper_rzxRecord(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	path := in[0].(eval.StringValue).Get(t)
+
+	errChan := make(chan error)
+	intp.speccy.CommandChannel <- spectrum.Cmd_RZXRecord{Path: path, ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func rzxStop()
+func (intp *Interpreter) wrapper_rzxStop(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	errChan := make(chan error)
+	intp.speccy.CommandChannel <- spectrum.Cmd_RZXStop{ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func asm(source string)
+func (intp *Interpreter) wrapper_asm(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	source := in[0].(eval.StringValue).Get(t)
+
+	program, err := asm.Assemble(source)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
 	}
 
-	if app.Verbose {
-		fmt.Fprintf(stdout, "wrote SNA snapshot \"%s\"", path)
+	for _, chunk := range program.Chunks {
+		intp.speccy.CommandChannel <- spectrum.Cmd_WriteMemory{Address: chunk.Address, Data: chunk.Data}
+		fmt.Fprintf(intp.stdout, "%04x-%04x (%d bytes)\n", chunk.Address, int(chunk.Address)+len(chunk.Data)-1, len(chunk.Data))
 	}
 }
 
 // Signature: func fps(n float32)
-func wrapper_fps(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_fps(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	fps := in[0].(eval.FloatValue).Get(t)
-	speccy.CommandChannel <- spectrum.Cmd_SetFPS{float32(fps), nil}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetFPS{float32(fps), nil}
 }
 
 // Signature: func ula_accuracy(accurateEmulation bool)
-func wrapper_ulaAccuracy(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_ulaAccuracy(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	accurateEmulation := in[0].(eval.BoolValue).Get(t)
-	speccy.CommandChannel <- spectrum.Cmd_SetUlaEmulationAccuracy{accurateEmulation}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetUlaEmulationAccuracy{accurateEmulation}
+}
+
+// Signature: func issue(n uint)
+func (intp *Interpreter) wrapper_issue(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	n := in[0].(eval.UintValue).Get(t)
+
+	errChan := make(chan error)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetIssue{Issue: uint(n), ErrChan: errChan}
+	err := <-errChan
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
 }
 
 // Signature: func wait(milliseconds uint)
-func wrapper_wait(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wait(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
@@ -246,78 +675,108 @@ func wrapper_wait(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	time.Sleep(time.Millisecond * time.Duration(milliseconds))
 }
 
+// Signature: func runFrames(n uint)
+func (intp *Interpreter) wrapper_runFrames(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	n := in[0].(eval.UintValue).Get(t)
+	intp.speccy.RunFrames(uint(n))
+}
+
 // Signature: func script(scriptName string)
-func wrapper_script(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_script(t *eval.Thread, in []eval.Value, out []eval.Value) {
 
-	if app.TerminationInProgress() || app.Terminated() {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	path := in[0].(eval.StringValue).Get(t)
 
 	var err error
-	path, err = spectrum.ScriptPath(path)
+	path, err = intp.app.SearchPaths.ScriptPath(path)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
-	err = runScript(w, path, false /*optional*/)
+	err = intp.runScript(path, false /*optional*/)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 }
 
 // Signature: func optionalScript(scriptName string)
-func wrapper_optionalScript(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_optionalScript(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	scriptName := in[0].(eval.StringValue).Get(t)
 
-	err := runScript(w, scriptName, true /*optional*/)
+	err := intp.runScript(scriptName, true /*optional*/)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 }
 
 // Signature: func screenshot(screenshotName string)
-func wrapper_screenshot(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_screenshot(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	path := in[0].(eval.StringValue).Get(t)
 
 	ch := make(chan []byte)
-	speccy.CommandChannel <- spectrum.Cmd_MakeVideoMemoryDump{ch}
+	intp.speccy.CommandChannel <- spectrum.Cmd_MakeVideoMemoryDump{ch}
 
 	data := <-ch
 
 	err := ioutil.WriteFile(path, data, 0600)
 
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 	}
 
-	if app.Verbose {
-		fmt.Fprintf(stdout, "wrote screenshot \"%s\"", path)
+	if intp.app.Verbose {
+		fmt.Fprintf(intp.stdout, "wrote screenshot \"%s\"", path)
 	}
 }
 
 // Signature: func puts(str string)
-func wrapper_puts(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_puts(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	str := in[0].(eval.StringValue).Get(t)
-	fmt.Fprintf(stdout, "%s", str)
+	fmt.Fprintf(intp.stdout, "%s", str)
 }
 
 // Signature: func acceleratedLoad(on bool)
-func wrapper_acceleratedLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_acceleratedLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	enable := in[0].(eval.BoolValue).Get(t)
-	speccy.CommandChannel <- spectrum.Cmd_SetAcceleratedLoad{enable}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetAcceleratedLoad{enable}
+}
+
+// Signature: func instantLoad(on bool)
+func (intp *Interpreter) wrapper_instantLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	enable := in[0].(eval.BoolValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetInstantLoad{Enable: enable}
+}
+
+// Signature: func instantSave(on bool)
+func (intp *Interpreter) wrapper_instantSave(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	enable := in[0].(eval.BoolValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetInstantSave{Enable: enable}
 }
 
 type WOS struct {
@@ -355,8 +814,8 @@ func url_printer(URL eval.Value) string {
 }
 
 // Signature: func wosFind(pattern string) []WOS
-func wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
@@ -364,9 +823,9 @@ func wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	pattern = strings.Replace(pattern, " ", "*", -1)
 
 	var records []spectrum.WosRecord
-	records, err := spectrum.WosQuery(app, "regexp="+url.QueryEscape(pattern))
+	records, err := spectrum.WosQuery(intp.app, "regexp="+url.QueryEscape(pattern))
 	if err != nil {
-		fmt.Fprintf(stdout, "%s", err)
+		fmt.Fprintf(intp.stdout, "%s", err)
 
 		var emptySlice eval.Slice
 		out[0].(eval.SliceValue).Set(t, emptySlice)
@@ -402,15 +861,15 @@ func wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func wosDownload(wos WOS) string
-func wrapper_wosDownload(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wosDownload(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	var url string = in[0].(eval.StructValue).Field(t, 0).(eval.StringValue).Get(t)
-	filePath, err := spectrum.WosGet(app, stdout, url)
+	filePath, err := spectrum.WosGet(intp.app, intp.stdout, url)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s", err)
+		fmt.Fprintf(intp.stdout, "%s", err)
 		out[0].(eval.StringValue).Set(t, "")
 		return
 	}
@@ -419,181 +878,384 @@ func wrapper_wosDownload(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func wosLoad(wos WOS)
-func wrapper_wosLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wosLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	var url string = in[0].(eval.StructValue).Field(t, 0).(eval.StringValue).Get(t)
-	filePath, err := spectrum.WosGet(app, stdout, url)
+	filePath, err := spectrum.WosGet(intp.app, intp.stdout, url)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s", err)
+		fmt.Fprintf(intp.stdout, "%s", err)
 		return
 	}
 
-	load(filePath)
+	intp.load(filePath)
 }
 
 // ==============
 // Initialization
 // ==============
 
-func defineFunctions(w *eval.World) {
+func (intp *Interpreter) defineFunctions() {
 	{
 		var functionSignature func()
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_help, functionSignature)
-		defineFunction("help", funcType, funcValue)
-		help_keys = append(help_keys, "help()")
-		help_vals = append(help_vals, "This help")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_help, functionSignature)
+		intp.defineFunction("help", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "help()")
+		intp.help_vals = append(intp.help_vals, "This help")
 	}
 	{
 		var functionSignature func()
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_exit, functionSignature)
-		defineFunction("exit", funcType, funcValue)
-		help_keys = append(help_keys, "exit()")
-		help_vals = append(help_vals, "Terminate this program")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_exit, functionSignature)
+		intp.defineFunction("exit", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "exit()")
+		intp.help_vals = append(intp.help_vals, "Terminate this program")
 	}
 	{
 		var functionSignature func() []string
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_vars, functionSignature)
-		defineFunction("vars", funcType, funcValue)
-		help_keys = append(help_keys, "vars()")
-		help_vals = append(help_vals, "Get the names of all variables")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_vars, functionSignature)
+		intp.defineFunction("vars", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "vars()")
+		intp.help_vals = append(intp.help_vals, "Get the names of all variables")
 	}
 	{
 		var functionSignature func()
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_reset, functionSignature)
-		defineFunction("reset", funcType, funcValue)
-		help_keys = append(help_keys, "reset()")
-		help_vals = append(help_vals, "Reset the emulated machine")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_reset, functionSignature)
+		intp.defineFunction("reset", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "reset()")
+		intp.help_vals = append(intp.help_vals, "Reset the emulated machine")
 	}
 	{
 		var functionSignature func(string) bool
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_definedFunction, functionSignature)
-		defineFunction("definedFunction", funcType, funcValue)
-		help_keys = append(help_keys, "definedFunction(name string) bool")
-		help_vals = append(help_vals, "Returns whether a Go function exists")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_definedFunction, functionSignature)
+		intp.defineFunction("definedFunction", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "definedFunction(name string) bool")
+		intp.help_vals = append(intp.help_vals, "Returns whether a Go function exists")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_addSearchPath, functionSignature)
-		defineFunction("addSearchPath", funcType, funcValue)
-		help_keys = append(help_keys, "addSearchPath(path string)")
-		help_vals = append(help_vals, "Append to the paths searched when loading snapshots, scripts, etc")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_addSearchPath, functionSignature)
+		intp.defineFunction("addSearchPath", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "addSearchPath(path string)")
+		intp.help_vals = append(intp.help_vals, "Append to the paths searched when loading snapshots, scripts, etc")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_setDownloadPath, functionSignature)
-		defineFunction("setDownloadPath", funcType, funcValue)
-		help_keys = append(help_keys, "setDownloadPath(path string)")
-		help_vals = append(help_vals, `Set path where to download files (""=default path)`)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_setDownloadPath, functionSignature)
+		intp.defineFunction("setDownloadPath", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "setDownloadPath(path string)")
+		intp.help_vals = append(intp.help_vals, `Set path where to download files (""=default path)`)
 	}
 	{
 		var functionSignature func() string
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_cmdLineArg, functionSignature)
-		defineFunction("cmdLineArg", funcType, funcValue)
-		help_keys = append(help_keys, "cmdLineArg() string)")
-		help_vals = append(help_vals, "The 1st non-flag command-line argument, or an empty string")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_cmdLineArg, functionSignature)
+		intp.defineFunction("cmdLineArg", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "cmdLineArg() string)")
+		intp.help_vals = append(intp.help_vals, "The 1st non-flag command-line argument, or an empty string")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_load, functionSignature)
+		intp.defineFunction("load", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "load(path string)")
+		intp.help_vals = append(intp.help_vals, "Load state from file (.SNA, .Z80, .Z80.ZIP, etc)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_save, functionSignature)
+		intp.defineFunction("save", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "save(path string)")
+		intp.help_vals = append(intp.help_vals, "Save state to file (Z80 or SZX format depending on the extension, SNA format otherwise; SNA is 48k only)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_saveTape, functionSignature)
+		intp.defineFunction("saveTape", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "saveTape(path string)")
+		intp.help_vals = append(intp.help_vals, "Write the blocks saved by the emulated machine (with instantSave on) to a TAP file")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_clearSavedTape, functionSignature)
+		intp.defineFunction("clearSavedTape", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "clearSavedTape()")
+		intp.help_vals = append(intp.help_vals, "Forget the blocks saved by the emulated machine")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_tapeResume, functionSignature)
+		intp.defineFunction("tapeResume", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "tapeResume()")
+		intp.help_vals = append(intp.help_vals, "Continue playing a tape stopped by a stop-the-tape block")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_breakpoint, functionSignature)
+		intp.defineFunction("breakpoint", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "breakpoint(address uint)")
+		intp.help_vals = append(intp.help_vals, "Stop the emulation when PC reaches the address")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_delBreakpoint, functionSignature)
+		intp.defineFunction("delBreakpoint", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "delBreakpoint(address uint)")
+		intp.help_vals = append(intp.help_vals, "Remove the breakpoint at the address")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_breakpoints, functionSignature)
+		intp.defineFunction("breakpoints", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "breakpoints()")
+		intp.help_vals = append(intp.help_vals, "List the breakpoints, watchpoints and port breakpoints")
+	}
+	{
+		var functionSignature func(uint, uint, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_watch, functionSignature)
+		intp.defineFunction("watch", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "watch(start, end uint, mode string)")
+		intp.help_vals = append(intp.help_vals, `Stop the emulation when memory in range start...end is read ("r"), written ("w") or both ("rw")`)
+	}
+	{
+		var functionSignature func(uint, uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_unwatch, functionSignature)
+		intp.defineFunction("unwatch", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "unwatch(start, end uint)")
+		intp.help_vals = append(intp.help_vals, "Remove the watchpoint with the range start...end")
+	}
+	{
+		var functionSignature func(uint, uint, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_portBreakpoint, functionSignature)
+		intp.defineFunction("portBreakpoint", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "portBreakpoint(port, mask uint, mode string)")
+		intp.help_vals = append(intp.help_vals, `Stop the emulation on IN ("r"), OUT ("w") or both ("rw") if (address & mask) == (port & mask)`)
+	}
+	{
+		var functionSignature func(uint, uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_delPortBreakpoint, functionSignature)
+		intp.defineFunction("delPortBreakpoint", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "delPortBreakpoint(port, mask uint)")
+		intp.help_vals = append(intp.help_vals, "Remove the port breakpoint")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_pause, functionSignature)
+		intp.defineFunction("pause", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "pause()")
+		intp.help_vals = append(intp.help_vals, "Stop the emulation")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_cont, functionSignature)
+		intp.defineFunction("cont", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "cont()")
+		intp.help_vals = append(intp.help_vals, "Continue the stopped emulation")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_step, functionSignature)
+		intp.defineFunction("step", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "step()")
+		intp.help_vals = append(intp.help_vals, "Execute a single Z80 instruction")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_stepOver, functionSignature)
+		intp.defineFunction("stepOver", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "stepOver()")
+		intp.help_vals = append(intp.help_vals, "Execute a single Z80 instruction, run calls and loops as a whole")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_runTo, functionSignature)
+		intp.defineFunction("runTo", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "runTo(address uint)")
+		intp.help_vals = append(intp.help_vals, "Continue the emulation until PC reaches the address")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_regs, functionSignature)
+		intp.defineFunction("regs", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "regs()")
+		intp.help_vals = append(intp.help_vals, "Print the Z80 registers")
+	}
+	{
+		var functionSignature func(uint, uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_disasm, functionSignature)
+		intp.defineFunction("disasm", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "disasm(address, count uint)")
+		intp.help_vals = append(intp.help_vals, "Disassemble 'count' instructions starting at the specified address")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_load, functionSignature)
-		defineFunction("load", funcType, funcValue)
-		help_keys = append(help_keys, "load(path string)")
-		help_vals = append(help_vals, "Load state from file (.SNA, .Z80, .Z80.ZIP, etc)")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_asm, functionSignature)
+		intp.defineFunction("asm", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "asm(source string)")
+		intp.help_vals = append(intp.help_vals, "Assemble Z80 code into the memory (default origin 0x8000, statements can be separated by ':')")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_save, functionSignature)
-		defineFunction("save", funcType, funcValue)
-		help_keys = append(help_keys, "save(path string)")
-		help_vals = append(help_vals, "Save state to file (SNA format)")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_traceStart, functionSignature)
+		intp.defineFunction("traceStart", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "traceStart(path string)")
+		intp.help_vals = append(intp.help_vals, "Start writing an execution trace (CSV, or JSON lines if the path ends with .json; gzip if it ends with .gz)")
+	}
+	{
+		var functionSignature func(string, uint, uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_traceStartRange, functionSignature)
+		intp.defineFunction("traceStartRange", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "traceStartRange(path string, start, end uint)")
+		intp.help_vals = append(intp.help_vals, "Start writing an execution trace of the instructions at addresses start ... end")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_traceStop, functionSignature)
+		intp.defineFunction("traceStop", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "traceStop()")
+		intp.help_vals = append(intp.help_vals, "Stop the execution trace")
 	}
 	{
 		var functionSignature func(float32)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_fps, functionSignature)
-		defineFunction("fps", funcType, funcValue)
-		help_keys = append(help_keys, "fps(n float32)")
-		help_vals = append(help_vals, "Change the display refresh frequency (0=default FPS)")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_rewind, functionSignature)
+		intp.defineFunction("rewind", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "rewind(seconds float32)")
+		intp.help_vals = append(intp.help_vals, "Move the emulation back in time")
+	}
+	{
+		var functionSignature func(uint, uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_rewindConfig, functionSignature)
+		intp.defineFunction("rewindConfig", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "rewindConfig(interval, capacity uint)")
+		intp.help_vals = append(intp.help_vals, "Keep a rewind snapshot every 'interval' frames, at most 'capacity' snapshots (0=disable)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_rzxRecord, functionSignature)
+		intp.defineFunction("rzxRecord", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "rzxRecord(path string)")
+		intp.help_vals = append(intp.help_vals, "Start recording the input into an RZX file (play it back with load)")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_rzxStop, functionSignature)
+		intp.defineFunction("rzxStop", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "rzxStop()")
+		intp.help_vals = append(intp.help_vals, "Stop the RZX recording or playback")
+	}
+	{
+		var functionSignature func(float32)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_fps, functionSignature)
+		intp.defineFunction("fps", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "fps(n float32)")
+		intp.help_vals = append(intp.help_vals, "Change the display refresh frequency (0=default FPS)")
 	}
 	{
 		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_ulaAccuracy, functionSignature)
-		defineFunction("ula", funcType, funcValue)
-		help_keys = append(help_keys, "ula(accurateEmulation bool)")
-		help_vals = append(help_vals, "Enable/disable accurate ULA emulation")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_ulaAccuracy, functionSignature)
+		intp.defineFunction("ula", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "ula(accurateEmulation bool)")
+		intp.help_vals = append(intp.help_vals, "Enable/disable accurate ULA emulation")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_issue, functionSignature)
+		intp.defineFunction("issue", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "issue(n uint)")
+		intp.help_vals = append(intp.help_vals, "Emulate the keyboard of an issue 2 or issue 3 board")
 	}
 	{
 		var functionSignature func(uint)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wait, functionSignature)
-		defineFunction("wait", funcType, funcValue)
-		help_keys = append(help_keys, "wait(milliseconds uint)")
-		help_vals = append(help_vals, "Wait before executing the next command")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wait, functionSignature)
+		intp.defineFunction("wait", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wait(milliseconds uint)")
+		intp.help_vals = append(intp.help_vals, "Wait before executing the next command")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_runFrames, functionSignature)
+		intp.defineFunction("runFrames", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "runFrames(n uint)")
+		intp.help_vals = append(intp.help_vals, "Execute n frames as fast as possible")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_script, functionSignature)
-		defineFunction("script", funcType, funcValue)
-		help_keys = append(help_keys, "script(scriptName string)")
-		help_vals = append(help_vals, "Load and evaluate the specified Go script")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_script, functionSignature)
+		intp.defineFunction("script", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "script(scriptName string)")
+		intp.help_vals = append(intp.help_vals, "Load and evaluate the specified Go script")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_optionalScript, functionSignature)
-		defineFunction("optionalScript", funcType, funcValue)
-		help_keys = append(help_keys, "optionalScript(scriptName string)")
-		help_vals = append(help_vals, "Load (if found) and evaluate the specified Go script")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_optionalScript, functionSignature)
+		intp.defineFunction("optionalScript", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "optionalScript(scriptName string)")
+		intp.help_vals = append(intp.help_vals, "Load (if found) and evaluate the specified Go script")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_screenshot, functionSignature)
-		defineFunction("screenshot", funcType, funcValue)
-		help_keys = append(help_keys, "screenshot(screenshotName string)")
-		help_vals = append(help_vals, "Take a screenshot of the current display")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_screenshot, functionSignature)
+		intp.defineFunction("screenshot", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "screenshot(screenshotName string)")
+		intp.help_vals = append(intp.help_vals, "Take a screenshot of the current display")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_puts, functionSignature)
-		defineFunction("puts", funcType, funcValue)
-		help_keys = append(help_keys, "puts(str string)")
-		help_vals = append(help_vals, "Print the given string")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_puts, functionSignature)
+		intp.defineFunction("puts", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "puts(str string)")
+		intp.help_vals = append(intp.help_vals, "Print the given string")
+	}
+	{
+		var functionSignature func(bool)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_acceleratedLoad, functionSignature)
+		intp.defineFunction("acceleratedLoad", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "acceleratedLoad(on bool)")
+		intp.help_vals = append(intp.help_vals, "Set accelerated tape load on/off")
+	}
+	{
+		var functionSignature func(bool)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_instantLoad, functionSignature)
+		intp.defineFunction("instantLoad", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "instantLoad(on bool)")
+		intp.help_vals = append(intp.help_vals, "Set instant tape load on/off (ROM loader only)")
 	}
 	{
 		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_acceleratedLoad, functionSignature)
-		defineFunction("acceleratedLoad", funcType, funcValue)
-		help_keys = append(help_keys, "acceleratedLoad(on bool)")
-		help_vals = append(help_vals, "Set accelerated tape load on/off")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_instantSave, functionSignature)
+		intp.defineFunction("instantSave", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "instantSave(on bool)")
+		intp.help_vals = append(intp.help_vals, "Set instant tape save on/off (ROM saver only)")
 	}
 	{
 		var functionSignature func(string) []WOS
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wosFind, functionSignature)
-		defineFunction("wosFind", funcType, funcValue)
-		help_keys = append(help_keys, "wosFind(pattern string) []WOS")
-		help_vals = append(help_vals, "Find tapes and snapshots on worldofspectrum.org")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wosFind, functionSignature)
+		intp.defineFunction("wosFind", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wosFind(pattern string) []WOS")
+		intp.help_vals = append(intp.help_vals, "Find tapes and snapshots on worldofspectrum.org")
 	}
 	{
 		var functionSignature func(WOS) string
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wosDownload, functionSignature)
-		defineFunction("wosDownload", funcType, funcValue)
-		help_keys = append(help_keys, "wosDownload(wos WOS) string")
-		help_vals = append(help_vals, "Download from worldofspectrum.org")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wosDownload, functionSignature)
+		intp.defineFunction("wosDownload", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wosDownload(wos WOS) string")
+		intp.help_vals = append(intp.help_vals, "Download from worldofspectrum.org")
 	}
 	{
 		var functionSignature func(WOS)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wosLoad, functionSignature)
-		defineFunction("wosLoad", funcType, funcValue)
-		help_keys = append(help_keys, "wosLoad(wos WOS)")
-		help_vals = append(help_vals, "Same as load(wosDownload(wos))")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wosLoad, functionSignature)
+		intp.defineFunction("wosLoad", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wosLoad(wos WOS)")
+		intp.help_vals = append(intp.help_vals, "Same as load(wosDownload(wos))")
 	}
 
-	for _, f := range functionsToAdd {
-		defineFunction(f.Name, f.Type, f.Value)
+	for _, f := range intp.functionsToAdd {
+		intp.defineFunction(f.Name, f.Type, f.Value)
 
 		if (f.Help_key != "") && (f.Help_value != "") {
-			help_keys = append(help_keys, f.Help_key)
-			help_vals = append(help_vals, f.Help_value)
+			intp.help_keys = append(intp.help_keys, f.Help_key)
+			intp.help_vals = append(intp.help_vals, f.Help_value)
 		}
 	}
-	functionsToAdd = nil
+	intp.functionsToAdd = nil
 }
@@ -15,58 +15,89 @@ import (
 	"sync"
 )
 
-// ==============
-// Some variables
-// ==============
-
-// These variables are set only once, before starting new goroutines,
-// so there is no need for controlling concurrent access via a sync.Mutex
-var (
-	app                 *spectrum.Application
-	cmdLineArg          string // The 1st non-flag command-line argument, or empty string
-	speccy              *spectrum.Spectrum48k
-	w                   *eval.World
-	intp                *Interpreter = newInterpreter()
-	stdout              io.Writer    = os.Stdout
-	IgnoreStartupScript              = false
-)
-
-var mutex sync.Mutex
+// Used by Init
+var IgnoreStartupScript = false
 
 const (
 	SCRIPT_DIRECTORY = "scripts"
 	STARTUP_SCRIPT   = "startup"
 )
 
+// An interpreter controlling one emulated machine.
+// Each interpreter has its own set of Go variables and functions,
+// so several interpreters can run concurrently in separate goroutines.
 type Interpreter struct {
+	app        *spectrum.Application
+	cmdLineArg string // The 1st non-flag command-line argument, or empty string
+	speccy     *spectrum.Spectrum48k
+	w          *eval.World
+	stdout     io.Writer
+
 	// The set of top-level Go variables.
 	// (This is a set, the values associated with the keys are pointless.)
 	vars map[string]bool
+
+	// Contains the names of all defined functions
+	definedFunctions map[string]byte
+
+	// Functions to define when the interpreter gets initialized
+	functionsToAdd []Function
+
+	help_keys []string
+	help_vals []string
+
+	mutex sync.Mutex
 }
 
+// Package go-eval caches types in global maps without any synchronization.
+// The creation of worlds and the compilation of source code is therefore
+// serialized across all interpreters. Compiled code runs concurrently.
+var evalMutex sync.Mutex
+
+// The interpreter used by Init, GetInterpreter and DefineFunction
+var defaultInterpreter *Interpreter = newInterpreter()
+
 func newInterpreter() *Interpreter {
 	return &Interpreter{
-		vars: make(map[string]bool),
+		stdout:           os.Stdout,
+		vars:             make(map[string]bool),
+		definedFunctions: make(map[string]byte),
 	}
 }
 
+// Creates a new interpreter controlling the specified machine.
+// The startup script is not executed, see RunStartupScript.
+func NewInterpreter(app *spectrum.Application, cmdLineArg string, speccy *spectrum.Spectrum48k) *Interpreter {
+	intp := newInterpreter()
+	intp.app = app
+	intp.cmdLineArg = cmdLineArg
+	intp.speccy = speccy
+
+	evalMutex.Lock()
+	intp.w = eval.NewWorld()
+	intp.defineFunctions()
+	evalMutex.Unlock()
+
+	return intp
+}
+
 // Returns the previous stdout
-func (i *Interpreter) SetStdout(newStdout io.Writer) io.Writer {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (intp *Interpreter) SetStdout(newStdout io.Writer) io.Writer {
+	intp.mutex.Lock()
+	defer intp.mutex.Unlock()
 
-	old := stdout
-	stdout = newStdout
+	old := intp.stdout
+	intp.stdout = newStdout
 	return old
 }
 
-func (i *Interpreter) Run(sourceCode string) error {
+func (intp *Interpreter) Run(sourceCode string) error {
 	sourceCode = strings.TrimSpace(sourceCode)
 	if sourceCode == "" {
 		sourceCode = "help()"
 	}
 
-	err := i.run(w, "", sourceCode)
+	err := intp.run("", sourceCode)
 
 	return err
 }
@@ -166,7 +197,7 @@ func parseDeclList(fset *token.FileSet, src string) ([]ast.Decl, error) {
 // The output parameter 'vars' contains the names of new top-level
 // variables potentially defined by the source code.
 // 'vars' may contain some elements even if an error occurred.
-func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode string) (code eval.Code, vars []string, err error) {
+func (intp *Interpreter) compile(fileSet *token.FileSet, sourceCode string) (code eval.Code, vars []string, err error) {
 	var statements []ast.Stmt
 	var declarations []ast.Decl
 
@@ -182,7 +213,7 @@ func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode
 			vars = append(vars, varName)
 		}
 
-		code, err = w.CompileStmtList(fileSet, statements)
+		code, err = intp.w.CompileStmtList(fileSet, statements)
 
 		return code, vars, err
 	}
@@ -197,7 +228,7 @@ func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode
 			vars = append(vars, varName)
 		}
 
-		code, err = w.CompileDeclList(fileSet, declarations)
+		code, err = intp.w.CompileDeclList(fileSet, declarations)
 
 		return code, vars, err
 	}
@@ -205,22 +236,22 @@ func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode
 	return nil, nil, err1
 }
 
-// Examines whether 'w' has values for the variables in 'vars'.
-// For each successfully found/verified variable, the variable's name is added to 'i.vars'.
-func (i *Interpreter) tryToAddVars(w *eval.World, fileSet *token.FileSet, vars []string) {
+// Examines whether the interpreter has values for the variables in 'vars'.
+// For each successfully found/verified variable, the variable's name is added to 'intp.vars'.
+func (intp *Interpreter) tryToAddVars(fileSet *token.FileSet, vars []string) {
 	for _, name := range vars {
-		_, err := w.Compile(fileSet, name /*sourceCode*/)
+		_, err := intp.w.Compile(fileSet, name /*sourceCode*/)
 		if err == nil {
-			// The variable exists, add its name to 'i.vars'
-			i.vars[name] = true
+			// The variable exists, add its name to 'intp.vars'
+			intp.vars[name] = true
 		} else {
 			// Ignore the error. Conclude that no such variable exists.
 		}
 	}
 }
 
-// Runs the specified Go source code in the context of 'w'
-func (i *Interpreter) run(w *eval.World, path_orEmpty string, sourceCode string) error {
+// Runs the specified Go source code in the context of the interpreter
+func (intp *Interpreter) run(path_orEmpty string, sourceCode string) error {
 	var code eval.Code
 	var vars []string
 	var err error
@@ -230,8 +261,10 @@ func (i *Interpreter) run(w *eval.World, path_orEmpty string, sourceCode string)
 		fileSet.AddFile(path_orEmpty, fileSet.Base(), len(sourceCode))
 	}
 
-	code, vars, err = i.compile(w, fileSet, sourceCode)
-	i.tryToAddVars(w, fileSet, vars)
+	evalMutex.Lock()
+	code, vars, err = intp.compile(fileSet, sourceCode)
+	intp.tryToAddVars(fileSet, vars)
+	evalMutex.Unlock()
 	if err != nil {
 		return err
 	}
@@ -242,17 +275,17 @@ func (i *Interpreter) run(w *eval.World, path_orEmpty string, sourceCode string)
 	}
 
 	if result != nil {
-		fmt.Fprintf(stdout, "%s\n", result)
+		fmt.Fprintf(intp.stdout, "%s\n", result)
 	}
 
 	return nil
 }
 
 // Loads and evaluates the specified Go script
-func runScript(w *eval.World, scriptName string, optional bool) error {
+func (intp *Interpreter) runScript(scriptName string, optional bool) error {
 	fileName := scriptName + ".go"
 
-	path, err := spectrum.ScriptPath(fileName)
+	path, err := intp.app.SearchPaths.ScriptPath(fileName)
 	if err != nil {
 		return err
 	}
@@ -266,22 +299,33 @@ func runScript(w *eval.World, scriptName string, optional bool) error {
 		}
 	}
 
-	err = intp.run(w, fileName, string(scriptData))
+	err = intp.run(fileName, string(scriptData))
 	return err
 }
 
-func Init(_app *spectrum.Application, _cmdLineArg string, _speccy *spectrum.Spectrum48k) {
-	app = _app
-	cmdLineArg = _cmdLineArg
-	speccy = _speccy
+// Runs the startup script
+func (intp *Interpreter) RunStartupScript(optional bool) error {
+	return intp.runScript(STARTUP_SCRIPT, optional)
+}
 
-	if w == nil {
-		w = eval.NewWorld()
-		defineFunctions(w)
+// Initializes the default interpreter
+func Init(app *spectrum.Application, cmdLineArg string, speccy *spectrum.Spectrum48k) {
+	intp := defaultInterpreter
+	intp.app = app
+	intp.cmdLineArg = cmdLineArg
+	intp.speccy = speccy
+
+	if intp.w == nil {
+		intp.mutex.Lock()
+		evalMutex.Lock()
+		intp.w = eval.NewWorld()
+		intp.defineFunctions()
+		evalMutex.Unlock()
+		intp.mutex.Unlock()
 
 		// Run the startup script
 		var err error
-		err = runScript(w, STARTUP_SCRIPT, IgnoreStartupScript /*optional*/)
+		err = intp.RunStartupScript(IgnoreStartupScript /*optional*/)
 		if err != nil {
 			app.PrintfMsg("%s", err)
 			app.RequestExit()
@@ -290,8 +334,9 @@ func Init(_app *spectrum.Application, _cmdLineArg string, _speccy *spectrum.Spec
 	}
 }
 
+// Returns the default interpreter
 func GetInterpreter() *Interpreter {
-	return intp
+	return defaultInterpreter
 }
 
 // Lines below will be uncommented when/if the keypress console
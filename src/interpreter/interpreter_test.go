@@ -0,0 +1,100 @@
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/remogatto/gospeccy/src/spectrum"
+)
+
+// Runs a headless 48k machine controlled by its own interpreter.
+// The ROM of the machine stores 'id' at address 0x8000 and then loops forever:
+//
+//	0000       DI
+//	0001       LD A,id
+//	0003       LD (0x8000),A
+//	0006 loop: INC B
+//	0007       JR loop
+func runTestInstance(id byte) error {
+	app := spectrum.NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	var rom [0x4000]byte
+	copy(rom[:], []byte{0xf3, 0x3e, id, 0x32, 0x00, 0x80, 0x04, 0x18, 0xfd})
+	speccy := spectrum.NewSpectrum48k(app, rom)
+
+	// Each instance has its own search paths
+	dir, err := ioutil.TempDir("", "gospeccy")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	script := fmt.Sprintf("puts(\"script %d\\n\")\n", id)
+	err = ioutil.WriteFile(path.Join(dir, "instance.go"), []byte(script), 0600)
+	if err != nil {
+		return err
+	}
+
+	var stdout bytes.Buffer
+	intp := NewInterpreter(app, "", speccy)
+	intp.SetStdout(&stdout)
+
+	// Each interpreter has its own variables
+	commands := []string{
+		fmt.Sprintf("var id uint = %d", id),
+		fmt.Sprintf("addSearchPath(\"%s\")", dir),
+		"runFrames(2)",
+		"script(\"instance\")",
+		"id",
+	}
+	for _, command := range commands {
+		err := intp.Run(command)
+		if err != nil {
+			return fmt.Errorf("instance %d: %s: %s", id, command, err)
+		}
+	}
+
+	expected := fmt.Sprintf("script %d\n%d\n", id, id)
+	if stdout.String() != expected {
+		return fmt.Errorf("instance %d: output %q, expected %q", id, stdout.String(), expected)
+	}
+	if value := speccy.Memory.Read(0x8000); value != id {
+		return fmt.Errorf("instance %d: memory contains %d", id, value)
+	}
+	if pc := speccy.Cpu.PC(); (pc < 0x0006) || (pc > 0x0008) {
+		return fmt.Errorf("instance %d: PC=%04x is outside of the loop", id, pc)
+	}
+
+	return nil
+}
+
+func TestConcurrentInstances(t *testing.T) {
+	const numInstances = 4
+
+	errors := make([]error, numInstances)
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < numInstances; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			errors[i] = runTestInstance(byte(i + 1))
+		}(i)
+	}
+	waitGroup.Wait()
+
+	for _, err := range errors {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
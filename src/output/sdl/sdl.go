@@ -22,6 +22,9 @@ import (
 
 const DEFAULT_JOYSTICK_ID = 0
 
+// The number of seconds the F9 key moves the emulation back in time
+const REWIND_SECONDS = 5
+
 var (
 	// Synchronizes the shutdown of SDL event loops.
 	// When all SDL event loops terminate, we can call 'sdl.Quit()'.
@@ -67,6 +70,7 @@ type SDLRenderer struct {
 	app                           *spectrum.Application
 	speccy                        *spectrum.Spectrum48k
 	scale2x, fullscreen           bool
+	border                        spectrum.Border
 	consoleY                      int16
 	width, height                 int
 	appSurface, speccySurface     SDLSurfaceAccessor
@@ -77,9 +81,10 @@ type SDLRenderer struct {
 
 	sliderCh chan cmd_newSlider
 
-	audio     bool
-	audioFreq uint
-	hqAudio   bool
+	audio       bool
+	audioFreq   uint
+	hqAudio     bool
+	audioStereo StereoMode
 }
 
 type wrapSurface struct {
@@ -94,27 +99,27 @@ func (s *wrapSurface) UpdatedRectsCh() <-chan []sdl.Rect {
 	return nil
 }
 
-func width(scale2x, fullscreen bool) int {
+func width(scale2x, fullscreen bool, border spectrum.Border) int {
 	if fullscreen {
 		scale2x = true
 	}
 	if scale2x {
-		return spectrum.TotalScreenWidth * 2
+		return border.TotalWidth() * 2
 	}
-	return spectrum.TotalScreenWidth
+	return border.TotalWidth()
 }
 
-func height(scale2x, fullscreen bool) int {
+func height(scale2x, fullscreen bool, border spectrum.Border) int {
 	if fullscreen {
 		scale2x = true
 	}
 	if scale2x {
-		return spectrum.TotalScreenHeight * 2
+		return border.TotalHeight() * 2
 	}
-	return spectrum.TotalScreenHeight
+	return border.TotalHeight()
 }
 
-func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool) SDLSurfaceAccessor {
+func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool, border spectrum.Border) SDLSurfaceAccessor {
 	var sdlMode int64
 	if fullscreen {
 		scale2x = true
@@ -127,7 +132,7 @@ func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool) SDLSurfa
 
 	<-composer.ReplaceOutputSurface(nil)
 
-	surface := sdl.SetVideoMode(int(width(scale2x, fullscreen)), int(height(scale2x, fullscreen)), 32, uint32(sdlMode))
+	surface := sdl.SetVideoMode(int(width(scale2x, fullscreen, border)), int(height(scale2x, fullscreen, border)), 32, uint32(sdlMode))
 	if app.Verbose {
 		app.PrintfMsg("video surface resolution: %dx%d", surface.W, surface.H)
 	}
@@ -137,43 +142,43 @@ func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool) SDLSurfa
 	return &wrapSurface{surface}
 }
 
-func newSpeccySurface(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale2x, fullscreen bool) SDLSurfaceAccessor {
+func newSpeccySurface(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale2x, fullscreen bool, border spectrum.Border) SDLSurfaceAccessor {
 	var speccySurface SDLSurfaceAccessor
 	if fullscreen {
 		scale2x = true
 	}
 	if scale2x {
-		sdlScreen := NewSDLScreen2x(app)
+		sdlScreen := NewSDLScreen2x(app, border)
 		speccy.CommandChannel <- spectrum.Cmd_AddDisplay{sdlScreen}
 		speccySurface = sdlScreen
 	} else {
-		sdlScreen := NewSDLScreen(app)
+		sdlScreen := NewSDLScreen(app, border)
 		speccy.CommandChannel <- spectrum.Cmd_AddDisplay{sdlScreen}
 		speccySurface = sdlScreen
 	}
 	return speccySurface
 }
 
-func newCLISurface(scale2x, fullscreen bool) *clingon.SDLRenderer {
+func newCLISurface(app *spectrum.Application, scale2x, fullscreen bool, border spectrum.Border) *clingon.SDLRenderer {
 	cliSurface := clingon.NewSDLRenderer(
 		sdl.CreateRGBSurface(
 			sdl.SRCALPHA,
-			width(scale2x, fullscreen),
-			height(scale2x, fullscreen)/2, 32, 0, 0, 0, 0),
-		newFont(scale2x, fullscreen),
+			width(scale2x, fullscreen, border),
+			height(scale2x, fullscreen, border)/2, 32, 0, 0, 0, 0),
+		newFont(app, scale2x, fullscreen),
 	)
 	cliSurface.GetSurface().SetAlpha(sdl.SRCALPHA, 0xdd)
 	return cliSurface
 }
 
-func newFont(scale2x, fullscreen bool) *ttf.Font {
+func newFont(app *spectrum.Application, scale2x, fullscreen bool) *ttf.Font {
 	if fullscreen {
 		scale2x = true
 	}
 
 	var font *ttf.Font
 	{
-		path, err := spectrum.FontPath("VeraMono.ttf")
+		path, err := app.SearchPaths.FontPath("VeraMono.ttf")
 		if err != nil {
 			panic(err.Error())
 		}
@@ -190,19 +195,21 @@ func newFont(scale2x, fullscreen bool) *ttf.Font {
 	return font
 }
 
-func NewSDLRenderer(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale2x, fullscreen bool, audio, hqAudio bool, audioFreq uint) *SDLRenderer {
-	width := width(scale2x, fullscreen)
-	height := height(scale2x, fullscreen)
+// The border has to be already set by 'spectrum.Cmd_SetBorder'
+func NewSDLRenderer(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale2x, fullscreen bool, border spectrum.Border, audio, hqAudio bool, audioFreq uint, audioStereo StereoMode) *SDLRenderer {
+	width := width(scale2x, fullscreen, border)
+	height := height(scale2x, fullscreen, border)
 	r := &SDLRenderer{
 		app:              app,
 		speccy:           speccy,
 		scale2x:          scale2x,
 		fullscreen:       fullscreen,
+		border:           border,
 		appSurfaceCh:     make(chan cmd_newSurface),
 		speccySurfaceCh:  make(chan cmd_newSurface),
 		cliSurfaceCh:     make(chan cmd_newCliSurface),
-		appSurface:       newAppSurface(app, scale2x, fullscreen),
-		speccySurface:    newSpeccySurface(app, speccy, scale2x, fullscreen),
+		appSurface:       newAppSurface(app, scale2x, fullscreen, border),
+		speccySurface:    newSpeccySurface(app, speccy, scale2x, fullscreen, border),
 		cliSurface_orNil: nil,
 		width:            width,
 		height:           height,
@@ -211,6 +218,7 @@ func NewSDLRenderer(app *spectrum.Application, speccy *spectrum.Spectrum48k, sca
 		audio:            audio,
 		audioFreq:        audioFreq,
 		hqAudio:          hqAudio,
+		audioStereo:      audioStereo,
 	}
 
 	composer.AddInputSurface(r.speccySurface.GetSurface(), 0, 0, r.speccySurface.UpdatedRectsCh())
@@ -224,36 +232,48 @@ func (r *SDLRenderer) Terminated() bool {
 }
 
 func (r *SDLRenderer) ResizeVideo(scale2x, fullscreen bool) {
+	r.resizeVideo(scale2x, fullscreen, r.border)
+}
+
+func (r *SDLRenderer) SetBorder(border spectrum.Border) {
+	if r.border == border {
+		return
+	}
+
+	errChan := make(chan error)
+	r.speccy.CommandChannel <- spectrum.Cmd_SetBorder{Border: border, ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		r.app.PrintfMsg("%s", err)
+		return
+	}
+
+	r.resizeVideo(r.scale2x, r.fullscreen, border)
+}
+
+func (r *SDLRenderer) resizeVideo(scale2x, fullscreen bool, border spectrum.Border) {
 	finished := make(chan byte)
 	r.speccy.CommandChannel <- spectrum.Cmd_CloseAllDisplays{finished}
 	<-finished
 
-	if r.scale2x != scale2x {
-		if scale2x {
-			// 1x --> 2x
-			y := int16(r.height) - r.consoleY
-			r.consoleY = int16(2*r.height) - 2*y
-		} else {
-			// 2x --> 1x
-			y := int16(r.height) - r.consoleY
-			r.consoleY = int16(r.height/2) - y/2
-		}
-	}
+	// Keep the console at the same relative position
+	newHeight := height(scale2x, fullscreen, border)
+	r.consoleY = int16(int(r.consoleY) * newHeight / r.height)
 
-	r.width = width(scale2x, fullscreen)
-	r.height = height(scale2x, fullscreen)
+	r.width = width(scale2x, fullscreen, border)
+	r.height = newHeight
 	r.scale2x = scale2x
 	r.fullscreen = fullscreen
+	r.border = border
 
 	done := make(chan bool)
-	r.appSurfaceCh <- cmd_newSurface{newAppSurface(r.app, scale2x, fullscreen), done}
+	r.appSurfaceCh <- cmd_newSurface{newAppSurface(r.app, scale2x, fullscreen, border), done}
 	<-done
 
-	r.speccySurfaceCh <- cmd_newSurface{newSpeccySurface(r.app, r.speccy, scale2x, fullscreen), done}
+	r.speccySurfaceCh <- cmd_newSurface{newSpeccySurface(r.app, r.speccy, scale2x, fullscreen, border), done}
 	<-done
 
 	if r.cliSurface_orNil != nil {
-		r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(scale2x, fullscreen), done}
+		r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(r.app, scale2x, fullscreen, border), done}
 		<-done
 	}
 }
@@ -262,17 +282,18 @@ func (r *SDLRenderer) ShowPaintedRegions(enable bool) {
 	composer.ShowPaintedRegions(enable)
 }
 
-func (r *SDLRenderer) setAudioParameters(enable, hqAudio bool, freq uint) {
+func (r *SDLRenderer) setAudioParameters(enable, hqAudio bool, freq uint, stereoMode StereoMode) {
 	r.audio = enable
 	r.hqAudio = hqAudio
 	r.audioFreq = freq
+	r.audioStereo = stereoMode
 
 	finished := make(chan byte)
 	r.speccy.CommandChannel <- spectrum.Cmd_CloseAllAudioReceivers{finished}
 	<-finished
 
 	if enable {
-		audio, err := NewSDLAudio(r.app, freq, hqAudio)
+		audio, err := NewSDLAudio(r.app, freq, hqAudio, stereoMode)
 		if err == nil {
 			finished := make(chan byte)
 			r.speccy.CommandChannel <- spectrum.Cmd_CloseAllAudioReceivers{finished}
@@ -287,18 +308,24 @@ func (r *SDLRenderer) setAudioParameters(enable, hqAudio bool, freq uint) {
 }
 
 func (r *SDLRenderer) EnableAudio(enable bool) {
-	r.setAudioParameters(enable, r.hqAudio, r.audioFreq)
+	r.setAudioParameters(enable, r.hqAudio, r.audioFreq, r.audioStereo)
 }
 
 func (r *SDLRenderer) SetAudioFreq(freq uint) {
 	if r.audioFreq != freq {
-		r.setAudioParameters(r.audio, r.hqAudio, freq)
+		r.setAudioParameters(r.audio, r.hqAudio, freq, r.audioStereo)
 	}
 }
 
 func (r *SDLRenderer) SetAudioQuality(hqAudio bool) {
 	if r.hqAudio != hqAudio {
-		r.setAudioParameters(r.audio, hqAudio, r.audioFreq)
+		r.setAudioParameters(r.audio, hqAudio, r.audioFreq, r.audioStereo)
+	}
+}
+
+func (r *SDLRenderer) SetAudioStereoMode(stereoMode StereoMode) {
+	if r.audioStereo != stereoMode {
+		r.setAudioParameters(r.audio, r.hqAudio, r.audioFreq, stereoMode)
 	}
 }
 
@@ -561,6 +588,12 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 					}
 					app.RequestExit()
 
+				} else if (keyName == "f9") && (e.Type == sdl.KEYDOWN) {
+					if app.Verbose {
+						app.PrintfMsg("f9 key -> rewind %d seconds", REWIND_SECONDS)
+					}
+					speccy.CommandChannel <- spectrum.Cmd_Rewind{Seconds: REWIND_SECONDS}
+
 				} else if (keyName == "f10") && (e.Type == sdl.KEYDOWN) {
 					//if app.Verbose {
 					//	app.PrintfMsg("f10 key -> toggle console")
@@ -570,7 +603,7 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 
 						if r.cliSurface_orNil == nil {
 							done := make(chan bool)
-							r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(r.scale2x, r.fullscreen), done}
+							r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(r.app, r.scale2x, r.fullscreen, r.border), done}
 							<-done
 						}
 
@@ -665,9 +698,11 @@ var (
 	enableSDL          = flag.Bool("enable-sdl", true, "Enable SDL user interface")
 	Scale2x            = flag.Bool("2x", false, "2x display scaler")
 	Fullscreen         = flag.Bool("fullscreen", false, "Fullscreen (enable 2x scaler by default)")
+	Border             = flag.String("border", "normal", "Visible part of the border: normal, full")
 	Audio              = flag.Bool("audio", true, "Enable or disable audio")
 	AudioFreq          = flag.Uint("audio-freq", PLAYBACK_FREQUENCY, "Audio playback frequency (units: Hz)")
 	HQAudio            = flag.Bool("audio-hq", true, "Enable or disable higher-quality audio")
+	AudioStereo        = flag.String("audio-stereo", "mono", "Placement of the AY channels: mono, abc, acb")
 	ShowPaintedRegions = flag.Bool("show-paint", false, "Show painted display regions")
 	verboseInput       = flag.Bool("verbose-input", false, "Enable debugging messages (input device events)")
 )
@@ -676,10 +711,12 @@ func init() {
 	uiSettings = &InitialSettings{
 		scale2x:            Scale2x,
 		fullscreen:         Fullscreen,
+		border:             Border,
 		showPaintedRegions: ShowPaintedRegions,
 		audio:              Audio,
 		audioFreq:          AudioFreq,
 		hqAudio:            HQAudio,
+		audioStereo:        AudioStereo,
 	}
 }
 
@@ -701,10 +738,12 @@ func Main() {
 	uiSettings = &InitialSettings{
 		scale2x:            Scale2x,
 		fullscreen:         Fullscreen,
+		border:             Border,
 		showPaintedRegions: ShowPaintedRegions,
 		audio:              Audio,
 		audioFreq:          AudioFreq,
 		hqAudio:            HQAudio,
+		audioStereo:        AudioStereo,
 	}
 
 	composer = NewSDLSurfaceComposer(app)
@@ -717,14 +756,32 @@ func Main() {
 		return
 	}
 
+	audioStereo, err := ParseStereoMode(*AudioStereo)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+	}
+
 	// Setup the display
-	r = NewSDLRenderer(app, speccy, *Scale2x, *Fullscreen, *Audio, *HQAudio, *AudioFreq)
+	border, err := spectrum.ParseBorder(*Border)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+	}
+	{
+		errChan := make(chan error)
+		speccy.CommandChannel <- spectrum.Cmd_SetBorder{Border: border, ErrChan: errChan}
+		if err := <-errChan; err != nil {
+			app.PrintfMsg("%s", err)
+			app.RequestExit()
+			return
+		}
+	}
+	r = NewSDLRenderer(app, speccy, *Scale2x, *Fullscreen, border, *Audio, *HQAudio, *AudioFreq, audioStereo)
 	setUI(r)
 	initCLI()
 
 	// Setup the audio
 	if *Audio {
-		audio, err := NewSDLAudio(app, *AudioFreq, *HQAudio)
+		audio, err := NewSDLAudio(app, *AudioFreq, *HQAudio, audioStereo)
 		if err == nil {
 			speccy.CommandChannel <- spectrum.Cmd_AddAudioReceiver{audio}
 		} else {
@@ -100,13 +100,13 @@ func newSDLSurface(app *spectrum.Application, w, h int) *SDLSurface {
 }
 
 // Create an SDL surface suitable for a 2x scaled screen
-func NewSDLSurface2x(app *spectrum.Application) *SDLSurface {
-	return newSDLSurface(app, 2*spectrum.TotalScreenWidth, 2*spectrum.TotalScreenHeight)
+func NewSDLSurface2x(app *spectrum.Application, border spectrum.Border) *SDLSurface {
+	return newSDLSurface(app, 2*border.TotalWidth(), 2*border.TotalHeight())
 }
 
 // Create an SDL surface suitable for an unscaled screen
-func NewSDLSurface(app *spectrum.Application) *SDLSurface {
-	return newSDLSurface(app, spectrum.TotalScreenWidth, spectrum.TotalScreenHeight)
+func NewSDLSurface(app *spectrum.Application, border spectrum.Border) *SDLSurface {
+	return newSDLSurface(app, border.TotalWidth(), border.TotalHeight())
 }
 
 // ==============================
@@ -169,11 +169,13 @@ type screen_renderer_t interface {
 	render(screen *spectrum.DisplayData)
 }
 
-func NewSDLScreen(app *spectrum.Application) *SDLScreen {
+// The border should be the same as the one set by 'spectrum.Cmd_SetBorder'.
+// Display data with a different border are ignored.
+func NewSDLScreen(app *spectrum.Application, border spectrum.Border) *SDLScreen {
 	SDL_screen := &SDLScreen{
 		screenChannel:   make(chan *spectrum.DisplayData),
-		screenSurface:   NewSDLSurface(app),
-		unscaledDisplay: newUnscaledDisplay(),
+		screenSurface:   NewSDLSurface(app, border),
+		unscaledDisplay: newUnscaledDisplay(border),
 		updatedRectsCh:  make(chan []sdl.Rect),
 		app:             app,
 	}
@@ -208,7 +210,9 @@ func (display *SDLScreen) render(screen *spectrum.DisplayData) {
 
 	surface := display.screenSurface
 	bpp := surface.Bpp()
-	pixels := &unscaledDisplay.pixels
+	pixels := unscaledDisplay.pixels
+	colors := &unscaledDisplay.colors
+	width := unscaledDisplay.width
 
 	surface.surface.Lock()
 	for _, r := range *unscaledDisplay.changedRegions {
@@ -216,10 +220,10 @@ func (display *SDLScreen) render(screen *spectrum.DisplayData) {
 		end_y := uint(r.Y) + uint(r.H)
 
 		for y := uint(r.Y); y < end_y; y++ {
-			wy := spectrum.TotalScreenWidth * y
+			wy := width * y
 			addr := surface.addrXY(uint(r.X), y)
 			for x := uint(r.X); x < end_x; x++ {
-				*(*uint32)(unsafe.Pointer(addr)) = spectrum.Palette[pixels[wy+x]]
+				*(*uint32)(unsafe.Pointer(addr)) = colors[pixels[wy+x]]
 				addr += uintptr(bpp)
 			}
 		}
@@ -254,11 +258,13 @@ type SDLScreen2x struct {
 	app *spectrum.Application
 }
 
-func NewSDLScreen2x(app *spectrum.Application) *SDLScreen2x {
+// The border should be the same as the one set by 'spectrum.Cmd_SetBorder'.
+// Display data with a different border are ignored.
+func NewSDLScreen2x(app *spectrum.Application, border spectrum.Border) *SDLScreen2x {
 	SDL_screen := &SDLScreen2x{
 		screenChannel:   make(chan *spectrum.DisplayData),
-		screenSurface:   NewSDLSurface2x(app),
-		unscaledDisplay: newUnscaledDisplay(),
+		screenSurface:   NewSDLSurface2x(app, border),
+		unscaledDisplay: newUnscaledDisplay(border),
 		updatedRectsCh:  make(chan []sdl.Rect),
 		app:             app,
 	}
@@ -295,7 +301,14 @@ func (display *SDLScreen2x) render(screen *spectrum.DisplayData) {
 	bpp := uintptr(surface.Bpp())
 	bpp2 := 2 * bpp
 	pitch := uintptr(surface.Pitch())
-	pixels := &unscaledDisplay.pixels
+	pixels := unscaledDisplay.pixels
+	hiresPixels := unscaledDisplay.hiresPixels
+	colors := &unscaledDisplay.colors
+	width := unscaledDisplay.width
+
+	// The position of the 256x192 screen area within 'pixels'
+	X0 := uint(unscaledDisplay.border.Left)
+	Y0 := uint(unscaledDisplay.border.Top)
 
 	surface.surface.Lock()
 	for _, r := range *unscaledDisplay.changedRegions {
@@ -304,16 +317,30 @@ func (display *SDLScreen2x) render(screen *spectrum.DisplayData) {
 
 		for y := uint(r.Y); y < end_y; y++ {
 			addr := surface.addrXY(2*uint(r.X), 2*y)
-			wy := spectrum.TotalScreenWidth * y
+			wy := width * y
 
-			for x := uint(r.X); x < end_x; x++ {
-				color := spectrum.Palette[pixels[wy+x]]
+			hires_row := unscaledDisplay.hires && (y >= Y0) && (y < Y0+spectrum.ScreenHeight)
+			hwy := HIRES_WIDTH * (y - Y0)
 
-				// Fill a 2x2 rectangle
-				*(*uint32)(unsafe.Pointer(addr)) = color
-				*(*uint32)(unsafe.Pointer(addr + bpp)) = color
-				*(*uint32)(unsafe.Pointer(addr + pitch)) = color
-				*(*uint32)(unsafe.Pointer(addr + pitch + bpp)) = color
+			for x := uint(r.X); x < end_x; x++ {
+				if hires_row && (x >= X0) && (x < X0+spectrum.ScreenWidth) {
+					// Each 2x2 rectangle is made of 2 hi-res pixels
+					left := colors[hiresPixels[hwy+2*(x-X0)]]
+					right := colors[hiresPixels[hwy+2*(x-X0)+1]]
+
+					*(*uint32)(unsafe.Pointer(addr)) = left
+					*(*uint32)(unsafe.Pointer(addr + bpp)) = right
+					*(*uint32)(unsafe.Pointer(addr + pitch)) = left
+					*(*uint32)(unsafe.Pointer(addr + pitch + bpp)) = right
+				} else {
+					color := colors[pixels[wy+x]]
+
+					// Fill a 2x2 rectangle
+					*(*uint32)(unsafe.Pointer(addr)) = color
+					*(*uint32)(unsafe.Pointer(addr + bpp)) = color
+					*(*uint32)(unsafe.Pointer(addr + pitch)) = color
+					*(*uint32)(unsafe.Pointer(addr + pitch + bpp)) = color
+				}
 
 				addr += bpp2
 			}
@@ -417,19 +444,21 @@ func (l *ListOfRects) add(x, y int, w, h uint) {
 	l.addRect(sdl.Rect{int16(x), int16(y), uint16(w), uint16(h)})
 }
 
-func (l *ListOfRects) addBorder(scale uint) {
+func (l *ListOfRects) addBorder(scale uint, border spectrum.Border) {
 	s := scale
 
 	const W = spectrum.ScreenWidth
 	const H = spectrum.ScreenHeight
-	const BW = spectrum.ScreenBorderX
-	const BH = spectrum.ScreenBorderY
-	const TW = spectrum.TotalScreenWidth
+	L := uint(border.Left)
+	R := uint(border.Right)
+	T := uint(border.Top)
+	B := uint(border.Bottom)
+	TW := uint(border.TotalWidth())
 
-	l.add(int(s*0), int(s*0), s*TW, s*BH)      // Top
-	l.add(int(s*0), int(s*(BH+H)), s*TW, s*BH) // Bottom
-	l.add(int(s*0), int(s*BH), s*BW, s*H)      // Left
-	l.add(int(s*(BW+W)), int(s*BH), s*BW, s*H) // Right
+	l.add(int(s*0), int(s*0), s*TW, s*T)     // Top
+	l.add(int(s*0), int(s*(T+H)), s*TW, s*B) // Bottom
+	l.add(int(s*0), int(s*T), s*L, s*H)      // Left
+	l.add(int(s*(L+W)), int(s*T), s*R, s*H)  // Right
 }
 
 // ===============
@@ -437,17 +466,71 @@ func (l *ListOfRects) addBorder(scale uint) {
 // ===============
 
 type UnscaledDisplay struct {
-	pixels         [spectrum.TotalScreenWidth * spectrum.TotalScreenHeight]byte
+	pixels         []byte
 	changedRegions *ListOfRects
 
+	// The visible part of the border, and the dimensions of 'pixels'
+	border        spectrum.Border
+	width, height uint
+
 	// This is the border which was rendered to 'pixels'
-	border []spectrum.BorderEvent
+	borderEvents []spectrum.BorderEvent
+
+	// The colors of 'pixels'. Entries 0..15 are the standard colors,
+	// entries ULAPLUS_BASE...ULAPLUS_BASE+63 are the ULAplus palette.
+	colors [ULAPLUS_BASE + 64]uint32
+
+	// Whether 'pixels' were rendered using the ULAplus palette
+	ulaplus bool
+
+	// The 512x192 screen area (without the border) in Timex hi-res mode.
+	// Valid only if 'hires' is true.
+	hiresPixels []byte
+	hires       bool
 }
 
-func newUnscaledDisplay() *UnscaledDisplay {
-	return &UnscaledDisplay{
+// The width of 'UnscaledDisplay.hiresPixels'
+const HIRES_WIDTH = 2 * spectrum.ScreenWidth
+
+// The index of the first ULAplus color in 'UnscaledDisplay.colors'
+const ULAPLUS_BASE = 16
+
+func newUnscaledDisplay(border spectrum.Border) *UnscaledDisplay {
+	width := uint(border.TotalWidth())
+	height := uint(border.TotalHeight())
+	disp := &UnscaledDisplay{
+		pixels:         make([]byte, width*height),
+		hiresPixels:    make([]byte, HIRES_WIDTH*spectrum.ScreenHeight),
 		changedRegions: newListOfRects(),
-		border:         nil,
+		border:         border,
+		width:          width,
+		height:         height,
+		borderEvents:   nil,
+	}
+	copy(disp.colors[:], spectrum.Palette[:])
+	return disp
+}
+
+// Updates 'disp.colors' to match the ULAplus palette
+func (disp *UnscaledDisplay) setULAplusPalette(palette_orNil *[64]uint32) {
+	if (palette_orNil != nil) != disp.ulaplus {
+		disp.ulaplus = (palette_orNil != nil)
+
+		// The border has to be painted using the other colors
+		disp.borderEvents = nil
+	}
+
+	if palette_orNil != nil {
+		colors := disp.colors[ULAPLUS_BASE:]
+		for i, color := range palette_orNil {
+			if colors[i] != color {
+				copy(colors, palette_orNil[:])
+
+				// The color of any pixel might have changed
+				disp.changedRegions.add(0, 0, disp.width, disp.height)
+				break
+			}
+		}
 	}
 }
 
@@ -461,37 +544,41 @@ func (disp *UnscaledDisplay) releaseMemory() {
 
 // Set pixels from (minx,y) to (maxx,y). Both bounds are inclusive.
 func (disp *UnscaledDisplay) scanlineFill(minx, maxx, y int, color byte) {
-	wy := spectrum.TotalScreenWidth * y
-	pixels := &disp.pixels
+	width := int(disp.width)
+	height := int(disp.height)
+	wy := width * y
+	pixels := disp.pixels
 
 	if !(minx <= maxx) {
 		return
 	}
 
-	if maxx >= spectrum.TotalScreenWidth {
-		maxx = spectrum.TotalScreenWidth - 1
+	if maxx >= width {
+		maxx = width - 1
 	}
 
-	if (y < spectrum.ScreenBorderY) || (y >= spectrum.TotalScreenHeight-spectrum.ScreenBorderY) {
+	if (y < disp.border.Top) || (y >= height-disp.border.Bottom) {
 		for x := minx; x <= maxx; x++ {
 			pixels[wy+x] = color
 		}
 	} else {
-		for x := minx; x < spectrum.ScreenBorderX; x++ {
+		for x := minx; x < disp.border.Left; x++ {
 			pixels[wy+x] = color
 		}
-		for x := spectrum.TotalScreenWidth - spectrum.ScreenBorderX; x <= maxx; x++ {
+		for x := width - disp.border.Right; x <= maxx; x++ {
 			pixels[wy+x] = color
 		}
 	}
 }
 
 // Render border in the interval [start,end)
-func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderEvent, end spectrum.BorderEvent) {
+func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderEvent, end spectrum.BorderEvent, timings *spectrum.MachineTimings) {
 	spectrum.Assert(start.TState < end.TState)
 
-	const DISPLAY_START = spectrum.DISPLAY_START
-	const TSTATES_PER_LINE = spectrum.TSTATES_PER_LINE
+	DISPLAY_START := timings.DisplayStart(disp.border)
+	TSTATES_PER_LINE := timings.TStatesPerLine
+	width := int(disp.width)
+	height := int(disp.height)
 
 	if start.TState < DISPLAY_START {
 		start.TState = DISPLAY_START
@@ -499,7 +586,7 @@ func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderE
 	if end.TState-1 < DISPLAY_START {
 		return
 	}
-	if start.TState >= DISPLAY_START+spectrum.TotalScreenHeight*TSTATES_PER_LINE {
+	if start.TState >= DISPLAY_START+height*TSTATES_PER_LINE {
 		return
 	}
 
@@ -515,24 +602,28 @@ func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderE
 
 	// Clip to visible screen area
 	{
-		if end_y >= spectrum.TotalScreenHeight {
-			end_x = spectrum.TotalScreenWidth - 1
-			end_y = spectrum.TotalScreenHeight - 1
+		if end_y >= height {
+			end_x = width - 1
+			end_y = height - 1
 		}
 	}
 
 	// Fill scanlines from (start_x,start_y) to (end_x,end_y)
 	color := start.Color
+	if disp.ulaplus {
+		// The border is the paper of palette group 0
+		color = ULAPLUS_BASE + 8 + color
+	}
 	if start_y == end_y {
 		y := start_y
 		disp.scanlineFill(start_x, end_x, y, color)
 	} else {
 		// Top scanline (start_y)
-		disp.scanlineFill(start_x, spectrum.TotalScreenWidth-1, start_y, color)
+		disp.scanlineFill(start_x, width-1, start_y, color)
 
 		// Scanlines (start_y+1) ... (end_y-1)
 		for y := (start_y + 1); y < end_y; y++ {
-			disp.scanlineFill(0, spectrum.TotalScreenWidth-1, y, color)
+			disp.scanlineFill(0, width-1, y, color)
 		}
 
 		// Bottom scanline (end_y)
@@ -540,25 +631,43 @@ func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderE
 	}
 }
 
-func (disp *UnscaledDisplay) renderBorder(events []spectrum.BorderEvent) {
-	if !spectrum.SameBorderEvents(disp.border, events) {
+func (disp *UnscaledDisplay) renderBorder(events []spectrum.BorderEvent, timings *spectrum.MachineTimings) {
+	if !spectrum.SameBorderEvents(disp.borderEvents, events) {
 		if len(events) > 0 {
 			firstEvent := &events[0]
 			spectrum.Assert(firstEvent.TState == 0)
 
 			lastEvent := &events[len(events)-1]
-			spectrum.Assert(lastEvent.TState == spectrum.TStatesPerFrame)
+			spectrum.Assert(lastEvent.TState == timings.TStatesPerFrame)
 
 			numEvents := len(events)
 
+			// A border taller than the top or bottom border of the machine begins before
+			// the frame or ends after it. The lines outside of the frame are painted
+			// with the color of the first or the last event.
+			DISPLAY_START := timings.DisplayStart(disp.border)
+			DISPLAY_END := DISPLAY_START + int(disp.height)*timings.TStatesPerLine
+			painted := events
+			if (DISPLAY_START < 0) || (DISPLAY_END > timings.TStatesPerFrame) {
+				extended := make([]spectrum.BorderEvent, numEvents)
+				copy(extended, events)
+				if DISPLAY_START < 0 {
+					extended[0].TState = DISPLAY_START
+				}
+				if DISPLAY_END > timings.TStatesPerFrame {
+					extended[numEvents-1].TState = DISPLAY_END
+				}
+				painted = extended
+			}
+
 			for i := 0; i < numEvents-1; i++ {
-				disp.renderBorderBetweenTwoEvents(events[i], events[i+1])
+				disp.renderBorderBetweenTwoEvents(painted[i], painted[i+1], timings)
 			}
 
-			disp.changedRegions.addBorder( /*scale*/ 1)
+			disp.changedRegions.addBorder( /*scale*/ 1, disp.border)
 		}
 
-		disp.border = events
+		disp.borderEvents = events
 	}
 }
 
@@ -580,14 +689,29 @@ func init() {
 }
 
 func (disp *UnscaledDisplay) render(screen *spectrum.DisplayData) {
-	const X0 = spectrum.ScreenBorderX
-	const Y0 = spectrum.ScreenBorderY
+	if screen.Border != disp.border {
+		// The data were prepared for a display with a different border
+		return
+	}
+
+	disp.setULAplusPalette(screen.ULAplusPalette_orNil)
+	ulaplus := disp.ulaplus
+
+	X0 := uint(disp.border.Left)
+	Y0 := uint(disp.border.Top)
+	width := disp.width
 
 	screen_dirty := &screen.Dirty
 	screen_attr := &screen.Attr
+	screen_group := &screen.Group
 	screen_bitmap := &screen.Bitmap
+	screen_bitmap2 := &screen.Bitmap2
 
-	pixels := &disp.pixels
+	pixels := disp.pixels
+
+	hires := (screen.Mode == spectrum.SCREEN_HIRES)
+	disp.hires = hires
+	hiresPixels := disp.hiresPixels
 
 	var attr_x, attr_y uint
 	for attr_y = 0; attr_y < spectrum.ScreenHeight_Attr; attr_y++ {
@@ -600,23 +724,47 @@ func (disp *UnscaledDisplay) render(screen *spectrum.DisplayData) {
 
 				var y uint = 0
 				var src_ofs uint = ((8 * attr_y) << spectrum.BytesPerLine_log2) + attr_x
-				var dst_ofs uint = spectrum.TotalScreenWidth*(dst_Y0+y) + dst_X0
+				var dst_ofs uint = width*(dst_Y0+y) + dst_X0
+				var hires_ofs uint = HIRES_WIDTH*(8*attr_y+y) + 16*attr_x
 				for y < 8 {
 					// Paper is in the lower 4 bits, ink is in the higher 4 bits
 					var paperInk spectrum.Attr_4bit = screen_attr[src_ofs]
 					paperInk_array := [2]uint8{uint8(paperInk) & 0xf, (uint8(paperInk) >> 4) & 0xf}
+					if ulaplus {
+						base := ULAPLUS_BASE + 16*screen_group[src_ofs]
+						paperInk_array[0] += base
+						paperInk_array[1] += base
+					}
 
-					var value byte = screen_bitmap[src_ofs]
-					var unpacked_value *[8]uint = &bitmap_unpack_table[value]
-
-					for x := 0; x < 8; x++ {
-						color := paperInk_array[unpacked_value[x]]
-						pixels[dst_ofs+uint(x)] = color
+					if hires {
+						var unpacked_left *[8]uint = &bitmap_unpack_table[screen_bitmap[src_ofs]]
+						var unpacked_right *[8]uint = &bitmap_unpack_table[screen_bitmap2[src_ofs]]
+
+						for x := 0; x < 8; x++ {
+							hiresPixels[hires_ofs+uint(x)] = paperInk_array[unpacked_left[x]]
+							hiresPixels[hires_ofs+8+uint(x)] = paperInk_array[unpacked_right[x]]
+						}
+
+						// A pixel of the 256x192 display has the ink color
+						// if any of the two corresponding hi-res pixels has the ink color
+						for x := 0; x < 4; x++ {
+							pixels[dst_ofs+uint(x)] = paperInk_array[unpacked_left[2*x]|unpacked_left[2*x+1]]
+							pixels[dst_ofs+4+uint(x)] = paperInk_array[unpacked_right[2*x]|unpacked_right[2*x+1]]
+						}
+					} else {
+						var value byte = screen_bitmap[src_ofs]
+						var unpacked_value *[8]uint = &bitmap_unpack_table[value]
+
+						for x := 0; x < 8; x++ {
+							color := paperInk_array[unpacked_value[x]]
+							pixels[dst_ofs+uint(x)] = color
+						}
 					}
 
 					y += 1
 					src_ofs += spectrum.BytesPerLine
-					dst_ofs += spectrum.TotalScreenWidth
+					dst_ofs += width
+					hires_ofs += HIRES_WIDTH
 				}
 
 				disp.changedRegions.add(int(dst_X0), int(dst_Y0), 8, 8)
@@ -624,5 +772,5 @@ func (disp *UnscaledDisplay) render(screen *spectrum.DisplayData) {
 		}
 	}
 
-	disp.renderBorder(screen.BorderEvents)
+	disp.renderBorder(screen.BorderEvents, &screen.Timings)
 }
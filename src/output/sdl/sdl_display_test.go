@@ -189,7 +189,7 @@ func BenchmarkRender(b *testing.B) {
 	sdlScreen := &SDLScreen{
 		screenChannel:   make(chan *spectrum.DisplayData),
 		screenSurface:   &SDLSurface{newSurface()},
-		unscaledDisplay: newUnscaledDisplay(),
+		unscaledDisplay: newUnscaledDisplay(spectrum.BorderNormal),
 		updatedRectsCh:  make(chan []sdl.Rect),
 		app:             app,
 	}
@@ -2,14 +2,20 @@
 
 package sdl_output
 
+import (
+	"github.com/remogatto/gospeccy/src/spectrum"
+)
+
 type InitialSettings struct {
 	scale2x            *bool
 	fullscreen         *bool
+	border             *string
 	showPaintedRegions *bool
 
-	audio     *bool
-	audioFreq *uint
-	hqAudio   *bool
+	audio       *bool
+	audioFreq   *uint
+	hqAudio     *bool
+	audioStereo *string
 }
 
 func (s *InitialSettings) Terminated() bool {
@@ -22,6 +28,11 @@ func (s *InitialSettings) ResizeVideo(scale2x, fullscreen bool) {
 	*s.fullscreen = fullscreen
 }
 
+func (s *InitialSettings) SetBorder(border spectrum.Border) {
+	// Overwrite the command-line settings
+	*s.border = border.String()
+}
+
 func (s *InitialSettings) ShowPaintedRegions(enable bool) {
 	*s.showPaintedRegions = enable
 }
@@ -40,3 +51,15 @@ func (s *InitialSettings) SetAudioQuality(hqAudio bool) {
 	// Overwrite the command-line settings
 	*s.hqAudio = hqAudio
 }
+
+func (s *InitialSettings) SetAudioStereoMode(stereoMode StereoMode) {
+	// Overwrite the command-line settings
+	switch stereoMode {
+	case STEREO_MONO:
+		*s.audioStereo = "mono"
+	case STEREO_ABC:
+		*s.audioStereo = "abc"
+	case STEREO_ACB:
+		*s.audioStereo = "acb"
+	}
+}
@@ -4,6 +4,7 @@ package sdl_output
 
 import (
 	intp "github.com/remogatto/gospeccy/src/interpreter"
+	"github.com/remogatto/gospeccy/src/spectrum"
 	"github.com/sbinet/go-eval"
 	"sync"
 )
@@ -12,10 +13,12 @@ type userInterfaceSettings_t interface {
 	Terminated() bool
 
 	ResizeVideo(scale2x, fullscreen bool)
+	SetBorder(border spectrum.Border)
 	ShowPaintedRegions(enable bool)
 	EnableAudio(enable bool)
 	SetAudioFreq(freq uint) // 0 means "default frequency"
 	SetAudioQuality(hqAudio bool)
+	SetAudioStereoMode(stereoMode StereoMode)
 }
 
 var uiSettings userInterfaceSettings_t
@@ -63,6 +66,22 @@ func wrapper_fullscreen(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	}
 }
 
+// Signature: func border(preset string)
+func wrapper_border(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if uiSettings.Terminated() {
+		return
+	}
+
+	border, err := spectrum.ParseBorder(in[0].(eval.StringValue).Get(t))
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	uiSettings.SetBorder(border)
+	mutex.Unlock()
+}
+
 // Signature: func showPaint(enable bool)
 func wrapper_showPaint(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
@@ -115,6 +134,22 @@ func wrapper_audioHQ(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	mutex.Unlock()
 }
 
+// Signature: func audioStereo(mode string)
+func wrapper_audioStereo(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if uiSettings.Terminated() {
+		return
+	}
+
+	stereoMode, err := ParseStereoMode(in[0].(eval.StringValue).Get(t))
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	uiSettings.SetAudioStereoMode(stereoMode)
+	mutex.Unlock()
+}
+
 func defineFunctions() {
 	{
 		var functionSignature func(uint)
@@ -138,6 +173,17 @@ func defineFunctions() {
 			Help_value: "Fullscreen on/off",
 		})
 	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_border, functionSignature)
+		intp.DefineFunction(intp.Function{
+			Name:       "border",
+			Type:       funcType,
+			Value:      funcValue,
+			Help_key:   "border(preset string)",
+			Help_value: "Change the visible part of the border (\"normal\" or \"full\")",
+		})
+	}
 	{
 		var functionSignature func(bool)
 		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_showPaint, functionSignature)
@@ -182,6 +228,17 @@ func defineFunctions() {
 			Help_value: "Enable or disable high-quality audio",
 		})
 	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_audioStereo, functionSignature)
+		intp.DefineFunction(intp.Function{
+			Name:       "audioStereo",
+			Type:       funcType,
+			Value:      funcValue,
+			Help_key:   "audioStereo(mode string)",
+			Help_value: "Placement of the AY channels (\"mono\", \"abc\" or \"acb\")",
+		})
+	}
 }
 
 func init() {
@@ -17,6 +17,7 @@ import (
 	"github.com/remogatto/gospeccy/src/spectrum"
 	"math"
 	"os"
+	"strings"
 	"sync"
 )
 
@@ -154,6 +155,35 @@ const MIN_PLAYBACK_FREQUENCY = 10000
 // It is used only when 'hqAudio' is enabled.
 const RESPONSE_FREQUENCY = 12000
 
+// The maximum level of a single AY-3-8912 channel.
+// The beeper and three AY channels at full volume add up to 2*0x7fff.
+const AY_CHANNEL_LEVEL = 0x7fff / 3
+
+// Scales the mixed sound down to the range of an int16 sample
+const VOLUME_ADJUSTMENT = 0.5
+
+// Placement of the AY-3-8912 channels in the stereo panorama
+type StereoMode int
+
+const (
+	STEREO_MONO StereoMode = iota // All channels in the center
+	STEREO_ABC                    // A left, B center, C right
+	STEREO_ACB                    // A left, C center, B right
+)
+
+// Converts "mono", "abc" or "acb" to a StereoMode
+func ParseStereoMode(mode string) (StereoMode, error) {
+	switch strings.ToLower(mode) {
+	case "mono":
+		return STEREO_MONO, nil
+	case "abc":
+		return STEREO_ABC, nil
+	case "acb":
+		return STEREO_ACB, nil
+	}
+	return STEREO_MONO, errors.New("invalid stereo mode \"" + mode + "\"")
+}
+
 type SDLAudio struct {
 	// Synchronous Go channel for receiving 'AudioData' objects
 	data chan *spectrum.AudioData
@@ -199,6 +229,15 @@ type SDLAudio struct {
 	// Enables higher-quality audio resampling
 	hqAudio bool
 
+	// Placement of the AY channels. If it is not STEREO_MONO, the SDL audio device has 2 channels.
+	stereoMode StereoMode
+
+	// Generates the AY sound, initially nil
+	aySynth *spectrum.AYSynth
+
+	// Arrays for storing the output of AY channels A, B and C
+	ay_a, ay_b, ay_c []float64
+
 	// The number of frames seen by this 'SDLAudio' object
 	frame uint
 
@@ -209,7 +248,7 @@ var sdlAudio_instance *SDLAudio = nil
 
 // Opens SDL audio.
 // If 'playbackFrequency' is 0, the frequency will be equivalent to PLAYBACK_FREQUENCY.
-func NewSDLAudio(app *spectrum.Application, playbackFrequency uint, hqAudio bool) (*SDLAudio, error) {
+func NewSDLAudio(app *spectrum.Application, playbackFrequency uint, hqAudio bool, stereoMode StereoMode) (*SDLAudio, error) {
 	if playbackFrequency == 0 {
 		playbackFrequency = PLAYBACK_FREQUENCY
 	}
@@ -223,7 +262,11 @@ func NewSDLAudio(app *spectrum.Application, playbackFrequency uint, hqAudio bool
 	{
 		spec.Freq = int(playbackFrequency)
 		spec.Format = sdl_audio.AUDIO_S16SYS
-		spec.Channels = 1
+		if stereoMode == STEREO_MONO {
+			spec.Channels = 1
+		} else {
+			spec.Channels = 2
+		}
 		spec.Samples = uint16(2048 * float32(playbackFrequency) / PLAYBACK_FREQUENCY)
 		if sdl_audio.OpenAudio(&spec, &spec) != 0 {
 			return nil, errors.New(sdl.GetError())
@@ -243,6 +286,10 @@ func NewSDLAudio(app *spectrum.Application, playbackFrequency uint, hqAudio bool
 		freq:                  uint(spec.Freq),
 		virtualFreq:           uint(spec.Freq),
 		hqAudio:               hqAudio,
+		stereoMode:            stereoMode,
+	}
+	if spec.Channels == 1 {
+		audio.stereoMode = STEREO_MONO
 	}
 
 	go forwarderLoop(app.NewEventLoop(), audio)
@@ -468,18 +515,20 @@ func add_hq(samples []float64, x, w, h, spread, spread1 float64) {
 func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 	var events []spectrum.BeeperEvent
 
+	TStatesPerFrame := audioData.TStatesPerFrame
+
 	if len(audioData.BeeperEvents) > 0 {
 		var firstEvent *spectrum.BeeperEvent = &audioData.BeeperEvents[0]
 		spectrum.Assert(firstEvent.TState == 0)
 
 		var lastEvent *spectrum.BeeperEvent = &audioData.BeeperEvents[len(audioData.BeeperEvents)-1]
-		spectrum.Assert(lastEvent.TState == spectrum.TStatesPerFrame)
+		spectrum.Assert(lastEvent.TState == TStatesPerFrame)
 
 		events = audioData.BeeperEvents
 	} else {
 		events = make([]spectrum.BeeperEvent, 2)
 		events[0] = spectrum.BeeperEvent{TState: 0, Level: 0}
-		events[1] = spectrum.BeeperEvent{TState: spectrum.TStatesPerFrame, Level: 0}
+		events[1] = spectrum.BeeperEvent{TState: TStatesPerFrame, Level: 0}
 	}
 
 	/*
@@ -520,8 +569,8 @@ func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 		}
 		samples = audio.samples
 
-		if len(audio.samples_int16) < numSamples {
-			audio.samples_int16 = make([]int16, numSamples)
+		if len(audio.samples_int16) < 2*numSamples {
+			audio.samples_int16 = make([]int16, 2*numSamples)
 		}
 		samples_int16 = audio.samples_int16
 
@@ -535,7 +584,7 @@ func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 		audio.mutex.Unlock()
 	}
 
-	var k float64 = float64(numSamples) / spectrum.TStatesPerFrame
+	var k float64 = float64(numSamples) / float64(TStatesPerFrame)
 
 	{
 		for i := 0; i < len(samples); i++ {
@@ -563,11 +612,75 @@ func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 		copy(overflow[:], samples[numSamples:])
 	}
 
-	for i := 0; i < numSamples; i++ {
-		const VOLUME_ADJUSTMENT = 0.5
-		samples_int16[i] = int16(VOLUME_ADJUSTMENT * samples[i])
+	if audioData.AY == nil {
+		if audio.stereoMode == STEREO_MONO {
+			for i := 0; i < numSamples; i++ {
+				samples_int16[i] = int16(VOLUME_ADJUSTMENT * samples[i])
+			}
+		} else {
+			for i := 0; i < numSamples; i++ {
+				sample := int16(VOLUME_ADJUSTMENT * samples[i])
+				samples_int16[2*i+0] = sample
+				samples_int16[2*i+1] = sample
+			}
+		}
+	} else {
+		a, b, c := audio.renderAY(audioData, numSamples)
+		mixAY(audio.stereoMode, samples[0:numSamples], a, b, c, samples_int16)
 	}
 
 	audio.frame++
-	sdl_audio.SendAudio_int16(samples_int16[0:numSamples])
+	if audio.stereoMode == STEREO_MONO {
+		sdl_audio.SendAudio_int16(samples_int16[0:numSamples])
+	} else {
+		sdl_audio.SendAudio_int16(samples_int16[0 : 2*numSamples])
+	}
+}
+
+// Mixes the beeper samples with the output of AY channels A, B and C.
+// If 'stereoMode' is not STEREO_MONO, the left and right samples are interleaved in 'out'.
+func mixAY(stereoMode StereoMode, beeper, a, b, c []float64, out []int16) {
+	switch stereoMode {
+	case STEREO_MONO:
+		for i := range beeper {
+			ay := AY_CHANNEL_LEVEL * (a[i] + b[i] + c[i])
+			out[i] = int16(VOLUME_ADJUSTMENT * (beeper[i] + ay))
+		}
+	case STEREO_ABC:
+		for i := range beeper {
+			left := AY_CHANNEL_LEVEL * (a[i] + 0.5*b[i])
+			right := AY_CHANNEL_LEVEL * (c[i] + 0.5*b[i])
+			out[2*i+0] = int16(VOLUME_ADJUSTMENT * (beeper[i] + left))
+			out[2*i+1] = int16(VOLUME_ADJUSTMENT * (beeper[i] + right))
+		}
+	case STEREO_ACB:
+		for i := range beeper {
+			left := AY_CHANNEL_LEVEL * (a[i] + 0.5*c[i])
+			right := AY_CHANNEL_LEVEL * (b[i] + 0.5*c[i])
+			out[2*i+0] = int16(VOLUME_ADJUSTMENT * (beeper[i] + left))
+			out[2*i+1] = int16(VOLUME_ADJUSTMENT * (beeper[i] + right))
+		}
+	}
+}
+
+// Synthesizes the sound of the AY-3-8912 chip.
+// Returns the output of channels A, B and C, in range 0.0 ... 1.0.
+func (audio *SDLAudio) renderAY(audioData *spectrum.AudioData, numSamples int) (a, b, c []float64) {
+	if audio.aySynth == nil {
+		audio.aySynth = spectrum.NewAYSynth()
+	}
+
+	if len(audio.ay_a) < numSamples {
+		audio.ay_a = make([]float64, numSamples)
+		audio.ay_b = make([]float64, numSamples)
+		audio.ay_c = make([]float64, numSamples)
+	}
+
+	a = audio.ay_a[0:numSamples]
+	b = audio.ay_b[0:numSamples]
+	c = audio.ay_c[0:numSamples]
+
+	audio.aySynth.Render(audioData.AY, audioData.TStatesPerFrame, a, b, c)
+
+	return a, b, c
 }
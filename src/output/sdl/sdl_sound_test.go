@@ -0,0 +1,77 @@
+// +build linux freebsd
+
+package sdl_output
+
+import (
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"math"
+	"testing"
+)
+
+func TestMixAY(t *testing.T) {
+	level := float64(AY_CHANNEL_LEVEL)
+	full := int16(VOLUME_ADJUSTMENT * level)
+	half := int16(VOLUME_ADJUSTMENT * level * 0.5)
+
+	tests := []struct {
+		stereoMode StereoMode
+		a, b, c    float64
+		out        []int16
+	}{
+		{STEREO_MONO, 1, 0, 0, []int16{full}},
+		{STEREO_MONO, 0, 1, 0, []int16{full}},
+		{STEREO_MONO, 0, 0, 1, []int16{full}},
+
+		{STEREO_ABC, 1, 0, 0, []int16{full, 0}},
+		{STEREO_ABC, 0, 1, 0, []int16{half, half}},
+		{STEREO_ABC, 0, 0, 1, []int16{0, full}},
+
+		{STEREO_ACB, 1, 0, 0, []int16{full, 0}},
+		{STEREO_ACB, 0, 1, 0, []int16{0, full}},
+		{STEREO_ACB, 0, 0, 1, []int16{half, half}},
+	}
+
+	for _, test := range tests {
+		out := make([]int16, len(test.out))
+		mixAY(test.stereoMode, []float64{0}, []float64{test.a}, []float64{test.b}, []float64{test.c}, out)
+
+		for i := range out {
+			if out[i] != test.out[i] {
+				t.Errorf("stereo mode %d, AY output (%v,%v,%v): expected %v, got %v",
+					test.stereoMode, test.a, test.b, test.c, test.out, out)
+				break
+			}
+		}
+	}
+
+	// The beeper is mixed into both stereo channels
+	out := make([]int16, 2)
+	mixAY(STEREO_ABC, []float64{1000}, []float64{1}, []float64{0}, []float64{0}, out)
+	if (out[0] != int16(VOLUME_ADJUSTMENT*(1000+level))) || (out[1] != int16(VOLUME_ADJUSTMENT*1000)) {
+		t.Errorf("beeper mixing: got %v", out)
+	}
+}
+
+func TestRenderAY(t *testing.T) {
+	audio := &SDLAudio{stereoMode: STEREO_ABC}
+
+	ayData := &spectrum.AYData{}
+	ayData.Registers[spectrum.AY_MIXER] = 0x3f
+	ayData.Registers[spectrum.AY_VOLUME_A] = 15
+	ayData.Registers[spectrum.AY_VOLUME_B] = 8
+
+	audioData := &spectrum.AudioData{TStatesPerFrame: spectrum.TStatesPerFrame_128k, AY: ayData}
+
+	const numSamples = 960
+	a, b, c := audio.renderAY(audioData, numSamples)
+	if (len(a) != numSamples) || (len(b) != numSamples) || (len(c) != numSamples) {
+		t.Fatalf("expected %d samples", numSamples)
+	}
+
+	for i := 0; i < numSamples; i++ {
+		// The samples are averages, which may differ from the volume table in the last bits
+		if (math.Abs(a[i]-1) > 1e-9) || (math.Abs(b[i]-spectrum.AY_VolumeTable[8]) > 1e-9) || (c[i] != 0) {
+			t.Fatalf("sample %d: got (%v,%v,%v)", i, a[i], b[i], c[i])
+		}
+	}
+}
@@ -30,6 +30,9 @@ type Application struct {
 	Verbose         bool
 	VerboseShutdown bool
 
+	// The directories in which this application searches for files
+	SearchPaths *SearchPaths
+
 	CreationTime time.Time // The time when this Application object was created
 }
 
@@ -40,6 +43,7 @@ func NewApplication() *Application {
 		eventLoops:    make([]*EventLoop, 0, 8),
 		CreationTime:  time.Now(),
 		messageOutput: &stdoutMessageOutput{},
+		SearchPaths:   NewSearchPaths(),
 	}
 
 	go appGoroutine(app)
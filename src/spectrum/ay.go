@@ -0,0 +1,398 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+// AY-3-8912 registers
+const (
+	AY_TONE_A_FINE = iota
+	AY_TONE_A_COARSE
+	AY_TONE_B_FINE
+	AY_TONE_B_COARSE
+	AY_TONE_C_FINE
+	AY_TONE_C_COARSE
+	AY_NOISE_PERIOD
+	AY_MIXER
+	AY_VOLUME_A
+	AY_VOLUME_B
+	AY_VOLUME_C
+	AY_ENVELOPE_FINE
+	AY_ENVELOPE_COARSE
+	AY_ENVELOPE_SHAPE
+	AY_IO_PORT_A
+	AY_IO_PORT_B
+
+	AY_NUM_REGISTERS
+)
+
+// The number of meaningful bits of each register
+var ay_registerMask = [AY_NUM_REGISTERS]byte{
+	0xff, 0x0f, 0xff, 0x0f, 0xff, 0x0f, 0x1f, 0xff,
+	0x1f, 0x1f, 0x1f, 0xff, 0xff, 0x0f, 0xff, 0xff,
+}
+
+// The AY-3-8912 is clocked at half the CPU clock on the 128k.
+// The tone generators advance once every 8 AY cycles.
+const AY_TSTATES_PER_TICK = 16
+
+// The logarithmic DAC of the AY-3-8912, normalized to range 0.0 ... 1.0.
+// The values approximate the output levels measured on a real chip.
+var AY_VolumeTable = [16]float64{
+	0.0000, 0.0137, 0.0205, 0.0291, 0.0423, 0.0618, 0.0847, 0.1369,
+	0.1691, 0.2647, 0.3527, 0.4499, 0.5704, 0.6873, 0.8482, 1.0000,
+}
+
+// A write to an AY register
+type AYEvent struct {
+	// The moment when the register was written.
+	// It is the number of T-states since the beginning of the frame.
+	TState int
+
+	Register byte
+	Value    byte
+}
+
+// The AY-3-8912 part of an AudioData object
+type AYData struct {
+	// Register values at the beginning of the frame
+	Registers [AY_NUM_REGISTERS]byte
+
+	// Register writes performed during the frame, in chronological order
+	Events []AYEvent
+}
+
+// The AY-3-8912 sound chip, as seen by the Z80.
+//
+// The chip does not generate any sound here. It only records
+// the register writes, which are sent to audio receivers via AudioData.
+// The sound itself is synthesized by AYSynth.
+type AY struct {
+	speccy *Spectrum48k
+
+	selectedRegister byte
+	registers        [AY_NUM_REGISTERS]byte
+
+	registersAtFrameBegin [AY_NUM_REGISTERS]byte
+	events                []AYEvent
+}
+
+func NewAY() *AY {
+	return &AY{events: make([]AYEvent, 0)}
+}
+
+func (ay *AY) init(speccy *Spectrum48k) {
+	ay.speccy = speccy
+}
+
+func (ay *AY) reset() {
+	ay.selectedRegister = 0
+	for i := 0; i < AY_NUM_REGISTERS; i++ {
+		ay.writeRegister(byte(i), 0)
+	}
+}
+
+func (ay *AY) frame_begin() {
+	ay.registersAtFrameBegin = ay.registers
+	ay.events = ay.events[0:0]
+}
+
+// Handles a write to port 0xfffd
+func (ay *AY) selectRegister(b byte) {
+	ay.selectedRegister = b
+}
+
+// Handles a write to port 0xbffd
+func (ay *AY) write(b byte) {
+	if ay.selectedRegister < AY_NUM_REGISTERS {
+		ay.writeRegister(ay.selectedRegister, b)
+	}
+}
+
+// Handles a read from port 0xfffd
+func (ay *AY) read() byte {
+	if ay.selectedRegister < AY_NUM_REGISTERS {
+		return ay.registers[ay.selectedRegister]
+	}
+	return 0xff
+}
+
+func (ay *AY) writeRegister(reg byte, b byte) {
+	b &= ay_registerMask[reg]
+	ay.registers[reg] = b
+
+	var tstate int
+	if ay.speccy != nil {
+		tstate = ay.speccy.Cpu.Tstates
+	}
+	ay.events = append(ay.events, AYEvent{tstate, reg, b})
+}
+
+// Returns the register values
+func (ay *AY) Registers() [AY_NUM_REGISTERS]byte {
+	return ay.registers
+}
+
+// Returns a copy of the data collected during the current frame
+func (ay *AY) getAYData() *AYData {
+	data := &AYData{
+		Registers: ay.registersAtFrameBegin,
+		Events:    make([]AYEvent, len(ay.events)),
+	}
+	copy(data.Events, ay.events)
+	return data
+}
+
+// ============
+// Sound output
+// ============
+
+// AYSynth generates the sound of the AY-3-8912 chip
+// from the register values and register writes contained in AYData objects.
+// An AYSynth object is meant to be used by an AudioReceiver.
+type AYSynth struct {
+	registers [AY_NUM_REGISTERS]byte
+
+	toneCounter [3]int
+	toneOutput  [3]bool
+
+	noiseCounter   int
+	noisePrescaler bool
+	noiseShift     uint32
+	noiseOutput    bool
+
+	envCounter int
+	envStep    int
+	envAttack  int
+	envHold    bool
+	envAlt     bool
+	envHolding bool
+	envVolume  int
+
+	// The first T-state of the next frame at which the chip advances
+	tstate int
+
+	// The number of ticks contributing to each sample.
+	// It is declared here in order to avoid repetitive allocation in method 'Render'.
+	counts []int
+}
+
+func NewAYSynth() *AYSynth {
+	s := &AYSynth{noiseShift: 1}
+	s.restartEnvelope()
+	return s
+}
+
+func (s *AYSynth) setRegister(reg byte, b byte) {
+	s.registers[reg] = b
+	if reg == AY_ENVELOPE_SHAPE {
+		s.restartEnvelope()
+	}
+}
+
+func (s *AYSynth) restartEnvelope() {
+	shape := s.registers[AY_ENVELOPE_SHAPE]
+
+	if (shape & 0x04) != 0 {
+		s.envAttack = 0x0f
+	} else {
+		s.envAttack = 0x00
+	}
+
+	if (shape & 0x08) == 0 {
+		// Non-continuing shapes: one cycle, then hold the volume at 0
+		s.envHold = true
+		s.envAlt = (s.envAttack != 0)
+	} else {
+		s.envHold = ((shape & 0x01) != 0)
+		s.envAlt = ((shape & 0x02) != 0)
+	}
+
+	s.envCounter = 0
+	s.envStep = 0x0f
+	s.envHolding = false
+	s.envVolume = s.envStep ^ s.envAttack
+}
+
+// Advances the chip by one tick (AY_TSTATES_PER_TICK T-states)
+func (s *AYSynth) tick() {
+	regs := &s.registers
+
+	// Tone
+	for ch := 0; ch < 3; ch++ {
+		period := int(regs[2*ch]) | (int(regs[2*ch+1]) << 8)
+		if period == 0 {
+			period = 1
+		}
+
+		s.toneCounter[ch]++
+		if s.toneCounter[ch] >= period {
+			s.toneCounter[ch] = 0
+			s.toneOutput[ch] = !s.toneOutput[ch]
+		}
+	}
+
+	// Noise (clocked at half the rate of the tone generators)
+	s.noisePrescaler = !s.noisePrescaler
+	if s.noisePrescaler {
+		period := int(regs[AY_NOISE_PERIOD])
+		if period == 0 {
+			period = 1
+		}
+
+		s.noiseCounter++
+		if s.noiseCounter >= period {
+			s.noiseCounter = 0
+
+			// 17-bit shift register
+			if ((s.noiseShift + 1) & 2) != 0 {
+				s.noiseOutput = !s.noiseOutput
+			}
+			if (s.noiseShift & 1) != 0 {
+				s.noiseShift ^= 0x24000
+			}
+			s.noiseShift >>= 1
+		}
+	}
+
+	// Envelope (16 steps per cycle, each step lasts 2*period ticks)
+	period := int(regs[AY_ENVELOPE_FINE]) | (int(regs[AY_ENVELOPE_COARSE]) << 8)
+	if period == 0 {
+		period = 1
+	}
+
+	s.envCounter++
+	if s.envCounter >= 2*period {
+		s.envCounter = 0
+
+		if !s.envHolding {
+			s.envStep--
+			if s.envStep < 0 {
+				if s.envHold {
+					if s.envAlt {
+						s.envAttack ^= 0x0f
+					}
+					s.envHolding = true
+					s.envStep = 0
+				} else {
+					if s.envAlt {
+						s.envAttack ^= 0x0f
+					}
+					s.envStep &= 0x0f
+				}
+			}
+			s.envVolume = s.envStep ^ s.envAttack
+		}
+	}
+}
+
+// Returns the current output level of the specified channel (0.0 ... 1.0)
+func (s *AYSynth) output(ch int) float64 {
+	mixer := s.registers[AY_MIXER]
+	toneDisabled := ((mixer >> uint(ch)) & 0x01) != 0
+	noiseDisabled := ((mixer >> uint(ch+3)) & 0x01) != 0
+
+	if (s.toneOutput[ch] || toneDisabled) && (s.noiseOutput || noiseDisabled) {
+		volume := s.registers[AY_VOLUME_A+ch]
+		if (volume & 0x10) != 0 {
+			return AY_VolumeTable[s.envVolume]
+		}
+		return AY_VolumeTable[volume&0x0f]
+	}
+
+	return 0
+}
+
+// Renders one frame of AY sound. The number of samples is determined by the length of 'a'.
+// The output of channels A, B and C is stored into 'a', 'b' and 'c'.
+// The values are in range 0.0 ... 1.0.
+func (s *AYSynth) Render(ayData *AYData, tstatesPerFrame int, a, b, c []float64) {
+	numSamples := len(a)
+	if (numSamples == 0) || (tstatesPerFrame <= 0) {
+		return
+	}
+
+	for i := 0; i < numSamples; i++ {
+		a[i], b[i], c[i] = 0, 0, 0
+	}
+
+	// Register values at the beginning of the frame.
+	// Using them does not restart the envelope.
+	s.registers = ayData.Registers
+
+	events := ayData.Events
+	numEvents := len(events)
+	nextEvent := 0
+
+	if len(s.counts) < numSamples {
+		s.counts = make([]int, numSamples)
+	}
+	counts := s.counts[0:numSamples]
+	for i := 0; i < numSamples; i++ {
+		counts[i] = 0
+	}
+
+	k := float64(numSamples) / float64(tstatesPerFrame)
+
+	t := s.tstate
+	for ; t < tstatesPerFrame; t += AY_TSTATES_PER_TICK {
+		for (nextEvent < numEvents) && (events[nextEvent].TState <= t) {
+			s.setRegister(events[nextEvent].Register, events[nextEvent].Value)
+			nextEvent++
+		}
+
+		s.tick()
+
+		i := int(float64(t) * k)
+		if i >= numSamples {
+			i = numSamples - 1
+		}
+		a[i] += s.output(0)
+		b[i] += s.output(1)
+		c[i] += s.output(2)
+		counts[i]++
+	}
+	s.tstate = t - tstatesPerFrame
+
+	// Events which did not fit into the frame
+	for ; nextEvent < numEvents; nextEvent++ {
+		s.setRegister(events[nextEvent].Register, events[nextEvent].Value)
+	}
+
+	// Average the ticks belonging to each sample.
+	// If a sample received no tick (playback frequency above the tick rate),
+	// it repeats the previous sample.
+	var last [3]float64
+	for i := 0; i < numSamples; i++ {
+		if counts[i] > 0 {
+			n := float64(counts[i])
+			a[i] /= n
+			b[i] /= n
+			c[i] /= n
+			last[0], last[1], last[2] = a[i], b[i], c[i]
+		} else {
+			a[i], b[i], c[i] = last[0], last[1], last[2]
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package spectrum
+
+import (
+	"fmt"
+	"math"
+)
+
+func (t *testSuite) TestAY_RegisterMask() {
+	ay := NewAY()
+
+	tests := []struct {
+		reg  byte
+		mask byte
+	}{
+		{AY_TONE_A_FINE, 0xff},
+		{AY_TONE_A_COARSE, 0x0f},
+		{AY_TONE_B_FINE, 0xff},
+		{AY_TONE_B_COARSE, 0x0f},
+		{AY_TONE_C_FINE, 0xff},
+		{AY_TONE_C_COARSE, 0x0f},
+		{AY_NOISE_PERIOD, 0x1f},
+		{AY_MIXER, 0xff},
+		{AY_VOLUME_A, 0x1f},
+		{AY_VOLUME_B, 0x1f},
+		{AY_VOLUME_C, 0x1f},
+		{AY_ENVELOPE_FINE, 0xff},
+		{AY_ENVELOPE_COARSE, 0xff},
+		{AY_ENVELOPE_SHAPE, 0x0f},
+		{AY_IO_PORT_A, 0xff},
+		{AY_IO_PORT_B, 0xff},
+	}
+
+	for _, test := range tests {
+		ay.selectRegister(test.reg)
+		ay.write(0xff)
+		t.Equal(test.mask, ay.read())
+		t.Equal(test.mask, ay.Registers()[test.reg])
+	}
+
+	// There are only 16 registers
+	ay.selectRegister(AY_NUM_REGISTERS)
+	ay.write(0x12)
+	t.Equal(byte(0xff), ay.read())
+
+	// Each write is recorded as an event
+	t.Equal(AY_NUM_REGISTERS, len(ay.getAYData().Events))
+	ay.frame_begin()
+	t.Equal(0, len(ay.getAYData().Events))
+	t.Equal(ay.Registers(), ay.getAYData().Registers)
+}
+
+// Returns the envelope volume at each of the first 'n' envelope steps,
+// with the envelope period set to 1
+func testEnvelope(shape byte, n int) []int {
+	s := NewAYSynth()
+	s.setRegister(AY_ENVELOPE_FINE, 1)
+	s.setRegister(AY_ENVELOPE_SHAPE, shape)
+
+	volumes := make([]int, n)
+	for i := range volumes {
+		volumes[i] = s.envVolume
+
+		// Each step lasts 2*period ticks
+		s.tick()
+		s.tick()
+	}
+	return volumes
+}
+
+// Returns the envelope volumes described by 'segments'.
+// Each segment is 16 steps long: '\' is a decay, '/' is an attack,
+// '_' holds the volume at 0 and '^' holds the volume at 15.
+func envelopeVolumes(segments string) []int {
+	volumes := make([]int, 0, 16*len(segments))
+	for _, segment := range segments {
+		for i := 0; i < 16; i++ {
+			switch segment {
+			case '\\':
+				volumes = append(volumes, 15-i)
+			case '/':
+				volumes = append(volumes, i)
+			case '_':
+				volumes = append(volumes, 0)
+			case '^':
+				volumes = append(volumes, 15)
+			}
+		}
+	}
+	return volumes
+}
+
+func (t *testSuite) TestAYSynth_EnvelopeShapes() {
+	shapes := [16]string{
+		`\__`, `\__`, `\__`, `\__`,
+		`/__`, `/__`, `/__`, `/__`,
+		`\\\`, `\__`, `\/\`, `\^^`,
+		`///`, `/^^`, `/\/`, `/__`,
+	}
+
+	for shape, segments := range shapes {
+		expected := envelopeVolumes(segments)
+		actual := testEnvelope(byte(shape), len(expected))
+
+		t.Equal(fmt.Sprint(expected), fmt.Sprint(actual), fmt.Sprintf("envelope shape %d", shape))
+	}
+}
+
+func (t *testSuite) TestAYSynth_TonePeriod() {
+	tests := []struct {
+		fine, coarse byte
+		ticks        int
+	}{
+		{0x00, 0x00, 1},
+		{0x01, 0x00, 1},
+		{0x05, 0x00, 5},
+		{0x23, 0x01, 0x123},
+		{0xff, 0x0f, 0xfff},
+	}
+
+	for _, test := range tests {
+		for ch := 0; ch < 3; ch++ {
+			s := NewAYSynth()
+			s.setRegister(byte(2*ch), test.fine)
+			s.setRegister(byte(2*ch+1), test.coarse)
+
+			// The output toggles after every 'period' ticks
+			for i := 0; i < test.ticks-1; i++ {
+				s.tick()
+			}
+			t.False(s.toneOutput[ch])
+			s.tick()
+			t.True(s.toneOutput[ch])
+
+			for i := 0; i < test.ticks; i++ {
+				s.tick()
+			}
+			t.False(s.toneOutput[ch])
+		}
+	}
+}
+
+func (t *testSuite) TestAYSynth_NoisePeriod() {
+	tests := []struct {
+		period byte
+		ticks  int
+	}{
+		{0x00, 2},
+		{0x01, 2},
+		{0x07, 14},
+		{0x1f, 62},
+	}
+
+	for _, test := range tests {
+		s := NewAYSynth()
+		s.setRegister(AY_NOISE_PERIOD, test.period)
+
+		// The noise generator is clocked at half the rate of the tone generators,
+		// so the shift register advances after every 2*period ticks
+		for i := 0; i < test.ticks-1; i++ {
+			s.tick()
+		}
+		shift := s.noiseShift
+		for i := 0; i < test.ticks-1; i++ {
+			s.tick()
+		}
+		t.Equal(shift, s.noiseShift)
+		s.tick()
+		t.True(shift != s.noiseShift)
+	}
+}
+
+func (t *testSuite) TestAYSynth_Volume() {
+	t.Equal(0.0, AY_VolumeTable[0])
+	t.Equal(1.0, AY_VolumeTable[15])
+	for i := 1; i < 16; i++ {
+		t.True(AY_VolumeTable[i] > AY_VolumeTable[i-1])
+	}
+
+	s := NewAYSynth()
+
+	// Tone and noise disabled: the output is the channel volume
+	s.setRegister(AY_MIXER, 0x3f)
+	for volume := byte(0); volume < 16; volume++ {
+		for ch := 0; ch < 3; ch++ {
+			s.setRegister(byte(AY_VOLUME_A+ch), volume)
+			t.Equal(AY_VolumeTable[volume], s.output(ch))
+		}
+	}
+
+	// Bit 4 of the volume selects the envelope
+	s.setRegister(AY_ENVELOPE_SHAPE, 0x0d)
+	s.setRegister(AY_VOLUME_A, 0x10)
+	t.Equal(AY_VolumeTable[0], s.output(0))
+
+	// Tone enabled: the output follows the tone generator
+	s.setRegister(AY_VOLUME_A, 15)
+	s.setRegister(AY_MIXER, 0x3e)
+	t.Equal(0.0, s.output(0))
+	s.toneOutput[0] = true
+	t.Equal(1.0, s.output(0))
+}
+
+// Rendered samples are averages, which may differ from the volume table in the last bits
+func nearlyEqual(x, y float64) bool {
+	return math.Abs(x-y) < 1e-9
+}
+
+func (t *testSuite) TestAYSynth_Render() {
+	data := &AYData{}
+	data.Registers[AY_MIXER] = 0x3f
+	data.Registers[AY_VOLUME_A] = 15
+	data.Registers[AY_VOLUME_B] = 8
+
+	// Channel A is silenced in the middle of the frame
+	const silence = TStatesPerFrame_128k / 2
+	data.Events = []AYEvent{{TState: silence, Register: AY_VOLUME_A, Value: 0}}
+
+	const numSamples = 100
+	a := make([]float64, numSamples)
+	b := make([]float64, numSamples)
+	c := make([]float64, numSamples)
+
+	s := NewAYSynth()
+	s.Render(data, TStatesPerFrame_128k, a, b, c)
+
+	for i := 0; i < numSamples; i++ {
+		t.True(nearlyEqual(AY_VolumeTable[8], b[i]))
+		t.Equal(0.0, c[i])
+
+		switch {
+		case i < numSamples/2-1:
+			t.True(nearlyEqual(1.0, a[i]))
+		case i > numSamples/2:
+			t.Equal(0.0, a[i])
+		}
+	}
+
+	// The synthesizer keeps its state across frames
+	t.Equal(byte(0), s.registers[AY_VOLUME_A])
+	t.True(s.tstate >= 0 && s.tstate < AY_TSTATES_PER_TICK)
+}
@@ -0,0 +1,357 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+import (
+	"fmt"
+	"github.com/remogatto/gospeccy/src/disasm"
+	"github.com/remogatto/gospeccy/src/formats"
+	"sort"
+)
+
+// Reasons why the debugger stopped the emulation
+const (
+	DEBUGGER_STOP_PAUSE = iota
+	DEBUGGER_STOP_BREAKPOINT
+	DEBUGGER_STOP_STEP
+	DEBUGGER_STOP_RUN_TO
+	DEBUGGER_STOP_WATCHPOINT
+)
+
+// Kinds of accesses caught by watchpoints and port breakpoints
+const (
+	WATCH_READ  = 1 << iota // Memory read, or IN instruction
+	WATCH_WRITE             // Memory write, or OUT instruction
+)
+
+// Catches accesses to the memory addresses Start ... End (inclusive)
+type Watchpoint struct {
+	Start, End uint16
+	Access     int // WATCH_READ, WATCH_WRITE, or both
+}
+
+// Catches accesses to ports whose address satisfies (address & Mask) == (Port & Mask)
+type PortBreakpoint struct {
+	Port, Mask uint16
+	Access     int // WATCH_READ, WATCH_WRITE, or both
+}
+
+// The state of the debugger, as reported by Cmd_DebuggerState
+type DebuggerState struct {
+	// Whether the emulation is stopped
+	Paused bool
+
+	// The frame number and the registers of the emulated CPU
+	Frame uint
+	Cpu   formats.CpuState
+
+	// PC breakpoints, in ascending order
+	Breakpoints []uint16
+
+	Watchpoints     []Watchpoint
+	PortBreakpoints []PortBreakpoint
+}
+
+// The debugger stops the emulation at PC breakpoints, after single steps,
+// or after an instruction accessed a watched memory address or port.
+//
+// While the emulation is stopped, 'Cmd_RenderFrame' does nothing. The stop may
+// happen in the middle of a frame, in which case the rest of the frame is
+// executed after the emulation is resumed.
+type Debugger struct {
+	speccy *Spectrum48k
+
+	breakpoints     map[uint16]bool
+	watchpoints     []Watchpoint
+	portBreakpoints []PortBreakpoint
+
+	// Set when a watchpoint or a port breakpoint has been hit by the current instruction.
+	// The emulation stops before the next instruction.
+	watchHit bool
+
+	// A temporary breakpoint used by step-over and run-to-cursor, or -1
+	tempBreakpoint int
+
+	// Stop before executing the next instruction
+	stepping bool
+
+	// The address at which the emulation has been resumed, or -1.
+	// The breakpoint at this address is ignored once, so that the
+	// emulation can continue from a breakpoint.
+	resumeAddress int
+
+	paused bool
+
+	// Set by 'check' when it decides to stop the emulation
+	stopReason int
+	stopInfo   string
+}
+
+func NewDebugger() *Debugger {
+	return &Debugger{
+		breakpoints:    make(map[uint16]bool),
+		tempBreakpoint: -1,
+		resumeAddress:  -1,
+	}
+}
+
+func (debugger *Debugger) init(speccy *Spectrum48k) {
+	debugger.speccy = speccy
+}
+
+// Returns true if the emulation loop needs to call 'check' before each instruction
+func (debugger *Debugger) active() bool {
+	return (len(debugger.breakpoints) > 0) || (debugger.tempBreakpoint >= 0) || debugger.stepping ||
+		(len(debugger.watchpoints) > 0) || (len(debugger.portBreakpoints) > 0)
+}
+
+// Returns true if the emulation should stop before the first instruction of the next frame
+func (debugger *Debugger) stopPending() bool {
+	if debugger.watchHit {
+		debugger.stopReason = DEBUGGER_STOP_WATCHPOINT
+		return true
+	}
+	if debugger.stepping {
+		debugger.stopReason = DEBUGGER_STOP_STEP
+		return true
+	}
+	return false
+}
+
+// Returns true if the emulation should stop before executing the instruction at 'pc'
+func (debugger *Debugger) check(pc uint16) bool {
+	if debugger.watchHit {
+		debugger.stopReason = DEBUGGER_STOP_WATCHPOINT
+		return true
+	}
+
+	if debugger.resumeAddress >= 0 {
+		resumeAddress := debugger.resumeAddress
+		debugger.resumeAddress = -1
+		if int(pc) == resumeAddress {
+			return false
+		}
+	}
+
+	switch {
+	case debugger.stepping:
+		debugger.stopReason = DEBUGGER_STOP_STEP
+	case int(pc) == debugger.tempBreakpoint:
+		debugger.stopReason = DEBUGGER_STOP_RUN_TO
+	case debugger.breakpoints[pc]:
+		debugger.stopReason = DEBUGGER_STOP_BREAKPOINT
+	default:
+		return false
+	}
+
+	return true
+}
+
+// Stops the emulation and reports the reason
+func (debugger *Debugger) stop(reason int) {
+	debugger.paused = true
+	debugger.stepping = false
+	debugger.tempBreakpoint = -1
+	debugger.resumeAddress = -1
+	debugger.watchHit = false
+
+	app := debugger.speccy.app
+	pc := debugger.speccy.Cpu.PC()
+	instr := disasm.Disassemble(debugger.speccy.Memory, pc)
+	switch reason {
+	case DEBUGGER_STOP_PAUSE:
+		app.PrintfMsg("debugger: paused at 0x%04x: %s", pc, instr.Mnemonic)
+	case DEBUGGER_STOP_BREAKPOINT:
+		app.PrintfMsg("debugger: breakpoint at 0x%04x: %s", pc, instr.Mnemonic)
+	case DEBUGGER_STOP_WATCHPOINT:
+		app.PrintfMsg("debugger: %s, stopped at 0x%04x: %s", debugger.stopInfo, pc, instr.Mnemonic)
+	default:
+		app.PrintfMsg("debugger: stopped at 0x%04x: %s", pc, instr.Mnemonic)
+	}
+}
+
+// Resumes the emulation. If 'stepping' is true, the emulation stops again
+// after executing one instruction.
+func (debugger *Debugger) resume(stepping bool) {
+	debugger.paused = false
+	debugger.stepping = stepping
+	debugger.resumeAddress = int(debugger.speccy.Cpu.PC())
+}
+
+func (debugger *Debugger) setBreakpoint(address uint16, enable bool) {
+	if enable {
+		debugger.breakpoints[address] = true
+	} else {
+		delete(debugger.breakpoints, address)
+	}
+}
+
+// Adds a watchpoint, or removes the watchpoint with the same range if 'access' is 0
+func (debugger *Debugger) setWatchpoint(watchpoint Watchpoint) {
+	watchpoints := debugger.watchpoints[:0]
+	for _, w := range debugger.watchpoints {
+		if (w.Start != watchpoint.Start) || (w.End != watchpoint.End) {
+			watchpoints = append(watchpoints, w)
+		}
+	}
+	if watchpoint.Access != 0 {
+		watchpoints = append(watchpoints, watchpoint)
+	}
+
+	debugger.watchpoints = watchpoints
+	debugger.speccy.Memory.watch = (len(watchpoints) > 0)
+}
+
+// Adds a port breakpoint, or removes the port breakpoint with the same port and mask if 'access' is 0
+func (debugger *Debugger) setPortBreakpoint(portBreakpoint PortBreakpoint) {
+	portBreakpoints := debugger.portBreakpoints[:0]
+	for _, p := range debugger.portBreakpoints {
+		if (p.Port != portBreakpoint.Port) || (p.Mask != portBreakpoint.Mask) {
+			portBreakpoints = append(portBreakpoints, p)
+		}
+	}
+	if portBreakpoint.Access != 0 {
+		portBreakpoints = append(portBreakpoints, portBreakpoint)
+	}
+
+	debugger.portBreakpoints = portBreakpoints
+	debugger.speccy.Ports.watch = (len(portBreakpoints) > 0)
+}
+
+// Called by Memory if there are any watchpoints
+func (debugger *Debugger) memoryAccess(address uint16, access int) {
+	for _, w := range debugger.watchpoints {
+		if ((w.Access & access) != 0) && (address >= w.Start) && (address <= w.End) {
+			if !debugger.watchHit {
+				debugger.watchHit = true
+				debugger.stopInfo = fmt.Sprintf("%s 0x%04x", accessName(access, "read from", "write to"), address)
+			}
+			return
+		}
+	}
+}
+
+// Called by Ports if there are any port breakpoints
+func (debugger *Debugger) portAccess(address uint16, access int) {
+	for _, p := range debugger.portBreakpoints {
+		if ((p.Access & access) != 0) && ((address & p.Mask) == (p.Port & p.Mask)) {
+			if !debugger.watchHit {
+				debugger.watchHit = true
+				debugger.stopInfo = fmt.Sprintf("%s port 0x%04x", accessName(access, "input from", "output to"), address)
+			}
+			return
+		}
+	}
+}
+
+func accessName(access int, read, write string) string {
+	if access == WATCH_READ {
+		return read
+	}
+	return write
+}
+
+func (debugger *Debugger) state() DebuggerState {
+	breakpoints := make([]int, 0, len(debugger.breakpoints))
+	for address := range debugger.breakpoints {
+		breakpoints = append(breakpoints, int(address))
+	}
+	sort.Ints(breakpoints)
+
+	state := DebuggerState{
+		Paused:      debugger.paused,
+		Frame:       debugger.speccy.ula.frame,
+		Cpu:         debugger.speccy.cpuState(),
+		Breakpoints: make([]uint16, len(breakpoints)),
+
+		Watchpoints:     append([]Watchpoint(nil), debugger.watchpoints...),
+		PortBreakpoints: append([]PortBreakpoint(nil), debugger.portBreakpoints...),
+	}
+	for i, address := range breakpoints {
+		state.Breakpoints[i] = uint16(address)
+	}
+
+	return state
+}
+
+// Returns the address of the instruction following the instruction at 'pc',
+// if the instruction at 'pc' may return to that address after executing
+// other code (CALL, RST) or may repeat itself (DJNZ, LDIR, ...).
+// Otherwise returns -1.
+func (debugger *Debugger) stepOverAddress(pc uint16) int {
+	memory := debugger.speccy.Memory
+	opcode := memory.ReadByteInternal(pc)
+
+	switch {
+	case (opcode == 0xcd) || ((opcode & 0xc7) == 0xc4):
+		// CALL nn, CALL cc,nn
+		return int(pc + 3)
+
+	case (opcode & 0xc7) == 0xc7:
+		// RST p
+		return int(pc + 1)
+
+	case opcode == 0x10:
+		// DJNZ e
+		return int(pc + 2)
+
+	case opcode == 0x76:
+		// HALT
+		return int(pc + 1)
+
+	case opcode == 0xed:
+		// LDIR, CPIR, INIR, OTIR, LDDR, CPDR, INDR, OTDR
+		if (memory.ReadByteInternal(pc+1) & 0xf4) == 0xb0 {
+			return int(pc + 2)
+		}
+	}
+
+	return -1
+}
+
+// Executes a single instruction
+func (debugger *Debugger) step() {
+	debugger.resume(true)
+	debugger.speccy.runFrame(nil)
+}
+
+// Executes the instruction at PC. If the instruction is a call or a loop,
+// the emulation continues until the instruction following it is reached.
+func (debugger *Debugger) stepOver() {
+	address := debugger.stepOverAddress(debugger.speccy.Cpu.PC())
+	if address < 0 {
+		debugger.step()
+		return
+	}
+
+	debugger.runTo(uint16(address))
+}
+
+// Continues the emulation until PC reaches 'address' (or until a breakpoint is hit)
+func (debugger *Debugger) runTo(address uint16) {
+	debugger.resume(false)
+	debugger.tempBreakpoint = int(address)
+}
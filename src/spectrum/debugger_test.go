@@ -0,0 +1,350 @@
+package spectrum
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Collects the messages printed by the application
+type testMessageOutput struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (out *testMessageOutput) PrintfMsg(format string, a ...interface{}) {
+	out.mutex.Lock()
+	out.messages = append(out.messages, fmt.Sprintf(format, a...))
+	out.mutex.Unlock()
+}
+
+func (out *testMessageOutput) last() string {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	if len(out.messages) == 0 {
+		return ""
+	}
+	return out.messages[len(out.messages)-1]
+}
+
+// A program running with interrupts disabled:
+//
+//	8000 loop: INC A
+//	8001       AND 7
+//	8003       OUT (0xfe),A
+//	8005       CALL sub
+//	8008       JR loop
+//	8010 sub:  LD B,3
+//	8012       DJNZ $
+//	8014       RET
+var testDebuggerProgram = map[uint16][]byte{
+	0x8000: {0x3c, 0xe6, 0x07, 0xd3, 0xfe, 0xcd, 0x10, 0x80, 0x18, 0xf6},
+	0x8010: {0x06, 0x03, 0x10, 0xfe, 0xc9},
+}
+
+// Creates a 48k machine running 'testDebuggerProgram'.
+// The messages printed by the machine are collected by the returned testMessageOutput.
+func newTestDebuggerSpectrum() (*Application, *Spectrum48k, *testMessageOutput, error) {
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{{}})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	out := &testMessageOutput{}
+	app.SetMessageOutput(out)
+
+	s := newTestSnapshot()
+	for address, code := range testDebuggerProgram {
+		copy(s.Mem[address-0x4000:], code)
+	}
+	err = loadTestProgram(speccy, s)
+	if err != nil {
+		exitTestSpectrum(app)
+		return nil, nil, nil, err
+	}
+
+	return app, speccy, out, nil
+}
+
+// Returns the state of the debugger after all previously sent commands have been executed
+func debuggerState(speccy *Spectrum48k) DebuggerState {
+	ch := make(chan DebuggerState)
+	speccy.CommandChannel <- Cmd_DebuggerState{ch}
+	return <-ch
+}
+
+func debuggerStep(speccy *Spectrum48k, over bool) DebuggerState {
+	speccy.CommandChannel <- Cmd_DebuggerStep{Over: over}
+	return debuggerState(speccy)
+}
+
+func (t *testSuite) TestDebugger_Breakpoint() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.CommandChannel <- Cmd_SetBreakpoint{Address: 0x8003, Enable: true}
+
+	// The breakpoint is hit in the middle of the first frame
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state := debuggerState(speccy)
+	t.True(state.Paused)
+	t.Equal(uint16(0x8003), state.Cpu.PC)
+	t.True((len(state.Breakpoints) == 1) && (state.Breakpoints[0] == 0x8003))
+	t.True(strings.HasPrefix(out.last(), "debugger: breakpoint at 0x8003"))
+	t.True(speccy.midFrame)
+
+	frame := state.Frame
+	tstate := state.Cpu.Tstate
+	t.True((tstate > 0) && (tstate < TStatesPerFrame))
+
+	// While paused, no frames are executed
+	t.Equal(uint(0), speccy.RunFrames(1))
+	t.Equal(tstate, debuggerState(speccy).Cpu.Tstate)
+
+	// Continuing from the breakpoint executes one loop and stops again
+	// at the breakpoint, in the same frame
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.True(state.Paused)
+	t.Equal(uint16(0x8003), state.Cpu.PC)
+	t.Equal(frame, state.Frame)
+	t.True(state.Cpu.Tstate > tstate)
+
+	// Without the breakpoint, the rest of the frame and the next frame are executed
+	speccy.CommandChannel <- Cmd_SetBreakpoint{Address: 0x8003, Enable: false}
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(2), speccy.RunFrames(2))
+	state = debuggerState(speccy)
+	t.False(state.Paused)
+	t.Equal(frame+1, state.Frame)
+	t.Equal(0, len(state.Breakpoints))
+}
+
+func (t *testSuite) TestDebugger_PauseResume() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	t.Equal(uint(1), speccy.RunFrames(1))
+
+	speccy.CommandChannel <- Cmd_DebuggerPause{}
+	state := debuggerState(speccy)
+	t.True(state.Paused)
+	t.True(strings.HasPrefix(out.last(), "debugger: paused at"))
+
+	frame := state.Frame
+	t.Equal(uint(0), speccy.RunFrames(5))
+	t.Equal(frame, debuggerState(speccy).Frame)
+
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(5), speccy.RunFrames(5))
+	state = debuggerState(speccy)
+	t.False(state.Paused)
+	t.Equal(frame+5, state.Frame)
+}
+
+func (t *testSuite) TestDebugger_StepAcrossFrameBoundary() {
+	app, speccy, _, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	t.Equal(uint(1), speccy.RunFrames(1))
+	speccy.CommandChannel <- Cmd_DebuggerPause{}
+
+	// The first step begins a new frame
+	state := debuggerStep(speccy, false)
+	t.True(state.Paused)
+	t.True(speccy.midFrame)
+	frame := state.Frame
+
+	// Move close to the end of the frame.
+	// The emulation is paused, so the machine is not accessed by the command loop.
+	speccy.Cpu.Tstates = TStatesPerFrame - 50
+
+	numBorderEvents := len(speccy.Ports.borderEvents)
+	frameEnds := 0
+	for steps := 0; (state.Frame == frame) && (steps < 50); steps++ {
+		state = debuggerStep(speccy, false)
+		t.True(state.Paused)
+
+		if state.Frame == frame {
+			if speccy.midFrame {
+				// The frame is not restarted by each step
+				t.True(len(speccy.Ports.borderEvents) >= numBorderEvents)
+				numBorderEvents = len(speccy.Ports.borderEvents)
+			} else {
+				// The step executed the last instruction of the frame
+				t.True(state.Cpu.Tstate >= TStatesPerFrame)
+				frameEnds++
+			}
+		}
+	}
+
+	// The previous frame ended exactly once, and the new frame began with a single instruction
+	t.Equal(1, frameEnds)
+	t.Equal(frame+1, state.Frame)
+	t.True(state.Cpu.Tstate < 20)
+	t.True(len(speccy.Ports.borderEvents) <= 2)
+	t.True(speccy.midFrame)
+}
+
+func (t *testSuite) TestDebugger_StepOver() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.CommandChannel <- Cmd_SetBreakpoint{Address: 0x8005, Enable: true}
+	t.Equal(uint(0), speccy.RunFrames(1))
+	speccy.CommandChannel <- Cmd_SetBreakpoint{Address: 0x8005, Enable: false}
+	state := debuggerState(speccy)
+	t.Equal(uint16(0x8005), state.Cpu.PC)
+
+	// The subroutine is executed as a whole, when the emulation continues
+	state = debuggerStep(speccy, true)
+	t.False(state.Paused)
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.True(state.Paused)
+	t.Equal(uint16(0x8008), state.Cpu.PC)
+	t.Equal(byte(0), state.Cpu.B)
+	t.Equal(uint16(0xff00), state.Cpu.SP)
+	t.True(strings.HasPrefix(out.last(), "debugger: stopped at 0x8008"))
+
+	// Stepping over an instruction which is not a call executes one instruction
+	state = debuggerStep(speccy, true)
+	t.Equal(uint16(0x8000), state.Cpu.PC)
+
+	// Single-stepping enters the subroutine
+	for _, pc := range []uint16{0x8001, 0x8003, 0x8005, 0x8010, 0x8012} {
+		state = debuggerStep(speccy, false)
+		t.Equal(pc, state.Cpu.PC)
+	}
+	t.Equal(uint16(0xfefe), state.Cpu.SP)
+
+	// Stepping over DJNZ finishes the loop
+	debuggerStep(speccy, true)
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.Equal(uint16(0x8014), state.Cpu.PC)
+	t.Equal(byte(0), state.Cpu.B)
+}
+
+func (t *testSuite) TestDebugger_RunTo() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.CommandChannel <- Cmd_DebuggerPause{}
+	t.True(debuggerState(speccy).Paused)
+
+	speccy.CommandChannel <- Cmd_DebuggerRunTo{Address: 0x8012}
+	t.False(debuggerState(speccy).Paused)
+
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state := debuggerState(speccy)
+	t.True(state.Paused)
+	t.Equal(uint16(0x8012), state.Cpu.PC)
+	t.Equal(byte(3), state.Cpu.B)
+	t.True(strings.HasPrefix(out.last(), "debugger: stopped at 0x8012"))
+
+	// The temporary breakpoint is removed when it is reached
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(1), speccy.RunFrames(1))
+	t.False(debuggerState(speccy).Paused)
+}
+
+func (t *testSuite) TestDebugger_Watchpoint() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// The stack is written by CALL and read by RET
+	speccy.CommandChannel <- Cmd_SetWatchpoint{Watchpoint{Start: 0xfefe, End: 0xfeff, Access: WATCH_WRITE}}
+
+	// The emulation stops after the instruction which wrote to the watched range
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state := debuggerState(speccy)
+	t.True(state.Paused)
+	t.Equal(uint16(0x8010), state.Cpu.PC)
+	t.Equal(uint16(0xfefe), state.Cpu.SP)
+	t.Equal(1, len(state.Watchpoints))
+	t.Equal("debugger: write to 0xfeff, stopped at 0x8010: LD B,$03", out.last())
+
+	// Reads do not trigger a write watchpoint
+	speccy.CommandChannel <- Cmd_SetWatchpoint{Watchpoint{Start: 0xfefe, End: 0xfeff, Access: WATCH_READ}}
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.Equal(uint16(0x8008), state.Cpu.PC)
+	t.Equal(uint16(0xff00), state.Cpu.SP)
+	t.Equal(1, len(state.Watchpoints))
+	t.True(strings.HasPrefix(out.last(), "debugger: read from 0xfefe, stopped at 0x8008"))
+
+	// A watchpoint with no access removes the watchpoint
+	speccy.CommandChannel <- Cmd_SetWatchpoint{Watchpoint{Start: 0xfefe, End: 0xfeff}}
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(1), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.False(state.Paused)
+	t.Equal(0, len(state.Watchpoints))
+	t.False(speccy.Memory.watch)
+}
+
+func (t *testSuite) TestDebugger_PortBreakpoint() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// Any even port address is handled by the ULA
+	speccy.CommandChannel <- Cmd_SetPortBreakpoint{PortBreakpoint{Port: 0xfe, Mask: 0x0001, Access: WATCH_WRITE}}
+
+	// The emulation stops after the first OUT to the ULA
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state := debuggerState(speccy)
+	t.True(state.Paused)
+	t.Equal(uint16(0x8005), state.Cpu.PC)
+	t.Equal(byte(1), state.Cpu.A)
+	t.Equal(1, len(state.PortBreakpoints))
+	t.True(strings.HasPrefix(out.last(), "debugger: output to port 0x01fe, stopped at 0x8005"))
+	t.Equal(byte(1), speccy.ula.getBorderColor())
+
+	// The next OUT stops the emulation again
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(0), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.Equal(uint16(0x8005), state.Cpu.PC)
+	t.Equal(byte(2), state.Cpu.A)
+
+	// An input breakpoint is not triggered by OUT
+	speccy.CommandChannel <- Cmd_SetPortBreakpoint{PortBreakpoint{Port: 0xfe, Mask: 0x0001}}
+	speccy.CommandChannel <- Cmd_SetPortBreakpoint{PortBreakpoint{Port: 0xfe, Mask: 0x0001, Access: WATCH_READ}}
+	speccy.CommandChannel <- Cmd_DebuggerContinue{}
+	t.Equal(uint(1), speccy.RunFrames(1))
+	state = debuggerState(speccy)
+	t.False(state.Paused)
+	t.Equal(1, len(state.PortBreakpoints))
+	t.Equal(WATCH_READ, state.PortBreakpoints[0].Access)
+}
@@ -0,0 +1,257 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+import "errors"
+
+// A device connected to the I/O bus of the emulated machine.
+//
+// The device handles all port accesses for which (address & mask) == value,
+// where 'mask' and 'value' are the values returned by method Decode.
+// If several devices handle a port read, their results are combined
+// using bitwise AND. Reading a port which no device handles returns
+// the value on the floating bus on machines which have one, 0xff otherwise.
+//
+// All methods are called from the goroutine running the emulation.
+type Device interface {
+	// Returns the address-decode mask and value.
+	// This method is called once, when the device is connected to the bus.
+	Decode() (mask, value uint16)
+
+	// Returns the value read by an IN instruction
+	Read(address uint16) byte
+
+	// Handles an OUT instruction
+	Write(address uint16, b byte)
+
+	// Called when the emulated machine is reset
+	Reset()
+}
+
+// Optionally implemented by a device which does not drive the data bus
+// when some of the ports it handles are read, such as a write-only port.
+// Such a read does not call method Read, and the device does not affect the read value.
+type BusDriver interface {
+	// Returns true if the device drives the data bus when the port is read
+	DrivesBus(address uint16) bool
+}
+
+type deviceInfo struct {
+	device Device
+	mask   uint16
+	value  uint16
+
+	// nil if the device drives the data bus when any of its ports is read
+	busDriver BusDriver
+}
+
+func (p *Ports) addDevice(device Device) error {
+	for _, d := range p.devices {
+		if d.device == device {
+			return errors.New("the device is already connected")
+		}
+	}
+
+	mask, value := device.Decode()
+	busDriver, _ := device.(BusDriver)
+	p.devices = append(p.devices, deviceInfo{device: device, mask: mask, value: value, busDriver: busDriver})
+	return nil
+}
+
+func (p *Ports) removeDevice(device Device) error {
+	for i, d := range p.devices {
+		if d.device == device {
+			p.devices = append(p.devices[:i], p.devices[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New("the device is not connected")
+}
+
+// ===============
+// Builtin devices
+// ===============
+
+// The ULA: keyboard and tape input, border color, EAR and MIC output
+type ulaDevice struct {
+	ports *Ports
+}
+
+func (d *ulaDevice) Decode() (mask, value uint16) {
+	return 0x0001, 0x0000
+}
+
+func (d *ulaDevice) Read(address uint16) byte {
+	p := d.ports
+	var result byte = 0xff
+
+	// Read keyboard
+	keyStates := p.speccy.rewind.keyStates()
+	var row uint
+	for row = 0; row < 8; row++ {
+		if (address & (1 << (uint16(row) + 8))) == 0 { // bit held low, so scan this row
+			result &= keyStates[row]
+		}
+	}
+
+	// Read tape
+	if p.speccy.readFromTape && (address == 0x7ffe) {
+		p.tapeReadCount++
+		earBit := p.speccy.tapeDrive.getEarBit()
+		result &= earBit
+	} else {
+		result &= p.earInput()
+	}
+
+	return result
+}
+
+func (d *ulaDevice) Write(address uint16, b byte) {
+	p := d.ports
+	color := (b & 0x07)
+
+	// Modify the border only if it really changed
+	if p.speccy.ula.getBorderColor() != color {
+		p.speccy.ula.setBorderColor(color)
+
+		last := len(p.borderEvents) - 1
+		if p.borderEvents[last].TState == p.speccy.Cpu.Tstates {
+			p.borderEvents[last].Color = color
+		} else {
+			p.borderEvents = append(p.borderEvents, BorderEvent{p.speccy.Cpu.Tstates, color})
+		}
+	}
+
+	// EAR(bit 4) and MIC(bit 3) output
+	p.earMic = (b & 0x18) >> 3
+	newBeeperLevel := p.earMic
+	if p.speccy.readFromTape && !p.speccy.tapeDrive.AcceleratedLoad {
+		if p.speccy.tapeDrive.earBit == 0xff {
+			newBeeperLevel |= 2
+		} else {
+			newBeeperLevel &^= 2
+		}
+	}
+	if p.beeperLevel != newBeeperLevel {
+		p.beeperLevel = newBeeperLevel
+
+		last := len(p.beeperEvents) - 1
+		if p.beeperEvents[last].TState == p.speccy.Cpu.Tstates {
+			p.beeperEvents[last].Level = newBeeperLevel
+		} else {
+			p.beeperEvents = append(p.beeperEvents, BeeperEvent{p.speccy.Cpu.Tstates, newBeeperLevel})
+		}
+	}
+}
+
+func (d *ulaDevice) Reset() {
+	p := d.ports
+
+	p.borderEvents = p.borderEvents[0:0]
+	p.borderEvents = append(p.borderEvents, BorderEvent{TState: 0, Color: p.speccy.ula.getBorderColor()})
+
+	p.earMic = 0
+	p.beeperLevel = 0
+	p.beeperEvents = p.beeperEvents[0:0]
+	p.beeperEvents = append(p.beeperEvents, BeeperEvent{TState: 0, Level: p.beeperLevel})
+}
+
+// The Kempston joystick interface (port 0x1f).
+// Bit 0 of the address is decoded as well, so that the interface does not conflict with the ULA.
+type kempstonDevice struct {
+	speccy *Spectrum48k
+}
+
+func (d *kempstonDevice) Decode() (mask, value uint16) {
+	return 0x00e1, 0x0001
+}
+
+func (d *kempstonDevice) Read(address uint16) byte {
+	return d.speccy.rewind.joystickState()
+}
+
+func (d *kempstonDevice) Write(address uint16, b byte) {}
+
+func (d *kempstonDevice) Reset() {}
+
+// Memory paging of the 128k machine (port 0x7ffd)
+type pagingDevice struct {
+	memory *Memory
+}
+
+func (d *pagingDevice) Decode() (mask, value uint16) {
+	return 0x8002, 0x0000
+}
+
+func (d *pagingDevice) Read(address uint16) byte {
+	return 0xff
+}
+
+// Port 0x7ffd is write-only
+func (d *pagingDevice) DrivesBus(address uint16) bool {
+	return false
+}
+
+func (d *pagingDevice) Write(address uint16, b byte) {
+	d.memory.writePort7ffd(b)
+}
+
+// The paging state is reset by Memory.reset
+func (d *pagingDevice) Reset() {}
+
+// The AY-3-8912 sound chip: register select (0xfffd), register read (0xfffd) and register write (0xbffd)
+type ayDevice struct {
+	ay *AY
+}
+
+func (d *ayDevice) Decode() (mask, value uint16) {
+	return 0x8002, 0x8000
+}
+
+func (d *ayDevice) Read(address uint16) byte {
+	if (address & 0x4000) == 0 {
+		return 0xff
+	}
+	return d.ay.read()
+}
+
+// Port 0xbffd is write-only
+func (d *ayDevice) DrivesBus(address uint16) bool {
+	return (address & 0x4000) != 0
+}
+
+func (d *ayDevice) Write(address uint16, b byte) {
+	if (address & 0x4000) != 0 {
+		d.ay.selectRegister(b)
+	} else {
+		d.ay.write(b)
+	}
+}
+
+func (d *ayDevice) Reset() {
+	d.ay.reset()
+}
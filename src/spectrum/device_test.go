@@ -0,0 +1,72 @@
+package spectrum
+
+type testDevice struct {
+	mask, value uint16
+	data        byte
+	writes      []byte
+}
+
+func (d *testDevice) Decode() (mask, value uint16) {
+	return d.mask, d.value
+}
+
+func (d *testDevice) Read(address uint16) byte {
+	return d.data
+}
+
+func (d *testDevice) Write(address uint16, b byte) {
+	d.writes = append(d.writes, b)
+}
+
+func (d *testDevice) Reset() {
+	d.writes = nil
+}
+
+func addTestDevice(speccy *Spectrum48k, device Device) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_AddDevice{Device: device, ErrChan: errChan}
+	return <-errChan
+}
+
+func removeTestDevice(speccy *Spectrum48k, device Device) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_RemoveDevice{Device: device, ErrChan: errChan}
+	return <-errChan
+}
+
+func (t *testSuite) TestDevice_AddRemove() {
+	var rom [0x4000]byte
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{rom})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// Ports 0x00df and 0x00d5 are not decoded by the ULA or by the Kempston interface
+	a := &testDevice{mask: 0x00ff, value: 0x00df, data: 0xf0}
+	b := &testDevice{mask: 0x00f0, value: 0x00d0, data: 0x3c}
+
+	t.Nil(addTestDevice(speccy, a))
+	t.Nil(addTestDevice(speccy, b))
+	t.Not(t.Nil(addTestDevice(speccy, a)))
+
+	// Outside of the screen area, so an unhandled read returns 0xff
+	speccy.Cpu.Tstates = 0
+
+	// Overlapping devices are combined using bitwise AND
+	t.Equal(byte(0x30), speccy.Ports.ReadPortInternal(0x00df, false))
+	t.Equal(byte(0x3c), speccy.Ports.ReadPortInternal(0x00d5, false))
+
+	speccy.Ports.WritePortInternal(0x00df, 0x01, false)
+	speccy.Ports.WritePortInternal(0x00d5, 0x02, false)
+	t.Equal(1, len(a.writes))
+	t.Equal(2, len(b.writes))
+
+	t.Nil(removeTestDevice(speccy, a))
+	t.Not(t.Nil(removeTestDevice(speccy, a)))
+	t.Equal(byte(0x3c), speccy.Ports.ReadPortInternal(0x00df, false))
+
+	t.Nil(removeTestDevice(speccy, b))
+	t.Equal(byte(0xff), speccy.Ports.ReadPortInternal(0x00df, false))
+}
@@ -26,6 +26,8 @@ WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 package spectrum
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -73,12 +75,80 @@ const (
 	BORDER_TOP    = ScreenBorderY
 	BORDER_BOTTOM = ScreenBorderY
 
-	// The T-state which corresponds to pixel (0,0) on the host-machine display.
-	// That pixel belongs to the border.
+	// The T-state which corresponds to pixel (0,0) on the host-machine display
+	// when the default border is used. That pixel belongs to the border.
 	DISPLAY_START            = (FIRST_SCREEN_BYTE - TSTATES_PER_LINE*BORDER_TOP - ScreenBorderX/PIXELS_PER_TSTATE + BORDER_TSTATE_ADJUSTMENT)
 	BORDER_TSTATE_ADJUSTMENT = 2
 )
 
+// The largest visible border: the whole raster of the 48k machine
+const (
+	MaxBorderLeft   = LINE_LEFT_BORDER * PIXELS_PER_TSTATE  // 48 pixels
+	MaxBorderRight  = LINE_RIGHT_BORDER * PIXELS_PER_TSTATE // 48 pixels
+	MaxBorderTop    = LINES_TOP                             // 64 lines
+	MaxBorderBottom = LINES_BOTTOM                          // 56 lines
+
+	// Screen dimensions, including the largest border
+	MaxTotalScreenWidth  = ScreenWidth + MaxBorderLeft + MaxBorderRight
+	MaxTotalScreenHeight = ScreenHeight + MaxBorderTop + MaxBorderBottom
+)
+
+// The visible part of the border surrounding the 256x192 screen area.
+// Left and Right are in pixels, Top and Bottom are in lines.
+type Border struct {
+	Left, Right, Top, Bottom int
+}
+
+var (
+	// The default border
+	BorderNormal = Border{ScreenBorderX, ScreenBorderX, ScreenBorderY, ScreenBorderY}
+
+	// The whole raster of the 48k machine, including overscan
+	BorderFull = Border{MaxBorderLeft, MaxBorderRight, MaxBorderTop, MaxBorderBottom}
+)
+
+// Converts a preset name ("normal", "full") to a Border
+func ParseBorder(name string) (Border, error) {
+	switch strings.ToLower(name) {
+	case "normal":
+		return BorderNormal, nil
+	case "full":
+		return BorderFull, nil
+	}
+	return BorderNormal, fmt.Errorf("unknown border preset \"%s\", expected \"normal\" or \"full\"", name)
+}
+
+// Returns the name of the preset, or the sizes if the border is not a preset
+func (b Border) String() string {
+	switch b {
+	case BorderNormal:
+		return "normal"
+	case BorderFull:
+		return "full"
+	}
+	return fmt.Sprintf("%d/%d/%d/%d", b.Left, b.Right, b.Top, b.Bottom)
+}
+
+// Screen width, including the border
+func (b Border) TotalWidth() int {
+	return b.Left + ScreenWidth + b.Right
+}
+
+// Screen height, including the border
+func (b Border) TotalHeight() int {
+	return b.Top + ScreenHeight + b.Bottom
+}
+
+func (b Border) check() error {
+	if (b.Left < 0) || (b.Left > MaxBorderLeft) || (b.Left%8 != 0) ||
+		(b.Right < 0) || (b.Right > MaxBorderRight) || (b.Right%8 != 0) ||
+		(b.Top < 0) || (b.Top > MaxBorderTop) ||
+		(b.Bottom < 0) || (b.Bottom > MaxBorderBottom) {
+		return fmt.Errorf("invalid border %s", b)
+	}
+	return nil
+}
+
 type RGBA struct {
 	R, G, B, A byte
 }
@@ -129,6 +199,9 @@ func xy_to_screenAddr(x, y uint8) uint16 {
 // The lower 4 bits define the paper, the higher 4 bits define the ink.
 // Note that the paper is in the *lower* half.
 // There is no flash bit.
+//
+// If the ULAplus palette is enabled, the ink (0..7) and the paper (8..15)
+// are entries of a palette group.
 type Attr_4bit byte
 
 // This is the primary structure for sending display changes
@@ -139,14 +212,40 @@ type Attr_4bit byte
 type DisplayData struct {
 	Bitmap [BytesPerLine * ScreenHeight]byte          // Linear y-coordinate
 	Attr   [BytesPerLine * ScreenHeight]Attr_4bit     // Linear y-coordinate
+	Group  [BytesPerLine * ScreenHeight]byte          // Linear y-coordinate, the ULAplus palette group (0..3) of 'Attr'
 	Dirty  [ScreenWidth_Attr * ScreenHeight_Attr]bool // The 8x8 rectangular region was modified, either the bitmap or the attr
 
+	// In SCREEN_HIRES mode, 'Bitmap' contains the left half and 'Bitmap2' the right half
+	// of each 16-pixel column of the 512x192 bitmap. Linear y-coordinate.
+	Bitmap2 [BytesPerLine * ScreenHeight]byte
+	Mode    ScreenMode
+
 	BorderEvents []BorderEvent
 
+	// The visible part of the border
+	Border Border
+
+	// The colors of the 64 ULAplus palette entries, or nil if the ULAplus palette is disabled.
+	// If not nil, the colors of 'Attr' are entries of the palette group 'Group',
+	// and the color of the border is entry 8+color of the palette.
+	ULAplusPalette_orNil *[64]uint32
+
+	// Timings of the machine which produced the display data.
+	// The T-states of 'BorderEvents' are relative to these timings.
+	Timings MachineTimings
+
 	// From structure Cmd_RenderFrame
 	CompletionTime_orNil chan<- time.Time
 }
 
+// The layout of the bitmap in DisplayData
+type ScreenMode byte
+
+const (
+	SCREEN_STANDARD ScreenMode = iota // 256x192 pixels
+	SCREEN_HIRES                      // 512x192 pixels (Timex hi-res mode)
+)
+
 // Interface to a rendering backend awaiting display changes
 type DisplayReceiver interface {
 	GetDisplayDataChannel() chan<- *DisplayData
@@ -155,22 +254,11 @@ type DisplayReceiver interface {
 	Close()
 }
 
-// Let 'addr' be in range 0x4000 ... 0x5800-1.
-// Then 'screenline_start_tstates[(addr-0x4000)/BytesPerLine]' is the T-state when the Spectrum
-// starts painting the screenline containing 'addr'.
-var screenline_start_tstates [ScreenHeight]int
-
-func init() {
-	for y := uint8(0); y < ScreenHeight; y++ {
-		addr := xy_to_screenAddr(0, y)
-		screenline_start_tstates[(addr-SCREEN_BASE_ADDR)/BytesPerLine] = FIRST_SCREEN_BYTE + int(y)*TSTATES_PER_LINE
-	}
-}
-
 func init() {
 	// Some sanity checks
-	Assert(ScreenBorderX <= LINE_RIGHT_BORDER*PIXELS_PER_TSTATE)
-	Assert(ScreenBorderY <= LINE_RIGHT_BORDER*PIXELS_PER_TSTATE)
-	Assert(ScreenBorderY <= LINES_TOP)
-	Assert(ScreenBorderY <= LINES_BOTTOM)
+	Assert(BorderNormal.check() == nil)
+	Assert(BorderFull.check() == nil)
+	Assert(ScreenBorderY <= LINES_TOP_128K)
+	Assert(ScreenBorderY <= LINES_TOP_TC2048)
+	Assert(FIRST_SCREEN_BYTE_TC2048+TSTATES_PER_LINE*(ScreenHeight+LINES_BOTTOM_TC2048) == TStatesPerFrame_TC2048)
 }
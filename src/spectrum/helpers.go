@@ -53,31 +53,61 @@ func init() {
 	srcDir = path.Join(gopath0, "src", "github.com", "remogatto", "gospeccy")
 }
 
-var customSearchPaths []string
-var downloadPath string
-var mutex sync.RWMutex
+// The directories in which GoSpeccy searches for programs, ROMs, scripts and fonts.
+// Each Application has its own set of search paths.
+type SearchPaths struct {
+	customSearchPaths []string
+	downloadPath      string
 
-func AddCustomSearchPath(path string) {
-	mutex.Lock()
-	customSearchPaths = append(customSearchPaths, path)
-	mutex.Unlock()
+	// The user directory, or empty string if DefaultUserDir should be used
+	userDir string
+
+	mutex sync.RWMutex
 }
 
-func DownloadPath() string {
-	mutex.RLock()
-	p := downloadPath
-	mutex.RUnlock()
+func NewSearchPaths() *SearchPaths {
+	return &SearchPaths{}
+}
+
+func (p *SearchPaths) AddCustomSearchPath(path string) {
+	p.mutex.Lock()
+	p.customSearchPaths = append(p.customSearchPaths, path)
+	p.mutex.Unlock()
+}
+
+// Returns the user directory, $HOME/.config/gospeccy/ by default
+func (p *SearchPaths) UserDir() string {
+	p.mutex.RLock()
+	dir := p.userDir
+	p.mutex.RUnlock()
 
-	if p == "" {
-		p = path.Join(DefaultUserDir, "snapshots")
+	if dir == "" {
+		dir = DefaultUserDir
 	}
-	return p
+	return dir
 }
 
-func SetDownloadPath(path string) {
-	mutex.Lock()
-	downloadPath = path
-	mutex.Unlock()
+func (p *SearchPaths) SetUserDir(dir string) {
+	p.mutex.Lock()
+	p.userDir = dir
+	p.mutex.Unlock()
+}
+
+func (p *SearchPaths) DownloadPath() string {
+	p.mutex.RLock()
+	dir := p.downloadPath
+	p.mutex.RUnlock()
+
+	if dir == "" {
+		dir = path.Join(p.UserDir(), "snapshots")
+	}
+	return dir
+}
+
+func (p *SearchPaths) SetDownloadPath(path string) {
+	p.mutex.Lock()
+	p.downloadPath = path
+	p.mutex.Unlock()
 }
 
 func searchForValidPath(paths []string, fileName string) (string, error) {
@@ -98,10 +128,16 @@ func searchForValidPath(paths []string, fileName string) (string, error) {
 	return fileName, nil
 }
 
-func appendCustomSearchPaths(paths *[]string) {
-	mutex.RLock()
-	*paths = append(*paths, customSearchPaths...)
-	mutex.RUnlock()
+// Returns ./<subDir>/, <user dir>/<subDir>/, $GOPATH/src/github.com/remogatto/gospeccy/<subDir>/
+// and the custom search paths
+func (p *SearchPaths) paths(subDir string) []string {
+	paths := []string{subDir, path.Join(p.UserDir(), subDir), path.Join(srcDir, subDir)}
+
+	p.mutex.RLock()
+	paths = append(paths, p.customSearchPaths...)
+	p.mutex.RUnlock()
+
+	return paths
 }
 
 // Return a valid path for the file based on its extension,
@@ -111,21 +147,12 @@ func appendCustomSearchPaths(paths *[]string) {
 //
 // The search is performed in this order:
 // 1. ./programs/
-// 2. $GOPATH/src/github.com/remogatto/gospeccy/programs/
-// 3. Custom search paths
-// 4. Download path
-func ProgramPath(fileName string) (string, error) {
-	var (
-		currDir = "programs"
-		userDir = path.Join(DefaultUserDir, "programs")
-		srcDir  = path.Join(srcDir, "programs")
-	)
-
-	var paths []string
-	paths = append(paths, currDir, userDir, srcDir)
-	appendCustomSearchPaths(&paths)
-	paths = append(paths, DownloadPath())
-
+// 2. $HOME/.config/gospeccy/programs/
+// 3. $GOPATH/src/github.com/remogatto/gospeccy/programs/
+// 4. Custom search paths
+// 5. Download path
+func (p *SearchPaths) ProgramPath(fileName string) (string, error) {
+	paths := append(p.paths("programs"), p.DownloadPath())
 	return searchForValidPath(paths, fileName)
 }
 
@@ -139,18 +166,8 @@ func ProgramPath(fileName string) (string, error) {
 // 2. $HOME/.config/gospeccy/roms/
 // 3. $GOPATH/src/github.com/remogatto/gospeccy/roms/
 // 4. Custom search paths
-func SystemRomPath(fileName string) (string, error) {
-	var (
-		currDir = "roms"
-		userDir = path.Join(DefaultUserDir, "roms")
-		srcDir  = path.Join(srcDir, "roms")
-	)
-
-	var paths []string
-	paths = append(paths, currDir, userDir, srcDir)
-	appendCustomSearchPaths(&paths)
-
-	return searchForValidPath(paths, fileName)
+func (p *SearchPaths) SystemRomPath(fileName string) (string, error) {
+	return searchForValidPath(p.paths("roms"), fileName)
 }
 
 // Return a valid path for the specified script,
@@ -163,18 +180,8 @@ func SystemRomPath(fileName string) (string, error) {
 // 2. $HOME/.config/gospeccy/scripts/
 // 3. $GOPATH/src/github.com/remogatto/gospeccy/scripts/
 // 4. Custom search paths
-func ScriptPath(fileName string) (string, error) {
-	var (
-		currDir = "scripts"
-		userDir = path.Join(DefaultUserDir, "scripts")
-		srcDir  = path.Join(srcDir, "scripts")
-	)
-
-	var paths []string
-	paths = append(paths, currDir, userDir, srcDir)
-	appendCustomSearchPaths(&paths)
-
-	return searchForValidPath(paths, fileName)
+func (p *SearchPaths) ScriptPath(fileName string) (string, error) {
+	return searchForValidPath(p.paths("scripts"), fileName)
 }
 
 // Return a valid path for the specified font file,
@@ -187,18 +194,56 @@ func ScriptPath(fileName string) (string, error) {
 // 2. $HOME/.config/gospeccy/fonts/
 // 3. $GOPATH/src/github.com/remogatto/gospeccy/fonts/
 // 4. Custom search paths
-func FontPath(fileName string) (string, error) {
-	var (
-		currDir = "fonts"
-		userDir = path.Join(DefaultUserDir, "fonts")
-		srcDir  = path.Join(srcDir, "fonts")
-	)
+func (p *SearchPaths) FontPath(fileName string) (string, error) {
+	return searchForValidPath(p.paths("fonts"), fileName)
+}
 
-	var paths []string
-	paths = append(paths, currDir, userDir, srcDir)
-	appendCustomSearchPaths(&paths)
+// ===========================
+// The default search paths
+// ===========================
 
-	return searchForValidPath(paths, fileName)
+// The search paths used by the package-level functions
+var defaultSearchPaths = NewSearchPaths()
+
+// Returns the search paths used by the package-level functions.
+// Each Application has its own search paths, which are not affected by these functions.
+func DefaultSearchPaths() *SearchPaths {
+	return defaultSearchPaths
+}
+
+// Same as DefaultSearchPaths().AddCustomSearchPath(path)
+func AddCustomSearchPath(path string) {
+	defaultSearchPaths.AddCustomSearchPath(path)
+}
+
+// Same as DefaultSearchPaths().DownloadPath()
+func DownloadPath() string {
+	return defaultSearchPaths.DownloadPath()
+}
+
+// Same as DefaultSearchPaths().SetDownloadPath(path)
+func SetDownloadPath(path string) {
+	defaultSearchPaths.SetDownloadPath(path)
+}
+
+// Same as DefaultSearchPaths().ProgramPath(fileName)
+func ProgramPath(fileName string) (string, error) {
+	return defaultSearchPaths.ProgramPath(fileName)
+}
+
+// Same as DefaultSearchPaths().SystemRomPath(fileName)
+func SystemRomPath(fileName string) (string, error) {
+	return defaultSearchPaths.SystemRomPath(fileName)
+}
+
+// Same as DefaultSearchPaths().ScriptPath(fileName)
+func ScriptPath(fileName string) (string, error) {
+	return defaultSearchPaths.ScriptPath(fileName)
+}
+
+// Same as DefaultSearchPaths().FontPath(fileName)
+func FontPath(fileName string) (string, error) {
+	return defaultSearchPaths.FontPath(fileName)
 }
 
 // Reads the 16KB ROM from the specified file
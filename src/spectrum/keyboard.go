@@ -27,7 +27,6 @@ package spectrum
 
 import (
 	"sync"
-	"time"
 )
 
 type rowState struct {
@@ -41,6 +40,32 @@ type Cmd_KeyPress struct {
 
 type Cmd_SendLoad struct {
 	romType RomType
+
+	// If true, the "Tape Loader" option of the 128k main menu is selected
+	// instead of typing LOAD ""
+	tapeLoaderMenu bool
+}
+
+// The number of frames a key is held down when typing
+const keyDownFrames = 1
+
+// The number of frames after releasing a key before typing the next key
+const keyUpFrames = 10
+
+// Kinds of scheduled keyboard actions
+const (
+	keyAction_down = iota
+	keyAction_up
+	keyAction_wait
+	keyAction_done
+)
+
+// A keyboard action performed at the beginning of an emulated frame
+type keyAction struct {
+	kind           int
+	logicalKeyCode uint      // keyAction_down, keyAction_up
+	frames         uint      // keyAction_wait
+	done           chan bool // keyAction_done
 }
 
 type Keyboard struct {
@@ -48,6 +73,10 @@ type Keyboard struct {
 	keyStates [8]byte
 	mutex     sync.RWMutex
 
+	// Actions waiting to be performed, protected by 'mutex'.
+	// Typing is measured in emulated frames, so it does not depend on the speed of the host.
+	script []keyAction
+
 	CommandChannel chan interface{}
 }
 
@@ -65,14 +94,66 @@ func (keyboard *Keyboard) init(speccy *Spectrum48k) {
 	go keyboard.commandLoop()
 }
 
-func (keyboard *Keyboard) delayAfterKeyDown() {
-	// Sleep for 1 frame
-	time.Sleep(1e9 / time.Duration(keyboard.speccy.GetCurrentFPS()))
+func keyDown(logicalKeyCode uint) keyAction {
+	return keyAction{kind: keyAction_down, logicalKeyCode: logicalKeyCode}
 }
 
-func (keyboard *Keyboard) delayAfterKeyUp() {
-	// Sleep for 10 frames
-	time.Sleep(10 * 1e9 / time.Duration(keyboard.speccy.GetCurrentFPS()))
+func keyUp(logicalKeyCode uint) keyAction {
+	return keyAction{kind: keyAction_up, logicalKeyCode: logicalKeyCode}
+}
+
+func waitFrames(frames uint) keyAction {
+	return keyAction{kind: keyAction_wait, frames: frames}
+}
+
+// Returns the actions which press and release the key
+func keyPress(logicalKeyCode uint) []keyAction {
+	return []keyAction{
+		keyDown(logicalKeyCode), waitFrames(keyDownFrames),
+		keyUp(logicalKeyCode), waitFrames(keyUpFrames),
+	}
+}
+
+// Appends actions to the script
+func (keyboard *Keyboard) schedule(actions ...keyAction) {
+	keyboard.mutex.Lock()
+	keyboard.script = append(keyboard.script, actions...)
+	keyboard.mutex.Unlock()
+}
+
+// This function is called at the beginning of each frame.
+// Performs the scheduled actions until the next wait.
+func (keyboard *Keyboard) frame_begin() {
+	keyboard.mutex.Lock()
+	defer keyboard.mutex.Unlock()
+
+	for len(keyboard.script) > 0 {
+		action := &keyboard.script[0]
+
+		switch action.kind {
+		case keyAction_down:
+			if keyCode, ok := keyCodes[action.logicalKeyCode]; ok {
+				keyboard.keyStates[keyCode.row] &= ^(keyCode.mask)
+			}
+
+		case keyAction_up:
+			if keyCode, ok := keyCodes[action.logicalKeyCode]; ok {
+				keyboard.keyStates[keyCode.row] |= (keyCode.mask)
+			}
+
+		case keyAction_wait:
+			if action.frames > 0 {
+				action.frames--
+				return
+			}
+
+		case keyAction_done:
+			// Note: The channel is buffered, so the send won't block
+			action.done <- true
+		}
+
+		keyboard.script = keyboard.script[1:]
+	}
 }
 
 func (keyboard *Keyboard) commandLoop() {
@@ -94,75 +175,51 @@ func (keyboard *Keyboard) commandLoop() {
 		case untyped_cmd := <-keyboard.CommandChannel:
 			switch cmd := untyped_cmd.(type) {
 			case Cmd_KeyPress:
-				keyboard.KeyDown(cmd.logicalKeyCode)
-				keyboard.delayAfterKeyDown()
-				keyboard.KeyUp(cmd.logicalKeyCode)
-				keyboard.delayAfterKeyUp()
-				cmd.done <- true
+				keyboard.scheduleKeyPress(cmd.logicalKeyCode, cmd.done)
 
 			case Cmd_SendLoad:
-				if cmd.romType == ROM_OPENSE {
-					// Sleep for 30 frames
-					time.Sleep(30e9 / time.Duration(keyboard.speccy.GetCurrentFPS()))
-
-					// l o a d
-					for _, keycode := range []uint{KEY_L, KEY_O, KEY_A, KEY_D} {
-						keyboard.KeyDown(keycode)
-						keyboard.delayAfterKeyDown()
-						keyboard.KeyUp(keycode)
-						keyboard.delayAfterKeyUp()
-					}
-
-					// " "
-					keyboard.KeyDown(KEY_SymbolShift)
-					{
-						keyboard.KeyDown(KEY_P)
-						keyboard.delayAfterKeyDown()
-						keyboard.KeyUp(KEY_P)
-						keyboard.delayAfterKeyUp()
-
-						keyboard.KeyDown(KEY_P)
-						keyboard.delayAfterKeyDown()
-						keyboard.KeyUp(KEY_P)
-						keyboard.delayAfterKeyUp()
-					}
-					keyboard.KeyUp(KEY_SymbolShift)
-
-					keyboard.KeyDown(KEY_Enter)
-					keyboard.delayAfterKeyDown()
-					keyboard.KeyUp(KEY_Enter)
-				} else {
-					// LOAD
-					keyboard.KeyDown(KEY_J)
-					keyboard.delayAfterKeyDown()
-					keyboard.KeyUp(KEY_J)
-					keyboard.delayAfterKeyUp()
-
-					// " "
-					keyboard.KeyDown(KEY_SymbolShift)
-					{
-						keyboard.KeyDown(KEY_P)
-						keyboard.delayAfterKeyDown()
-						keyboard.KeyUp(KEY_P)
-						keyboard.delayAfterKeyUp()
-
-						keyboard.KeyDown(KEY_P)
-						keyboard.delayAfterKeyDown()
-						keyboard.KeyUp(KEY_P)
-						keyboard.delayAfterKeyUp()
-					}
-					keyboard.KeyUp(KEY_SymbolShift)
-
-					keyboard.KeyDown(KEY_Enter)
-					keyboard.delayAfterKeyDown()
-					keyboard.KeyUp(KEY_Enter)
-				}
+				keyboard.scheduleLoad(cmd.romType, cmd.tapeLoaderMenu)
 			}
 		}
 	}
 
 }
 
+// Schedules pressing and releasing the key.
+// The channel receives a value when the key has been released for a couple of frames.
+func (keyboard *Keyboard) scheduleKeyPress(logicalKeyCode uint, done chan bool) {
+	keyboard.schedule(keyPress(logicalKeyCode)...)
+	keyboard.schedule(keyAction{kind: keyAction_done, done: done})
+}
+
+// Schedules typing LOAD ""
+func (keyboard *Keyboard) scheduleLoad(romType RomType, tapeLoaderMenu bool) {
+	if tapeLoaderMenu {
+		// "Tape Loader" is the first (pre-selected) item of the menu
+		keyboard.schedule(keyDown(KEY_Enter), waitFrames(keyDownFrames), keyUp(KEY_Enter))
+	} else {
+		if romType == ROM_OPENSE {
+			keyboard.schedule(waitFrames(30))
+
+			// l o a d
+			for _, keycode := range []uint{KEY_L, KEY_O, KEY_A, KEY_D} {
+				keyboard.schedule(keyPress(keycode)...)
+			}
+		} else {
+			// LOAD
+			keyboard.schedule(keyPress(KEY_J)...)
+		}
+
+		// " "
+		keyboard.schedule(keyDown(KEY_SymbolShift))
+		keyboard.schedule(keyPress(KEY_P)...)
+		keyboard.schedule(keyPress(KEY_P)...)
+		keyboard.schedule(keyUp(KEY_SymbolShift))
+
+		keyboard.schedule(keyDown(KEY_Enter), waitFrames(keyDownFrames), keyUp(KEY_Enter))
+	}
+}
+
 func (k *Keyboard) reset() {
 	// Initialize 'k.keyStates'
 	for row := uint(0); row < 8; row++ {
@@ -177,6 +234,14 @@ func (keyboard *Keyboard) GetKeyState(row uint) byte {
 	return keyState
 }
 
+// Returns the state of all 8 rows
+func (keyboard *Keyboard) getKeyStates() [8]byte {
+	keyboard.mutex.RLock()
+	keyStates := keyboard.keyStates
+	keyboard.mutex.RUnlock()
+	return keyStates
+}
+
 func (keyboard *Keyboard) SetKeyState(row uint, state byte) {
 	keyboard.mutex.Lock()
 	keyboard.keyStates[row] = state
@@ -203,16 +268,22 @@ func (keyboard *Keyboard) KeyUp(logicalKeyCode uint) {
 	}
 }
 
+// Presses and releases the key. The returned channel receives
+// a value when the key has been released for a couple of frames.
+//
+// The key press is scheduled before this function returns, so 'KeyPress'
+// followed by 'Spectrum48k.RunFrames' always produces the same result.
 func (keyboard *Keyboard) KeyPress(logicalKeyCode uint) chan bool {
-	done := make(chan bool)
-	keyboard.CommandChannel <- Cmd_KeyPress{logicalKeyCode, done}
+	done := make(chan bool, 1)
+	keyboard.scheduleKeyPress(logicalKeyCode, done)
 	return done
 }
 
+// Presses and releases the keys one after another. The returned channel receives a value for each key.
 func (keyboard *Keyboard) KeyPressSequence(logicalKeyCodes ...uint) chan bool {
 	done := make(chan bool, len(logicalKeyCodes))
 	for _, keyCode := range logicalKeyCodes {
-		keyboard.CommandChannel <- Cmd_KeyPress{keyCode, done}
+		keyboard.scheduleKeyPress(keyCode, done)
 	}
 	return done
 }
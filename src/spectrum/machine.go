@@ -0,0 +1,221 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+import (
+	"errors"
+	"strings"
+)
+
+type MachineType int
+
+const (
+	MACHINE_48K MachineType = iota
+	MACHINE_128K
+	MACHINE_TC2048
+)
+
+// Spectrum 128k video timings
+const (
+	TStatesPerFrame_128k = 70908 // Number of T-states per frame
+	InterruptLength_128k = 36    // How long does an interrupt last in T-states
+
+	TSTATES_PER_LINE_128K  = 228   // 128 T-states of screen, 24+24 T-states of border, 52 T-states of retrace
+	FIRST_SCREEN_BYTE_128K = 14362 // T-state when the first byte of the screen is displayed
+	LINES_TOP_128K         = 63
+)
+
+// Timex TC2048 video timings (60Hz)
+const (
+	TStatesPerFrame_TC2048 = 58688 // 262 lines
+
+	FIRST_SCREEN_BYTE_TC2048 = 8960 // T-state when the first byte of the screen is displayed
+	LINES_TOP_TC2048         = 40
+	LINES_BOTTOM_TC2048      = 30
+)
+
+// Timing parameters of an emulated machine
+type MachineTimings struct {
+	TStatesPerFrame int // Number of T-states per frame
+	TStatesPerLine  int // Number of T-states per screen line (border and retrace included)
+	FirstScreenByte int // T-state when the first byte of the screen is displayed
+	InterruptLength int // How long does an interrupt last in T-states
+}
+
+// The T-state which corresponds to pixel (0,0) on the host-machine display.
+// That pixel belongs to the border.
+//
+// The result is negative if the border begins before the frame does,
+// which is the case of the full border.
+func (t *MachineTimings) DisplayStart(border Border) int {
+	return t.FirstScreenByte - t.TStatesPerLine*border.Top - border.Left/PIXELS_PER_TSTATE + BORDER_TSTATE_ADJUSTMENT
+}
+
+type machineModel struct {
+	machineType MachineType
+	name        string
+
+	timings MachineTimings
+
+	// The default display refresh frequency
+	fps float32
+
+	// Number of 16k ROM images required by the machine
+	numRoms int
+
+	// Whether the machine has the 0x7ffd memory paging port
+	paging bool
+
+	// Whether the machine has the AY-3-8912 sound chip
+	ay bool
+
+	// Whether reading an unassigned port returns the byte which the ULA
+	// is fetching from the video memory (the floating bus), instead of 0xff
+	floatingBus bool
+
+	// Whether the machine has the Timex SCLD (port 0xff),
+	// which provides the second screen, the hi-colour mode and the hi-res mode
+	scld bool
+
+	// Number of T-states to delay, for each possible T-state within a frame.
+	// The array is extended at the end - this covers the case when the emulator
+	// begins to execute an instruction at Tstate=(TStatesPerFrame-1). Such an
+	// instruction will finish at (TStatesPerFrame-1+4) or later. While playing back
+	// an RZX recording, a frame may also last up to RZX_MAX_FRAME_OVERRUN T-states longer.
+	delay_table []byte
+
+	// Let 'addr' be in range 0x4000 ... 0x5800-1.
+	// Then 'screenline_start_tstates[(addr-0x4000)/BytesPerLine]' is the T-state when the ULA
+	// starts painting the screenline containing 'addr'.
+	screenline_start_tstates [ScreenHeight]int
+}
+
+var (
+	model_48k = newMachineModel(MACHINE_48K, "48k",
+		MachineTimings{
+			TStatesPerFrame: TStatesPerFrame,
+			TStatesPerLine:  TSTATES_PER_LINE,
+			FirstScreenByte: FIRST_SCREEN_BYTE,
+			InterruptLength: InterruptLength,
+		},
+		DefaultFPS, 1, false, false, true, false)
+
+	model_128k = newMachineModel(MACHINE_128K, "128k",
+		MachineTimings{
+			TStatesPerFrame: TStatesPerFrame_128k,
+			TStatesPerLine:  TSTATES_PER_LINE_128K,
+			FirstScreenByte: FIRST_SCREEN_BYTE_128K,
+			InterruptLength: InterruptLength_128k,
+		},
+		3546900.0/TStatesPerFrame_128k, 2, true, true, true, false)
+
+	// The Kempston joystick interface is built in, as in all other models
+	model_tc2048 = newMachineModel(MACHINE_TC2048, "tc2048",
+		MachineTimings{
+			TStatesPerFrame: TStatesPerFrame_TC2048,
+			TStatesPerLine:  TSTATES_PER_LINE,
+			FirstScreenByte: FIRST_SCREEN_BYTE_TC2048,
+			InterruptLength: InterruptLength,
+		},
+		3528000.0/TStatesPerFrame_TC2048, 1, false, false, false, true)
+)
+
+func newMachineModel(machineType MachineType, name string, timings MachineTimings, fps float32, numRoms int, paging, ay, floatingBus, scld bool) *machineModel {
+	model := &machineModel{
+		machineType: machineType,
+		name:        name,
+		timings:     timings,
+		fps:         fps,
+		numRoms:     numRoms,
+		paging:      paging,
+		ay:          ay,
+		floatingBus: floatingBus,
+		scld:        scld,
+		delay_table: make([]byte, timings.TStatesPerFrame+RZX_MAX_FRAME_OVERRUN+100),
+	}
+
+	// Note: The language automatically initialized all values
+	//       of the 'delay_table' array to zeroes. So, we only
+	//       have to modify the non-zero elements.
+	tstate := timings.FirstScreenByte - 1
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x += 16 {
+			tstate_x := x / PIXELS_PER_TSTATE
+			model.delay_table[tstate+tstate_x+0] = 6
+			model.delay_table[tstate+tstate_x+1] = 5
+			model.delay_table[tstate+tstate_x+2] = 4
+			model.delay_table[tstate+tstate_x+3] = 3
+			model.delay_table[tstate+tstate_x+4] = 2
+			model.delay_table[tstate+tstate_x+5] = 1
+		}
+		tstate += timings.TStatesPerLine
+	}
+
+	for y := uint8(0); y < ScreenHeight; y++ {
+		addr := xy_to_screenAddr(0, y)
+		model.screenline_start_tstates[(addr-SCREEN_BASE_ADDR)/BytesPerLine] = timings.FirstScreenByte + int(y)*timings.TStatesPerLine
+	}
+
+	return model
+}
+
+func getMachineModel(machineType MachineType) *machineModel {
+	switch machineType {
+	case MACHINE_128K:
+		return model_128k
+	case MACHINE_TC2048:
+		return model_tc2048
+	}
+	return model_48k
+}
+
+func (t MachineType) String() string {
+	return getMachineModel(t).name
+}
+
+// Converts a machine name ("48k", "128k", "tc2048") to a MachineType
+func ParseMachineType(name string) (MachineType, error) {
+	switch strings.ToLower(name) {
+	case "48", "48k":
+		return MACHINE_48K, nil
+	case "128", "128k":
+		return MACHINE_128K, nil
+	case "2048", "tc2048":
+		return MACHINE_TC2048, nil
+	}
+	return MACHINE_48K, errors.New("unknown machine model \"" + name + "\"")
+}
+
+// Returns the names of the ROM files required by the specified machine model
+func RomFilenames(machineType MachineType) []string {
+	switch machineType {
+	case MACHINE_128K:
+		return []string{"128-0.rom", "128-1.rom"}
+	case MACHINE_TC2048:
+		return []string{"tc2048.rom"}
+	}
+	return []string{"48.rom"}
+}
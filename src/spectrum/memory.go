@@ -2,13 +2,48 @@ package spectrum
 
 import "github.com/remogatto/z80"
 
+// The number of 16k RAM banks available to a machine with memory paging
+const NumRamBanks = 8
+
+// Memory of the emulated machine.
+//
+// The 64k address space of the Z80 is divided into four 16k pages.
+// The 1st page always contains a ROM, the remaining three pages contain
+// RAM banks. The 48k machine uses the fixed mapping [ROM 0, bank 5,
+// bank 2, bank 0]. On the 128k machine the ROM in the 1st page and
+// the bank in the 4th page are selected by writing to port 0x7ffd.
 type Memory struct {
-	data   [0x10000]byte
+	// The 16k pages currently visible to the Z80
+	pages [4]*[0x4000]byte
+
+	// The RAM bank mapped into each page, or -1 if the page contains a ROM
+	pageBanks [4]int
+
+	// Whether memory accesses to each page are subject to contention
+	contended [4]bool
+
+	rom [2][0x4000]byte
+	ram [NumRamBanks][0x4000]byte
+
+	// The last value written to port 0x7ffd
+	port7ffd byte
+
+	// Set after bit 5 of port 0x7ffd has been written, cleared only by a reset
+	pagingLocked bool
+
+	// The RAM bank the ULA is reading the screen from (5 or 7)
+	screenBank int
+
+	// Set by the debugger if there are any memory watchpoints
+	watch bool
+
 	speccy *Spectrum48k
 }
 
 func NewMemory() *Memory {
-	return &Memory{}
+	memory := &Memory{}
+	memory.mapPages(0)
+	return memory
 }
 
 func (memory *Memory) init(speccy *Spectrum48k) {
@@ -16,56 +51,150 @@ func (memory *Memory) init(speccy *Spectrum48k) {
 }
 
 func (memory *Memory) reset() {
-	for i := 0; i < 0x10000; i++ {
-		memory.data[i] = 0
+	for i := 0; i < NumRamBanks; i++ {
+		memory.ram[i] = [0x4000]byte{}
+	}
+
+	memory.pagingLocked = false
+	memory.mapPages(0)
+}
+
+// Sets the memory configuration according to the value of port 0x7ffd:
+//
+//	bits 0-2: RAM bank paged in at 0xc000
+//	bit 3:    screen bank (0 = bank 5, 1 = bank 7)
+//	bit 4:    ROM (0 = 128k editor, 1 = 48k BASIC)
+//	bit 5:    disable further paging until the next reset
+func (memory *Memory) mapPages(port7ffd byte) {
+	memory.port7ffd = port7ffd
+
+	romIndex := int((port7ffd >> 4) & 0x01)
+	bank := int(port7ffd & 0x07)
+
+	memory.pages[0] = &memory.rom[romIndex]
+	memory.pages[1] = &memory.ram[5]
+	memory.pages[2] = &memory.ram[2]
+	memory.pages[3] = &memory.ram[bank]
+
+	memory.pageBanks = [4]int{-1, 5, 2, bank}
+
+	memory.contended[0] = false
+	memory.contended[1] = true
+	memory.contended[2] = false
+	memory.contended[3] = (memory.speccy != nil) && memory.speccy.model.paging && ((bank & 0x01) == 1)
+
+	if (port7ffd & 0x08) == 0 {
+		memory.screenBank = 5
+	} else {
+		memory.screenBank = 7
+	}
+}
+
+// Handles a write to port 0x7ffd.
+// The write is ignored if paging has been locked.
+func (memory *Memory) writePort7ffd(b byte) {
+	if memory.pagingLocked {
+		return
 	}
+
+	oldScreenBank := memory.screenBank
+
+	memory.mapPages(b)
+	memory.pagingLocked = ((b & 0x20) != 0)
+
+	if memory.screenBank != oldScreenBank {
+		memory.speccy.ula.screenBankSwitch(&memory.ram[oldScreenBank])
+	}
+}
+
+// Returns the RAM bank currently displayed by the ULA
+func (memory *Memory) screen() *[0x4000]byte {
+	return &memory.ram[memory.screenBank]
+}
+
+// Returns the specified 16k RAM bank (0 ... NumRamBanks-1)
+func (memory *Memory) RamBank(bank int) *[0x4000]byte {
+	return &memory.ram[bank]
+}
+
+// Returns the last value written to port 0x7ffd
+func (memory *Memory) Port7ffd() byte {
+	return memory.port7ffd
+}
+
+func (memory *Memory) loadRom(index int, rom *[0x4000]byte) {
+	memory.rom[index] = *rom
 }
 
 func (memory *Memory) ReadByteInternal(address uint16) byte {
-	return memory.data[address]
+	return memory.pages[address>>14][address&0x3fff]
 }
 
 func (memory *Memory) WriteByteInternal(address uint16, b byte) {
-	if (address >= SCREEN_BASE_ADDR) && (address < ATTR_BASE_ADDR) {
-		memory.speccy.ula.screenBitmapWrite(address, memory.data[address], b)
-	} else if (address >= ATTR_BASE_ADDR) && (address < 0x5b00) {
-		memory.speccy.ula.screenAttrWrite(address, memory.data[address], b)
+	page := address >> 14
+	if page == 0 {
+		// ROM
+		return
 	}
 
-	if address >= 0x4000 {
-		memory.data[address] = b
+	ofs := address & 0x3fff
+	data := memory.pages[page]
+
+	if (ofs < 0x1b00) && (memory.pageBanks[page] == memory.screenBank) {
+		if ofs < ATTR_BASE_ADDR-SCREEN_BASE_ADDR {
+			memory.speccy.ula.screenBitmapWrite(SCREEN_BASE_ADDR+ofs, data[ofs], b)
+		} else {
+			memory.speccy.ula.screenAttrWrite(SCREEN_BASE_ADDR+ofs, data[ofs], b)
+		}
+	} else if (ofs >= TIMEX_SECOND_SCREEN_OFFSET) && (ofs < TIMEX_SECOND_SCREEN_OFFSET+0x1b00) &&
+		(memory.pageBanks[page] == memory.screenBank) && memory.speccy.model.scld {
+		memory.speccy.ula.timexScreenWrite(SCREEN_BASE_ADDR+ofs, data[ofs], b)
 	}
+
+	data[ofs] = b
 }
 
 func (memory *Memory) ReadByte(address uint16) byte {
-	contendMemory(memory.speccy.Cpu, address, 3)
+	memory.contend(address, 3)
+	if memory.watch {
+		memory.speccy.debugger.memoryAccess(address, WATCH_READ)
+	}
 	return memory.ReadByteInternal(address)
 }
 
 func (memory *Memory) WriteByte(address uint16, b byte) {
-	contendMemory(memory.speccy.Cpu, address, 3)
+	memory.contend(address, 3)
+	if memory.watch {
+		memory.speccy.debugger.memoryAccess(address, WATCH_WRITE)
+	}
 	memory.WriteByteInternal(address, b)
 }
 
-func contendMemory(z80 *z80.Z80, address uint16, time int) {
-	tstates_p := &z80.Tstates
-	tstates := *tstates_p
+// Returns true if accesses to the specified address are subject to contention
+func (memory *Memory) isContended(address uint16) bool {
+	return memory.contended[address>>14]
+}
+
+func (memory *Memory) contend(address uint16, time int) {
+	z80 := memory.speccy.Cpu
+	tstates := z80.Tstates
 
-	if (address & 0xc000) == 0x4000 {
-		tstates += int(delay_table[tstates])
+	if memory.contended[address>>14] {
+		tstates += int(memory.speccy.model.delay_table[tstates])
 	}
 
 	tstates += time
 
-	*tstates_p = tstates
+	z80.Tstates = tstates
 }
 
-// Equivalent to executing "contendMemory(z80, address, time)" count times
-func contendMemory_loop(z80 *z80.Z80, address uint16, time int, count uint) {
-	tstates_p := &z80.Tstates
-	tstates := *tstates_p
+// Equivalent to executing "memory.contend(address, time)" count times
+func (memory *Memory) contend_loop(address uint16, time int, count uint) {
+	z80 := memory.speccy.Cpu
+	tstates := z80.Tstates
 
-	if (address & 0xc000) == 0x4000 {
+	if memory.contended[address>>14] {
+		delay_table := memory.speccy.model.delay_table
 		for i := uint(0); i < count; i++ {
 			tstates += int(delay_table[tstates])
 			tstates += time
@@ -74,66 +203,48 @@ func contendMemory_loop(z80 *z80.Z80, address uint16, time int, count uint) {
 		tstates += time * int(count)
 	}
 
-	*tstates_p = tstates
+	z80.Tstates = tstates
 }
 
 func (memory *Memory) ContendRead(address uint16, time int) {
-	contendMemory(memory.speccy.Cpu, address, time)
+	memory.contend(address, time)
 }
 
 func (memory *Memory) ContendReadNoMreq(address uint16, time int) {
-	contendMemory(memory.speccy.Cpu, address, time)
+	memory.contend(address, time)
 }
 
 func (memory *Memory) ContendReadNoMreq_loop(address uint16, time int, count uint) {
-	contendMemory_loop(memory.speccy.Cpu, address, time, count)
+	memory.contend_loop(address, time, count)
 }
 
 func (memory *Memory) ContendWriteNoMreq(address uint16, time int) {
-	contendMemory(memory.speccy.Cpu, address, time)
+	memory.contend(address, time)
 }
 
 func (memory *Memory) ContendWriteNoMreq_loop(address uint16, time int, count uint) {
-	contendMemory_loop(memory.speccy.Cpu, address, time, count)
+	memory.contend_loop(address, time, count)
 }
 
 func (memory *Memory) Read(address uint16) byte {
-	return memory.data[address]
+	return memory.pages[address>>14][address&0x3fff]
 }
 
 func (memory *Memory) Write(address uint16, value byte, protectROM bool) {
 	if (address >= 0x4000) || !protectROM {
-		memory.data[address] = value
+		memory.pages[address>>14][address&0x3fff] = value
 	}
 }
 
+// Returns a copy of the 64k address space as currently seen by the Z80.
+// Modifying the returned slice does not modify the memory.
 func (memory *Memory) Data() []byte {
-	return memory.data[:]
-}
-
-// Number of T-states to delay, for each possible T-state within a frame.
-// The array is extended at the end - this covers the case when the emulator
-// begins to execute an instruction at Tstate=(TStatesPerFrame-1). Such an
-// instruction will finish at (TStatesPerFrame-1+4) or later.
-var delay_table [TStatesPerFrame + 100]byte
-
-// Initialize 'delay_table' at program startup
-func init() {
-	// Note: The language automatically initialized all values
-	//       of the 'delay_table' array to zeroes. So, we only
-	//       have to modify the non-zero elements.
-
-	tstate := FIRST_SCREEN_BYTE - 1
-	for y := 0; y < ScreenHeight; y++ {
-		for x := 0; x < ScreenWidth; x += 16 {
-			tstate_x := x / PIXELS_PER_TSTATE
-			delay_table[tstate+tstate_x+0] = 6
-			delay_table[tstate+tstate_x+1] = 5
-			delay_table[tstate+tstate_x+2] = 4
-			delay_table[tstate+tstate_x+3] = 3
-			delay_table[tstate+tstate_x+4] = 2
-			delay_table[tstate+tstate_x+5] = 1
-		}
-		tstate += TSTATES_PER_LINE
+	data := make([]byte, 0x10000)
+	for page := 0; page < 4; page++ {
+		copy(data[page*0x4000:], memory.pages[page][:])
 	}
+	return data
 }
+
+// Make sure that Memory implements z80.MemoryAccessor
+var _ z80.MemoryAccessor = (*Memory)(nil)
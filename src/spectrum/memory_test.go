@@ -0,0 +1,141 @@
+package spectrum
+
+// Creates a 128k machine whose ROM images are filled with 0x00 (editor) and 0x01 (48k BASIC)
+func newTestSpectrum128k() (*Application, *Spectrum48k, error) {
+	var rom0, rom1 [0x4000]byte
+	for i := range rom1 {
+		rom1[i] = 0x01
+	}
+	return newTestSpectrum(MACHINE_128K, [][0x4000]byte{rom0, rom1})
+}
+
+func (t *testSuite) TestMemory_128kBanks() {
+	app, speccy, err := newTestSpectrum128k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+	for bank := byte(0); bank < NumRamBanks; bank++ {
+		memory.writePort7ffd(bank)
+		t.Equal(bank, memory.Port7ffd())
+
+		memory.Write(0xc000, 0x80|bank, true)
+		t.Equal(0x80|bank, memory.RamBank(int(bank))[0])
+	}
+
+	// Banks 5 and 2 are always paged in at 0x4000 and 0x8000
+	t.Equal(byte(0x85), memory.Read(0x4000))
+	t.Equal(byte(0x82), memory.Read(0x8000))
+
+	memory.writePort7ffd(5)
+	memory.Write(0xc001, 0x55, true)
+	t.Equal(byte(0x55), memory.Read(0x4001))
+}
+
+func (t *testSuite) TestMemory_128kROM() {
+	app, speccy, err := newTestSpectrum128k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+
+	memory.writePort7ffd(0x00)
+	t.Equal(byte(0x00), memory.Read(0x0000))
+
+	memory.writePort7ffd(0x10)
+	t.Equal(byte(0x01), memory.Read(0x0000))
+
+	// The ROM is protected
+	memory.WriteByteInternal(0x0000, 0xaa)
+	t.Equal(byte(0x01), memory.Read(0x0000))
+}
+
+func (t *testSuite) TestMemory_128kScreen() {
+	app, speccy, err := newTestSpectrum128k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+
+	t.True(memory.screen() == memory.RamBank(5))
+
+	memory.writePort7ffd(0x08)
+	t.True(memory.screen() == memory.RamBank(7))
+
+	memory.writePort7ffd(0x00)
+	t.True(memory.screen() == memory.RamBank(5))
+}
+
+func (t *testSuite) TestMemory_128kContention() {
+	app, speccy, err := newTestSpectrum128k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+	for bank := byte(0); bank < NumRamBanks; bank++ {
+		memory.writePort7ffd(bank)
+
+		t.False(memory.isContended(0x0000))
+		t.True(memory.isContended(0x4000))
+		t.False(memory.isContended(0x8000))
+		t.Equal((bank&0x01) == 1, memory.isContended(0xc000))
+	}
+}
+
+func (t *testSuite) TestMemory_128kPagingLock() {
+	app, speccy, err := newTestSpectrum128k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+
+	memory.writePort7ffd(0x20 | 0x10 | 0x03)
+	t.Equal(byte(0x33), memory.Port7ffd())
+
+	// Further writes are ignored
+	memory.writePort7ffd(0x08 | 0x01)
+	t.Equal(byte(0x33), memory.Port7ffd())
+	t.True(memory.RamBank(3) == memory.pages[3])
+	t.True(memory.screen() == memory.RamBank(5))
+	t.Equal(byte(0x01), memory.Read(0x0000))
+
+	// Until the next reset
+	memory.reset()
+	t.Equal(byte(0x00), memory.Port7ffd())
+	t.Equal(byte(0x00), memory.Read(0x0000))
+
+	memory.writePort7ffd(0x01)
+	t.Equal(byte(0x01), memory.Port7ffd())
+	t.True(memory.RamBank(1) == memory.pages[3])
+}
+
+func (t *testSuite) TestMemory_48kContention() {
+	rom := [0x4000]byte{}
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{rom})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+	t.False(memory.isContended(0x0000))
+	t.True(memory.isContended(0x4000))
+	t.False(memory.isContended(0x8000))
+	t.False(memory.isContended(0xc000))
+}
@@ -25,8 +25,6 @@ WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 
 package spectrum
 
-import "github.com/remogatto/z80"
-
 type FrameStatusOfPorts struct {
 	shouldPlayTheTape bool
 }
@@ -68,8 +66,20 @@ type Ports struct {
 	// Number of supposed reads from tapedrive port.
 	// This counter is reset to 0 at the beginning of each frame.
 	tapeReadCount uint
+
+	// Set by the debugger if there are any port breakpoints
+	watch bool
+
+	// The devices connected to the I/O bus
+	devices []deviceInfo
+
+	// Bits 3 (MIC) and 4 (EAR) of the last value written to port 0xfe, shifted right by 3
+	earMic byte
 }
 
+// The voltage on pin 28 of the ULA chip above which bit 6 of port 0xfe reads as 1
+const EAR_INPUT_THRESHOLD = 0.7
+
 // If 'tapeReadCount' is equal to or above this threshold,
 // the program running within the emulated machine probably wants to read data from the tape
 const tapeReadCount_tapeAccessThreshold = 400
@@ -85,15 +95,26 @@ func NewPorts() *Ports {
 
 func (p *Ports) init(speccy *Spectrum48k) {
 	p.speccy = speccy
+
+	// Builtin devices
+	p.addDevice(&ulaDevice{ports: p})
+	p.addDevice(&kempstonDevice{speccy: speccy})
+	p.addDevice(&ulaplusDevice{ula: speccy.ula})
+	if speccy.model.paging {
+		p.addDevice(&pagingDevice{memory: speccy.Memory})
+	}
+	if speccy.ay != nil {
+		p.addDevice(&ayDevice{ay: speccy.ay})
+	}
+	if speccy.model.scld {
+		p.addDevice(&scldDevice{ula: speccy.ula})
+	}
 }
 
 func (p *Ports) reset() {
-	p.borderEvents = p.borderEvents[0:0]
-	p.borderEvents = append(p.borderEvents, BorderEvent{TState: 0, Color: p.speccy.ula.getBorderColor()})
-
-	p.beeperLevel = 0
-	p.beeperEvents = p.beeperEvents[0:0]
-	p.beeperEvents = append(p.beeperEvents, BeeperEvent{TState: 0, Level: p.beeperLevel})
+	for _, d := range p.devices {
+		d.device.Reset()
+	}
 }
 
 func SameBorderEvents(l1, l2 []BorderEvent) bool {
@@ -118,6 +139,8 @@ func (p *Ports) frame_begin() {
 }
 
 func (p *Ports) frame_end() FrameStatusOfPorts {
+	TStatesPerFrame := p.speccy.model.timings.TStatesPerFrame
+
 	// Border events
 	{
 		// Determine the number of events overflowing the frame
@@ -216,6 +239,8 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 // 
 // If the returned list is non-empty, its length is at least 2.
 func (p *Ports) getBorderEvents() []BorderEvent {
+	TStatesPerFrame := p.speccy.model.timings.TStatesPerFrame
+
 	n := len(p.borderEvents)
 	for (n > 0) && (p.borderEvents[n-1].TState > TStatesPerFrame) {
 		n--
@@ -238,6 +263,8 @@ func (p *Ports) getBorderEvents() []BorderEvent {
 //
 // If the returned list is non-empty, its length is at least 2.
 func (p *Ports) getBeeperEvents() []BeeperEvent {
+	TStatesPerFrame := p.speccy.model.timings.TStatesPerFrame
+
 	n := len(p.beeperEvents)
 	for (n > 0) && (p.beeperEvents[n-1].TState > TStatesPerFrame) {
 		n--
@@ -261,35 +288,89 @@ func (p *Ports) ReadPortInternal(address uint16, contend bool) byte {
 	if contend {
 		p.ContendPortPreio(address)
 		p.ContendPortPostio(address)
+
+		if p.watch {
+			p.speccy.debugger.portAccess(address, WATCH_READ)
+		}
 	}
 
-	var result byte = 0xff
+	if contend && p.speccy.rzx.playing {
+		return p.speccy.rzx.playbackInput()
+	}
 
-	if (address & 0x0001) == 0x0000 {
-		// Read keyboard
-		var row uint
-		for row = 0; row < 8; row++ {
-			if (address & (1 << (uint16(row) + 8))) == 0 { // bit held low, so scan this row
-				result &= p.speccy.Keyboard.GetKeyState(row)
+	var result byte = 0xff
+	var assigned bool = false
+	for _, d := range p.devices {
+		if (address & d.mask) == d.value {
+			if (d.busDriver != nil) && !d.busDriver.DrivesBus(address) {
+				continue
 			}
+			result &= d.device.Read(address)
+			assigned = true
 		}
+	}
 
-		// Read tape
-		if p.speccy.readFromTape && (address == 0x7ffe) {
-			p.tapeReadCount++
-			earBit := p.speccy.tapeDrive.getEarBit()
-			result &= earBit
-		}
-	} else if (address & 0x00e0) == 0x0000 {
-		result &= p.speccy.Joystick.GetState()
-	} else {
-		// Unassigned port
-		result = 0xff
+	if !assigned && p.speccy.model.floatingBus {
+		result = p.floatingBus()
+	}
+
+	if contend && p.speccy.rzx.recording {
+		p.speccy.rzx.recordInput(result)
 	}
 
 	return result
 }
 
+// Returns bit 6 of port 0xfe when there is no input signal on the EAR socket,
+// the other bits are set to 1.
+//
+// The bit depends on the voltage on pin 28 of the ULA chip, which depends
+// on the last value sent to the EAR and MIC outputs and on the board issue.
+func (p *Ports) earInput() byte {
+	voltage := Voltage_Issue3[p.earMic]
+	if p.speccy.ula.issue == 2 {
+		voltage = Voltage_Issue2[p.earMic]
+	}
+
+	if voltage > EAR_INPUT_THRESHOLD {
+		return 0xff
+	}
+	return 0xbf
+}
+
+// Returns the byte which the ULA is fetching from the video memory at the current T-state,
+// or 0xff if the ULA is painting the border or the beam is retracing.
+//
+// In each 8 T-states of a screen line, the ULA fetches a bitmap byte, its attribute,
+// the next bitmap byte and its attribute. The bus is idle during the remaining 4 T-states.
+func (p *Ports) floatingBus() byte {
+	timings := &p.speccy.model.timings
+
+	tstate := p.speccy.Cpu.Tstates - timings.FirstScreenByte
+	if tstate < 0 {
+		return 0xff
+	}
+
+	y := tstate / timings.TStatesPerLine
+	x := tstate % timings.TStatesPerLine
+	if (y >= ScreenHeight) || (x >= LINE_SCREEN) {
+		return 0xff
+	}
+
+	screen := p.speccy.Memory.screen()
+	column := (x/8)*2 + (x%8)/2
+	switch x % 8 {
+	case 0, 2:
+		addr := xy_to_screenAddr(uint8(column*8), uint8(y))
+		return screen[addr-SCREEN_BASE_ADDR]
+	case 1, 3:
+		addr := ATTR_BASE_ADDR + (y/8)*ScreenWidth_Attr + column
+		return screen[addr-SCREEN_BASE_ADDR]
+	}
+
+	return 0xff
+}
+
 func (p *Ports) WritePort(address uint16, b byte) {
 	p.WritePortInternal(address, b, true)
 }
@@ -297,41 +378,15 @@ func (p *Ports) WritePort(address uint16, b byte) {
 func (p *Ports) WritePortInternal(address uint16, b byte, contend bool) {
 	if contend {
 		p.ContendPortPreio(address)
-	}
-
-	if (address & 0x0001) == 0 {
-		color := (b & 0x07)
 
-		// Modify the border only if it really changed
-		if p.speccy.ula.getBorderColor() != color {
-			p.speccy.ula.setBorderColor(color)
-
-			last := len(p.borderEvents) - 1
-			if p.borderEvents[last].TState == p.speccy.Cpu.Tstates {
-				p.borderEvents[last].Color = color
-			} else {
-				p.borderEvents = append(p.borderEvents, BorderEvent{p.speccy.Cpu.Tstates, color})
-			}
+		if p.watch {
+			p.speccy.debugger.portAccess(address, WATCH_WRITE)
 		}
+	}
 
-		// EAR(bit 4) and MIC(bit 3) output
-		newBeeperLevel := (b & 0x18) >> 3
-		if p.speccy.readFromTape && !p.speccy.tapeDrive.AcceleratedLoad {
-			if p.speccy.tapeDrive.earBit == 0xff {
-				newBeeperLevel |= 2
-			} else {
-				newBeeperLevel &^= 2
-			}
-		}
-		if p.beeperLevel != newBeeperLevel {
-			p.beeperLevel = newBeeperLevel
-
-			last := len(p.beeperEvents) - 1
-			if p.beeperEvents[last].TState == p.speccy.Cpu.Tstates {
-				p.beeperEvents[last].Level = newBeeperLevel
-			} else {
-				p.beeperEvents = append(p.beeperEvents, BeeperEvent{p.speccy.Cpu.Tstates, newBeeperLevel})
-			}
+	for _, d := range p.devices {
+		if (address & d.mask) == d.value {
+			d.device.Write(address, b)
 		}
 	}
 
@@ -340,15 +395,15 @@ func (p *Ports) WritePortInternal(address uint16, b byte, contend bool) {
 	}
 }
 
-func contendPort(z80 *z80.Z80, time int) {
-	tstates_p := &z80.Tstates
-	*tstates_p += int(delay_table[*tstates_p])
+func (p *Ports) contendPort(time int) {
+	tstates_p := &p.speccy.Cpu.Tstates
+	*tstates_p += int(p.speccy.model.delay_table[*tstates_p])
 	*tstates_p += time
 }
 
 func (p *Ports) ContendPortPreio(address uint16) {
-	if (address & 0xc000) == 0x4000 {
-		contendPort(p.speccy.Cpu, 1)
+	if p.speccy.Memory.isContended(address) {
+		p.contendPort(1)
 	} else {
 		p.speccy.Cpu.Tstates += 1
 	}
@@ -356,15 +411,15 @@ func (p *Ports) ContendPortPreio(address uint16) {
 
 func (p *Ports) ContendPortPostio(address uint16) {
 	if (address & 0x0001) == 1 {
-		if (address & 0xc000) == 0x4000 {
-			contendPort(p.speccy.Cpu, 1)
-			contendPort(p.speccy.Cpu, 1)
-			contendPort(p.speccy.Cpu, 1)
+		if p.speccy.Memory.isContended(address) {
+			p.contendPort(1)
+			p.contendPort(1)
+			p.contendPort(1)
 		} else {
 			p.speccy.Cpu.Tstates += 3
 		}
 
 	} else {
-		contendPort(p.speccy.Cpu, 3)
+		p.contendPort(3)
 	}
 }
@@ -0,0 +1,115 @@
+package spectrum
+
+import (
+	"fmt"
+
+	"github.com/remogatto/z80"
+)
+
+// Executes the instruction IN A,(n) at 0x8000, starting at the given T-state.
+// Returns the value read from the port.
+func testIN(speccy *Spectrum48k, address uint16, tstate int) byte {
+	speccy.Memory.Write(0x8000, 0xdb, true)
+	speccy.Memory.Write(0x8001, byte(address), true)
+
+	speccy.Cpu.SetPC(0x8000)
+	speccy.Cpu.A = byte(address >> 8)
+	speccy.Cpu.Tstates = tstate
+
+	speccy.Memory.ContendRead(speccy.Cpu.PC(), 4)
+	speccy.Cpu.IncPC(1)
+	z80.OpcodesMap[0xdb](speccy.Cpu)
+
+	return speccy.Cpu.A
+}
+
+// Returns the values read from the port by IN A,(n) instructions whose
+// I/O cycle ends at the T-states FirstScreenByte+0 to FirstScreenByte+7
+func testFloatingBus(speccy *Spectrum48k, address uint16) [8]byte {
+	speccy.Memory.Write(0x4000, 0x11, true)
+	speccy.Memory.Write(0x4001, 0x22, true)
+	speccy.Memory.Write(0x5800, 0x33, true)
+	speccy.Memory.Write(0x5801, 0x44, true)
+
+	// IN A,(n) ends its uncontended I/O cycle 11 T-states after the opcode fetch begins
+	const ioEnd = 11
+
+	var values [8]byte
+	for i := range values {
+		values[i] = testIN(speccy, address, speccy.model.timings.FirstScreenByte-ioEnd+i)
+	}
+	return values
+}
+
+var floatingBusSequence = [8]byte{0x11, 0x33, 0x22, 0x44, 0xff, 0xff, 0xff, 0xff}
+
+func (t *testSuite) TestFloatingBus_48k() {
+	var rom [0x4000]byte
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{rom})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	t.Equal(floatingBusSequence, testFloatingBus(speccy, 0x00fd))
+	t.Equal(floatingBusSequence, testFloatingBus(speccy, 0xbffd))
+
+	// Outside of the screen area
+	t.Equal(byte(0xff), testIN(speccy, 0x00fd, speccy.model.timings.FirstScreenByte-100))
+}
+
+func (t *testSuite) TestFloatingBus_128k() {
+	app, speccy, err := newTestSpectrum128k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// The write-only ports 0x7ffd and 0xbffd do not drive the data bus
+	t.Equal(floatingBusSequence, testFloatingBus(speccy, 0x00fd))
+	t.Equal(floatingBusSequence, testFloatingBus(speccy, 0xbffd))
+
+	// Port 0xfffd drives the data bus
+	speccy.Ports.WritePortInternal(0xfffd, 7, false)
+	speccy.Ports.WritePortInternal(0xbffd, 0x2a, false)
+	t.Equal(byte(0x2a), testIN(speccy, 0xfffd, speccy.model.timings.FirstScreenByte-11))
+}
+
+func (t *testSuite) TestEarInput() {
+	var rom [0x4000]byte
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{rom})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// Bit 6 of port 0xfe after writing EAR (bit 4) and MIC (bit 3) to port 0xfe
+	tests := []struct {
+		issue uint
+		out   byte
+		bit6  byte
+	}{
+		{2, 0x00, 0x00},
+		{2, 0x08, 0x40},
+		{2, 0x10, 0x40},
+		{2, 0x18, 0x40},
+
+		{3, 0x00, 0x00},
+		{3, 0x08, 0x00},
+		{3, 0x10, 0x40},
+		{3, 0x18, 0x40},
+	}
+
+	errChan := make(chan error)
+	for _, test := range tests {
+		speccy.CommandChannel <- Cmd_SetIssue{Issue: test.issue, ErrChan: errChan}
+		t.Nil(<-errChan)
+
+		speccy.Ports.WritePortInternal(0x00fe, test.out, false)
+		t.Equal(test.bit6, speccy.Ports.ReadPortInternal(0x00fe, false)&0x40,
+			fmt.Sprintf("issue %d, OUT 0x%02x", test.issue, test.out))
+	}
+}
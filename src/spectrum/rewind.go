@@ -0,0 +1,376 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// By default, a snapshot is taken every second and the last minute can be rewound
+const (
+	DEFAULT_REWIND_INTERVAL = 50 // Frames
+	DEFAULT_REWIND_CAPACITY = 60 // Snapshots
+)
+
+// The state of the input devices as seen by the emulated machine
+type inputState struct {
+	keys     [8]byte
+	joystick byte
+}
+
+// A change of the input state. The change is recorded at the moment
+// when the emulated machine observed it by reading a port.
+type inputEvent struct {
+	frame  uint // The value of 'Rewind.frame' at the time of the event
+	tstate int
+	state  inputState
+}
+
+// A snapshot in the rewind buffer.
+//
+// The memory of the newest snapshot is stored as is. The memory of older
+// snapshots is stored as a compressed XOR-delta relative to the next newer snapshot.
+type rewindEntry struct {
+	frame uint // The value of 'Rewind.frame' at the time of the snapshot
+
+	cpu formats.CpuState
+	ula formats.UlaState
+
+	is128k   bool
+	port7ffd byte
+	ay       formats.AyState
+
+	raw   []byte // The memory of the newest snapshot, otherwise nil
+	delta []byte // The compressed delta of older snapshots, otherwise nil
+}
+
+// The rewind buffer.
+//
+// It keeps a snapshot of the machine every 'interval' frames, up to 'capacity' snapshots,
+// and a log of input changes covering the time span of the snapshots.
+// Rewinding restores the latest snapshot preceding the requested moment,
+// and then replays the input log until the requested moment is reached.
+// The position of the tape is not rewound.
+type Rewind struct {
+	speccy *Spectrum48k
+
+	interval uint // Zero if the rewind buffer is disabled
+	capacity int
+
+	// The number of frames completed since the rewind buffer was enabled
+	frame uint
+
+	entries []*rewindEntry // From the oldest to the newest
+
+	log     []inputEvent // From the oldest to the newest
+	initial inputState   // The input state at the time of the oldest snapshot, minus the events in 'log'
+	state   inputState   // The current input state
+
+	// Set while the input log is being replayed
+	replaying   bool
+	replayIndex int // The next event in 'log'
+
+	compressor *flate.Writer
+}
+
+func NewRewind() *Rewind {
+	return &Rewind{interval: DEFAULT_REWIND_INTERVAL, capacity: DEFAULT_REWIND_CAPACITY}
+}
+
+func (r *Rewind) init(speccy *Spectrum48k) {
+	r.speccy = speccy
+	r.state = inputState{keys: speccy.Keyboard.getKeyStates(), joystick: speccy.Joystick.GetState()}
+	r.initial = r.state
+}
+
+// Changes the snapshot interval (in frames) and the maximum number of snapshots.
+// An interval of zero disables the rewind buffer. The buffer is cleared.
+func (r *Rewind) configure(interval uint, capacity uint) {
+	if capacity == 0 {
+		interval = 0
+	}
+
+	r.interval = interval
+	r.capacity = int(capacity)
+	r.entries = nil
+	r.log = nil
+	r.initial = r.state
+}
+
+func (r *Rewind) enabled() bool {
+	return r.interval > 0
+}
+
+// Returns the state of the keyboard rows as seen by the emulated machine
+func (r *Rewind) keyStates() [8]byte {
+	if !r.enabled() {
+		return r.speccy.Keyboard.getKeyStates()
+	}
+	return r.input().keys
+}
+
+// Returns the state of the Kempston joystick as seen by the emulated machine
+func (r *Rewind) joystickState() byte {
+	if !r.enabled() {
+		return r.speccy.Joystick.GetState()
+	}
+	return r.input().joystick
+}
+
+// Returns the input state. While replaying, the state is taken from the input log.
+// Otherwise, the state of the input devices is returned and its changes are recorded.
+func (r *Rewind) input() inputState {
+	tstate := r.speccy.Cpu.Tstates
+
+	if r.replaying {
+		for r.replayIndex < len(r.log) {
+			e := &r.log[r.replayIndex]
+			if (e.frame > r.frame) || ((e.frame == r.frame) && (e.tstate > tstate)) {
+				break
+			}
+			r.state = e.state
+			r.replayIndex++
+		}
+		return r.state
+	}
+
+	state := inputState{keys: r.speccy.Keyboard.getKeyStates(), joystick: r.speccy.Joystick.GetState()}
+	if state != r.state {
+		r.log = append(r.log, inputEvent{frame: r.frame, tstate: tstate, state: state})
+		r.state = state
+	}
+	return state
+}
+
+// This function is called at the end of each frame
+func (r *Rewind) frameEnd() {
+	if !r.enabled() {
+		return
+	}
+
+	r.frame++
+	if (r.frame % r.interval) == 0 {
+		r.add(r.speccy.MakeSnapshot())
+	}
+}
+
+// Returns the memory of the snapshot in the form stored in the rewind buffer
+func rewindMemory(s *formats.FullSnapshot) []byte {
+	if s.Machine128k == nil {
+		return append([]byte(nil), s.Mem[:]...)
+	}
+
+	raw := make([]byte, 0, NumRamBanks*0x4000)
+	for bank := 0; bank < NumRamBanks; bank++ {
+		raw = append(raw, s.Machine128k.Ram[bank][:]...)
+	}
+	return raw
+}
+
+// Adds a snapshot to the buffer, and drops the oldest snapshot if the buffer is full
+func (r *Rewind) add(s *formats.FullSnapshot) {
+	entry := &rewindEntry{
+		frame: r.frame,
+		cpu:   s.Cpu,
+		ula:   s.Ula,
+		raw:   rewindMemory(s),
+	}
+	if s.Machine128k != nil {
+		entry.is128k = true
+		entry.port7ffd = s.Machine128k.Port7ffd
+		entry.ay = s.Machine128k.Ay
+	}
+
+	if len(r.entries) > 0 {
+		newest := r.entries[len(r.entries)-1]
+		if len(newest.raw) == len(entry.raw) {
+			newest.delta = r.compressDelta(newest.raw, entry.raw)
+			newest.raw = nil
+		} else {
+			// The machine changed: the older snapshots cannot be restored anymore
+			r.entries = r.entries[0:0]
+		}
+	}
+	r.entries = append(r.entries, entry)
+
+	if len(r.entries) > r.capacity {
+		r.entries = r.entries[1:]
+
+		// Merge the input events preceding the oldest snapshot into 'r.initial'
+		oldest := r.entries[0].frame
+		n := 0
+		for (n < len(r.log)) && (r.log[n].frame < oldest) {
+			r.initial = r.log[n].state
+			n++
+		}
+		r.log = r.log[n:]
+		if r.replaying {
+			r.replayIndex -= n
+		}
+	}
+}
+
+// Returns the compressed XOR-delta of two memory images
+func (r *Rewind) compressDelta(older, newer []byte) []byte {
+	delta := make([]byte, len(older))
+	for i := range delta {
+		delta[i] = older[i] ^ newer[i]
+	}
+
+	var buf bytes.Buffer
+	if r.compressor == nil {
+		r.compressor, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		r.compressor.Reset(&buf)
+	}
+	r.compressor.Write(delta)
+	r.compressor.Close()
+
+	return buf.Bytes()
+}
+
+// Reconstructs the older memory image from the newer one and the compressed delta
+func applyDelta(newer []byte, delta []byte) ([]byte, error) {
+	older, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(delta)))
+	if err != nil {
+		return nil, err
+	}
+	if len(older) != len(newer) {
+		return nil, errors.New("corrupted rewind buffer")
+	}
+
+	for i := range older {
+		older[i] ^= newer[i]
+	}
+	return older, nil
+}
+
+// Returns the snapshot stored in entry 'i'
+func (r *Rewind) snapshot(i int) (*formats.FullSnapshot, error) {
+	raw := r.entries[len(r.entries)-1].raw
+	for j := len(r.entries) - 2; j >= i; j-- {
+		var err error
+		raw, err = applyDelta(raw, r.entries[j].delta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry := r.entries[i]
+	s := &formats.FullSnapshot{Cpu: entry.cpu, Ula: entry.ula}
+	if entry.is128k {
+		state := &formats.State128k{Port7ffd: entry.port7ffd, Ay: entry.ay}
+		for bank := 0; bank < NumRamBanks; bank++ {
+			copy(state.Ram[bank][:], raw[bank*0x4000:])
+		}
+		s.Machine128k = state
+	} else {
+		copy(s.Mem[:], raw)
+	}
+
+	return s, nil
+}
+
+// Returns the number of frames which can be rewound
+func (r *Rewind) available() uint {
+	if len(r.entries) == 0 {
+		return 0
+	}
+	return r.frame - r.entries[0].frame
+}
+
+// Moves the emulation 'frames' frames back in time. If the buffer does not
+// reach that far, the emulation moves to the oldest moment available.
+func (r *Rewind) rewind(frames uint) error {
+	if !r.enabled() {
+		return errors.New("rewinding is disabled")
+	}
+	if len(r.entries) == 0 {
+		return errors.New("the rewind buffer is empty")
+	}
+	if r.speccy.rzx.active() {
+		return errors.New("rewinding is not possible during an RZX recording or playback")
+	}
+
+	if frames > r.available() {
+		frames = r.available()
+	}
+	target := r.frame - frames
+
+	// The newest snapshot preceding the target
+	i := len(r.entries) - 1
+	for r.entries[i].frame > target {
+		i--
+	}
+
+	s, err := r.snapshot(i)
+	if err != nil {
+		return err
+	}
+	err = r.speccy.loadSnapshot(s)
+	if err != nil {
+		return err
+	}
+
+	// The newer snapshots will be taken again while replaying
+	entry := r.entries[i]
+	entry.raw = rewindMemory(s)
+	entry.delta = nil
+	r.entries = r.entries[0 : i+1]
+	r.frame = entry.frame
+
+	// The input state at the time of the snapshot
+	r.state = r.initial
+	r.replayIndex = 0
+	for (r.replayIndex < len(r.log)) && (r.log[r.replayIndex].frame < r.frame) {
+		r.state = r.log[r.replayIndex].state
+		r.replayIndex++
+	}
+
+	// Watchpoints hit while replaying are ignored
+	watchHit := r.speccy.debugger.watchHit
+
+	r.replaying = true
+	for r.frame < target {
+		r.speccy.runFrameQuietly()
+	}
+	r.replaying = false
+
+	r.speccy.debugger.watchHit = watchHit
+
+	// The next frame sent to the displays has to be complete
+	r.speccy.ula.frame = 0
+
+	// Forget the input which happened after the target
+	r.log = r.log[0:r.replayIndex]
+
+	return nil
+}
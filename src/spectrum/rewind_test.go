@@ -0,0 +1,148 @@
+package spectrum
+
+import (
+	"bytes"
+)
+
+func (t *testSuite) TestRewind_Delta() {
+	r := NewRewind()
+
+	older := make([]byte, 0xc000)
+	newer := make([]byte, 0xc000)
+	for i := range older {
+		older[i] = byte(i * 7)
+		newer[i] = older[i]
+	}
+	for i := 0x1000; i < 0x1100; i++ {
+		newer[i] = byte(i)
+	}
+
+	delta := r.compressDelta(older, newer)
+	t.True(len(delta) < 0x1000)
+
+	restored, err := applyDelta(newer, delta)
+	t.Nil(err)
+	t.True(bytes.Equal(older, restored))
+
+	// The compressor is reused
+	delta = r.compressDelta(newer, newer)
+	restored, err = applyDelta(newer, delta)
+	t.Nil(err)
+	t.True(bytes.Equal(newer, restored))
+
+	// The delta does not match a memory image of a different size
+	_, err = applyDelta(newer[0:0x4000], delta)
+	t.Not(t.Nil(err))
+}
+
+// A program which stores the values read from the keyboard port into memory at 0x9000 ... 0x90ff:
+//
+//	8000 loop: XOR A
+//	8001       IN A,(0xfe)
+//	8003       LD (HL),A
+//	8004       INC L
+//	8005       JR loop
+func newTestRewindSpectrum() (*Application, *Spectrum48k, error) {
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{{}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := newTestSnapshot()
+	s.Cpu.H, s.Cpu.L = 0x90, 0x00
+	copy(s.Mem[0x8000-0x4000:], []byte{0xaf, 0xdb, 0xfe, 0x77, 0x2c, 0x18, 0xf9})
+	err = loadTestProgram(speccy, s)
+	if err != nil {
+		exitTestSpectrum(app)
+		return nil, nil, err
+	}
+
+	return app, speccy, nil
+}
+
+func (t *testSuite) TestRewind_Capacity() {
+	app, speccy, err := newTestRewindSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.CommandChannel <- Cmd_SetRewind{Interval: 1, Capacity: 3}
+	t.Equal(uint(1), speccy.RunFrames(1))
+
+	r := speccy.rewind
+	start := r.frame
+
+	speccy.Keyboard.KeyDown(KEY_A)
+	speccy.RunFrames(1)
+	speccy.Keyboard.KeyDown(KEY_B)
+	aAndB := speccy.Keyboard.getKeyStates()
+	speccy.RunFrames(3)
+	speccy.Keyboard.KeyUp(KEY_B)
+	speccy.RunFrames(1)
+
+	// Three snapshots are kept, the older ones are dropped
+	t.Equal(start+5, r.frame)
+	t.Equal(3, len(r.entries))
+	t.Equal(start+3, r.entries[0].frame)
+	t.Equal(uint(2), r.available())
+
+	// Only the newest snapshot is stored uncompressed
+	for i, entry := range r.entries {
+		newest := (i == len(r.entries)-1)
+		t.Equal(newest, entry.raw != nil)
+		t.Equal(newest, entry.delta == nil)
+	}
+
+	// The input events preceding the oldest snapshot are merged into the initial state
+	t.Equal(aAndB, r.initial.keys)
+	t.Equal(1, len(r.log))
+	t.Equal(start+4, r.log[0].frame)
+	t.Equal(speccy.Keyboard.getKeyStates(), r.log[0].state.keys)
+}
+
+func (t *testSuite) TestRewind_Replay() {
+	app, speccy, err := newTestRewindSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.CommandChannel <- Cmd_SetRewind{Interval: 5, Capacity: 10}
+
+	// The key is pressed while the machine executes frames 8 ... 13
+	speccy.RunFrames(7)
+	speccy.Keyboard.KeyDown(KEY_Q)
+	speccy.RunFrames(2)
+	expected := speccy.MakeSnapshot()
+	speccy.RunFrames(4)
+	speccy.Keyboard.KeyUp(KEY_Q)
+	speccy.RunFrames(5)
+
+	// Rewinding restores the snapshot taken after 5 frames,
+	// and replays the recorded key press in the following 4 frames
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_Rewind{Seconds: 9 / speccy.model.fps, ErrChan: errChan}
+	t.Nil(<-errChan)
+
+	actual := speccy.MakeSnapshot()
+	t.Equal(expected.Cpu, actual.Cpu)
+	t.Equal(expected.Ula.Border, actual.Ula.Border)
+	t.True(expected.Mem == actual.Mem)
+
+	// The key press has been stored into memory
+	pressed := false
+	for _, b := range actual.Mem[0x9000-0x4000 : 0x9100-0x4000] {
+		if (b & 0x01) == 0 {
+			pressed = true
+		}
+	}
+	t.True(pressed)
+
+	// The buffer does not reach further than the oldest snapshot
+	speccy.CommandChannel <- Cmd_Rewind{Seconds: 100, ErrChan: errChan}
+	t.Nil(<-errChan)
+	t.Equal(uint(0), speccy.rewind.available())
+}
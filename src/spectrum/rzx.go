@@ -0,0 +1,226 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// The name of the emulator stored in RZX files
+const RZX_CREATOR = "GoSpeccy"
+
+// The maximum number of T-states by which a played back frame may be longer than
+// a frame of the emulated machine. The playback stops if a frame is longer.
+const RZX_MAX_FRAME_OVERRUN = 1000
+
+// Records the input of the emulated machine into an RZX file,
+// or plays back an RZX input recording.
+//
+// While recording, the values returned by IN instructions are stored frame by frame.
+// While playing back, IN instructions return the recorded values
+// instead of reading the keyboard, the joystick or the tape,
+// and each frame ends when the recorded number of opcode fetches is reached.
+type RZXRecorder struct {
+	speccy *Spectrum48k
+
+	recording bool
+	playing   bool
+
+	// The recording, nil if neither recording nor playing back
+	rzx *formats.RZX
+
+	// The file the recording is written to
+	path string
+
+	// The number of opcode fetches executed in the current frame
+	fetchCount uint
+
+	// The inputs of the current frame (recording)
+	inputs []byte
+
+	// The current frame and the next input of the frame (playback)
+	frame      int
+	inputIndex int
+}
+
+func NewRZXRecorder() *RZXRecorder {
+	return &RZXRecorder{}
+}
+
+func (r *RZXRecorder) init(speccy *Spectrum48k) {
+	r.speccy = speccy
+}
+
+// Returns true if the emulation loop needs to count opcode fetches
+func (r *RZXRecorder) active() bool {
+	return r.recording || r.playing
+}
+
+// Starts recording. The recording starts with a snapshot of the current state of the machine.
+func (r *RZXRecorder) startRecording(path string) error {
+	if r.active() {
+		return errors.New("an RZX recording or playback is already in progress")
+	}
+	if r.speccy.midFrame {
+		return errors.New("an RZX recording cannot start in the middle of a frame")
+	}
+
+	snapshot := r.speccy.MakeSnapshot()
+	TStatesPerFrame := r.speccy.model.timings.TStatesPerFrame
+
+	r.rzx = &formats.RZX{
+		Creator:  RZX_CREATOR,
+		Snapshot: snapshot,
+		TStates:  uint32(snapshot.Cpu.Tstate % uint(TStatesPerFrame)),
+	}
+	r.path = path
+	r.recording = true
+	r.fetchCount = 0
+	r.inputs = nil
+
+	return nil
+}
+
+// Stops recording and writes the recorded frames to the file.
+// An unfinished frame is not included in the recording.
+func (r *RZXRecorder) stopRecording() error {
+	if !r.recording {
+		return errors.New("no RZX recording is in progress")
+	}
+
+	rzx, path := r.rzx, r.path
+	r.recording = false
+	r.rzx = nil
+
+	data, err := rzx.Encode()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	if r.speccy.app.Verbose {
+		r.speccy.app.PrintfMsg("RZX recording \"%s\": %d frames", path, len(rzx.Frames))
+	}
+
+	return nil
+}
+
+// Loads the snapshot of the recording and starts playing back the recorded input
+func (r *RZXRecorder) startPlayback(rzx *formats.RZX) error {
+	if r.recording {
+		return errors.New("an RZX recording is in progress")
+	}
+
+	err := r.speccy.loadSnapshot(rzx.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	if len(rzx.Frames) > 0 {
+		r.rzx = rzx
+		r.playing = true
+		r.frame = 0
+		r.inputIndex = 0
+		r.fetchCount = 0
+	}
+
+	return nil
+}
+
+// Stops playing back and prints the reason
+func (r *RZXRecorder) stopPlayback(format string, a ...interface{}) {
+	r.playing = false
+	r.rzx = nil
+	r.speccy.app.PrintfMsg(format, a...)
+}
+
+// Called when the machine is reset or a program is loaded.
+// The recording ends and the playback stops.
+func (r *RZXRecorder) reset() {
+	if r.recording {
+		err := r.stopRecording()
+		if err != nil {
+			r.speccy.app.PrintfMsg("RZX recording: %s", err)
+		}
+	}
+	if r.playing {
+		r.stopPlayback("RZX playback stopped")
+	}
+}
+
+// Called by Ports for each IN instruction while recording
+func (r *RZXRecorder) recordInput(value byte) {
+	r.inputs = append(r.inputs, value)
+}
+
+// Returns the number of opcode fetches after which the current frame ends (playback)
+func (r *RZXRecorder) frameFetchCount() uint {
+	return uint(r.rzx.Frames[r.frame].FetchCount)
+}
+
+// Called by Ports for each IN instruction while playing back
+func (r *RZXRecorder) playbackInput() byte {
+	inputs := r.rzx.Frames[r.frame].Inputs
+	if r.inputIndex >= len(inputs) {
+		r.stopPlayback("RZX playback: desynchronized in frame %d", r.frame)
+		return 0xff
+	}
+
+	value := inputs[r.inputIndex]
+	r.inputIndex++
+	return value
+}
+
+// This function is called at the end of each frame
+func (r *RZXRecorder) frameEnd() {
+	if r.recording {
+		r.rzx.Frames = append(r.rzx.Frames, formats.RZXFrame{FetchCount: uint16(r.fetchCount), Inputs: r.inputs})
+		r.inputs = nil
+	}
+
+	if r.playing {
+		frame := &r.rzx.Frames[r.frame]
+		if (r.inputIndex != len(frame.Inputs)) || (r.fetchCount != uint(frame.FetchCount)) {
+			r.stopPlayback("RZX playback: desynchronized in frame %d", r.frame)
+			r.fetchCount = 0
+			return
+		}
+
+		r.frame++
+		r.inputIndex = 0
+		if r.frame == len(r.rzx.Frames) {
+			r.stopPlayback("RZX playback finished")
+		}
+	}
+
+	r.fetchCount = 0
+}
@@ -0,0 +1,58 @@
+package spectrum
+
+import (
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// The frames of the recording are not related to the length of a frame
+// of the emulated machine. Each frame has to end after the recorded number of opcode fetches.
+func (t *testSuite) TestRZXPlayback() {
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{{}})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	snapshot := &formats.FullSnapshot{}
+	snapshot.Cpu.PC = 0x9000
+	snapshot.Cpu.SP = 0xff00
+	snapshot.Cpu.H, snapshot.Cpu.L = 0x80, 0x00
+	snapshot.Cpu.IM = 1
+
+	// 4 opcode fetches and 1 input in each iteration:
+	//   loop: IN A,(0xfe)
+	//         LD (HL),A
+	//         INC HL
+	//         JR loop
+	copy(snapshot.Mem[0x9000-0x4000:], []byte{0xdb, 0xfe, 0x77, 0x23, 0x18, 0xfa})
+
+	var value byte = 0
+	inputs := func(n int) []byte {
+		values := make([]byte, n)
+		for i := range values {
+			value++
+			values[i] = value
+		}
+		return values
+	}
+
+	rzx := &formats.RZX{
+		Creator:  "test",
+		Snapshot: snapshot,
+		Frames: []formats.RZXFrame{
+			{FetchCount: 40, Inputs: inputs(10)},
+			{FetchCount: 8, Inputs: inputs(2)},
+			{FetchCount: 400, Inputs: inputs(100)},
+		},
+	}
+
+	t.Nil(loadTestProgram(speccy, rzx))
+	t.Equal(uint(3), speccy.RunFrames(3))
+
+	t.False(speccy.rzx.playing)
+	t.Equal(3, speccy.rzx.frame)
+	for i := 0; i < int(value); i++ {
+		t.Equal(byte(i+1), speccy.Memory.Read(uint16(0x8000+i)))
+	}
+}
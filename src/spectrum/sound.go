@@ -13,7 +13,14 @@ type AudioData struct {
 	// The FPS (frames per second) value that applies to this AudioData object
 	FPS float32
 
+	// The number of T-states in the frame this AudioData object covers.
+	// The T-state of the last beeper event equals to this value.
+	TStatesPerFrame int
+
 	BeeperEvents []BeeperEvent
+
+	// Data for the AY-3-8912 sound chip, nil if the machine has no such chip
+	AY *AYData
 }
 
 const MAX_AUDIO_LEVEL = 3
@@ -29,10 +29,13 @@ package spectrum
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	perf "github.com/remogatto/Go-PerfEvents"
+	"github.com/remogatto/gospeccy/src/disasm"
 	"github.com/remogatto/gospeccy/src/formats"
 	"github.com/remogatto/z80"
 )
@@ -71,14 +74,27 @@ type Spectrum48k struct {
 	Keyboard  *Keyboard
 	Joystick  *Joystick
 	tapeDrive *TapeDrive
+	debugger  *Debugger
+	tracer    *Tracer // Nil if no execution trace is active
+	rewind    *Rewind
+	rzx       *RZXRecorder
+
+	// The debugger stopped the emulation in the middle of the current frame
+	midFrame bool
+
+	// The AY-3-8912 sound chip, nil if the machine has no such chip
+	ay *AY
 
 	Ports *Ports
 
-	rom     [0x4000]byte
+	// The emulated machine model
+	model *machineModel
+
+	rom     [2][0x4000]byte // Only the first 'model.numRoms' images are used
 	romType RomType
 
 	// The current display refresh frequency.
-	// The initial value is 'DefaultFPS'.
+	// The initial value is the default FPS of the machine model.
 	// It is always greater than 0.
 	currentFPS       float32
 	currentFPS_mutex sync.Mutex // To respect the Go memory model
@@ -183,6 +199,146 @@ type Cmd_SetAcceleratedLoad struct {
 	// Set accelerated tape load on/off
 	Enable bool
 }
+type Cmd_SetInstantLoad struct {
+	// Set instant tape load (ROM trap) on/off
+	Enable bool
+}
+type Cmd_SetInstantSave struct {
+	// Set instant tape save (ROM trap) on/off
+	Enable bool
+}
+
+// Continues playing a tape which has been stopped by the tape itself,
+// such as by a TZX stop-the-tape block
+type Cmd_TapeResume struct{}
+type Cmd_MakeSavedTape struct {
+	// Receives a copy of the blocks saved by the emulated machine
+	Chan chan<- *formats.TAP
+}
+type Cmd_ClearSavedTape struct{}
+
+// Adds (Enable=true) or removes (Enable=false) a PC breakpoint
+type Cmd_SetBreakpoint struct {
+	Address uint16
+	Enable  bool
+}
+
+// Stops the emulation
+type Cmd_DebuggerPause struct{}
+
+// Resumes the emulation stopped by the debugger
+type Cmd_DebuggerContinue struct{}
+
+// Executes a single instruction. If 'Over' is true, calls and loops are executed as a whole.
+type Cmd_DebuggerStep struct {
+	Over bool
+}
+
+// Resumes the emulation, and stops it when PC reaches 'Address'
+type Cmd_DebuggerRunTo struct {
+	Address uint16
+}
+
+// Adds a memory watchpoint. A watchpoint with Access=0 removes the watchpoint with the same range.
+type Cmd_SetWatchpoint struct {
+	Watchpoint Watchpoint
+}
+
+// Adds a port breakpoint. A port breakpoint with Access=0 removes the port breakpoint with the same port and mask.
+type Cmd_SetPortBreakpoint struct {
+	PortBreakpoint PortBreakpoint
+}
+
+type Cmd_DebuggerState struct {
+	Chan chan<- DebuggerState
+}
+
+// Writes 'Data' into the memory starting at 'Address'. The ROM can be modified as well.
+type Cmd_WriteMemory struct {
+	Address uint16
+	Data    []byte
+}
+
+// Starts writing an execution trace. An active trace is stopped first.
+type Cmd_TraceStart struct {
+	Config  TraceConfig
+	ErrChan chan<- error
+}
+
+// Stops the execution trace and closes the trace file
+type Cmd_TraceStop struct {
+	ErrChan chan<- error
+}
+
+// Executes 'N' frames without waiting for the wall clock, see 'RunFrames'
+type Cmd_RunFrames struct {
+	N    uint
+	Chan chan<- uint
+}
+
+// Executes frames until 'Predicate' returns true, see 'RunUntil'
+type Cmd_RunUntil struct {
+	Predicate func(speccy *Spectrum48k) bool
+	MaxFrames uint
+	Chan      chan<- bool
+}
+
+// Moves the emulation 'Seconds' seconds back in time.
+// The error is printed if 'ErrChan' is nil.
+type Cmd_Rewind struct {
+	Seconds float32
+	ErrChan chan<- error
+}
+
+// Configures the rewind buffer: a snapshot is taken every 'Interval' frames,
+// and at most 'Capacity' snapshots are kept. An interval of zero disables rewinding.
+type Cmd_SetRewind struct {
+	Interval uint
+	Capacity uint
+}
+
+// Starts recording the input into an RZX file
+type Cmd_RZXRecord struct {
+	Path    string
+	ErrChan chan<- error
+}
+
+// Stops the RZX recording, and writes the RZX file. Also stops the RZX playback.
+type Cmd_RZXStop struct {
+	ErrChan chan<- error
+}
+
+// Disassembles 'Count' instructions starting at 'Address'
+type Cmd_Disassemble struct {
+	Address uint16
+	Count   uint
+	Chan    chan<- []disasm.Instruction
+}
+
+// Sets the issue of the emulated board (2 or 3), which determines bit 6 of port 0xfe
+type Cmd_SetIssue struct {
+	Issue   uint
+	ErrChan chan<- error
+}
+
+// Sets the visible part of the border sent to display receivers.
+// Display receivers added before the command may need to be re-added.
+type Cmd_SetBorder struct {
+	Border  Border
+	ErrChan chan<- error
+}
+
+// Connects a device to the I/O bus
+type Cmd_AddDevice struct {
+	Device  Device
+	ErrChan chan<- error
+}
+
+// Disconnects a device from the I/O bus
+type Cmd_RemoveDevice struct {
+	Device  Device
+	ErrChan chan<- error
+}
 
 // Creates a new speccy object and starts its command-loop goroutine.
 //
@@ -193,6 +349,29 @@ type Cmd_SetAcceleratedLoad struct {
 // To start the actual emulation-loop, create a separate goroutine for
 // running the object's EmulatorLoop function.
 func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
+	return newSpectrum(app, model_48k, [][0x4000]byte{rom})
+}
+
+// Creates a new ZX Spectrum 128k and starts its command-loop goroutine.
+//
+// The 'rom0' image is the 128k editor, the 'rom1' image is the 48k BASIC.
+// The returned object is used in the same way as the object returned by NewSpectrum48k.
+func NewSpectrum128k(app *Application, rom0, rom1 [0x4000]byte) *Spectrum48k {
+	return newSpectrum(app, model_128k, [][0x4000]byte{rom0, rom1})
+}
+
+// Creates a new machine of the specified type.
+// The number of ROM images has to match the machine type, see RomFilenames.
+func NewSpectrum(app *Application, machineType MachineType, roms [][0x4000]byte) (*Spectrum48k, error) {
+	model := getMachineModel(machineType)
+	if len(roms) != model.numRoms {
+		return nil, errors.New("the " + model.name + " machine requires " + strconv.Itoa(model.numRoms) + " ROM image(s)")
+	}
+
+	return newSpectrum(app, model, roms), nil
+}
+
+func newSpectrum(app *Application, model *machineModel, roms [][0x4000]byte) *Spectrum48k {
 	memory := NewMemory()
 	keyboard := NewKeyboard()
 	joystick := NewJoystick()
@@ -201,6 +380,9 @@ func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
 	ula := NewULA()
 
 	tapeDrive := NewTapeDrive()
+	debugger := NewDebugger()
+	rewind := NewRewind()
+	rzx := NewRZXRecorder()
 
 	speccy := &Spectrum48k{
 		Cpu:            z80,
@@ -209,26 +391,39 @@ func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
 		Keyboard:       keyboard,
 		Joystick:       joystick,
 		Ports:          ports,
-		rom:            rom,
+		model:          model,
 		romType:        ROM_UNKNOWN,
 		displays:       make([]*DisplayInfo, 0),
 		audioReceivers: make([]AudioReceiver, 0),
 		app:            app,
 		tapeDrive:      tapeDrive,
+		debugger:       debugger,
+		rewind:         rewind,
+		rzx:            rzx,
+	}
+
+	copy(speccy.rom[:], roms)
+
+	if model.ay {
+		speccy.ay = NewAY()
+		speccy.ay.init(speccy)
 	}
 
 	memory.init(speccy)
 	keyboard.init(speccy)
 	joystick.init(speccy)
-	ula.init(z80, memory, ports)
+	ula.init(z80, memory, ports, model)
 	ports.init(speccy)
 	tapeDrive.init(speccy)
+	debugger.init(speccy)
+	rewind.init(speccy)
+	rzx.init(speccy)
 
 	speccy.reset(nil)
 
-	speccy.currentFPS = DefaultFPS
+	speccy.currentFPS = model.fps
 	speccy.fpsCh = make(chan float32, 1)
-	speccy.fpsCh <- DefaultFPS
+	speccy.fpsCh <- model.fps
 
 	commandChannel := make(chan interface{})
 	speccy.CommandChannel = commandChannel
@@ -273,9 +468,13 @@ func (speccy *Spectrum48k) load(program interface{}) error {
 	switch program := program.(type) {
 
 	case formats.Snapshot:
-		speccy.loadSnapshot(program.(formats.Snapshot))
+		err = speccy.loadSnapshot(program.(formats.Snapshot))
 	case *formats.TAP:
-		speccy.loadTape(program)
+		speccy.loadTape(NewTape(program))
+	case *formats.TZX:
+		speccy.loadTape(NewTZXTape(program))
+	case *formats.RZX:
+		err = speccy.rzx.startPlayback(program)
 	default:
 		err = errors.New("Invalid program type.")
 		return err
@@ -284,11 +483,43 @@ func (speccy *Spectrum48k) load(program interface{}) error {
 	return err
 }
 
+// Returns the type of the emulated machine
+func (speccy *Spectrum48k) MachineType() MachineType {
+	return speccy.model.machineType
+}
+
+// Returns the timings of the emulated machine
+func (speccy *Spectrum48k) Timings() MachineTimings {
+	return speccy.model.timings
+}
+
 // Return the TapeDrive instance
 func (speccy *Spectrum48k) TapeDrive() *TapeDrive {
 	return speccy.tapeDrive
 }
 
+// Executes 'n' frames as fast as the host allows.
+// Returns the number of finished frames, which is less than 'n' if the debugger stopped the emulation.
+//
+// Together with 'RunUntil', this function drives the emulation in a deterministic way
+// when 'EmulatorLoop' is not running, for example in tests or in batch processing.
+func (speccy *Spectrum48k) RunFrames(n uint) uint {
+	ch := make(chan uint)
+	speccy.CommandChannel <- Cmd_RunFrames{N: n, Chan: ch}
+	return <-ch
+}
+
+// Executes frames as fast as the host allows, until the predicate returns true.
+// The predicate is evaluated before the first frame and after each frame,
+// within the goroutine executing the emulation, so it can safely inspect the machine.
+// If 'maxFrames' is not zero, at most 'maxFrames' frames are executed.
+// Returns true if the predicate returned true.
+func (speccy *Spectrum48k) RunUntil(predicate func(speccy *Spectrum48k) bool, maxFrames uint) bool {
+	ch := make(chan bool)
+	speccy.CommandChannel <- Cmd_RunUntil{Predicate: predicate, MaxFrames: maxFrames, Chan: ch}
+	return <-ch
+}
+
 // Sends 'Cmd_RenderFrame' commands to the 'speccy' object in regular intervals.
 // The interval depends on the value of FPS (frames per second).
 //
@@ -385,16 +616,17 @@ func commandLoop(speccy *Spectrum48k) {
 				speccy.reset(cmd.SystemROMLoaded_orNil)
 
 			case Cmd_RenderFrame:
-				// Ugly hack to check whenever the system ROM has been loaded after a reset.
-				// I bet this won't work with custom ROMs.
-				if (speccy.Cpu.PC() == 0x10ac) && (speccy.systemROMLoaded_orNil != nil) {
-					// Note: This is a buffered channel, so the send won't block
-					speccy.systemROMLoaded_orNil <- true
-					speccy.systemROMLoaded_orNil = nil
-				}
-
+				speccy.checkSystemROMLoaded()
 				speccy.renderFrame(cmd.CompletionTime_orNil)
 
+			case Cmd_RunFrames:
+				frames, _ := speccy.runFrames(cmd.N, nil)
+				cmd.Chan <- frames
+
+			case Cmd_RunUntil:
+				_, satisfied := speccy.runFrames(cmd.MaxFrames, cmd.Predicate)
+				cmd.Chan <- satisfied
+
 			case Cmd_GetNumDisplayReceivers:
 				cmd.N <- uint(len(speccy.displays))
 
@@ -416,7 +648,7 @@ func commandLoop(speccy *Spectrum48k) {
 
 					newFPS := cmd.NewFPS
 					if newFPS <= 1.0 {
-						newFPS = DefaultFPS
+						newFPS = speccy.model.fps
 					}
 
 					if newFPS != speccy.currentFPS {
@@ -483,6 +715,120 @@ func commandLoop(speccy *Spectrum48k) {
 			case Cmd_SetAcceleratedLoad:
 				speccy.tapeDrive.AcceleratedLoad = cmd.Enable
 
+			case Cmd_SetInstantLoad:
+				speccy.tapeDrive.InstantLoad = cmd.Enable
+
+			case Cmd_SetInstantSave:
+				speccy.tapeDrive.InstantSave = cmd.Enable
+
+			case Cmd_TapeResume:
+				speccy.tapeDrive.Resume()
+
+			case Cmd_MakeSavedTape:
+				cmd.Chan <- speccy.tapeDrive.makeSavedTape()
+
+			case Cmd_ClearSavedTape:
+				speccy.tapeDrive.savedTape = formats.TAP{}
+
+			case Cmd_SetBreakpoint:
+				speccy.debugger.setBreakpoint(cmd.Address, cmd.Enable)
+
+			case Cmd_SetWatchpoint:
+				speccy.debugger.setWatchpoint(cmd.Watchpoint)
+
+			case Cmd_SetPortBreakpoint:
+				speccy.debugger.setPortBreakpoint(cmd.PortBreakpoint)
+
+			case Cmd_DebuggerPause:
+				if !speccy.debugger.paused {
+					speccy.debugger.stop(DEBUGGER_STOP_PAUSE)
+				}
+
+			case Cmd_DebuggerContinue:
+				if speccy.debugger.paused {
+					speccy.debugger.resume(false)
+				}
+
+			case Cmd_DebuggerStep:
+				if cmd.Over {
+					speccy.debugger.stepOver()
+				} else {
+					speccy.debugger.step()
+				}
+
+			case Cmd_DebuggerRunTo:
+				speccy.debugger.runTo(cmd.Address)
+
+			case Cmd_DebuggerState:
+				cmd.Chan <- speccy.debugger.state()
+
+			case Cmd_WriteMemory:
+				for i, b := range cmd.Data {
+					speccy.Memory.Write(cmd.Address+uint16(i), b, false)
+				}
+
+			case Cmd_TraceStart:
+				var err error
+				if speccy.tracer != nil {
+					err = speccy.stopTrace()
+				}
+				if err == nil {
+					speccy.tracer, err = newTracer(speccy, cmd.Config)
+				}
+				cmd.ErrChan <- err
+
+			case Cmd_TraceStop:
+				if speccy.tracer != nil {
+					cmd.ErrChan <- speccy.stopTrace()
+				} else {
+					cmd.ErrChan <- errors.New("no trace is active")
+				}
+
+			case Cmd_Rewind:
+				var err error
+				if cmd.Seconds > 0 {
+					err = speccy.rewind.rewind(uint(cmd.Seconds*speccy.model.fps + 0.5))
+				} else {
+					err = errors.New("invalid rewind time")
+				}
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				} else if err != nil {
+					speccy.app.PrintfMsg("rewind: %s", err)
+				}
+
+			case Cmd_SetRewind:
+				speccy.rewind.configure(cmd.Interval, cmd.Capacity)
+
+			case Cmd_RZXRecord:
+				cmd.ErrChan <- speccy.rzx.startRecording(cmd.Path)
+
+			case Cmd_RZXStop:
+				switch {
+				case speccy.rzx.recording:
+					cmd.ErrChan <- speccy.rzx.stopRecording()
+				case speccy.rzx.playing:
+					speccy.rzx.stopPlayback("RZX playback stopped")
+					cmd.ErrChan <- nil
+				default:
+					cmd.ErrChan <- errors.New("no RZX recording or playback is in progress")
+				}
+
+			case Cmd_Disassemble:
+				cmd.Chan <- disasm.DisassembleN(speccy.Memory, cmd.Address, int(cmd.Count))
+
+			case Cmd_SetIssue:
+				cmd.ErrChan <- speccy.ula.setIssue(cmd.Issue)
+
+			case Cmd_SetBorder:
+				cmd.ErrChan <- speccy.ula.setBorder(cmd.Border)
+
+			case Cmd_AddDevice:
+				cmd.ErrChan <- speccy.Ports.addDevice(cmd.Device)
+
+			case Cmd_RemoveDevice:
+				cmd.ErrChan <- speccy.Ports.removeDevice(cmd.Device)
+
 			}
 		}
 	}
@@ -494,6 +840,8 @@ func (speccy *Spectrum48k) reset(systemROMLoaded_orNil chan<- <-chan bool) error
 	speccy.ula.reset()
 	speccy.Keyboard.reset()
 	speccy.Ports.reset()
+	speccy.rzx.reset()
+	speccy.midFrame = false
 
 	if speccy.systemROMLoaded_orNil != nil {
 		speccy.systemROMLoaded_orNil <- false
@@ -505,11 +853,13 @@ func (speccy *Spectrum48k) reset(systemROMLoaded_orNil chan<- <-chan bool) error
 		systemROMLoaded_orNil <- speccy.systemROMLoaded_orNil
 	}
 
-	// Copy the ROM image into the first 16k of memory
-	copy(speccy.Memory.Data()[0:0x4000], speccy.rom[:])
+	// Copy the ROM images into memory
+	for i := 0; i < speccy.model.numRoms; i++ {
+		speccy.Memory.loadRom(i, &speccy.rom[i])
+	}
 
-	// ROM type detection
-	if bytes.Contains(speccy.rom[:], []byte("1981 Nine Tiles Networks")) {
+	// ROM type detection (the last ROM image contains the 48k BASIC)
+	if bytes.Contains(speccy.rom[speccy.model.numRoms-1][:], []byte("1981 Nine Tiles Networks")) {
 		speccy.romType = ROM_OPENSE
 	}
 
@@ -522,6 +872,18 @@ func (speccy *Spectrum48k) reset(systemROMLoaded_orNil chan<- <-chan bool) error
 	return nil
 }
 
+// Number of frames it takes the 128k ROM to test the memory and display the main menu
+const romInitFrames_128k = 100
+
+// Returns true if it appears that the system ROM has finished initializing the machine
+func (speccy *Spectrum48k) systemROMLoaded() bool {
+	switch speccy.model.machineType {
+	case MACHINE_128K:
+		return speccy.ula.frame >= romInitFrames_128k
+	}
+	return speccy.Cpu.PC() == 0x10ac
+}
+
 func (speccy *Spectrum48k) addDisplay(display DisplayReceiver) {
 	d := &DisplayInfo{
 		displayReceiver: display,
@@ -572,6 +934,17 @@ func (speccy *Spectrum48k) GetEmulationEfficiency() uint {
 }
 
 func (speccy *Spectrum48k) close() {
+	if speccy.tracer != nil {
+		speccy.stopTrace()
+	}
+
+	if speccy.rzx.recording {
+		err := speccy.rzx.stopRecording()
+		if err != nil {
+			speccy.app.PrintfMsg("RZX recording: %s", err)
+		}
+	}
+
 	if speccy.perfCounter_hostCpuInstr != nil {
 		speccy.perfCounter_hostCpuInstr.Close()
 		speccy.perfCounter_hostCpuInstr = nil
@@ -580,7 +953,35 @@ func (speccy *Spectrum48k) close() {
 
 // Initializes state from the specified snapshot.
 // Returns nil on success.
+// Returns an error if the snapshot cannot be loaded into this machine
+func (speccy *Spectrum48k) checkSnapshot(s formats.Snapshot) error {
+	ula := s.UlaState()
+
+	if (ula.Issue != 0) && (ula.Issue != 2) && (ula.Issue != 3) {
+		return fmt.Errorf("invalid board issue %d, expected 2 or 3", ula.Issue)
+	}
+
+	if (ula.Timex != nil) && !speccy.model.scld {
+		return errors.New("the snapshot requires a Timex machine")
+	}
+
+	if s128k, ok := s.(formats.Snapshot128k); ok && (s128k.State128k() != nil) {
+		if !speccy.model.paging {
+			return errors.New("the snapshot requires a 128k machine")
+		}
+	}
+
+	return nil
+}
+
+// Loads the snapshot. If the snapshot cannot be loaded,
+// an error is returned and the state of the machine is not modified.
 func (speccy *Spectrum48k) loadSnapshot(s formats.Snapshot) error {
+	err := speccy.checkSnapshot(s)
+	if err != nil {
+		return err
+	}
+
 	speccy.reset(nil)
 
 	cpu := s.CpuState()
@@ -623,57 +1024,144 @@ func (speccy *Spectrum48k) loadSnapshot(s formats.Snapshot) error {
 	// Border color
 	speccy.Ports.WritePortInternal(0xfe, ula.Border&0x07, false /*contend*/)
 
-	// Populate memory
-	copy(speccy.Memory.Data()[0x4000:], mem[:])
+	// Board issue, if the snapshot records it
+	if ula.Issue != 0 {
+		speccy.ula.setIssue(uint(ula.Issue))
+	}
+
+	// Timex SCLD
+	if ula.Timex != nil {
+		speccy.ula.setSCLD(ula.Timex.PortFF)
+	}
+
+	// ULAplus palette, if the snapshot records it
+	if ula.ULAplus != nil {
+		speccy.ula.ulaplus.register = ula.ULAplus.Register
+		speccy.ula.ulaplus.palette = ula.ULAplus.Palette
+		speccy.ula.setULAplusEnabled(ula.ULAplus.Enabled)
+	}
+
+	var state128k *formats.State128k
+	if s128k, ok := s.(formats.Snapshot128k); ok {
+		state128k = s128k.State128k()
+	}
+
+	if state128k != nil {
+		speccy.Memory.writePort7ffd(state128k.Port7ffd)
+
+		// Populate memory
+		for bank := 0; bank < NumRamBanks; bank++ {
+			copy(speccy.Memory.RamBank(bank)[:], state128k.Ram[bank][:])
+		}
+
+		// Sound chip
+		for reg := 0; reg < AY_NUM_REGISTERS; reg++ {
+			speccy.ay.writeRegister(byte(reg), state128k.Ay.Registers[reg])
+		}
+		speccy.ay.selectRegister(state128k.Ay.SelectedRegister)
+	} else {
+		// A 48k snapshot runs on the 128k machine in 48k mode: 48k BASIC ROM, paging locked
+		if speccy.model.paging {
+			speccy.Memory.writePort7ffd(0x30)
+		}
+
+		// Populate memory
+		for page := 1; page < 4; page++ {
+			copy(speccy.Memory.pages[page][:], mem[(page-1)*0x4000:])
+		}
+	}
 
 	speccy.Cpu.Tstates = int(cpu.Tstate)
+	speccy.Cpu.Halted = cpu.Halted
 
 	return nil
 }
 
+// Stops the execution trace and closes the trace file
+func (speccy *Spectrum48k) stopTrace() error {
+	err := speccy.tracer.close()
+	speccy.tracer = nil
+	return err
+}
+
+// Returns the state of the Z80 CPU
+func (speccy *Spectrum48k) cpuState() formats.CpuState {
+	var cpu formats.CpuState
+
+	cpu.A = speccy.Cpu.A
+	cpu.F = speccy.Cpu.F
+	cpu.B = speccy.Cpu.B
+	cpu.C = speccy.Cpu.C
+	cpu.D = speccy.Cpu.D
+	cpu.E = speccy.Cpu.E
+	cpu.H = speccy.Cpu.H
+	cpu.L = speccy.Cpu.L
+	cpu.A_ = speccy.Cpu.A_
+	cpu.F_ = speccy.Cpu.F_
+	cpu.B_ = speccy.Cpu.B_
+	cpu.C_ = speccy.Cpu.C_
+	cpu.D_ = speccy.Cpu.D_
+	cpu.E_ = speccy.Cpu.E_
+	cpu.H_ = speccy.Cpu.H_
+	cpu.L_ = speccy.Cpu.L_
+	cpu.IX = uint16(speccy.Cpu.IXL) | (uint16(speccy.Cpu.IXH) << 8)
+	cpu.IY = uint16(speccy.Cpu.IYL) | (uint16(speccy.Cpu.IYH) << 8)
+
+	cpu.I = speccy.Cpu.I
+	cpu.IFF1 = speccy.Cpu.IFF1
+	cpu.IFF2 = speccy.Cpu.IFF2
+	cpu.IM = speccy.Cpu.IM
+
+	cpu.R = byte(speccy.Cpu.R&0x7f) | (speccy.Cpu.R7 & 0x80)
+
+	cpu.SP = speccy.Cpu.SP()
+	cpu.PC = speccy.Cpu.PC()
+
+	cpu.Tstate = uint(speccy.Cpu.Tstates)
+	cpu.Halted = speccy.Cpu.Halted
+
+	return cpu
+}
+
 func (speccy *Spectrum48k) MakeSnapshot() *formats.FullSnapshot {
 	var s formats.FullSnapshot
 
-	// Save registers
-	s.Cpu.A = speccy.Cpu.A
-	s.Cpu.F = speccy.Cpu.F
-	s.Cpu.B = speccy.Cpu.B
-	s.Cpu.C = speccy.Cpu.C
-	s.Cpu.D = speccy.Cpu.D
-	s.Cpu.E = speccy.Cpu.E
-	s.Cpu.H = speccy.Cpu.H
-	s.Cpu.L = speccy.Cpu.L
-	s.Cpu.A_ = speccy.Cpu.A_
-	s.Cpu.F_ = speccy.Cpu.F_
-	s.Cpu.B_ = speccy.Cpu.B_
-	s.Cpu.C_ = speccy.Cpu.C_
-	s.Cpu.D_ = speccy.Cpu.D_
-	s.Cpu.E_ = speccy.Cpu.E_
-	s.Cpu.H_ = speccy.Cpu.H_
-	s.Cpu.L_ = speccy.Cpu.L_
-	s.Cpu.IX = uint16(speccy.Cpu.IXL) | (uint16(speccy.Cpu.IXH) << 8)
-	s.Cpu.IY = uint16(speccy.Cpu.IYL) | (uint16(speccy.Cpu.IYH) << 8)
-
-	s.Cpu.I = speccy.Cpu.I
-	s.Cpu.IFF1 = speccy.Cpu.IFF1
-	s.Cpu.IFF2 = speccy.Cpu.IFF2
-	s.Cpu.IM = speccy.Cpu.IM
-
-	s.Cpu.R = byte(speccy.Cpu.R&0x7f) | (speccy.Cpu.R7 & 0x80)
-
-	s.Cpu.SP = speccy.Cpu.SP()
-	s.Cpu.PC = speccy.Cpu.PC()
+	s.Cpu = speccy.cpuState()
 
 	// Border color
 	s.Ula.Border = speccy.ula.getBorderColor() & 0x07
+	s.Ula.Issue = speccy.ula.issue
+	s.Ula.ULAplus = &formats.ULAplusState{
+		Enabled:  speccy.ula.ulaplus.enabled,
+		Register: speccy.ula.ulaplus.register,
+		Palette:  speccy.ula.ulaplus.palette,
+	}
+	if speccy.model.scld {
+		s.Ula.Timex = &formats.TimexState{PortFF: speccy.ula.scld}
+	}
 
-	// Memory
-	copy(s.Mem[:], speccy.Memory.Data()[0x4000:])
+	// Memory (the 48k visible to the Z80)
+	for page := 1; page < 4; page++ {
+		copy(s.Mem[(page-1)*0x4000:], speccy.Memory.pages[page][:])
+	}
+
+	if speccy.model.paging {
+		state := new(formats.State128k)
+		state.Port7ffd = speccy.Memory.Port7ffd()
+		for bank := 0; bank < NumRamBanks; bank++ {
+			state.Ram[bank] = *speccy.Memory.RamBank(bank)
+		}
+		state.Ay.SelectedRegister = speccy.ay.selectedRegister
+		state.Ay.Registers = speccy.ay.Registers()
+		s.Machine128k = state
+	}
 
 	return &s
 }
 
-func (speccy *Spectrum48k) doOpcodes() {
+// Executes instructions until the end of the current frame.
+// Returns false if the debugger stopped the emulation before the end of the frame.
+func (speccy *Spectrum48k) doOpcodes() (frameFinished bool) {
 	var ttid_start int
 	if speccy.perfCounter_hostCpuInstr != nil {
 		ttid_start = speccy.perfCounter_hostCpuInstr.Gettid()
@@ -689,6 +1177,8 @@ func (speccy *Spectrum48k) doOpcodes() {
 
 	var z80_localInstructionCounter uint = 0
 
+	frameFinished = true
+
 	// Main instruction emulation loop
 	{
 		var readFromTape bool = (speccy.readFromTape && (speccy.shouldPlayTheTape > 0) && (speccy.tapeDrive != nil))
@@ -704,10 +1194,58 @@ func (speccy *Spectrum48k) doOpcodes() {
 			}
 		}
 
-		for (speccy.Cpu.Tstates < speccy.Cpu.EventNextEvent) && !speccy.Cpu.Halted {
+		var instantLoad bool = (speccy.readFromTape && (speccy.tapeDrive != nil) && speccy.tapeDrive.InstantLoad)
+		var instantSave bool = ((speccy.tapeDrive != nil) && speccy.tapeDrive.InstantSave)
+
+		// The debugger costs nothing if there are no breakpoints and no single-stepping
+		// Replaying the input log after rewinding is neither debugged nor traced.
+		var debug bool = speccy.debugger.active() && !speccy.rewind.replaying
+
+		// Likewise, tracing costs nothing if there is no active trace
+		var trace bool = (speccy.tracer != nil) && !speccy.rewind.replaying
+
+		// RZX recordings count opcode fetches
+		var rzx bool = speccy.rzx.active()
+
+		// While playing back an RZX recording, the frame ends
+		// when the recorded number of opcode fetches is reached
+		var rzxPlayback bool = speccy.rzx.playing
+		var frameEnd int = speccy.Cpu.EventNextEvent
+		var fetchLimit uint = 0
+		if rzxPlayback {
+			frameEnd = speccy.model.timings.TStatesPerFrame + RZX_MAX_FRAME_OVERRUN
+			fetchLimit = speccy.rzx.frameFetchCount()
+		}
+
+		for (speccy.Cpu.Tstates < frameEnd) && !(rzxPlayback && (speccy.rzx.fetchCount >= fetchLimit)) && !speccy.Cpu.Halted {
+			if debug && speccy.debugger.check(speccy.Cpu.PC()) {
+				frameFinished = false
+				break
+			}
+
+			if instantLoad || instantSave {
+				switch speccy.Cpu.PC() {
+				case ROM_LD_BYTES:
+					if instantLoad && speccy.tapeDrive.trapLoad() {
+						continue
+					}
+				case ROM_SA_BYTES:
+					if instantSave && speccy.tapeDrive.trapSave() {
+						continue
+					}
+				}
+			}
+
+			if trace {
+				speccy.tracer.instruction()
+			}
+
 			speccy.Memory.ContendRead(speccy.Cpu.PC(), 4)
 			opcode := speccy.Memory.ReadByteInternal(speccy.Cpu.PC())
 
+			var R uint16 = speccy.Cpu.R
+			var ldRA bool = rzx && (opcode == 0xed) && (speccy.Memory.ReadByteInternal(speccy.Cpu.PC()+1) == 0x4f)
+
 			speccy.Cpu.R = (speccy.Cpu.R + 1) & 0x7f
 			speccy.Cpu.IncPC(1)
 
@@ -715,6 +1253,15 @@ func (speccy *Spectrum48k) doOpcodes() {
 
 			z80.OpcodesMap[opcode](speccy.Cpu)
 
+			if rzx {
+				// Each opcode fetch increments the R register, except that LD R,A overwrites it
+				if ldRA {
+					speccy.rzx.fetchCount += 2
+				} else {
+					speccy.rzx.fetchCount += uint((speccy.Cpu.R - R) & 0x7f)
+				}
+			}
+
 			if readFromTape {
 				endOfBlock := speccy.tapeDrive.doPlay()
 				if endOfBlock {
@@ -725,18 +1272,35 @@ func (speccy *Spectrum48k) doOpcodes() {
 			}
 		}
 
-		if speccy.Cpu.Halted {
+		if speccy.Cpu.Halted && frameFinished {
 			speccy.shouldPlayTheTape = 0
 			if speccy.tapeDrive != nil {
 				speccy.tapeDrive.decelerate()
 			}
 
-			// Repeat emulating the HALT instruction until 'speccy.Cpu.eventNextEvent'
-			for speccy.Cpu.Tstates < speccy.Cpu.EventNextEvent {
+			// Repeat emulating the HALT instruction until the end of the frame
+			for (speccy.Cpu.Tstates < frameEnd) && !(rzxPlayback && (speccy.rzx.fetchCount >= fetchLimit)) {
+				if debug && speccy.debugger.check(speccy.Cpu.PC()) {
+					frameFinished = false
+					break
+				}
+
 				speccy.Memory.ContendRead(speccy.Cpu.PC(), 4)
 
 				speccy.Cpu.R = (speccy.Cpu.R + 1) & 0x7f
 				z80_localInstructionCounter++
+				if rzx {
+					speccy.rzx.fetchCount++
+				}
+			}
+		}
+
+		// A played back frame which is shorter than a frame of the emulated machine
+		// is followed by the interrupt at the beginning of the next frame
+		if rzxPlayback && frameFinished {
+			TStatesPerFrame := speccy.model.timings.TStatesPerFrame
+			if speccy.Cpu.Tstates < TStatesPerFrame {
+				speccy.Cpu.Tstates = TStatesPerFrame
 			}
 		}
 	}
@@ -780,17 +1344,104 @@ func (speccy *Spectrum48k) doOpcodes() {
 		}
 	}
 
+	return frameFinished
+}
+
+// Ugly hack to check whenever the system ROM has been loaded after a reset.
+// I bet this won't work with custom ROMs.
+func (speccy *Spectrum48k) checkSystemROMLoaded() {
+	if (speccy.systemROMLoaded_orNil != nil) && speccy.systemROMLoaded() {
+		// Note: This is a buffered channel, so the send won't block
+		speccy.systemROMLoaded_orNil <- true
+		speccy.systemROMLoaded_orNil = nil
+	}
+}
+
+// Executes frames back to back, without waiting for the wall clock.
+// Without a predicate, 'maxFrames' frames are executed. With a predicate, the execution
+// stops when the predicate returns true, or after 'maxFrames' frames if 'maxFrames' is not zero.
+// The execution also stops when the debugger stops the emulation.
+// Returns the number of finished frames, and whether the predicate returned true.
+func (speccy *Spectrum48k) runFrames(maxFrames uint, predicate func(speccy *Spectrum48k) bool) (uint, bool) {
+	var frames uint = 0
+	for {
+		if (predicate != nil) && predicate(speccy) {
+			return frames, true
+		}
+		if ((predicate == nil) || (maxFrames > 0)) && (frames >= maxFrames) {
+			return frames, false
+		}
+		if speccy.debugger.paused {
+			return frames, false
+		}
+
+		speccy.checkSystemROMLoaded()
+		speccy.runFrame(nil)
+
+		// A frame interrupted by the debugger is finished after the emulation is resumed
+		if !speccy.midFrame {
+			frames++
+		}
+	}
 }
 
 func (speccy *Spectrum48k) renderFrame(completionTime_orNil chan<- time.Time) {
+	if speccy.debugger.paused {
+		if completionTime_orNil != nil {
+			completionTime_orNil <- time.Now()
+		}
+		return
+	}
+
+	speccy.runFrame(completionTime_orNil)
+}
+
+// Executes the rest of the current frame, or the whole next frame if the current frame is finished.
+// The execution ends prematurely if the debugger stops the emulation.
+func (speccy *Spectrum48k) runFrame(completionTime_orNil chan<- time.Time) {
+	if !speccy.midFrame {
+		speccy.beginFrame()
+	}
+
+	if !speccy.doOpcodes() {
+		speccy.midFrame = true
+		speccy.debugger.stop(speccy.debugger.stopReason)
+
+		if completionTime_orNil != nil {
+			completionTime_orNil <- time.Now()
+		}
+		return
+	}
+
+	speccy.midFrame = false
+	speccy.endFrame(completionTime_orNil)
+
+	// The last instruction of the frame was single-stepped, or it hit a watchpoint
+	if speccy.debugger.stopPending() {
+		speccy.debugger.stop(speccy.debugger.stopReason)
+	}
+}
+
+func (speccy *Spectrum48k) beginFrame() {
+	if !speccy.rewind.replaying {
+		speccy.Keyboard.frame_begin()
+	}
 	speccy.Ports.frame_begin()
 	speccy.ula.frame_begin()
+	if speccy.ay != nil {
+		speccy.ay.frame_begin()
+	}
 
-	// Execute instructions corresponding to one screen frame
+	TStatesPerFrame := speccy.model.timings.TStatesPerFrame
 	speccy.Cpu.Tstates = (speccy.Cpu.Tstates % TStatesPerFrame)
-	speccy.Cpu.Interrupt()
+	if !speccy.ula.interruptsDisabled() {
+		speccy.Cpu.Interrupt()
+	}
 	speccy.Cpu.EventNextEvent = TStatesPerFrame
-	speccy.doOpcodes()
+}
+
+func (speccy *Spectrum48k) endFrame(completionTime_orNil chan<- time.Time) {
+	TStatesPerFrame := speccy.model.timings.TStatesPerFrame
 
 	// Send display data to display backend(s)
 	if len(speccy.displays) > 0 {
@@ -814,8 +1465,12 @@ func (speccy *Spectrum48k) renderFrame(completionTime_orNil chan<- time.Time) {
 	// Send audio data to audio backend(s)
 	if len(speccy.audioReceivers) > 0 {
 		audioData := AudioData{
-			FPS:          speccy.currentFPS,
-			BeeperEvents: speccy.Ports.getBeeperEvents(),
+			FPS:             speccy.currentFPS,
+			TStatesPerFrame: TStatesPerFrame,
+			BeeperEvents:    speccy.Ports.getBeeperEvents(),
+		}
+		if speccy.ay != nil {
+			audioData.AY = speccy.ay.getAYData()
 		}
 
 		for _, audioReceiver := range speccy.audioReceivers {
@@ -823,6 +1478,18 @@ func (speccy *Spectrum48k) renderFrame(completionTime_orNil chan<- time.Time) {
 		}
 	}
 
+	speccy.finishFrame()
+}
+
+// Executes the next frame without sending display and audio data to the backends
+func (speccy *Spectrum48k) runFrameQuietly() {
+	speccy.beginFrame()
+	speccy.doOpcodes()
+	speccy.finishFrame()
+}
+
+// The bookkeeping at the end of each frame
+func (speccy *Spectrum48k) finishFrame() {
 	portFrameStatus := speccy.Ports.frame_end()
 
 	if portFrameStatus.shouldPlayTheTape {
@@ -832,11 +1499,14 @@ func (speccy *Spectrum48k) renderFrame(completionTime_orNil chan<- time.Time) {
 			speccy.shouldPlayTheTape--
 		}
 	}
+
+	speccy.rewind.frameEnd()
+	speccy.rzx.frameEnd()
 }
 
 // Load the given tape
-func (speccy *Spectrum48k) loadTape(tap *formats.TAP) {
-	speccy.tapeDrive.Insert(NewTape(tap))
+func (speccy *Spectrum48k) loadTape(tape *Tape) {
+	speccy.tapeDrive.Insert(tape)
 	speccy.tapeDrive.Stop()
 	speccy.sendLOADCommand()
 	speccy.tapeDrive.Play()
@@ -844,9 +1514,12 @@ func (speccy *Spectrum48k) loadTape(tap *formats.TAP) {
 
 // Send LOAD ""
 func (speccy *Spectrum48k) sendLOADCommand() {
-	speccy.Keyboard.CommandChannel <- Cmd_SendLoad{speccy.romType}
+	// The 128k editor ROM paged in means that the machine is showing the main menu
+	tapeLoaderMenu := speccy.model.paging && ((speccy.Memory.port7ffd & 0x10) == 0)
+
+	speccy.Keyboard.scheduleLoad(speccy.romType, tapeLoaderMenu)
 }
 
 func (speccy *Spectrum48k) makeVideoMemoryDump() []byte {
-	return speccy.Memory.Data()[0x4000 : 0x4000+6912]
+	return speccy.Memory.screen()[0:6912]
 }
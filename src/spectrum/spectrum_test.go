@@ -0,0 +1,186 @@
+package spectrum
+
+import (
+	"testing"
+
+	"github.com/remogatto/gospeccy/src/formats"
+
+	"github.com/remogatto/prettytest"
+)
+
+type testSuite struct {
+	prettytest.Suite
+}
+
+func TestSpectrum(t *testing.T) {
+	prettytest.Run(t, new(testSuite))
+}
+
+// Creates a machine which is not connected to any display or audio backend.
+// The emulation-loop is not running, the machine is driven by RunFrames and RunUntil.
+func newTestSpectrum(machineType MachineType, roms [][0x4000]byte) (*Application, *Spectrum48k, error) {
+	app := NewApplication()
+
+	speccy, err := NewSpectrum(app, machineType, roms)
+	if err != nil {
+		exitTestSpectrum(app)
+		return nil, nil, err
+	}
+
+	return app, speccy, nil
+}
+
+func exitTestSpectrum(app *Application) {
+	app.RequestExit()
+	<-app.HasTerminated
+}
+
+// Loads a program into the machine, using the same command as the user interface
+func loadTestProgram(speccy *Spectrum48k, program interface{}) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_Load{Program: program, ErrChan: errChan}
+	return <-errChan
+}
+
+// Creates a 48k machine running the original ROM
+func newTestSpectrum48k() (*Application, *Spectrum48k, error) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		return nil, nil, err
+	}
+	return newTestSpectrum(MACHINE_48K, [][0x4000]byte{*rom})
+}
+
+func (t *testSuite) TestRunUntil_SystemROMLoaded() {
+	app, speccy, err := newTestSpectrum48k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	var frames uint = 0
+	loaded := speccy.RunUntil(func(speccy *Spectrum48k) bool {
+		if speccy.systemROMLoaded() {
+			return true
+		}
+		frames++
+		return false
+	}, 500)
+
+	t.True(loaded)
+	t.True(frames < 500)
+}
+
+func (t *testSuite) TestRunUntil_MaxFrames() {
+	app, speccy, err := newTestSpectrum48k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// The predicate is evaluated before the first frame and after each frame
+	calls := 0
+	t.False(speccy.RunUntil(func(speccy *Spectrum48k) bool {
+		calls++
+		return false
+	}, 10))
+	t.Equal(11, calls)
+	t.Equal(uint(10), speccy.ula.frame)
+}
+
+func (t *testSuite) TestKeyPress() {
+	app, speccy, err := newTestSpectrum48k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	t.True(speccy.RunUntil(func(speccy *Spectrum48k) bool { return speccy.systemROMLoaded() }, 500))
+
+	done := speccy.Keyboard.KeyPress(KEY_P)
+	t.Equal(uint(keyDownFrames+keyUpFrames+1), speccy.RunFrames(keyDownFrames+keyUpFrames+1))
+
+	released := false
+	select {
+	case released = <-done:
+	default:
+	}
+	t.True(released)
+
+	// In K mode, the key P enters the keyword PRINT at the beginning of the edit line
+	const E_LINE = 23641
+	eline := uint16(speccy.Memory.Read(E_LINE)) | (uint16(speccy.Memory.Read(E_LINE+1)) << 8)
+	t.Equal(byte(0xf5), speccy.Memory.Read(eline))
+}
+
+// Returns a snapshot of a 48k machine running a program at 0x8000
+func newTestSnapshot() *formats.FullSnapshot {
+	s := &formats.FullSnapshot{}
+	s.Cpu.PC = 0x8000
+	s.Cpu.SP = 0xff00
+	s.Cpu.IM = 1
+	s.Ula.Border = 2
+
+	// loop: JR loop
+	copy(s.Mem[0x8000-0x4000:], []byte{0x18, 0xfe})
+
+	return s
+}
+
+// Loads the snapshot into a machine running a 48k program.
+// Returns the state of the machine before and after loading the snapshot.
+func loadOverTestSnapshot(speccy *Spectrum48k, s formats.Snapshot) (before, after *formats.FullSnapshot, err error) {
+	err = loadTestProgram(speccy, newTestSnapshot())
+	if err != nil {
+		panic(err)
+	}
+	speccy.RunFrames(2)
+
+	before = speccy.MakeSnapshot()
+	err = loadTestProgram(speccy, s)
+	after = speccy.MakeSnapshot()
+
+	return before, after, err
+}
+
+func (t *testSuite) TestLoadSnapshot_Requires128k() {
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{{}})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	s := newTestSnapshot()
+	s.Cpu.PC = 0xc123
+	s.Cpu.SP = 0xfff0
+	s.Machine128k = new(formats.State128k)
+
+	before, after, err := loadOverTestSnapshot(speccy, s)
+	t.Not(t.Nil(err))
+	t.Equal(before.Cpu, after.Cpu)
+	t.Equal(before.Ula.Border, after.Ula.Border)
+	t.Equal(*before.Ula.ULAplus, *after.Ula.ULAplus)
+	t.True(before.Mem == after.Mem)
+}
+
+func (t *testSuite) TestLoadSnapshot_RequiresTimex() {
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{{}})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	s := newTestSnapshot()
+	s.Cpu.PC = 0xc123
+	s.Ula.Timex = &formats.TimexState{PortFF: TIMEX_HIRES}
+
+	before, after, err := loadOverTestSnapshot(speccy, s)
+	t.Not(t.Nil(err))
+	t.Equal(before.Cpu, after.Cpu)
+	t.Equal(byte(0), speccy.ula.scld)
+}
@@ -6,18 +6,6 @@ import (
 	"sync"
 )
 
-const (
-	TAPE_DRIVE_START = iota
-	TAPE_DRIVE_STOP
-	TAPE_DRIVE_PAUSE
-	TAPE_DRIVE_LEADER
-	TAPE_DRIVE_SYNC
-	TAPE_DRIVE_NEWBIT
-	TAPE_DRIVE_HALF2
-	TAPE_DRIVE_PAUSE_STOP
-	TAPE_DRIVE_NEWBYTE
-)
-
 const (
 	TAPE_LEADER               = 2168
 	TAPE_FIRST_SYNC           = 667
@@ -31,12 +19,57 @@ const (
 
 const TAPE_ACCELERATION_IN_FPS = DefaultFPS * 20
 
+// Values of the EAR bit as seen by the CPU
+const (
+	EAR_LOW  = 0xbf
+	EAR_HIGH = 0xff
+)
+
+// An edge of the EAR signal
+type TapeEdge struct {
+	Duration int  // Number of T-states until the next edge
+	Level    bool // The level of the signal after the edge, true means "high"
+
+	// The edge belongs to the gap following a block of data.
+	// The tape drive takes this as a hint that the loader has finished reading the block.
+	BlockEnd bool
+}
+
+// A source of timed EAR edges. Each tape format is converted
+// into edges by its own TapeSource implementation.
+type TapeSource interface {
+	// Returns the next edge. The value of 'ok' is false when the tape
+	// should be stopped, either because all edges have been played or
+	// because the tape contains a stop instruction. In the latter case,
+	// subsequent calls continue after the stop instruction.
+	NextEdge() (edge TapeEdge, ok bool)
+
+	// Returns true if all edges have been played
+	AtEnd() bool
+
+	// Moves to the beginning of the tape
+	Rewind()
+}
+
+// Implemented by tape sources whose edges depend on the emulated machine
+type machineDependentTapeSource interface {
+	setMachineType(machineType MachineType)
+}
+
 type Tape struct {
-	tap *formats.TAP
+	source TapeSource
 }
 
 func NewTape(tap *formats.TAP) *Tape {
-	return &Tape{tap}
+	return &Tape{newTAPSource(tap)}
+}
+
+func NewTZXTape(tzx *formats.TZX) *Tape {
+	return &Tape{newTZXSource(tzx)}
+}
+
+func NewTapeFromSource(source TapeSource) *Tape {
+	return &Tape{source}
 }
 
 func NewTapeFromFile(filename string) (*Tape, error) {
@@ -45,44 +78,139 @@ func NewTapeFromFile(filename string) (*Tape, error) {
 		return nil, err
 	}
 
+	if (len(data) >= 8) && (string(data[0:8]) == "ZXTape!\x1a") {
+		tzx, err := formats.NewTZX(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewTZXTape(tzx), nil
+	}
+
 	tap, err := formats.NewTAP(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Tape{tap}, nil
+	return NewTape(tap), nil
 }
 
-func (tape *Tape) At(pos uint) byte {
-	return tape.tap.At(pos)
+func (tape *Tape) Source() TapeSource {
+	return tape.source
+}
+
+// A helper for tape sources which convert a block of data into edges
+type edgeBuffer struct {
+	edges []TapeEdge
+	pos   int  // Index of the next edge to be returned by 'next'
+	level bool // Level of the signal after the last edge
+
+	// Index of the first edge following the pilot tone of a ROM-loadable block,
+	// or -1 if the edges do not belong to such a block
+	dataStart int
+}
+
+func (b *edgeBuffer) reset() {
+	b.clear()
+	b.level = false
+}
+
+func (b *edgeBuffer) clear() {
+	b.edges = b.edges[:0]
+	b.pos = 0
+	b.dataStart = -1
+}
+
+func (b *edgeBuffer) empty() bool {
+	return b.pos >= len(b.edges)
+}
+
+func (b *edgeBuffer) next() TapeEdge {
+	edge := b.edges[b.pos]
+	b.pos++
+	return edge
+}
+
+// Inverts the level of the signal and holds it for 'duration' T-states
+func (b *edgeBuffer) toggle(duration int) {
+	b.level = !b.level
+	b.edges = append(b.edges, TapeEdge{Duration: duration, Level: b.level})
+}
+
+// Holds the signal at 'level' for 'duration' T-states.
+// If the signal already is at 'level', the last edge is extended.
+func (b *edgeBuffer) hold(level bool, duration int) {
+	n := len(b.edges)
+	if (n > 0) && (b.level == level) {
+		b.edges[n-1].Duration += duration
+	} else {
+		b.level = level
+		b.edges = append(b.edges, TapeEdge{Duration: duration, Level: level})
+	}
+}
+
+// Appends the bits of 'data', most significant bit first. Each bit is
+// encoded as two pulses. Only 'usedBits' bits of the last byte are used.
+func (b *edgeBuffer) appendData(data []byte, usedBits byte, zeroPulse, onePulse int) {
+	for i, value := range data {
+		numBits := 8
+		if i == len(data)-1 {
+			numBits = int(usedBits)
+		}
+
+		for mask := byte(0x80); numBits > 0; mask, numBits = mask>>1, numBits-1 {
+			pulse := zeroPulse
+			if (value & mask) != 0 {
+				pulse = onePulse
+			}
+			b.toggle(pulse)
+			b.toggle(pulse)
+		}
+	}
+}
+
+// Marks the last edge as belonging to the gap after a block
+func (b *edgeBuffer) markBlockEnd() {
+	if len(b.edges) > 0 {
+		b.edges[len(b.edges)-1].BlockEnd = true
+	}
 }
 
 type TapeDrive struct {
 	AcceleratedLoad    bool
 	NotifyLoadComplete bool
 
+	// If true, blocks loaded by the ROM routine LD-BYTES are copied directly into memory
+	InstantLoad bool
+
+	// If true, blocks saved by the ROM routine SA-BYTES are appended to the saved tape
+	// without executing the routine
+	InstantSave bool
+
 	speccy *Spectrum48k
 	tape   *Tape
 
-	pos                                   uint
-	tstate, lastIn                        uint64
-	earBit                                byte
-	timeout                               int
-	timeLastIn, currBlockLen, currBlockId int
-	leaderPulses, bitTime                 uint16
-	state, mask                           byte
-	accelerating                          bool
-	fpsBeforeAcceleration                 float32
-	notifyCpuLoadCompleted                bool
-	loadComplete                          chan bool
+	earBit                 byte
+	timeout                int
+	timeLastIn             int
+	accelerating           bool
+	fpsBeforeAcceleration  float32
+	notifyCpuLoadCompleted bool
+	loadComplete           chan bool
+
+	// The sum of the durations of the edges played so far,
+	// including the edge which is currently being played
+	position uint64
+
+	// The blocks saved by the ROM routine SA-BYTES
+	savedTape formats.TAP
 
 	mutex sync.RWMutex
 }
 
 func NewTapeDrive() *TapeDrive {
 	return &TapeDrive{
-		pos:          0,
-		earBit:       0xbf,
+		earBit:       EAR_LOW,
 		loadComplete: make(chan bool),
 	}
 }
@@ -93,23 +221,59 @@ func (tapeDrive *TapeDrive) init(speccy *Spectrum48k) {
 
 func (tapeDrive *TapeDrive) Insert(tape *Tape) {
 	tapeDrive.tape = tape
+
+	if source, ok := tape.source.(machineDependentTapeSource); ok {
+		source.setMachineType(tapeDrive.speccy.model.machineType)
+	}
+
+	if source, ok := tape.source.(*tzxSource); ok && tapeDrive.speccy.app.Verbose {
+		for _, i := range source.unsupportedBlocks() {
+			id := source.tzx.GetBlock(i).ID()
+			tapeDrive.speccy.app.PrintfMsg("tape: skipping unsupported TZX block 0x%02x (block #%d)", id, i)
+		}
+	}
 }
 
 func (tapeDrive *TapeDrive) Play() {
 	tapeDrive.speccy.readFromTape = true
-	tapeDrive.pos = 0
-	tapeDrive.state = TAPE_DRIVE_START
+	tapeDrive.timeout = 0
+	tapeDrive.timeLastIn = 0
+	tapeDrive.earBit = EAR_LOW
+	tapeDrive.position = 0
+
+	if tapeDrive.tape != nil {
+		tapeDrive.tape.source.Rewind()
+	}
+}
+
+// Continues playing the tape from the current position.
+// This is used after the tape has been stopped by the tape itself (such as by a TZX stop-the-tape block).
+func (tapeDrive *TapeDrive) Resume() {
+	if tapeDrive.tape == nil {
+		return
+	}
+	tapeDrive.speccy.readFromTape = true
 	tapeDrive.timeout = 0
 	tapeDrive.timeLastIn = 0
 }
 
 func (tapeDrive *TapeDrive) Stop() {
 	tapeDrive.speccy.readFromTape = false
-	tapeDrive.pos = 0
-	tapeDrive.state = TAPE_DRIVE_PAUSE_STOP
 	tapeDrive.timeout = 0
 	tapeDrive.timeLastIn = 0
-	tapeDrive.currBlockId = 0
+	tapeDrive.position = 0
+
+	if tapeDrive.tape != nil {
+		tapeDrive.tape.source.Rewind()
+	}
+}
+
+// Returns the position of the tape, in T-states from the beginning of the tape
+func (tapeDrive *TapeDrive) Position() uint64 {
+	if tapeDrive.timeout > 0 {
+		return tapeDrive.position - uint64(tapeDrive.timeout)
+	}
+	return tapeDrive.position
 }
 
 func (tapeDrive *TapeDrive) accelerate() {
@@ -141,7 +305,7 @@ func (tapeDrive *TapeDrive) decelerate() {
 }
 
 func (tapeDrive *TapeDrive) doPlay() (endOfBlock bool) {
-	now := int(tapeDrive.speccy.ula.frame)*TStatesPerFrame + tapeDrive.speccy.Cpu.Tstates
+	now := int(tapeDrive.speccy.ula.frame)*tapeDrive.speccy.model.timings.TStatesPerFrame + tapeDrive.speccy.Cpu.Tstates
 
 	tapeDrive.timeout -= now - tapeDrive.timeLastIn
 	tapeDrive.timeLastIn = now
@@ -150,116 +314,64 @@ func (tapeDrive *TapeDrive) doPlay() (endOfBlock bool) {
 		return
 	}
 
-	tapeDrive.timeout = 0
-
 	if tapeDrive.AcceleratedLoad {
 		tapeDrive.accelerate()
 	} else {
 		tapeDrive.decelerate()
 	}
 
-	switch tapeDrive.state {
-	case TAPE_DRIVE_START:
-		currBlock := tapeDrive.tape.tap.GetBlock(tapeDrive.currBlockId)
-		tapeDrive.currBlockLen = currBlock.Len()
+	source := tapeDrive.tape.source
 
-		if currBlock.BlockType() == formats.TAP_BLOCK_HEADER {
-			tapeDrive.leaderPulses = TAPE_HEADER_LEADER_PULSES
-		} else {
-			tapeDrive.leaderPulses = TAPE_DATA_LEADER_PULSES
-		}
-
-		tapeDrive.earBit = 0xbf
-		tapeDrive.timeout = TAPE_LEADER
-		tapeDrive.state = TAPE_DRIVE_LEADER
-	case TAPE_DRIVE_LEADER:
-		if tapeDrive.earBit == 0xbf {
-			tapeDrive.earBit = 0xff
-		} else {
-			tapeDrive.earBit = 0xbf
-		}
-		tapeDrive.leaderPulses--
-		if tapeDrive.leaderPulses > 0 {
-			tapeDrive.timeout = TAPE_LEADER
-		} else {
-			tapeDrive.timeout = TAPE_FIRST_SYNC
-			tapeDrive.state = TAPE_DRIVE_SYNC
-		}
+	edge, ok := source.NextEdge()
+	if !ok {
+		tapeDrive.timeout = 0
+		tapeDrive.stopped(source.AtEnd())
+		return true
+	}
 
-	case TAPE_DRIVE_SYNC:
-		if tapeDrive.earBit == 0xbf {
-			tapeDrive.earBit = 0xff
-		} else {
-			tapeDrive.earBit = 0xbf
-		}
-		tapeDrive.timeout = TAPE_SECOND_SYNC
-		tapeDrive.state = TAPE_DRIVE_NEWBYTE
-
-	case TAPE_DRIVE_NEWBYTE:
-		tapeDrive.mask = 0x80
-		fallthrough
-
-	case TAPE_DRIVE_NEWBIT:
-		if tapeDrive.earBit == 0xbf {
-			tapeDrive.earBit = 0xff
-		} else {
-			tapeDrive.earBit = 0xbf
-		}
-		if (tapeDrive.tape.At(tapeDrive.pos) & tapeDrive.mask) == 0 {
-			tapeDrive.bitTime = TAPE_UNSET_BIT
-		} else {
-			tapeDrive.bitTime = TAPE_SET_BIT
-		}
-		tapeDrive.timeout = int(tapeDrive.bitTime)
-		tapeDrive.state = TAPE_DRIVE_HALF2
-
-	case TAPE_DRIVE_HALF2:
-		if tapeDrive.earBit == 0xbf {
-			tapeDrive.earBit = 0xff
-		} else {
-			tapeDrive.earBit = 0xbf
-		}
-		tapeDrive.timeout = int(tapeDrive.bitTime)
-		tapeDrive.mask >>= 1
-		if tapeDrive.mask == 0 {
-			tapeDrive.pos++
-			tapeDrive.currBlockLen--
-			if tapeDrive.currBlockLen > 0 {
-				tapeDrive.state = TAPE_DRIVE_NEWBYTE
-			} else {
-				tapeDrive.state = TAPE_DRIVE_PAUSE
-			}
-		} else {
-			tapeDrive.state = TAPE_DRIVE_NEWBIT
-		}
+	if edge.Level {
+		tapeDrive.earBit = EAR_HIGH
+	} else {
+		tapeDrive.earBit = EAR_LOW
+	}
 
-	case TAPE_DRIVE_PAUSE:
-		if tapeDrive.earBit == 0xbf {
-			tapeDrive.earBit = 0xff
-		} else {
-			tapeDrive.earBit = 0xbf
-		}
+	// Carry over the T-states by which the previous edge was late,
+	// unless the tape was not being read for longer than the duration of the edge
+	tapeDrive.timeout += edge.Duration
+	if tapeDrive.timeout <= 0 {
+		tapeDrive.timeout = edge.Duration
+	}
+	tapeDrive.position += uint64(edge.Duration)
 
+	if edge.BlockEnd {
 		endOfBlock = true
 		tapeDrive.decelerate()
+	}
 
-		if tapeDrive.pos < tapeDrive.tape.tap.Len() {
-			tapeDrive.timeout = TAPE_PAUSE
-			tapeDrive.state = TAPE_DRIVE_PAUSE_STOP
-		} else {
-			tapeDrive.timeout = 1
-			tapeDrive.state = TAPE_DRIVE_STOP
+	if source.AtEnd() {
+		endOfBlock = true
+		tapeDrive.stopped(true)
+	}
 
-			tapeDrive.speccy.readFromTape = false
-			tapeDrive.notifyCpuLoadCompleted = true
-		}
+	return endOfBlock
+}
 
-	case TAPE_DRIVE_PAUSE_STOP:
-		tapeDrive.currBlockId++
-		tapeDrive.state = TAPE_DRIVE_START
+// Called when the tape stops by itself
+func (tapeDrive *TapeDrive) stopped(atEnd bool) {
+	tapeDrive.decelerate()
+	tapeDrive.speccy.readFromTape = false
+	if atEnd {
+		tapeDrive.notifyCpuLoadCompleted = true
 	}
+}
 
-	return endOfBlock
+// Returns a copy of the blocks saved by the emulated machine
+func (tapeDrive *TapeDrive) makeSavedTape() *formats.TAP {
+	tap := new(formats.TAP)
+	for i := 0; i < tapeDrive.savedTape.NumBlocks(); i++ {
+		tap.AppendBlock(tapeDrive.savedTape.GetBlock(i).Data())
+	}
+	return tap
 }
 
 func (tapeDrive *TapeDrive) getEarBit() uint8 {
@@ -0,0 +1,67 @@
+package spectrum
+
+import (
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// Converts the blocks of a TAP tape into edges, using the timings of the ROM
+// saving routine. The blocks are converted on demand, one at a time.
+type tapSource struct {
+	tap   *formats.TAP
+	block int // Index of the next block to convert
+	edgeBuffer
+}
+
+func newTAPSource(tap *formats.TAP) *tapSource {
+	return &tapSource{tap: tap}
+}
+
+func (s *tapSource) NextEdge() (edge TapeEdge, ok bool) {
+	if s.empty() {
+		if s.block >= s.tap.NumBlocks() {
+			return TapeEdge{}, false
+		}
+
+		s.clear()
+		s.convertBlock()
+		s.block++
+	}
+
+	return s.next(), true
+}
+
+func (s *tapSource) AtEnd() bool {
+	return s.empty() && (s.block >= s.tap.NumBlocks())
+}
+
+func (s *tapSource) Rewind() {
+	s.block = 0
+	s.reset()
+}
+
+func (s *tapSource) convertBlock() {
+	block := s.tap.GetBlock(s.block)
+
+	leaderPulses := TAPE_DATA_LEADER_PULSES
+	if block.BlockType() == formats.TAP_BLOCK_HEADER {
+		leaderPulses = TAPE_HEADER_LEADER_PULSES
+	}
+
+	// The leader tone always begins with a rising edge
+	s.level = false
+	for ; leaderPulses > 0; leaderPulses-- {
+		s.toggle(TAPE_LEADER)
+	}
+	s.dataStart = len(s.edges)
+	s.toggle(TAPE_FIRST_SYNC)
+	s.toggle(TAPE_SECOND_SYNC)
+	s.appendData(block.Data(), 8, TAPE_UNSET_BIT, TAPE_SET_BIT)
+
+	// The gap between blocks
+	if s.block < s.tap.NumBlocks()-1 {
+		s.toggle(TAPE_PAUSE)
+	} else {
+		s.toggle(1)
+	}
+	s.markBlockEnd()
+}
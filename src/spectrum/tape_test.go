@@ -0,0 +1,219 @@
+package spectrum
+
+import (
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// Blocks of a small tape: a header and one byte of data
+var testTapeBlocks = []formats.TAPBlock{
+	formats.NewTAPBlock(0x00, []byte{0x03, 'T', 'E', 'S', 'T', ' ', ' ', ' ', ' ', ' ', ' ', 0x01, 0x00, 0x00, 0x80, 0x00, 0x80}),
+	formats.NewTAPBlock(0xff, []byte{0xa5}),
+}
+
+func newTestTAP() *formats.TAP {
+	tap := new(formats.TAP)
+	for _, block := range testTapeBlocks {
+		tap.AppendBlock(block.Data())
+	}
+	return tap
+}
+
+// Returns a TZX tape containing the blocks of 'testTapeBlocks'
+// as standard speed data blocks, each followed by a pause of 'pause' milliseconds
+func newTestTZX(pause uint16) (*formats.TZX, error) {
+	data := []byte("ZXTape!\x1a\x01\x14")
+	for _, block := range testTapeBlocks {
+		n := len(block.Data())
+		data = append(data, formats.TZX_BLOCK_STANDARD_SPEED, byte(pause), byte(pause>>8), byte(n), byte(n>>8))
+		data = append(data, block.Data()...)
+	}
+	return formats.NewTZX(data)
+}
+
+// Returns the edges of a block saved by the ROM routine SA-BYTES,
+// followed by a gap of 'pause' T-states
+func romBlockEdges(data []byte, pause int) []TapeEdge {
+	var edges []TapeEdge
+	level := false
+	toggle := func(duration int) {
+		level = !level
+		edges = append(edges, TapeEdge{Duration: duration, Level: level})
+	}
+
+	// Pilot
+	pilotPulses := TAPE_DATA_LEADER_PULSES
+	if data[0] < 0x80 {
+		pilotPulses = TAPE_HEADER_LEADER_PULSES
+	}
+	for i := 0; i < pilotPulses; i++ {
+		toggle(TAPE_LEADER)
+	}
+
+	// Sync
+	toggle(TAPE_FIRST_SYNC)
+	toggle(TAPE_SECOND_SYNC)
+
+	// Bits
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			pulse := TAPE_UNSET_BIT
+			if (b & (1 << uint(i))) != 0 {
+				pulse = TAPE_SET_BIT
+			}
+			toggle(pulse)
+			toggle(pulse)
+		}
+	}
+
+	// Pause
+	toggle(pause)
+	edges[len(edges)-1].BlockEnd = true
+
+	return edges
+}
+
+// Returns the edges played by 'source' until it stops
+func readTapeEdges(source TapeSource) []TapeEdge {
+	var edges []TapeEdge
+	for {
+		edge, ok := source.NextEdge()
+		if !ok {
+			return edges
+		}
+		edges = append(edges, edge)
+	}
+}
+
+// Returns the index of the first edge which differs, or -1 if the edges are equal
+func firstDifferentEdge(expected, actual []TapeEdge) int {
+	for i := range expected {
+		if (i >= len(actual)) || (actual[i] != expected[i]) {
+			return i
+		}
+	}
+	if len(actual) > len(expected) {
+		return len(expected)
+	}
+	return -1
+}
+
+func (t *testSuite) TestTapeSource_TAP() {
+	source := newTAPSource(newTestTAP())
+
+	header := romBlockEdges(testTapeBlocks[0].Data(), TAPE_PAUSE)
+	data := romBlockEdges(testTapeBlocks[1].Data(), 1)
+	expected := append(header, data...)
+
+	t.False(source.AtEnd())
+	t.Equal(-1, firstDifferentEdge(expected, readTapeEdges(source)))
+	t.True(source.AtEnd())
+
+	// The pilot of the header is longer than the pilot of the data block
+	t.Equal(TAPE_HEADER_LEADER_PULSES+2+8*len(testTapeBlocks[0].Data())*2+1, len(header))
+	t.Equal(TAPE_DATA_LEADER_PULSES+2+8*len(testTapeBlocks[1].Data())*2+1, len(data))
+
+	// Each edge is returned once
+	_, ok := source.NextEdge()
+	t.False(ok)
+
+	source.Rewind()
+	t.False(source.AtEnd())
+	t.Equal(-1, firstDifferentEdge(expected, readTapeEdges(source)))
+}
+
+func (t *testSuite) TestTapeSource_TZX() {
+	tzx, err := newTestTZX(1000)
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	source := newTZXSource(tzx)
+
+	// A pause of 1000 ms has the same duration as the gap in a TAP tape
+	const pause = 1000 * formats.TZX_TSTATES_PER_MS
+	expected := append(romBlockEdges(testTapeBlocks[0].Data(), pause), romBlockEdges(testTapeBlocks[1].Data(), pause)...)
+
+	t.False(source.AtEnd())
+	t.Equal(-1, firstDifferentEdge(expected, readTapeEdges(source)))
+	t.True(source.AtEnd())
+
+	source.Rewind()
+	t.False(source.AtEnd())
+	t.Equal(-1, firstDifferentEdge(expected, readTapeEdges(source)))
+}
+
+func (t *testSuite) TestTapeDrive_Position() {
+	var rom [0x4000]byte
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{rom})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	tapeDrive := speccy.tapeDrive
+	tapeDrive.Insert(NewTape(newTestTAP()))
+	tapeDrive.Play()
+
+	// The position is the time elapsed since the tape started playing,
+	// it advances between the edges
+	for _, tstate := range []int{0, 1000, TAPE_LEADER, TAPE_LEADER + 500, 2*TAPE_LEADER + 100, 3 * TAPE_LEADER} {
+		speccy.Cpu.Tstates = tstate
+		tapeDrive.doPlay()
+		t.Equal(uint64(tstate), tapeDrive.Position())
+	}
+
+	// The position is relative to the beginning of the tape
+	tapeDrive.Stop()
+	t.Equal(uint64(0), tapeDrive.Position())
+}
+
+func (t *testSuite) TestTapeSource_TZX_FinalEdge() {
+	tzx, err := newTestTZX(0)
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	source := newTZXSource(tzx)
+
+	// Without the pauses, the last pulse of each block is unfinished
+	header := romBlockEdges(testTapeBlocks[0].Data(), 0)
+	data := romBlockEdges(testTapeBlocks[1].Data(), 0)
+	// and the tape is finished by a 1ms pulse
+	expected := append(header[:len(header)-1], data[:len(data)-1]...)
+	expected = append(expected, TapeEdge{Duration: formats.TZX_TSTATES_PER_MS, BlockEnd: true})
+
+	// The level toggles at each edge, across the blocks
+	for i := range expected {
+		expected[i].Level = (i%2 == 0)
+	}
+
+	t.Equal(-1, firstDifferentEdge(expected, readTapeEdges(source)))
+	t.True(source.AtEnd())
+}
+
+func (t *testSuite) TestTapeDrive_UnsupportedTZXBlocks() {
+	app, speccy, out, err := newTestDebuggerSpectrum()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// A CSW recording and a text description
+	data := []byte("ZXTape!\x1a\x01\x14")
+	data = append(data, formats.TZX_BLOCK_TEXT, 4, 'T', 'E', 'S', 'T')
+	data = append(data, formats.TZX_BLOCK_CSW_RECORDING, 2, 0, 0, 0, 0x12, 0x34)
+	tzx, err := formats.NewTZX(data)
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+
+	speccy.tapeDrive.Insert(NewTZXTape(tzx))
+	t.Equal("", out.last())
+
+	app.Verbose = true
+	speccy.tapeDrive.Insert(NewTZXTape(tzx))
+	t.Equal("tape: skipping unsupported TZX block 0x18 (block #1)", out.last())
+}
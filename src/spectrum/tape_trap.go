@@ -0,0 +1,293 @@
+package spectrum
+
+import (
+	"math/bits"
+
+	"github.com/remogatto/gospeccy/src/formats"
+	"github.com/remogatto/z80"
+)
+
+// Addresses of ROM routines
+const (
+	ROM_SA_BYTES  = 0x04c2
+	ROM_SA_LD_RET = 0x053f // Common exit of LD-BYTES and SA-BYTES: restores the border and enables interrupts
+	ROM_LD_BYTES  = 0x0556
+)
+
+// The first instructions of SA-BYTES in the standard 48k ROM:
+// LD HL,SA/LD-RET; PUSH HL; LD HL,0x1F80; BIT 7,A; JR Z,+3; LD HL,...
+var rom_saBytesCode = []byte{0x21, 0x3f, 0x05, 0xe5, 0x21, 0x80, 0x1f, 0xcb, 0x7f, 0x28, 0x03, 0x21}
+
+// The first instructions of LD-BYTES in the standard 48k ROM:
+// INC D; EX AF,AF'; DEC D; DI; LD A,0x0F; OUT (0xFE),A; LD HL,SA/LD-RET; PUSH HL
+var rom_ldBytesCode = []byte{0x14, 0x08, 0x15, 0xf3, 0x3e, 0x0f, 0xd3, 0xfe, 0x21, 0x3f, 0x05, 0xe5}
+
+// Implemented by tape sources which are able to provide whole blocks of data.
+// This is required by the ROM loading trap.
+type blockTapeSource interface {
+	// Returns the next block (flag byte, data bytes, checksum byte).
+	// The value of 'ok' is false if the next block cannot be loaded by the ROM,
+	// or if there are no more blocks.
+	peekBlock() (data []byte, ok bool)
+
+	// Moves past the block returned by 'peekBlock'
+	skipBlock()
+}
+
+// Returns true if the memory at 'address' contains 'code'
+func (memory *Memory) matches(address uint16, code []byte) bool {
+	for i, b := range code {
+		if memory.ReadByteInternal(address+uint16(i)) != b {
+			return false
+		}
+	}
+	return true
+}
+
+// Emulates the ROM routine LD-BYTES by copying the next tape block directly
+// into memory. The Z80 registers are set in the same way as the routine would
+// set them, and the execution continues at SA/LD-RET.
+//
+// Returns false if the routine cannot be emulated. In such a case,
+// the edges of the tape are played as usual.
+func (tapeDrive *TapeDrive) trapLoad() bool {
+	speccy := tapeDrive.speccy
+	if (tapeDrive.tape == nil) || !speccy.readFromTape {
+		return false
+	}
+
+	source, ok := tapeDrive.tape.source.(blockTapeSource)
+	if !ok {
+		return false
+	}
+
+	memory := speccy.Memory
+	if !memory.matches(ROM_LD_BYTES, rom_ldBytesCode) {
+		return false
+	}
+
+	data, ok := source.peekBlock()
+	if !ok {
+		return false
+	}
+
+	cpu := speccy.Cpu
+	flag := cpu.A
+	load := (cpu.F & z80.FLAG_C) != 0 // LOAD or VERIFY
+	length := cpu.DE()
+
+	if (len(data) != int(length)+2) || (data[0] != flag) {
+		return false
+	}
+
+	source.skipBlock()
+
+	address := cpu.IX()
+	parity := data[0]
+	last := data[0]
+	verified := true
+	for _, b := range data[1 : len(data)-1] {
+		last = b
+		if load {
+			memory.WriteByteInternal(address, b)
+		} else if memory.ReadByteInternal(address) != b {
+			verified = false
+			break
+		}
+		parity ^= b
+		address++
+		length--
+	}
+
+	if verified {
+		// The checksum byte
+		last = data[len(data)-1]
+		parity ^= last
+		cpu.A = parity
+		cpu.H = parity
+
+		// The routine ends with LD A,H; CP 1. The carry flag is set if the parity is zero.
+		cpu.F = cpFlags(cpu.A, 1)
+	} else {
+		// The routine returns from LD-VERIFY after LD A,(IX+0); XOR L
+		cpu.H = parity ^ last
+		cpu.A = memory.ReadByteInternal(address) ^ last
+		cpu.F = xorFlags(cpu.A)
+	}
+
+	cpu.SetIX(address)
+	cpu.SetDE(length)
+	cpu.L = last
+
+	cpu.SetPC(ROM_SA_LD_RET)
+
+	if tapeDrive.tape.source.AtEnd() {
+		tapeDrive.stopped(true)
+	}
+
+	return true
+}
+
+// Returns the flags set by the instruction CP executed with the accumulator 'a'
+func cpFlags(a, value byte) byte {
+	result := uint16(a) - uint16(value)
+
+	f := z80.FLAG_N | (value & (z80.FLAG_3 | z80.FLAG_5)) | (byte(result) & z80.FLAG_S)
+	if (result & 0x100) != 0 {
+		f |= z80.FLAG_C
+	} else if result == 0 {
+		f |= z80.FLAG_Z
+	}
+	if (a & 0x0f) < (value & 0x0f) {
+		f |= z80.FLAG_H
+	}
+	if ((a ^ value) & (a ^ byte(result)) & 0x80) != 0 {
+		f |= z80.FLAG_V
+	}
+
+	return f
+}
+
+// Returns the flags set by the instruction XOR which results in 'value'
+func xorFlags(value byte) byte {
+	f := value & (z80.FLAG_S | z80.FLAG_5 | z80.FLAG_3)
+	if value == 0 {
+		f |= z80.FLAG_Z
+	}
+	if (bits.OnesCount8(value) % 2) == 0 {
+		f |= z80.FLAG_P
+	}
+
+	return f
+}
+
+// Returns the flags set by the instruction INC which results in 'value'.
+// The carry flag is not affected by INC.
+func incFlags(value byte, carry bool) byte {
+	f := value & (z80.FLAG_S | z80.FLAG_5 | z80.FLAG_3)
+	if carry {
+		f |= z80.FLAG_C
+	}
+	if value == 0 {
+		f |= z80.FLAG_Z
+	}
+	if (value & 0x0f) == 0 {
+		f |= z80.FLAG_H
+	}
+	if value == 0x80 {
+		f |= z80.FLAG_V
+	}
+
+	return f
+}
+
+// Emulates the ROM routine SA-BYTES by appending the block to the saved tape.
+// The Z80 registers are set in the same way as the routine would set them,
+// and the execution continues at SA/LD-RET.
+//
+// Returns false if the routine cannot be emulated.
+func (tapeDrive *TapeDrive) trapSave() bool {
+	memory := tapeDrive.speccy.Memory
+	if !memory.matches(ROM_SA_BYTES, rom_saBytesCode) {
+		return false
+	}
+
+	cpu := tapeDrive.speccy.Cpu
+	address := cpu.IX()
+	length := cpu.DE()
+
+	data := make([]byte, 0, int(length)+2)
+	parity := cpu.A
+	data = append(data, cpu.A)
+	for i := uint16(0); i < length; i++ {
+		b := memory.ReadByteInternal(address + i)
+		parity ^= b
+		data = append(data, b)
+	}
+	data = append(data, parity)
+
+	err := tapeDrive.savedTape.AppendBlock(data)
+	if err != nil {
+		tapeDrive.speccy.app.PrintfMsg("tape save: %s", err)
+		return false
+	}
+
+	if tapeDrive.speccy.app.Verbose {
+		tapeDrive.speccy.app.PrintfMsg("saved tape block (flag 0x%02x, %d bytes)", data[0], length)
+	}
+
+	cpu.SetIX(address + length + 1)
+	cpu.SetDE(0xffff)
+
+	// The routine ends with LD A,D; INC A with D=0xff. The carry flag
+	// is set by the preceding RRA, which tests the BREAK key (not pressed).
+	cpu.A = 0
+	cpu.F = incFlags(cpu.A, true)
+	cpu.SetPC(ROM_SA_LD_RET)
+
+	return true
+}
+
+func (s *tapSource) peekBlock() (data []byte, ok bool) {
+	if !s.empty() && (s.pos <= s.dataStart) {
+		// The pilot tone of the current block is being played
+		return s.tap.GetBlock(s.block - 1).Data(), true
+	}
+
+	if s.block >= s.tap.NumBlocks() {
+		return nil, false
+	}
+
+	return s.tap.GetBlock(s.block).Data(), true
+}
+
+func (s *tapSource) skipBlock() {
+	if s.empty() || (s.pos > s.dataStart) {
+		s.block++
+	}
+	s.clear()
+}
+
+func (s *tzxSource) peekBlock() (data []byte, ok bool) {
+	if !s.empty() && (s.pos <= s.dataStart) {
+		// The pilot tone of the current block is being played
+		return s.tzx.GetBlock(s.block - 1).(*formats.TZXStandardSpeed).Data, true
+	}
+
+	i := s.nextStandardBlock()
+	if i < 0 {
+		return nil, false
+	}
+
+	return s.tzx.GetBlock(i).(*formats.TZXStandardSpeed).Data, true
+}
+
+func (s *tzxSource) skipBlock() {
+	if s.empty() || (s.pos > s.dataStart) {
+		s.block = s.nextStandardBlock() + 1
+	}
+	s.clear()
+}
+
+// Returns the index of the next standard speed block, skipping the blocks
+// which do not affect the loader. Returns -1 if there is no such block.
+func (s *tzxSource) nextStandardBlock() int {
+	for i := s.block; i < s.tzx.Len(); i++ {
+		switch b := s.tzx.GetBlock(i).(type) {
+		case *formats.TZXStandardSpeed:
+			return i
+
+		case *formats.TZXGroupStart, *formats.TZXGroupEnd, *formats.TZXOtherBlock:
+			// Ignored
+
+		case *formats.TZXPause:
+			if b.Duration == 0 {
+				return -1
+			}
+
+		default:
+			return -1
+		}
+	}
+	return -1
+}
@@ -0,0 +1,111 @@
+package spectrum
+
+import (
+	"fmt"
+
+	"github.com/remogatto/gospeccy/src/formats"
+	"github.com/remogatto/z80"
+)
+
+// Prepares the registers for calling LD-BYTES, and inserts a tape with the block
+func setupTrapLoad(speccy *Spectrum48k, load bool, block formats.TAPBlock) error {
+	tap := new(formats.TAP)
+	err := tap.InsertBlock(0, block)
+	if err != nil {
+		return err
+	}
+
+	speccy.tapeDrive.Insert(NewTape(tap))
+	speccy.tapeDrive.Play()
+
+	cpu := speccy.Cpu
+	cpu.A = 0xff
+	cpu.F = 0
+	if load {
+		cpu.F = z80.FLAG_C
+	}
+	cpu.SetDE(3)
+	cpu.SetIX(0x8000)
+	cpu.SetPC(ROM_LD_BYTES)
+
+	return nil
+}
+
+func (t *testSuite) TestTrapLoad() {
+	app, speccy, err := newTestSpectrum48k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	t.Nil(setupTrapLoad(speccy, true, formats.NewTAPBlock(0xff, []byte{1, 2, 3})))
+	t.True(speccy.tapeDrive.trapLoad())
+
+	cpu := speccy.Cpu
+	t.Equal(uint16(ROM_SA_LD_RET), cpu.PC())
+	t.Equal(uint16(0x8003), cpu.IX())
+	t.Equal(uint16(0), cpu.DE())
+	t.Equal(byte(3), speccy.Memory.Read(0x8002))
+
+	// LD A,H; CP 1 with H=0
+	t.Equal(byte(0), cpu.A)
+	t.Equal(byte(z80.FLAG_S|z80.FLAG_H|z80.FLAG_N|z80.FLAG_C), cpu.F)
+}
+
+func (t *testSuite) TestTrapLoad_VerifyError() {
+	app, speccy, err := newTestSpectrum48k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.Memory.Write(0x8000, 1, true)
+	speccy.Memory.Write(0x8001, 7, true)
+
+	t.Nil(setupTrapLoad(speccy, false, formats.NewTAPBlock(0xff, []byte{1, 2, 3})))
+	t.True(speccy.tapeDrive.trapLoad())
+
+	// LD A,(IX+0); XOR L with (IX+0)=7 and L=2
+	cpu := speccy.Cpu
+	t.Equal(uint16(0x8001), cpu.IX())
+	t.Equal(uint16(2), cpu.DE())
+	t.Equal(byte(0xff^1^2), cpu.H)
+	t.Equal(byte(7^2), cpu.A)
+	t.Equal(byte(z80.FLAG_P), cpu.F)
+}
+
+func (t *testSuite) TestTrapSave() {
+	app, speccy, err := newTestSpectrum48k()
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	speccy.Memory.Write(0x8000, 1, true)
+	speccy.Memory.Write(0x8001, 2, true)
+	speccy.Memory.Write(0x8002, 3, true)
+
+	cpu := speccy.Cpu
+	cpu.A = 0xff
+	cpu.SetDE(3)
+	cpu.SetIX(0x8000)
+	cpu.SetPC(ROM_SA_BYTES)
+	t.True(speccy.tapeDrive.trapSave())
+
+	t.Equal(uint16(ROM_SA_LD_RET), cpu.PC())
+	t.Equal(uint16(0x8004), cpu.IX())
+	t.Equal(uint16(0xffff), cpu.DE())
+
+	// LD A,D; INC A with D=0xff, the carry flag is preserved
+	t.Equal(byte(0), cpu.A)
+	t.Equal(byte(z80.FLAG_Z|z80.FLAG_H|z80.FLAG_C), cpu.F)
+
+	tap := speccy.tapeDrive.makeSavedTape()
+	t.Equal(1, tap.NumBlocks())
+	if !t.Failed() {
+		t.Equal(fmt.Sprint([]byte{0xff, 1, 2, 3, 0xff ^ 1 ^ 2 ^ 3}), fmt.Sprint(tap.GetBlock(0).Data()))
+	}
+}
@@ -0,0 +1,180 @@
+package spectrum
+
+import (
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// Converts the blocks of a TZX tape into edges.
+// The blocks are converted on demand, one at a time.
+type tzxSource struct {
+	tzx   *formats.TZX
+	is48k bool
+
+	block     int // Index of the next block to convert
+	loopStart int // Index of the first block of the current loop
+	loopCount int // Remaining repetitions of the current loop
+
+	// True if the last converted edge is not followed by a pause
+	unterminated bool
+
+	edgeBuffer
+}
+
+func newTZXSource(tzx *formats.TZX) *tzxSource {
+	return &tzxSource{tzx: tzx}
+}
+
+func (s *tzxSource) setMachineType(machineType MachineType) {
+	s.is48k = (machineType != MACHINE_128K)
+}
+
+func (s *tzxSource) NextEdge() (edge TapeEdge, ok bool) {
+	for s.empty() {
+		if s.block >= s.tzx.Len() {
+			if !s.unterminated {
+				return TapeEdge{}, false
+			}
+
+			// The tape ends with a pulse of undefined length,
+			// the final edge finishes it
+			s.clear()
+			s.appendPause(1)
+			s.unterminated = false
+			continue
+		}
+
+		s.clear()
+
+		stop := s.convert(s.tzx.GetBlock(s.block))
+		s.block++
+
+		if n := len(s.edges); n > 0 {
+			s.unterminated = !s.edges[n-1].BlockEnd
+		}
+
+		if stop {
+			return TapeEdge{}, false
+		}
+	}
+
+	return s.next(), true
+}
+
+func (s *tzxSource) AtEnd() bool {
+	return s.empty() && (s.block >= s.tzx.Len()) && !s.unterminated
+}
+
+func (s *tzxSource) Rewind() {
+	s.block = 0
+	s.loopStart = 0
+	s.loopCount = 0
+	s.unterminated = false
+	s.reset()
+}
+
+// Appends a pause of 'ms' milliseconds. The last edge of the preceding
+// pulse is finished by a 1ms pulse of the opposite level, then the
+// signal is held low.
+func (s *tzxSource) appendPause(ms uint16) {
+	if ms == 0 {
+		return
+	}
+
+	s.toggle(formats.TZX_TSTATES_PER_MS)
+	if ms > 1 {
+		s.hold(false, (int(ms)-1)*formats.TZX_TSTATES_PER_MS)
+	}
+	s.markBlockEnd()
+}
+
+// Returns the indices of the blocks which affect the signal
+// but cannot be converted into edges, and are therefore skipped
+func (s *tzxSource) unsupportedBlocks() []int {
+	var indices []int
+	for i := 0; i < s.tzx.Len(); i++ {
+		if b, ok := s.tzx.GetBlock(i).(*formats.TZXOtherBlock); ok {
+			switch b.BlockID {
+			case formats.TZX_BLOCK_CSW_RECORDING, formats.TZX_BLOCK_GENERALIZED_DATA,
+				formats.TZX_BLOCK_JUMP, formats.TZX_BLOCK_CALL_SEQUENCE, formats.TZX_BLOCK_RETURN,
+				formats.TZX_BLOCK_SELECT, formats.TZX_BLOCK_SIGNAL_LEVEL:
+				indices = append(indices, i)
+			}
+		}
+	}
+	return indices
+}
+
+// Converts the block into edges. Returns true if the tape should be stopped.
+func (s *tzxSource) convert(block formats.TZXBlock) (stop bool) {
+	switch b := block.(type) {
+	case *formats.TZXStandardSpeed:
+		for n := b.PilotPulses(); n > 0; n-- {
+			s.toggle(formats.TZX_PILOT_PULSE)
+		}
+		s.dataStart = len(s.edges)
+		s.toggle(formats.TZX_SYNC1_PULSE)
+		s.toggle(formats.TZX_SYNC2_PULSE)
+		s.appendData(b.Data, 8, formats.TZX_ZERO_PULSE, formats.TZX_ONE_PULSE)
+		s.appendPause(b.Pause)
+
+	case *formats.TZXTurboSpeed:
+		for n := b.PilotPulses; n > 0; n-- {
+			s.toggle(int(b.PilotPulse))
+		}
+		s.toggle(int(b.Sync1Pulse))
+		s.toggle(int(b.Sync2Pulse))
+		s.appendData(b.Data, b.UsedBits, int(b.ZeroPulse), int(b.OnePulse))
+		s.appendPause(b.Pause)
+
+	case *formats.TZXPureTone:
+		for n := b.NumPulses; n > 0; n-- {
+			s.toggle(int(b.PulseLen))
+		}
+
+	case *formats.TZXPulseSequence:
+		for _, pulseLen := range b.Pulses {
+			s.toggle(int(pulseLen))
+		}
+
+	case *formats.TZXPureData:
+		s.appendData(b.Data, b.UsedBits, int(b.ZeroPulse), int(b.OnePulse))
+		s.appendPause(b.Pause)
+
+	case *formats.TZXDirectRecording:
+		for i, sample := range b.Data {
+			numBits := 8
+			if i == len(b.Data)-1 {
+				numBits = int(b.UsedBits)
+			}
+
+			for mask := byte(0x80); numBits > 0; mask, numBits = mask>>1, numBits-1 {
+				s.hold((sample&mask) != 0, int(b.TStatesPerSample))
+			}
+		}
+		s.appendPause(b.Pause)
+
+	case *formats.TZXPause:
+		if b.Duration == 0 {
+			return true
+		}
+		s.appendPause(b.Duration)
+
+	case *formats.TZXLoopStart:
+		s.loopStart = s.block + 1
+		s.loopCount = int(b.Repetitions)
+
+	case *formats.TZXLoopEnd:
+		if s.loopCount > 1 {
+			s.loopCount--
+			// The block index is incremented by the caller
+			s.block = s.loopStart - 1
+		} else {
+			s.loopCount = 0
+		}
+
+	case *formats.TZXStop48k:
+		return s.is48k
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+// The screen modes of the Timex machines, selected by bits 0-2 of port 0xff
+const (
+	TIMEX_STANDARD      = 0x00 // The standard screen at 0x4000
+	TIMEX_SECOND_SCREEN = 0x01 // The standard screen at 0x6000
+	TIMEX_HICOLOR       = 0x02 // Bitmap at 0x4000, 8x1 attributes at 0x6000
+	TIMEX_HIRES         = 0x06 // 512x192 pixels, the columns alternate between 0x4000 and 0x6000
+
+	// The offset of the second screen in the screen memory
+	TIMEX_SECOND_SCREEN_OFFSET = 0x2000
+)
+
+// Bits of the Timex SCLD port 0xff
+const (
+	SCLD_SCREEN_MODE       = 0x07
+	SCLD_HIRES_COLOR       = 0x38 // The paper in hi-res mode, the ink is its complement
+	SCLD_INTERRUPT_DISABLE = 0x40
+)
+
+// Returns one of TIMEX_STANDARD, TIMEX_SECOND_SCREEN, TIMEX_HICOLOR, TIMEX_HIRES.
+// Bit 2 of port 0xff selects the hi-res mode regardless of the other bits.
+func (ula *ULA) timexScreenMode() byte {
+	mode := ula.scld & SCLD_SCREEN_MODE
+	switch {
+	case (mode & 0x04) != 0:
+		return TIMEX_HIRES
+	case (mode & 0x02) != 0:
+		return TIMEX_HICOLOR
+	}
+	return mode
+}
+
+// Returns the attribute of the whole screen in hi-res mode.
+// Both the ink and the paper are bright.
+func (ula *ULA) hiresAttr() byte {
+	paper := (ula.scld & SCLD_HIRES_COLOR) >> 3
+	ink := 7 - paper
+	return 0x40 | (paper << 3) | ink
+}
+
+func (ula *ULA) interruptsDisabled() bool {
+	return (ula.scld & SCLD_INTERRUPT_DISABLE) != 0
+}
+
+func (ula *ULA) setSCLD(scld byte) {
+	if ((ula.scld ^ scld) & (SCLD_SCREEN_MODE | SCLD_HIRES_COLOR)) != 0 {
+		// The screen is read from other addresses --> repaint the whole screen
+		for i := 0; i < ScreenWidth_Attr*ScreenHeight_Attr; i++ {
+			ula.dirtyScreen[i] = true
+		}
+	}
+	ula.scld = scld
+}
+
+// Handle a write to an address in range (0x6000 ... 0x7b00-1)
+func (ula *ULA) timexScreenWrite(address uint16, oldValue byte, newValue byte) {
+	if (oldValue != newValue) && (ula.timexScreenMode() != TIMEX_STANDARD) {
+		address -= TIMEX_SECOND_SCREEN_OFFSET
+		if address < ATTR_BASE_ADDR {
+			ula.screenBitmapTouch(address)
+		} else {
+			ula.screenAttrTouch(address)
+		}
+	}
+}
+
+// The Timex SCLD port 0xff: screen modes and interrupt disabling
+type scldDevice struct {
+	ula *ULA
+}
+
+func (d *scldDevice) Decode() (mask, value uint16) {
+	return 0x00ff, 0x00ff
+}
+
+func (d *scldDevice) Read(address uint16) byte {
+	return d.ula.scld
+}
+
+func (d *scldDevice) Write(address uint16, b byte) {
+	d.ula.setSCLD(b)
+}
+
+func (d *scldDevice) Reset() {
+	d.ula.setSCLD(0)
+}
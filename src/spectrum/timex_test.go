@@ -0,0 +1,111 @@
+package spectrum
+
+// Returns the number of dirty 8x8 cells, and marks all cells as clean
+func countDirtyCells(ula *ULA) int {
+	dirty := 0
+	for i, d := range ula.dirtyScreen {
+		if d {
+			dirty++
+		}
+		ula.dirtyScreen[i] = false
+	}
+	return dirty
+}
+
+func (t *testSuite) TestTimex_ScreenModes() {
+	app, speccy, err := newTestSpectrum(MACHINE_TC2048, [][0x4000]byte{{}})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	// The first byte of the bitmap and of the attributes of both screens
+	speccy.Memory.Write(0x4000, 0x11, true)
+	speccy.Memory.Write(0x5800, 0x07, true)
+	speccy.Memory.Write(0x6000, 0x22, true)
+	speccy.Memory.Write(0x7800, 0x38, true)
+
+	tests := []struct {
+		portFF  byte
+		mode    byte
+		screen  ScreenMode
+		bitmap  byte
+		bitmap2 byte
+		attr    Attr_4bit
+	}{
+		{0x00, TIMEX_STANDARD, SCREEN_STANDARD, 0x11, 0x00, 0x70},
+		{0x01, TIMEX_SECOND_SCREEN, SCREEN_STANDARD, 0x22, 0x00, 0x07},
+
+		// The 8x1 attribute is the byte at 0x6000
+		{0x02, TIMEX_HICOLOR, SCREEN_STANDARD, 0x11, 0x00, 0x24},
+
+		// Bright ink 6 on bright paper 1, both screens are displayed
+		{0x0e, TIMEX_HIRES, SCREEN_HIRES, 0x11, 0x22, 0xe9},
+		{0x04, TIMEX_HIRES, SCREEN_HIRES, 0x11, 0x22, 0xf8},
+
+		{0x00, TIMEX_STANDARD, SCREEN_STANDARD, 0x11, 0x00, 0x70},
+	}
+
+	ula := speccy.ula
+	previous := byte(0x00)
+	for _, test := range tests {
+		countDirtyCells(ula)
+
+		// Only the lower byte of the address is decoded
+		speccy.Ports.WritePortInternal(0x12ff, test.portFF, false)
+		t.Equal(test.portFF, speccy.Ports.ReadPortInternal(0x00ff, false))
+		t.Equal(test.mode, ula.timexScreenMode())
+
+		// Changing the screen mode repaints the whole screen
+		if test.portFF != previous {
+			t.Equal(ScreenWidth_Attr*ScreenHeight_Attr, countDirtyCells(ula))
+		} else {
+			t.Equal(0, countDirtyCells(ula))
+		}
+		previous = test.portFF
+
+		screen := ula.prepare(&DisplayInfo{})
+		t.Equal(test.screen, screen.Mode)
+		t.Equal(test.bitmap, screen.Bitmap[0])
+		t.Equal(test.bitmap2, screen.Bitmap2[0])
+		t.Equal(test.attr, screen.Attr[0])
+	}
+
+	// Disabling the interrupts does not change the screen
+	speccy.Ports.WritePortInternal(0x00ff, SCLD_INTERRUPT_DISABLE, false)
+	t.True(ula.interruptsDisabled())
+	t.Equal(0, countDirtyCells(ula))
+}
+
+func (t *testSuite) TestTimex_SecondScreenWrite() {
+	app, speccy, err := newTestSpectrum(MACHINE_TC2048, [][0x4000]byte{{}})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	memory := speccy.Memory
+	ula := speccy.ula
+
+	// The second screen is not displayed in the standard mode
+	countDirtyCells(ula)
+	memory.WriteByteInternal(0x6000, 0x55)
+	t.Equal(0, countDirtyCells(ula))
+
+	speccy.Ports.WritePortInternal(0x00ff, TIMEX_SECOND_SCREEN, false)
+	countDirtyCells(ula)
+
+	// The bitmap byte at 0x6001 and the attribute of the cell (1,1)
+	memory.WriteByteInternal(0x6001, 0x55)
+	t.True(ula.dirtyScreen[1])
+	memory.WriteByteInternal(0x7800+ScreenWidth_Attr+1, 0x55)
+	t.True(ula.dirtyScreen[ScreenWidth_Attr+1])
+	t.Equal(2, countDirtyCells(ula))
+
+	// Writing the same value, or outside of the second screen, does not change the display
+	memory.WriteByteInternal(0x6001, 0x55)
+	memory.WriteByteInternal(0x7b00, 0x55)
+	t.Equal(0, countDirtyCells(ula))
+}
@@ -0,0 +1,233 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/remogatto/gospeccy/src/disasm"
+)
+
+// Output formats of the execution trace
+const (
+	TRACE_CSV  = iota // Comma-separated values, with a header line
+	TRACE_JSON        // One JSON object per line
+)
+
+// The configuration of an execution trace
+type TraceConfig struct {
+	// The output file. If the name ends with ".gz", the output is compressed by gzip.
+	Path string
+
+	Format int
+
+	// Only the instructions at addresses Start ... End (inclusive) are traced
+	Start, End uint16
+}
+
+// Returns the trace format implied by the extension of the file name:
+// ".json" or ".jsonl" selects JSON lines, anything else selects CSV.
+func TraceFormatFromPath(path string) int {
+	path = strings.TrimSuffix(strings.ToLower(path), ".gz")
+	if strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".jsonl") {
+		return TRACE_JSON
+	}
+	return TRACE_CSV
+}
+
+// A single traced instruction, together with the state of the machine
+// before the instruction was executed. Numbers are hexadecimal.
+type TraceRecord struct {
+	Frame       uint   `json:"frame"`
+	Tstate      int    `json:"tstate"`
+	PC          string `json:"pc"`
+	Bytes       string `json:"bytes"`
+	Instruction string `json:"instruction"`
+
+	AF  string `json:"af"`
+	BC  string `json:"bc"`
+	DE  string `json:"de"`
+	HL  string `json:"hl"`
+	IX  string `json:"ix"`
+	IY  string `json:"iy"`
+	SP  string `json:"sp"`
+	AF_ string `json:"af_"`
+	BC_ string `json:"bc_"`
+	DE_ string `json:"de_"`
+	HL_ string `json:"hl_"`
+	IR  string `json:"ir"`
+
+	IFF1 byte `json:"iff1"`
+	IFF2 byte `json:"iff2"`
+	IM   byte `json:"im"`
+}
+
+const traceCSVHeader = "frame,tstate,pc,bytes,instruction,af,bc,de,hl,ix,iy,sp,af_,bc_,de_,hl_,ir,iff1,iff2,im\n"
+
+// Writes the executed instructions into a file.
+//
+// The tracer is hooked into the main loop of 'Spectrum48k.doOpcodes'.
+// When no trace is active, the loop only checks a local boolean variable.
+type Tracer struct {
+	speccy *Spectrum48k
+	config TraceConfig
+
+	file       *os.File
+	compressor *gzip.Writer // Can be nil
+	out        *bufio.Writer
+
+	// The number of traced instructions
+	count uint64
+
+	// The first error encountered while writing the trace
+	err error
+}
+
+// Creates the output file and writes the header of the trace
+func newTracer(speccy *Spectrum48k, config TraceConfig) (*Tracer, error) {
+	if (config.Format != TRACE_CSV) && (config.Format != TRACE_JSON) {
+		return nil, fmt.Errorf("invalid trace format %d", config.Format)
+	}
+	if config.Start > config.End {
+		return nil, fmt.Errorf("invalid address range 0x%04x-0x%04x", config.Start, config.End)
+	}
+
+	file, err := os.Create(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	tracer := &Tracer{speccy: speccy, config: config, file: file}
+
+	var w io.Writer = file
+	if strings.HasSuffix(strings.ToLower(config.Path), ".gz") {
+		tracer.compressor = gzip.NewWriter(file)
+		w = tracer.compressor
+	}
+	tracer.out = bufio.NewWriterSize(w, 64*1024)
+
+	if config.Format == TRACE_CSV {
+		_, tracer.err = tracer.out.WriteString(traceCSVHeader)
+	}
+
+	return tracer, nil
+}
+
+// Traces the instruction at PC, which is about to be executed
+func (tracer *Tracer) instruction() {
+	speccy := tracer.speccy
+	pc := speccy.Cpu.PC()
+	if (pc < tracer.config.Start) || (pc > tracer.config.End) || (tracer.err != nil) {
+		return
+	}
+
+	instr := disasm.Disassemble(speccy.Memory, pc)
+	cpu := speccy.cpuState()
+
+	bytes := make([]string, len(instr.Bytes))
+	for i, b := range instr.Bytes {
+		bytes[i] = fmt.Sprintf("%02x", b)
+	}
+
+	pair := func(hi, lo byte) string {
+		return fmt.Sprintf("%02x%02x", hi, lo)
+	}
+
+	record := TraceRecord{
+		Frame:       speccy.ula.frame,
+		Tstate:      speccy.Cpu.Tstates,
+		PC:          fmt.Sprintf("%04x", pc),
+		Bytes:       strings.Join(bytes, " "),
+		Instruction: instr.Mnemonic,
+
+		AF:  pair(cpu.A, cpu.F),
+		BC:  pair(cpu.B, cpu.C),
+		DE:  pair(cpu.D, cpu.E),
+		HL:  pair(cpu.H, cpu.L),
+		IX:  fmt.Sprintf("%04x", cpu.IX),
+		IY:  fmt.Sprintf("%04x", cpu.IY),
+		SP:  fmt.Sprintf("%04x", cpu.SP),
+		AF_: pair(cpu.A_, cpu.F_),
+		BC_: pair(cpu.B_, cpu.C_),
+		DE_: pair(cpu.D_, cpu.E_),
+		HL_: pair(cpu.H_, cpu.L_),
+		IR:  pair(cpu.I, cpu.R),
+
+		IFF1: cpu.IFF1,
+		IFF2: cpu.IFF2,
+		IM:   cpu.IM,
+	}
+
+	tracer.count++
+	tracer.err = tracer.write(&record)
+	if tracer.err != nil {
+		speccy.app.PrintfMsg("trace: %s", tracer.err)
+	}
+}
+
+func (tracer *Tracer) write(r *TraceRecord) error {
+	if tracer.config.Format == TRACE_JSON {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = tracer.out.Write(append(data, '\n'))
+		return err
+	}
+
+	_, err := fmt.Fprintf(tracer.out, "%d,%d,%s,%s,\"%s\",%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%d,%d,%d\n",
+		r.Frame, r.Tstate, r.PC, r.Bytes, r.Instruction,
+		r.AF, r.BC, r.DE, r.HL, r.IX, r.IY, r.SP, r.AF_, r.BC_, r.DE_, r.HL_, r.IR,
+		r.IFF1, r.IFF2, r.IM)
+	return err
+}
+
+// Flushes the trace and closes the output file
+func (tracer *Tracer) close() error {
+	err := tracer.err
+	if e := tracer.out.Flush(); err == nil {
+		err = e
+	}
+	if tracer.compressor != nil {
+		if e := tracer.compressor.Close(); err == nil {
+			err = e
+		}
+	}
+	if e := tracer.file.Close(); err == nil {
+		err = e
+	}
+
+	if tracer.speccy.app.Verbose {
+		tracer.speccy.app.PrintfMsg("trace: %d instructions written to \"%s\"", tracer.count, tracer.config.Path)
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package spectrum
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Traces two frames of 'testDebuggerProgram'. Returns the lines of the trace file.
+func traceTestProgram(config TraceConfig) ([]string, error) {
+	app, speccy, _, err := newTestDebuggerSpectrum()
+	if err != nil {
+		return nil, err
+	}
+	defer exitTestSpectrum(app)
+
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_TraceStart{Config: config, ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	speccy.RunFrames(2)
+	speccy.CommandChannel <- Cmd_TraceStop{ErrChan: errChan}
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(config.Path, ".gz") {
+		r, err = gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// The addresses of the instructions executed by the first loop of 'testDebuggerProgram'
+var testDebuggerProgramPCs = []string{"8000", "8001", "8003", "8005", "8010", "8012", "8012", "8012", "8014", "8008"}
+
+func (t *testSuite) TestTrace_CSV() {
+	dir, err := ioutil.TempDir("", "gospeccy")
+	t.Nil(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"trace.csv", "trace.csv.gz"} {
+		config := TraceConfig{Path: path.Join(dir, name), Format: TraceFormatFromPath(name), End: 0xffff}
+		t.Equal(TRACE_CSV, config.Format)
+
+		lines, err := traceTestProgram(config)
+		t.Nil(err)
+		if t.Failed() {
+			return
+		}
+
+		t.Equal(strings.TrimSuffix(traceCSVHeader, "\n"), lines[0])
+		t.True(len(lines) > 1000)
+
+		// Instructions containing a comma are quoted
+		rows, err := csv.NewReader(strings.NewReader(strings.Join(lines, "\n"))).ReadAll()
+		t.Nil(err)
+		if t.Failed() {
+			return
+		}
+
+		// frame,tstate,pc,bytes,instruction,af,bc,de,hl,ix,iy,sp,...
+		for i, pc := range testDebuggerProgramPCs {
+			t.Equal(pc, rows[1+i][2])
+		}
+		t.Equal("0", rows[1][1])
+		t.Equal("3c", rows[1][3])
+		t.Equal("INC A", rows[1][4])
+		t.Equal("ff00", rows[1][11])
+		t.Equal("OUT ($FE),A", rows[3][4])
+		t.Equal("06 03", rows[5][3])
+		t.Equal("fefe", rows[5][11])
+	}
+}
+
+func (t *testSuite) TestTrace_JSON() {
+	dir, err := ioutil.TempDir("", "gospeccy")
+	t.Nil(err)
+	defer os.RemoveAll(dir)
+
+	name := "trace.jsonl.gz"
+	config := TraceConfig{Path: path.Join(dir, name), Format: TraceFormatFromPath(name), End: 0xffff}
+	t.Equal(TRACE_JSON, config.Format)
+
+	lines, err := traceTestProgram(config)
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+
+	t.True(len(lines) > 1000)
+
+	var records []TraceRecord
+	for _, line := range lines {
+		var record TraceRecord
+		t.Nil(json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+
+	for i, pc := range testDebuggerProgramPCs {
+		t.Equal(pc, records[i].PC)
+	}
+	t.Equal(0, records[0].Tstate)
+	t.Equal("3c", records[0].Bytes)
+	t.Equal("INC A", records[0].Instruction)
+	t.Equal("cd 10 80", records[3].Bytes)
+	t.Equal("ff00", records[3].SP)
+	t.Equal("fefe", records[4].SP)
+
+	// Both frames are traced
+	t.Equal(records[0].Frame+1, records[len(records)-1].Frame)
+}
+
+func (t *testSuite) TestTrace_AddressRange() {
+	dir, err := ioutil.TempDir("", "gospeccy")
+	t.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// Only the subroutine is traced
+	config := TraceConfig{Path: path.Join(dir, "trace.json"), Format: TRACE_JSON, Start: 0x8010, End: 0x8013}
+	lines, err := traceTestProgram(config)
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+
+	t.True(len(lines) > 0)
+	count := make(map[string]int)
+	for _, line := range lines {
+		var record TraceRecord
+		t.Nil(json.Unmarshal([]byte(line), &record))
+		count[record.PC]++
+	}
+	t.Equal(2, len(count))
+	t.Equal(3*count["8010"], count["8012"])
+
+	// An empty address range is rejected
+	config.Start, config.End = 0x8013, 0x8010
+	_, err = traceTestProgram(config)
+	t.Not(t.Nil(err))
+}
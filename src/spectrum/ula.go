@@ -1,10 +1,15 @@
 package spectrum
 
 import (
+	"fmt"
 	"time"
 	"github.com/remogatto/z80"
 )
 
+// The board issue emulated by default. It determines the value of bit 6
+// read from port 0xfe, see Ports.earInput.
+const DefaultIssue = 3
+
 type ula_byte_t struct {
 	valid bool
 	value uint8
@@ -38,19 +43,33 @@ type ULA struct {
 	// Whether the 8x8 rectangular screen area was modified during the current frame
 	dirtyScreen [ScreenWidth_Attr * ScreenHeight_Attr]bool
 
+	// The issue of the emulated board (2 or 3)
+	issue byte
+
+	// The visible part of the border, sent to display receivers
+	border Border
+
+	// The state of the ULAplus palette extension
+	ulaplus ulaplusState
+
+	// The last value written to the Timex SCLD port 0xff
+	scld byte
+
 	z80    *z80.Z80
 	memory *Memory
 	ports  *Ports
+	model  *machineModel
 }
 
 func NewULA() *ULA {
-	return &ULA{accurateEmulation: true}
+	return &ULA{accurateEmulation: true, issue: DefaultIssue, border: BorderNormal}
 }
 
-func (ula *ULA) init(z80 *z80.Z80, memory *Memory, ports *Ports) {
+func (ula *ULA) init(z80 *z80.Z80, memory *Memory, ports *Ports, model *machineModel) {
 	ula.z80 = z80
 	ula.memory = memory
 	ula.ports = ports
+	ula.model = model
 }
 
 func (ula *ULA) reset() {
@@ -65,6 +84,22 @@ func (ula *ULA) setBorderColor(borderColor byte) {
 	ula.borderColor = borderColor
 }
 
+func (ula *ULA) setIssue(issue uint) error {
+	if (issue != 2) && (issue != 3) {
+		return fmt.Errorf("invalid board issue %d, expected 2 or 3", issue)
+	}
+	ula.issue = byte(issue)
+	return nil
+}
+
+func (ula *ULA) setBorder(border Border) error {
+	if err := border.check(); err != nil {
+		return err
+	}
+	ula.border = border
+	return nil
+}
+
 func (ula *ULA) setEmulationAccuracy(accurateEmulation bool) {
 	ula.accurateEmulation = accurateEmulation
 }
@@ -121,7 +156,7 @@ func (ula *ULA) screenBitmapWrite(address uint16, oldValue byte, newValue byte)
 
 		if ula.accurateEmulation {
 			rel_addr := address - SCREEN_BASE_ADDR
-			ula_lineStart_tstate := screenline_start_tstates[rel_addr>>BytesPerLine_log2]
+			ula_lineStart_tstate := ula.model.screenline_start_tstates[rel_addr>>BytesPerLine_log2]
 			x, _ := screenAddr_to_xy(address)
 			ula_tstate := ula_lineStart_tstate + int(x>>PIXELS_PER_TSTATE_LOG2)
 			if ula_tstate <= ula.z80.Tstates {
@@ -147,7 +182,7 @@ func (ula *ULA) screenAttrWrite(address uint16, oldValue byte, newValue byte) {
 			y := 8 * attr_y
 
 			ofs := (y << BytesPerLine_log2) + attr_x
-			ula_tstate := int(FIRST_SCREEN_BYTE + y*TSTATES_PER_LINE + (x >> PIXELS_PER_TSTATE_LOG2))
+			ula_tstate := ula.model.timings.FirstScreenByte + int(y)*ula.model.timings.TStatesPerLine + int(x>>PIXELS_PER_TSTATE_LOG2)
 
 			for i := 0; i < 8; i++ {
 				if ula_tstate <= CPU.Tstates {
@@ -156,7 +191,7 @@ func (ula *ULA) screenAttrWrite(address uint16, oldValue byte, newValue byte) {
 						*ula_attr = ula_attr_t{true, oldValue, CPU.Tstates}
 					}
 					ofs += BytesPerLine
-					ula_tstate += TSTATES_PER_LINE
+					ula_tstate += ula.model.timings.TStatesPerLine
 				} else {
 					break
 				}
@@ -165,6 +200,72 @@ func (ula *ULA) screenAttrWrite(address uint16, oldValue byte, newValue byte) {
 	}
 }
 
+// Handle a change of the RAM bank the ULA is reading the screen from.
+// The bytes which the ULA already read from 'oldScreen' during the current frame
+// are remembered, the whole screen is marked as modified.
+func (ula *ULA) screenBankSwitch(oldScreen *[0x4000]byte) {
+	for i := 0; i < ScreenWidth_Attr*ScreenHeight_Attr; i++ {
+		ula.dirtyScreen[i] = true
+	}
+
+	if !ula.accurateEmulation {
+		return
+	}
+
+	CPU := ula.z80
+	newScreen := ula.memory.screen()
+
+	for rel_addr := uint16(0); rel_addr < BytesPerLine*ScreenHeight; rel_addr++ {
+		if ula.bitmap[rel_addr].valid || (oldScreen[rel_addr] == newScreen[rel_addr]) {
+			continue
+		}
+
+		x, _ := screenAddr_to_xy(SCREEN_BASE_ADDR + rel_addr)
+		ula_tstate := ula.model.screenline_start_tstates[rel_addr>>BytesPerLine_log2] + int(x>>PIXELS_PER_TSTATE_LOG2)
+		if ula_tstate <= CPU.Tstates {
+			ula.bitmap[rel_addr] = ula_byte_t{true, oldScreen[rel_addr]}
+		}
+	}
+
+	for ofs := uint(0); ofs < BytesPerLine*ScreenHeight; ofs++ {
+		if ula.attr[ofs].valid {
+			continue
+		}
+
+		y := (ofs >> BytesPerLine_log2)
+		attr_x := (ofs & 0x001f)
+		attr_ofs := ATTR_BASE_ADDR - SCREEN_BASE_ADDR + ((y >> 3) << BytesPerLine_log2) + attr_x
+		if oldScreen[attr_ofs] == newScreen[attr_ofs] {
+			continue
+		}
+
+		x := 8 * attr_x
+		ula_tstate := ula.model.timings.FirstScreenByte + int(y)*ula.model.timings.TStatesPerLine + int(x>>PIXELS_PER_TSTATE_LOG2)
+		if ula_tstate <= CPU.Tstates {
+			ula.attr[ofs] = ula_attr_t{true, oldScreen[attr_ofs], CPU.Tstates}
+		}
+	}
+}
+
+// Returns the attribute of the pixel line 'linearY_ofs' in the specified Timex screen mode.
+// 'attr_ofs' is the offset of the 8x8 attribute, 'screen_addr' is the address
+// of the bitmap byte on the same pixel line.
+func (ula *ULA) attrAt(mode byte, screen_data *[0x4000]byte, linearY_ofs uint, attr_ofs uint, screen_addr uint16) byte {
+	switch mode {
+	case TIMEX_SECOND_SCREEN:
+		return screen_data[TIMEX_SECOND_SCREEN_OFFSET+ATTR_BASE_ADDR-SCREEN_BASE_ADDR+attr_ofs]
+	case TIMEX_HICOLOR:
+		return screen_data[TIMEX_SECOND_SCREEN_OFFSET+uint(screen_addr)-SCREEN_BASE_ADDR]
+	case TIMEX_HIRES:
+		return ula.hiresAttr()
+	}
+
+	if ula.attr[linearY_ofs].valid {
+		return ula.attr[linearY_ofs].value
+	}
+	return screen_data[ATTR_BASE_ADDR-SCREEN_BASE_ADDR+attr_ofs]
+}
+
 func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 	sendDiffOnly := false
 	if display.lastFrame != nil {
@@ -173,9 +274,18 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 
 	var screen DisplayData
 	{
+		// If the ULAplus palette is enabled, the FLASH bit selects a palette group
+		ulaplus := ula.ulaplus.enabled
+
+		// The Timex screen mode
+		mode := ula.timexScreenMode()
+		if mode == TIMEX_HIRES {
+			screen.Mode = SCREEN_HIRES
+		}
+
 		flash := (ula.frame & 0x10) != 0
 		flash_previous := ((ula.frame - 1) & 0x10) != 0
-		flash_diff := (flash != flash_previous)
+		flash_diff := (flash != flash_previous) && !ulaplus
 
 		// screen.dirty
 		if sendDiffOnly {
@@ -188,12 +298,13 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 
 		// Fill screen.bitmap & screen.attr, but only the dirty regions.
 
-		var memory_data = ula.memory.Data()
+		var screen_data = ula.memory.screen()
 		ula_bitmap := &ula.bitmap
-		ula_attr := &ula.attr
 		screen_dirty := &screen.Dirty
 		screen_bitmap := &screen.Bitmap
+		screen_bitmap2 := &screen.Bitmap2
 		screen_attr := &screen.Attr
+		screen_group := &screen.Group
 		for attr_y := uint(0); attr_y < ScreenHeight_Attr; attr_y++ {
 			attr_y8 := 8 * attr_y
 
@@ -203,14 +314,10 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 				// Make sure to send all changed flashing pixels to the DisplayReceiver
 				if flash_diff {
 					linearY_ofs := (attr_y8 << BytesPerLine_log2) + attr_x
+					screen_addr := xy_to_screenAddr(uint8(8*attr_x), uint8(attr_y8))
 
 					for y := 0; y < 8; y++ {
-						var attr byte
-						if !ula_attr[linearY_ofs].valid {
-							attr = memory_data[ATTR_BASE_ADDR+attr_ofs]
-						} else {
-							attr = ula_attr[linearY_ofs].value
-						}
+						attr := ula.attrAt(mode, screen_data, linearY_ofs, attr_ofs, screen_addr)
 
 						if (attr & 0x80) != 0 {
 							screen_dirty[attr_ofs] = true
@@ -218,6 +325,7 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 						}
 
 						linearY_ofs += BytesPerLine
+						screen_addr += 8 * BytesPerLine
 					}
 				}
 
@@ -231,10 +339,16 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 					linearY_ofs := (attr_y8 << BytesPerLine_log2) + attr_x
 
 					for y := 0; y < 8; y++ {
-						if !ula_bitmap[screen_addr-SCREEN_BASE_ADDR].valid {
-							screen_bitmap[linearY_ofs] = memory_data[screen_addr]
+						rel_addr := screen_addr - SCREEN_BASE_ADDR
+						if mode == TIMEX_SECOND_SCREEN {
+							screen_bitmap[linearY_ofs] = screen_data[TIMEX_SECOND_SCREEN_OFFSET+rel_addr]
+						} else if !ula_bitmap[rel_addr].valid {
+							screen_bitmap[linearY_ofs] = screen_data[rel_addr]
 						} else {
-							screen_bitmap[linearY_ofs] = ula_bitmap[screen_addr-SCREEN_BASE_ADDR].value
+							screen_bitmap[linearY_ofs] = ula_bitmap[rel_addr].value
+						}
+						if mode == TIMEX_HIRES {
+							screen_bitmap2[linearY_ofs] = screen_data[TIMEX_SECOND_SCREEN_OFFSET+rel_addr]
 						}
 
 						screen_addr += 8 * BytesPerLine
@@ -245,26 +359,31 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 				// screen.attr
 				{
 					linearY_ofs := (attr_y8 << BytesPerLine_log2) + attr_x
+					screen_addr := xy_to_screenAddr(uint8(8*attr_x), uint8(attr_y8))
 
 					for y := 0; y < 8; y++ {
-						var attr byte
-						if !ula_attr[linearY_ofs].valid {
-							attr = memory_data[ATTR_BASE_ADDR+attr_ofs]
+						attr := ula.attrAt(mode, screen_data, linearY_ofs, attr_ofs, screen_addr)
+
+						if ulaplus {
+							ink := attr & 0x07
+							paper := 8 | ((attr & 0x38) >> 3)
+
+							screen_attr[linearY_ofs] = Attr_4bit((ink << 4) | paper)
+							screen_group[linearY_ofs] = attr >> 6
 						} else {
-							attr = ula_attr[linearY_ofs].value
-						}
+							ink := ((attr & 0x40) >> 3) | (attr & 0x07)
+							paper := (attr & 0x78) >> 3
 
-						ink := ((attr & 0x40) >> 3) | (attr & 0x07)
-						paper := (attr & 0x78) >> 3
+							if flash && ((attr & 0x80) != 0) {
+								/* invert flashing attributes */
+								ink, paper = paper, ink
+							}
 
-						if flash && ((attr & 0x80) != 0) {
-							/* invert flashing attributes */
-							ink, paper = paper, ink
+							screen_attr[linearY_ofs] = Attr_4bit((ink << 4) | paper)
 						}
 
-						screen_attr[linearY_ofs] = Attr_4bit((ink << 4) | paper)
-
 						linearY_ofs += BytesPerLine
+						screen_addr += 8 * BytesPerLine
 					}
 				}
 			}
@@ -272,6 +391,17 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 
 		// screen.borderEvents
 		screen.BorderEvents = ula.ports.getBorderEvents()
+		if mode == TIMEX_HIRES {
+			// The border has the color of the paper
+			paper := 8 | ((ula.hiresAttr() & 0x38) >> 3)
+			screen.BorderEvents = []BorderEvent{
+				{TState: 0, Color: paper},
+				{TState: ula.model.timings.TStatesPerFrame, Color: paper},
+			}
+		}
+		screen.Border = ula.border
+		screen.ULAplusPalette_orNil = ula.ulaplusPalette()
+		screen.Timings = ula.model.timings
 	}
 
 	return &screen
@@ -321,10 +451,14 @@ func (a *DisplayData) add(b *DisplayData) {
 	a_dirty := &a.Dirty
 	a_bitmap := &a.Bitmap
 	a_attr := &a.Attr
+	a_group := &a.Group
+	a_bitmap2 := &a.Bitmap2
 
 	b_dirty := &b.Dirty
 	b_bitmap := &b.Bitmap
 	b_attr := &b.Attr
+	b_group := &b.Group
+	b_bitmap2 := &b.Bitmap2
 
 	for attr_y := uint(0); attr_y < ScreenHeight_Attr; attr_y++ {
 		attr_y8 := 8 * attr_y
@@ -339,6 +473,8 @@ func (a *DisplayData) add(b *DisplayData) {
 				for y := 0; y < 8; y++ {
 					a_bitmap[ofs] = b_bitmap[ofs]
 					a_attr[ofs] = b_attr[ofs]
+					a_group[ofs] = b_group[ofs]
+					a_bitmap2[ofs] = b_bitmap2[ofs]
 					ofs += BytesPerLine
 				}
 			}
@@ -346,4 +482,8 @@ func (a *DisplayData) add(b *DisplayData) {
 	}
 
 	a.BorderEvents = b.BorderEvents
+	a.Mode = b.Mode
+	a.Border = b.Border
+	a.ULAplusPalette_orNil = b.ULAplusPalette_orNil
+	a.Timings = b.Timings
 }
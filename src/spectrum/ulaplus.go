@@ -0,0 +1,140 @@
+/*
+
+Copyright (c) 2010 Andrea Fazzi
+
+Permission is hereby granted, free of charge, to any person obtaining
+a copy of this software and associated documentation files (the
+"Software"), to deal in the Software without restriction, including
+without limitation the rights to use, copy, modify, merge, publish,
+distribute, sublicense, and/or sell copies of the Software, and to
+permit persons to whom the Software is furnished to do so, subject to
+the following conditions:
+
+The above copyright notice and this permission notice shall be
+included in all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+*/
+
+package spectrum
+
+// ULAplus is an extension of the ULA adding a palette of 64 colours.
+//
+// Writing to port 0xbf3b selects a register: bits 7-6 select the group
+// (0 = palette, 1 = mode) and bits 5-0 select the palette entry.
+// Port 0xff3b reads or writes the selected register.
+// Bit 0 of the mode register enables the palette.
+//
+// If the palette is enabled, the FLASH and BRIGHT bits of an attribute
+// select one of four palette groups of 16 entries: the ink is one of the entries 0..7
+// of the group, the paper is one of the entries 8..15. The border is the paper of group 0.
+type ulaplusState struct {
+	// Whether the palette is enabled
+	enabled bool
+
+	// The last value written to port 0xbf3b
+	register byte
+
+	// The palette registers, in GRB332 format
+	palette [64]byte
+}
+
+const (
+	ULAPLUS_GROUP_PALETTE = 0x00
+	ULAPLUS_GROUP_MODE    = 0x40
+)
+
+// Converts a GRB332 palette entry to a 32-bit color.
+// The 2-bit blue component is extended to 3 bits by OR-ing its two bits.
+func ulaplusColor(grb byte) uint32 {
+	g := (grb >> 5) & 0x07
+	r := (grb >> 2) & 0x07
+	b := ((grb & 0x03) << 1) | ((grb >> 1) & 0x01) | (grb & 0x01)
+
+	expand := func(c byte) byte {
+		return (c << 5) | (c << 2) | (c >> 1)
+	}
+
+	return RGBA{expand(r), expand(g), expand(b), 255}.value32()
+}
+
+// Returns the colors of the palette, or nil if the palette is disabled
+func (ula *ULA) ulaplusPalette() *[64]uint32 {
+	if !ula.ulaplus.enabled {
+		return nil
+	}
+
+	palette := new([64]uint32)
+	for i, grb := range ula.ulaplus.palette {
+		palette[i] = ulaplusColor(grb)
+	}
+	return palette
+}
+
+func (ula *ULA) setULAplusEnabled(enabled bool) {
+	if ula.ulaplus.enabled != enabled {
+		ula.ulaplus.enabled = enabled
+
+		// The attributes are interpreted differently --> repaint the whole screen
+		for i := 0; i < ScreenWidth_Attr*ScreenHeight_Attr; i++ {
+			ula.dirtyScreen[i] = true
+		}
+	}
+}
+
+// The ULAplus ports (0xbf3b and 0xff3b).
+// Bit 14 of the address selects the port.
+type ulaplusDevice struct {
+	ula *ULA
+}
+
+func (d *ulaplusDevice) Decode() (mask, value uint16) {
+	return 0xbfff, 0xbf3b
+}
+
+func (d *ulaplusDevice) Read(address uint16) byte {
+	state := &d.ula.ulaplus
+	if (address & 0x4000) != 0 {
+		switch state.register & 0xc0 {
+		case ULAPLUS_GROUP_PALETTE:
+			return state.palette[state.register&0x3f]
+		case ULAPLUS_GROUP_MODE:
+			if state.enabled {
+				return 0x01
+			}
+			return 0x00
+		}
+	}
+	return 0xff
+}
+
+// The register port 0xbf3b is write-only
+func (d *ulaplusDevice) DrivesBus(address uint16) bool {
+	return (address & 0x4000) != 0
+}
+
+func (d *ulaplusDevice) Write(address uint16, b byte) {
+	state := &d.ula.ulaplus
+	if (address & 0x4000) == 0 {
+		state.register = b
+		return
+	}
+
+	switch state.register & 0xc0 {
+	case ULAPLUS_GROUP_PALETTE:
+		state.palette[state.register&0x3f] = b
+	case ULAPLUS_GROUP_MODE:
+		d.ula.setULAplusEnabled((b & 0x01) != 0)
+	}
+}
+
+func (d *ulaplusDevice) Reset() {
+	d.ula.ulaplus = ulaplusState{}
+}
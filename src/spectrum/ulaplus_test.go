@@ -0,0 +1,82 @@
+package spectrum
+
+func (t *testSuite) TestULAplus_Registers() {
+	app, speccy, err := newTestSpectrum(MACHINE_48K, [][0x4000]byte{{}})
+	t.Nil(err)
+	if t.Failed() {
+		return
+	}
+	defer exitTestSpectrum(app)
+
+	ports := speccy.Ports
+	ula := speccy.ula
+
+	// Select the palette entry 5 and write it
+	ports.WritePortInternal(0xbf3b, ULAPLUS_GROUP_PALETTE|5, false)
+	ports.WritePortInternal(0xff3b, 0xa7, false)
+	t.Equal(byte(0xa7), ula.ulaplus.palette[5])
+	t.Equal(byte(0xa7), ports.ReadPortInternal(0xff3b, false))
+
+	// Other entries are not affected
+	ports.WritePortInternal(0xbf3b, ULAPLUS_GROUP_PALETTE|6, false)
+	t.Equal(byte(0x00), ports.ReadPortInternal(0xff3b, false))
+
+	// The palette is disabled until bit 0 of the mode register is set
+	t.True(ula.ulaplusPalette() == nil)
+	ports.WritePortInternal(0xbf3b, ULAPLUS_GROUP_MODE, false)
+	t.Equal(byte(0x00), ports.ReadPortInternal(0xff3b, false))
+
+	for i := range ula.dirtyScreen {
+		ula.dirtyScreen[i] = false
+	}
+	ports.WritePortInternal(0xff3b, 0x01, false)
+	t.True(ula.ulaplus.enabled)
+	t.Equal(byte(0x01), ports.ReadPortInternal(0xff3b, false))
+
+	palette := ula.ulaplusPalette()
+	t.True(palette != nil)
+	if !t.Failed() {
+		t.Equal(ulaplusColor(0xa7), palette[5])
+		t.Equal(ulaplusColor(0x00), palette[6])
+	}
+
+	// Enabling the palette repaints the whole screen
+	dirty := 0
+	for _, d := range ula.dirtyScreen {
+		if d {
+			dirty++
+		}
+	}
+	t.Equal(ScreenWidth_Attr*ScreenHeight_Attr, dirty)
+
+	ports.WritePortInternal(0xff3b, 0x00, false)
+	t.False(ula.ulaplus.enabled)
+	t.True(ula.ulaplusPalette() == nil)
+
+	// Resetting the machine clears the registers
+	ports.reset()
+	t.Equal(ulaplusState{}, ula.ulaplus)
+}
+
+func (t *testSuite) TestULAplusColor() {
+	tests := []struct {
+		grb   byte
+		color RGBA
+	}{
+		{0x00, RGBA{0, 0, 0, 255}},
+		{0xff, RGBA{255, 255, 255, 255}},
+		{0xe0, RGBA{0, 255, 0, 255}},
+		{0x1c, RGBA{255, 0, 0, 255}},
+		{0x03, RGBA{0, 0, 255, 255}},
+		{0x24, RGBA{36, 36, 0, 255}},
+		{0x92, RGBA{146, 146, 182, 255}},
+
+		// The blue component is extended from 2 to 3 bits: 01 -> 011, 10 -> 101
+		{0x01, RGBA{0, 0, 109, 255}},
+		{0x02, RGBA{0, 0, 182, 255}},
+	}
+
+	for _, test := range tests {
+		t.Equal(test.color.value32(), ulaplusColor(test.grb))
+	}
+}
@@ -195,7 +195,7 @@ func httpGet(url string, outfile io.Writer) error {
 // An URL can be obtained by calling function WosQuery.
 func WosGet(app *Application, stdout io.Writer, url string) (string, error) {
 	filename := path.Base(url)
-	dir := DownloadPath()
+	dir := app.SearchPaths.DownloadPath()
 	filePath := path.Join(dir, filename)
 	httpURL := baseURL + url
 
@@ -274,7 +274,7 @@ func StartFullEmulation(cli bool) {
 	app = spectrum.NewApplication()
 	speccy = spectrum.NewSpectrum48k(app, *rom)
 	speccy.TapeDrive().NotifyLoadComplete = true
-	sdlScreen := output.NewSDLScreen2x(app)
+	sdlScreen := output.NewSDLScreen2x(app, spectrum.BorderNormal)
 	speccy.CommandChannel <- spectrum.Cmd_AddDisplay{sdlScreen}
 	if !cli {
 		r = newRenderer(app, sdlScreen, nil)
@@ -296,7 +296,7 @@ Welcome to the GoSpeccy CLI Testing Mode
 ----------------------------------------
 `)
 	}
-	audio, err := output.NewSDLAudio(app, output.PLAYBACK_FREQUENCY, true /*hqAudio*/)
+	audio, err := output.NewSDLAudio(app, output.PLAYBACK_FREQUENCY, true /*hqAudio*/, output.STEREO_MONO)
 	if err == nil {
 		speccy.CommandChannel <- spectrum.Cmd_AddAudioReceiver{audio}
 	} else {